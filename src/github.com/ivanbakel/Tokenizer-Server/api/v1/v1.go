@@ -0,0 +1,339 @@
+// Package v1 defines the JSON bodies of version 1 of the API.
+//
+// These types are the public contract, kept apart from the generated models
+// so that a schema change cannot alter the API by accident. Within v1,
+// fields may be added but never removed, renamed or given a new meaning;
+// anything else belongs in a new version.
+package v1
+
+import "time"
+
+// Version is the number clients ask for, in the /v1 prefix or the Accept
+// header.
+const Version = 1
+
+// MediaType selects this version through the Accept header.
+const MediaType = "application/vnd.tokenizer.v1+json"
+
+// Organisation is an organisation that issues tokens.
+type Organisation struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// User is a user, who may belong to an organisation. FrozenAt is set while
+// the user is frozen, when none of their holdings can be spent or
+// transferred.
+type User struct {
+	ID         string     `json:"id"`
+	FacebookID string     `json:"facebook_id"`
+	OrgID      *string    `json:"org_id"`
+	FrozenAt   *time.Time `json:"frozen_at,omitempty"`
+}
+
+// Token is a kind of token issued by an organisation. MaxSupply caps how
+// many units of it can ever be issued, and UserCap how many one user can
+// hold; either is absent if the token has no such cap.
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Expires   time.Time `json:"expires"`
+	OrgID     string    `json:"org_id"`
+	MaxSupply *int      `json:"max_supply,omitempty"`
+	UserCap   *int16    `json:"user_cap,omitempty"`
+}
+
+// TokenSupply accounts for a token's units: Issued ever, of which Spent
+// were spent and Expired expired, leaving Outstanding in users' holdings.
+// Remaining is how many more can be issued, if the token has a maximum
+// supply.
+type TokenSupply struct {
+	TokenID     string `json:"token_id"`
+	Issued      int64  `json:"issued"`
+	Outstanding int64  `json:"outstanding"`
+	Spent       int64  `json:"spent"`
+	Expired     int64  `json:"expired"`
+	MaxSupply   *int   `json:"max_supply,omitempty"`
+	Remaining   *int64 `json:"remaining,omitempty"`
+	UserCap     *int16 `json:"user_cap,omitempty"`
+}
+
+// Holding is the number of units of a token a user holds. FrozenAt is set
+// while the holding is frozen, when it cannot be spent or transferred.
+type Holding struct {
+	UserID   string     `json:"user_id"`
+	TokenID  string     `json:"token_id"`
+	Number   int16      `json:"number"`
+	FrozenAt *time.Time `json:"frozen_at,omitempty"`
+}
+
+// BalanceEvent is a committed change to a user's holding, as sent on the
+// user's event stream. Type is one of granted, spent, transferred, expired
+// and rate_limited. For a transfer, UserID is the recipient and FromUserID
+// the sender, and Balance and FromBalance are their balances afterwards.
+// A rate_limited event is a change refused by the rate limit named by
+// Limit, with the balances as they stood.
+type BalanceEvent struct {
+	ID          int64     `json:"id"`
+	Type        string    `json:"type"`
+	OrgID       string    `json:"org_id"`
+	TokenID     string    `json:"token_id"`
+	UserID      string    `json:"user_id"`
+	FromUserID  string    `json:"from_user_id,omitempty"`
+	Number      int16     `json:"number"`
+	Balance     int16     `json:"balance"`
+	FromBalance *int16    `json:"from_balance,omitempty"`
+	Limit       string    `json:"limit,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// Webhook is an organisation's subscription to the events of its tokens.
+// Events lists the event types delivered; empty means all of them. Secret
+// keys the signature on each delivery, and is only returned when the webhook
+// is created.
+type Webhook struct {
+	ID        string    `json:"id"`
+	OrgID     string    `json:"org_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is the sending of one event to a webhook. State is one of
+// pending, delivered and failed. Payload is the BalanceEvent sent, and
+// Attempts, when present, is the log of tries at sending it, oldest first.
+type WebhookDelivery struct {
+	ID            string           `json:"id"`
+	WebhookID     string           `json:"webhook_id"`
+	EventID       int64            `json:"event_id"`
+	EventType     string           `json:"event_type"`
+	Payload       BalanceEvent     `json:"payload"`
+	State         string           `json:"state"`
+	AttemptCount  int              `json:"attempt_count"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	DeliveredAt   *time.Time       `json:"delivered_at,omitempty"`
+	Attempts      []WebhookAttempt `json:"attempts,omitempty"`
+}
+
+// WebhookAttempt is one try at sending a delivery: the status the receiver
+// answered with, or the error if there was no answer.
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode *int16    `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int       `json:"duration_ms"`
+}
+
+// RedemptionCode is a single-use code a merchant can redeem to spend Number
+// units of a token from a user's holding, until ExpiresAt. QRURL is where a
+// QR code for it can be fetched, to show at the till.
+type RedemptionCode struct {
+	Code       string     `json:"code"`
+	UserID     string     `json:"user_id"`
+	TokenID    string     `json:"token_id"`
+	Number     int16      `json:"number"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RedeemedAt *time.Time `json:"redeemed_at,omitempty"`
+	QRURL      string     `json:"qr_url"`
+}
+
+// Redemption is a redeemed code, with the holding it was spent from as it
+// stands afterwards.
+type Redemption struct {
+	Code       string    `json:"code"`
+	UserID     string    `json:"user_id"`
+	TokenID    string    `json:"token_id"`
+	Number     int16     `json:"number"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+	Holding    Holding   `json:"holding"`
+}
+
+// OrgKey is an API key an organisation's systems authenticate with. Key is
+// only returned when it is created.
+type OrgKey struct {
+	ID        string     `json:"id"`
+	OrgID     string     `json:"org_id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Key       string     `json:"key,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Voucher is a signed voucher for spending Number units of a token from a
+// user's holding, which terminals can check offline against the keys at
+// /.well-known/voucher-keys/{org_id}. Voucher is the encoded, signed form to
+// hand to the terminal; the other fields repeat what it says.
+type Voucher struct {
+	ID        string    `json:"id"`
+	Voucher   string    `json:"voucher"`
+	OrgID     string    `json:"org_id"`
+	KeyID     string    `json:"kid"`
+	UserID    string    `json:"user_id"`
+	TokenID   string    `json:"token_id"`
+	Number    int16     `json:"number"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SettlementResult is the outcome of settling one voucher. Status is one of
+// settled, duplicate and conflict; Reason is set for conflicts, and Holding
+// for vouchers just settled.
+type SettlementResult struct {
+	VoucherID string   `json:"voucher_id"`
+	Status    string   `json:"status"`
+	Reason    string   `json:"reason,omitempty"`
+	Holding   *Holding `json:"holding,omitempty"`
+}
+
+// Settlement is the outcome of settling a terminal's vouchers, in the order
+// they were sent.
+type Settlement struct {
+	Results []SettlementResult `json:"results"`
+}
+
+// VoucherConflict is a voucher a terminal synced that could not be settled.
+// For a double spend, SettledBy is the terminal that settled it first.
+type VoucherConflict struct {
+	ID         string    `json:"id"`
+	VoucherID  string    `json:"voucher_id"`
+	Reason     string    `json:"reason"`
+	Terminal   string    `json:"terminal"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+	SettledBy  string    `json:"settled_by,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// GiftBatch is a batch of printable gift codes, each worth Number units of a
+// token to whoever claims it first. Claimed and Unclaimed count its codes;
+// once RevokedAt is set, the unclaimed ones can no longer be claimed.
+type GiftBatch struct {
+	ID        string     `json:"id"`
+	OrgID     string     `json:"org_id"`
+	TokenID   string     `json:"token_id"`
+	Number    int16      `json:"number"`
+	Size      int        `json:"size"`
+	Claimed   int        `json:"claimed"`
+	Unclaimed int        `json:"unclaimed"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// GiftCode is one code of a batch, formatted as it is printed, and who
+// claimed it, if anyone has.
+type GiftCode struct {
+	Code      string     `json:"code"`
+	BatchID   string     `json:"batch_id"`
+	ClaimedBy string     `json:"claimed_by,omitempty"`
+	ClaimedAt *time.Time `json:"claimed_at,omitempty"`
+}
+
+// GiftClaim is a claimed gift code, with the holding it was credited to as
+// it stands afterwards.
+type GiftClaim struct {
+	Code    string  `json:"code"`
+	BatchID string  `json:"batch_id"`
+	TokenID string  `json:"token_id"`
+	Number  int16   `json:"number"`
+	Holding Holding `json:"holding"`
+}
+
+// FraudAlert is raised when a fraud rule matches the balance events. EventID
+// is the event that completed the pattern, and Count what the rule counted.
+// Frozen is what the alert froze: user, holding, or nothing.
+type FraudAlert struct {
+	ID      string    `json:"id"`
+	Rule    string    `json:"rule"`
+	UserID  string    `json:"user_id"`
+	TokenID string    `json:"token_id"`
+	EventID int64     `json:"event_id"`
+	Count   int       `json:"count"`
+	Frozen  string    `json:"frozen,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// NewToken is the body of a request to create a token. MaxSupply and
+// UserCap may be left out, for a token without caps.
+type NewToken struct {
+	Name      string    `json:"name"`
+	Expires   time.Time `json:"expires"`
+	OrgID     string    `json:"org_id"`
+	MaxSupply *int      `json:"max_supply,omitempty"`
+	UserCap   *int16    `json:"user_cap,omitempty"`
+}
+
+// GrantRequest is the body of a request to grant a token. grant-user reads
+// UserID, grant-group reads UserIDs.
+type GrantRequest struct {
+	UserID  string   `json:"user_id,omitempty"`
+	UserIDs []string `json:"user_ids,omitempty"`
+	Number  int16    `json:"number"`
+}
+
+// NewWebhook is the body of a request to create a webhook. Events and Secret
+// may be left out, to deliver every event type and have a secret generated.
+type NewWebhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// NewRedemptionCode is the body of a request for a redemption code.
+// ExpiresIn is how many seconds it lasts; left out, it lasts five minutes.
+type NewRedemptionCode struct {
+	Number    int16 `json:"number"`
+	ExpiresIn int   `json:"expires_in,omitempty"`
+}
+
+// RedeemRequest is the body of a request to redeem a code.
+type RedeemRequest struct {
+	Code string `json:"code"`
+}
+
+// NewOrgKey is the body of a request to create an org key.
+type NewOrgKey struct {
+	Name string `json:"name"`
+}
+
+// NewVoucher is the body of a request for a voucher. ExpiresIn is how many
+// seconds it lasts; left out, it lasts a day.
+type NewVoucher struct {
+	Number    int16 `json:"number"`
+	ExpiresIn int   `json:"expires_in,omitempty"`
+}
+
+// SettlementRequest is the body of a request to settle the vouchers a
+// terminal accepted.
+type SettlementRequest struct {
+	Terminal    string              `json:"terminal"`
+	Redemptions []VoucherRedemption `json:"redemptions"`
+}
+
+// VoucherRedemption is a voucher a terminal accepted, and when.
+type VoucherRedemption struct {
+	Voucher    string    `json:"voucher"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+// NewGiftBatch is the body of a request to create a batch of Count gift
+// codes.
+type NewGiftBatch struct {
+	TokenID string `json:"token_id"`
+	Number  int16  `json:"number"`
+	Count   int    `json:"count"`
+}
+
+// ClaimRequest is the body of a request to claim a gift code. The code may
+// be given as printed, with or without dashes, in either case.
+type ClaimRequest struct {
+	Code string `json:"code"`
+}
+
+// TransferRequest is the body of a request to spend a token, or to receive
+// it from another user, in which case From is that user.
+type TransferRequest struct {
+	From   string `json:"from,omitempty"`
+	Number int16  `json:"number"`
+}
@@ -0,0 +1,219 @@
+// Package apierror maps failures to HTTP statuses and writes them as RFC 7807
+// problem details, each with a stable machine-readable code.
+package apierror
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/pkg/errors"
+)
+
+// ContentType is the media type of every error body.
+const ContentType = "application/problem+json"
+
+// Codes identify each kind of error. They are part of the API: clients may
+// switch on them, so existing codes must never change meaning.
+const (
+	CodeMalformedBody       = "malformed_body"
+	CodeInvalidID           = "invalid_id"
+	CodeInvalidQuery        = "invalid_query"
+	CodeValidation          = "validation_failed"
+	CodeInvalidNumber       = "invalid_number"
+	CodeMissingReference    = "missing_reference"
+	CodeNotFound            = "not_found"
+	CodeConflict            = "conflict"
+	CodeInsufficientBalance = "insufficient_balance"
+	CodeSupplyExceeded      = "supply_exceeded"
+	CodeHoldingCapExceeded  = "holding_cap_exceeded"
+	CodeCodeRedeemed        = "code_already_redeemed"
+	CodeCodeExpired         = "code_expired"
+	CodeGiftCodeClaimed     = "gift_code_claimed"
+	CodeGiftCodeRevoked     = "gift_code_revoked"
+	CodeRateLimited         = "rate_limited"
+	CodeUserFrozen          = "user_frozen"
+	CodeHoldingFrozen       = "holding_frozen"
+	CodeUnauthenticated     = "unauthenticated"
+	CodeForbidden           = "forbidden"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeUnsupportedVersion  = "unsupported_version"
+	CodeIdempotencyReused   = "idempotency_key_reused"
+	CodeIdempotencyInFlight = "idempotency_key_in_flight"
+	CodeInternal            = "internal_error"
+)
+
+// Error is a failure with the status and code it is reported to clients as.
+// Violations, if set, counts the records that broke a limit. RetryAfter, if
+// set, is how long the client should wait before trying again.
+type Error struct {
+	Status     int
+	Code       string
+	Detail     string
+	Violations int
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Detail)
+}
+
+// New returns an error reported with the given status and code.
+func New(status int, code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+// BadRequest is for requests that cannot be understood at all.
+func BadRequest(code, detail string) *Error {
+	return New(http.StatusBadRequest, code, detail)
+}
+
+// Invalid is for well-formed requests whose content is not acceptable.
+func Invalid(code, detail string) *Error {
+	return New(http.StatusUnprocessableEntity, code, detail)
+}
+
+// MalformedJSON is for a part of a request, described by subject, that
+// encoding/json could not decode. The detail says where the problem is, by
+// byte offset or JSON field, but not what Go made of it, since that would
+// name the server's own types.
+func MalformedJSON(code, subject string, err error) *Error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		return BadRequest(code, fmt.Sprintf("%s is not valid JSON (at byte %d)", subject, syntaxErr.Offset))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return BadRequest(code, fmt.Sprintf("%s has the wrong type of value for %q (got %s, at byte %d)", subject, typeErr.Field, typeErr.Value, typeErr.Offset))
+	case errors.As(err, &typeErr):
+		return BadRequest(code, fmt.Sprintf("%s has the wrong type of value (got %s, at byte %d)", subject, typeErr.Value, typeErr.Offset))
+	}
+	return BadRequest(code, subject+" is not valid JSON")
+}
+
+// NotFound is for references to records that do not exist.
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+// Unauthenticated is for requests without valid credentials.
+func Unauthenticated(detail string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthenticated, detail)
+}
+
+// Forbidden is for credentials that do not allow the request.
+func Forbidden(detail string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, detail)
+}
+
+// RateLimited is for requests refused by the named rate limit until
+// retryAfter has passed.
+func RateLimited(limit string, retryAfter time.Duration) *Error {
+	apiErr := New(http.StatusTooManyRequests, CodeRateLimited, fmt.Sprintf("the %s rate limit has been reached", limit))
+	apiErr.RetryAfter = retryAfter
+	return apiErr
+}
+
+// RetryAfterSeconds is e's RetryAfter in whole seconds, rounded up so that a
+// client waiting that long will not be refused again for waiting too little.
+func (e *Error) RetryAfterSeconds() int {
+	return int((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// From classifies err. Errors that are not recognised become a 500 whose
+// detail does not reveal the underlying error.
+func From(err error) *Error {
+	if apiErr, ok := errors.Cause(err).(*Error); ok {
+		return apiErr
+	}
+
+	if capErr, ok := errors.Cause(err).(*storage.HoldingCapError); ok {
+		apiErr := New(http.StatusConflict, CodeHoldingCapExceeded,
+			fmt.Sprintf("%d of the users would hold more than the token's cap of %d", capErr.Users, capErr.Cap))
+		apiErr.Violations = capErr.Users
+		return apiErr
+	}
+
+	switch storage.Translate(errors.Cause(err)) {
+	case sql.ErrNoRows:
+		return NotFound("the requested record does not exist")
+	case storage.ErrInsufficientBalance:
+		return New(http.StatusConflict, CodeInsufficientBalance, "the user does not hold enough of the token")
+	case storage.ErrSupplyExceeded:
+		return New(http.StatusConflict, CodeSupplyExceeded, "the token's maximum supply would be exceeded")
+	case storage.ErrCodeRedeemed:
+		return New(http.StatusConflict, CodeCodeRedeemed, "the code has already been redeemed")
+	case storage.ErrCodeExpired:
+		return New(http.StatusConflict, CodeCodeExpired, "the code has expired")
+	case storage.ErrGiftCodeClaimed:
+		return New(http.StatusConflict, CodeGiftCodeClaimed, "the gift code has already been claimed")
+	case storage.ErrGiftCodeRevoked:
+		return New(http.StatusConflict, CodeGiftCodeRevoked, "the gift code has been revoked")
+	case storage.ErrUserFrozen:
+		return New(http.StatusConflict, CodeUserFrozen, "the user is frozen pending review")
+	case storage.ErrHoldingFrozen:
+		return New(http.StatusConflict, CodeHoldingFrozen, "the user's holding of the token is frozen pending review")
+	case storage.ErrConflict:
+		return New(http.StatusConflict, CodeConflict, "the record conflicts with an existing one")
+	case storage.ErrInvalidNumber:
+		return Invalid(CodeInvalidNumber, "number must be positive")
+	case storage.ErrMissingReference:
+		return Invalid(CodeMissingReference, "the request refers to a record that does not exist")
+	case storage.ErrIdempotencyKeyReused:
+		return Invalid(CodeIdempotencyReused, "the idempotency key was already used for a different request")
+	case storage.ErrIdempotencyKeyInFlight:
+		return New(http.StatusConflict, CodeIdempotencyInFlight, "the first request with this idempotency key has not finished")
+	case storage.ErrMalformedValue:
+		return BadRequest(CodeInvalidID, "the request contains a malformed id")
+	}
+
+	return New(http.StatusInternalServerError, CodeInternal, "the server was unable to complete the request")
+}
+
+// Problem is the RFC 7807 body written for every error.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Violations is set for errors caused by records breaking a limit,
+	// e.g. how many users a grant would have taken over a cap.
+	Violations int `json:"violations,omitempty"`
+}
+
+// Write reports err to the client as problem+json. Server errors are logged
+// with the request id, since their detail is withheld from the client.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := From(err)
+
+	if apiErr.Status >= 500 {
+		logging.Error(r, "handler failed", err)
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfterSeconds()))
+	}
+	w.WriteHeader(apiErr.Status)
+
+	json.NewEncoder(w).Encode(Problem{
+		Type:       "/errors/" + apiErr.Code,
+		Title:      http.StatusText(apiErr.Status),
+		Status:     apiErr.Status,
+		Detail:     apiErr.Detail,
+		Instance:   r.URL.Path,
+		Code:       apiErr.Code,
+		RequestID:  logging.RequestID(r.Context()),
+		Violations: apiErr.Violations,
+	})
+}
@@ -0,0 +1,110 @@
+package apierror
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/pkg/errors"
+)
+
+func TestFrom(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{sql.ErrNoRows, 404, CodeNotFound},
+		{errors.Wrap(storage.ErrInsufficientBalance, "spend"), 409, CodeInsufficientBalance},
+		{storage.ErrCodeRedeemed, 409, CodeCodeRedeemed},
+		{storage.ErrSupplyExceeded, 409, CodeSupplyExceeded},
+		{errors.Wrap(storage.ErrHoldingFrozen, "transfer"), 409, CodeHoldingFrozen},
+		{storage.ErrUserFrozen, 409, CodeUserFrozen},
+		{errors.Wrap(&storage.HoldingCapError{Cap: 5, Users: 2}, "grant"), 409, CodeHoldingCapExceeded},
+		{storage.ErrInvalidNumber, 422, CodeInvalidNumber},
+		{BadRequest(CodeInvalidID, "bad id"), 400, CodeInvalidID},
+		{errors.New("connection refused"), 500, CodeInternal},
+	}
+
+	for _, c := range cases {
+		got := From(c.err)
+		if got.Status != c.status || got.Code != c.code {
+			t.Errorf("From(%v) = %d %s, want %d %s", c.err, got.Status, got.Code, c.status, c.code)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/users/123", nil)
+
+	Write(w, r, errors.New("pq: password authentication failed"))
+
+	if w.Code != 500 {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, ContentType)
+	}
+
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("body is not JSON: %s", w.Body.String())
+	}
+	if problem.Code != CodeInternal || problem.Status != 500 || problem.Instance != "/users/123" {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+	if problem.Detail == "" || problem.Detail == "pq: password authentication failed" {
+		t.Errorf("detail %q should be generic", problem.Detail)
+	}
+}
+
+func TestWriteRetryAfter(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/users/123/tokens/456/spend", nil)
+
+	Write(w, r, RateLimited("spends", 2100*time.Millisecond))
+
+	if w.Code != 429 {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	// Rounded up, so that waiting as asked is always long enough.
+	if got := w.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q, want 3", got)
+	}
+
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("body is not JSON: %s", w.Body.String())
+	}
+	if problem.Code != CodeRateLimited {
+		t.Errorf("code = %q, want %q", problem.Code, CodeRateLimited)
+	}
+}
+
+func TestMalformedJSON(t *testing.T) {
+	var into struct {
+		Number int16 `json:"number"`
+	}
+
+	cases := map[string]string{
+		`{"number": `:     "the request body is not valid JSON",
+		`{"number" 1}`:    "the request body is not valid JSON (at byte 11)",
+		`{"number": "1"}`: `the request body has the wrong type of value for "number" (got string, at byte 14)`,
+		`[1]`:             "the request body has the wrong type of value (got array, at byte 1)",
+	}
+
+	for body, want := range cases {
+		err := MalformedJSON(CodeMalformedBody, "the request body", json.NewDecoder(strings.NewReader(body)).Decode(&into))
+		if err.Status != 400 || err.Code != CodeMalformedBody || err.Detail != want {
+			t.Errorf("%s: got %d %s %q, want %q", body, err.Status, err.Code, err.Detail, want)
+		}
+		if strings.Contains(err.Detail, "int16") || strings.Contains(err.Detail, "struct") {
+			t.Errorf("%s: detail %q names Go types", body, err.Detail)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	var header = r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimPrefix(header, "Bearer ")
+}
+
+// requireAdmin only lets requests carrying the admin token through to next.
+// With no admin token configured, the endpoint is always forbidden.
+func requireAdmin(adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			apierror.Write(w, r, apierror.Forbidden("admin endpoints are disabled"))
+			return
+		}
+
+		var token = bearerToken(r)
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tokenizer"`)
+			apierror.Write(w, r, apierror.Unauthenticated("an admin bearer token is required"))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+type orgKeyContextKey struct{}
+
+// requireOrgKey only lets requests carrying one of the organisation's own
+// API keys through to next, where orgKeyFrom returns the key. The
+// organisation is the one named by the "oid" path variable.
+func requireOrgKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var key,err = service.AuthenticateOrgKey(bearerToken(r))
+
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tokenizer"`)
+			apierror.Write(w, r, err)
+			return
+		}
+
+		if key.OrgID != mux.Vars(r)["oid"] {
+			apierror.Write(w, r, apierror.Forbidden("the API key belongs to another organisation"))
+			return
+		}
+
+		logging.Annotate(r, "org_key", key.ID)
+
+		next(w, r.WithContext(context.WithValue(r.Context(), orgKeyContextKey{}, key)))
+	}
+}
+
+// orgKeyFrom returns the key requireOrgKey authenticated the request with.
+func orgKeyFrom(r *http.Request) *storage.OrgKey {
+	return r.Context().Value(orgKeyContextKey{}).(*storage.OrgKey)
+}
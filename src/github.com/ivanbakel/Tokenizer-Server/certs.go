@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// certReloader serves a TLS certificate that can be replaced without a
+// restart: it is re-read from disk whenever the process receives SIGHUP.
+type certReloader struct {
+	certFile	string
+	keyFile		string
+
+	mut	sync.RWMutex
+	cert	*tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	var reloader = &certReloader{certFile: certFile, keyFile: keyFile}
+
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (c *certReloader) reload() error {
+	var cert,err = tls.LoadX509KeyPair(c.certFile, c.keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	c.mut.Lock()
+	c.cert = &cert
+	c.mut.Unlock()
+
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	return c.cert, nil
+}
+
+// watch reloads the certificate on SIGHUP until ctx is done. A failed reload
+// keeps the previous certificate in use.
+func (c *certReloader) watch(ctx context.Context) {
+	var hangups = make(chan os.Signal, 1)
+
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			if err := c.reload(); err != nil {
+				log.Printf("unable to reload TLS certificate: %v", err)
+			} else {
+				log.Printf("reloaded TLS certificate from %s", c.certFile)
+			}
+		}
+	}
+}
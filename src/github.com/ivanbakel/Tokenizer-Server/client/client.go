@@ -0,0 +1,363 @@
+// Package client is a Go client for version 1 of the Tokenizer API.
+//
+// Every method takes a context, which bounds the whole call including any
+// retries. Calls that change balances send an idempotency key, so they are
+// retried safely after a timeout or a transient server error; see
+// WithIdempotencyKey to choose the key yourself.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultBackoff    = 100 * time.Millisecond
+	maxBackoff        = 5 * time.Second
+)
+
+// Client calls a Tokenizer server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	http       *http.Client
+	maxRetries int
+	backoff    time.Duration
+	token      string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetries sets how many times a failed call is retried, and the delay
+// before the first retry, which doubles on each one after it.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) { c.maxRetries, c.backoff = maxRetries, backoff }
+}
+
+// WithBearerToken authenticates every request with token.
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// New returns a client for the server at baseURL, e.g. https://tokens.example.com.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		http:       http.DefaultClient,
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey makes the call made with ctx use key, instead of one
+// generated for the call. Reusing a key across calls, e.g. after a restart,
+// makes the server answer the repeat with the first call's response.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// GetUser returns the user with the given id.
+func (c *Client) GetUser(ctx context.Context, userID string) (*v1.User, error) {
+	var user v1.User
+	if err := c.do(ctx, http.MethodGet, "/v1/users/"+url.PathEscape(userID), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetToken returns the token with the given id.
+func (c *Client) GetToken(ctx context.Context, tokenID string) (*v1.Token, error) {
+	var token v1.Token
+	if err := c.do(ctx, http.MethodGet, "/v1/tokens/"+url.PathEscape(tokenID), nil, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetTokenSupply returns the account of a token's units: how many have
+// been issued, spent and expired, and how many are still held.
+func (c *Client) GetTokenSupply(ctx context.Context, tokenID string) (*v1.TokenSupply, error) {
+	var supply v1.TokenSupply
+	if err := c.do(ctx, http.MethodGet, "/v1/tokens/"+url.PathEscape(tokenID)+"/supply", nil, &supply); err != nil {
+		return nil, err
+	}
+	return &supply, nil
+}
+
+// ListUserTokens returns every holding of the given user, reading as many
+// pages as it takes.
+func (c *Client) ListUserTokens(ctx context.Context, userID string) ([]v1.Holding, error) {
+	var holdings []v1.Holding
+	var cursor string
+
+	for {
+		path := "/v1/users/" + url.PathEscape(userID) + "/tokens?limit=500"
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+
+		var page struct {
+			Items      []v1.Holding `json:"items"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+
+		holdings = append(holdings, page.Items...)
+		if page.NextCursor == "" {
+			return holdings, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// CreateToken creates a token.
+func (c *Client) CreateToken(ctx context.Context, token v1.NewToken) (*v1.Token, error) {
+	var created v1.Token
+	if err := c.do(ctx, http.MethodPost, "/v1/tokens", token, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GrantUser credits number units of a token to a user, returning the user's
+// holding afterwards.
+func (c *Client) GrantUser(ctx context.Context, tokenID, userID string, number int16) (*v1.Holding, error) {
+	var holding v1.Holding
+	request := v1.GrantRequest{UserID: userID, Number: number}
+	if err := c.do(ctx, http.MethodPost, "/v1/tokens/"+url.PathEscape(tokenID)+"/grant-user", request, &holding); err != nil {
+		return nil, err
+	}
+	return &holding, nil
+}
+
+// GrantGroup credits number units of a token to each of the users, or to
+// none of them if any cannot be credited. If any would go over the token's
+// per-user cap, the error wraps ErrHoldingCapExceeded and its Violations
+// counts them.
+func (c *Client) GrantGroup(ctx context.Context, tokenID string, userIDs []string, number int16) ([]v1.Holding, error) {
+	var holdings []v1.Holding
+	request := v1.GrantRequest{UserIDs: userIDs, Number: number}
+	if err := c.do(ctx, http.MethodPost, "/v1/tokens/"+url.PathEscape(tokenID)+"/grant-group", request, &holdings); err != nil {
+		return nil, err
+	}
+	return holdings, nil
+}
+
+// Spend removes number units of a token from a user's holding, returning the
+// holding afterwards. It fails with ErrInsufficientBalance if the user holds
+// too few.
+func (c *Client) Spend(ctx context.Context, userID, tokenID string, number int16) (*v1.Holding, error) {
+	var holding v1.Holding
+	request := v1.TransferRequest{Number: number}
+	if err := c.do(ctx, http.MethodPost, holdingPath(userID, tokenID)+"/spend", request, &holding); err != nil {
+		return nil, err
+	}
+	return &holding, nil
+}
+
+// Transfer moves number units of a token from one user to another, returning
+// the recipient's holding afterwards.
+func (c *Client) Transfer(ctx context.Context, tokenID, fromUserID, toUserID string, number int16) (*v1.Holding, error) {
+	var holding v1.Holding
+	request := v1.TransferRequest{From: fromUserID, Number: number}
+	if err := c.do(ctx, http.MethodPost, holdingPath(toUserID, tokenID)+"/receive", request, &holding); err != nil {
+		return nil, err
+	}
+	return &holding, nil
+}
+
+func holdingPath(userID, tokenID string) string {
+	return "/v1/users/" + url.PathEscape(userID) + "/tokens/" + url.PathEscape(tokenID)
+}
+
+// do sends a request, retrying it while it fails in a way that may be
+// transient, and decodes a successful response into out.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("tokenizer: unable to encode request: %v", err)
+		}
+	}
+
+	// Only POSTs change anything, and every attempt at one must carry the
+	// same key for the server to apply it once.
+	var key string
+	if method == http.MethodPost {
+		key, _ = ctx.Value(idempotencyKey{}).(string)
+		if key == "" {
+			key = newIdempotencyKey()
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.attempt(ctx, method, path, key, body, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(err) {
+			return err
+		}
+
+		wait := c.delay(attempt, retryAfter)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends the request once. When the server says when to retry, the
+// delay is returned along with the error.
+func (c *Client) attempt(ctx context.Context, method, path, key string, body []byte, out interface{}) (time.Duration, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Accept", v1.MediaType)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, &transportError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return parseRetryAfter(resp.Header.Get("Retry-After")), decodeError(resp)
+	}
+
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return 0, nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("tokenizer: unable to decode response: %v", err)
+	}
+	return 0, nil
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{Status: resp.StatusCode}
+
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, apiErr) != nil || apiErr.Code == "" {
+		apiErr.Code = strings.ToLower(strings.Replace(http.StatusText(resp.StatusCode), " ", "_", -1))
+		apiErr.Detail = strings.TrimSpace(string(data))
+	}
+	apiErr.Status = resp.StatusCode
+	return apiErr
+}
+
+// transportError is a failure to get any response from the server.
+type transportError struct{ err error }
+
+func (e *transportError) Error() string { return "tokenizer: " + e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+// retryable reports whether err may go away if the request is sent again.
+// Because POSTs carry an idempotency key, they are retried just like reads:
+// the server applies them at most once.
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *transportError:
+		return true
+	case *Error:
+		switch e.Status {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		case http.StatusConflict:
+			// The first attempt is still being processed.
+			return e.Code == "idempotency_key_in_flight"
+		}
+	}
+	return false
+}
+
+// delay is how long to wait before retrying after the given attempt:
+// whatever the server asked for, or else an exponential backoff with jitter.
+func (c *Client) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	if c.backoff <= 0 {
+		return 0
+	}
+
+	backoff := time.Duration(float64(c.backoff) * math.Pow(2, float64(attempt)))
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+	return backoff/2 + time.Duration(mathrand.Int63n(int64(backoff/2)+1))
+}
+
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+func newIdempotencyKey() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+)
+
+func problem(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "code": code, "detail": "detail", "request_id": "req-1"})
+}
+
+func TestErrorMapping(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem(w, http.StatusConflict, "insufficient_balance")
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL).Spend(context.Background(), "user", "token", 5)
+
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("want ErrInsufficientBalance, got %v", err)
+	}
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict || apiErr.RequestID != "req-1" {
+		t.Errorf("want the *Error with its status and request id, got %#v", err)
+	}
+}
+
+func TestRetriesKeepIdempotencyKey(t *testing.T) {
+	var mut sync.Mutex
+	var keys []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		attempt := len(keys)
+		mut.Unlock()
+
+		if attempt < 3 {
+			problem(w, http.StatusServiceUnavailable, "internal_error")
+			return
+		}
+		json.NewEncoder(w).Encode(v1.Holding{UserID: "user", TokenID: "token", Number: 7})
+	}))
+	defer srv.Close()
+
+	holding, err := New(srv.URL, WithRetries(3, time.Millisecond)).GrantUser(context.Background(), "token", "user", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holding.Number != 7 {
+		t.Errorf("want 7 units, got %d", holding.Number)
+	}
+
+	if len(keys) != 3 {
+		t.Fatalf("want 3 attempts, got %d", len(keys))
+	}
+	if keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("want one key on every attempt, got %q", keys)
+	}
+}
+
+func TestChosenIdempotencyKey(t *testing.T) {
+	var got string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Idempotency-Key")
+		json.NewEncoder(w).Encode(v1.Holding{})
+	}))
+	defer srv.Close()
+
+	ctx := WithIdempotencyKey(context.Background(), "order-42")
+	if _, err := New(srv.URL).Spend(ctx, "user", "token", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got != "order-42" {
+		t.Errorf("want key order-42, got %q", got)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	var attempts int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		problem(w, http.StatusNotFound, "not_found")
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, WithRetries(3, time.Millisecond)).GetUser(context.Background(), "user")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("want 1 attempt, got %d", attempts)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := New(srv.URL).GetUser(ctx, "user")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestListUserTokensFollowsCursors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("cursor") == "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"items": []v1.Holding{{TokenID: "a"}}, "next_cursor": "next"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": []v1.Holding{{TokenID: "b"}}})
+	}))
+	defer srv.Close()
+
+	holdings, err := New(srv.URL).ListUserTokens(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holdings) != 2 || holdings[0].TokenID != "a" || holdings[1].TokenID != "b" {
+		t.Errorf("want holdings a and b, got %+v", holdings)
+	}
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors the API reports, for use with errors.Is. Every *Error whose code
+// is recognised wraps one of them.
+var (
+	ErrNotFound               = errors.New("tokenizer: not found")
+	ErrInsufficientBalance    = errors.New("tokenizer: insufficient balance")
+	ErrSupplyExceeded         = errors.New("tokenizer: token supply exceeded")
+	ErrHoldingCapExceeded     = errors.New("tokenizer: holding cap exceeded")
+	ErrUserFrozen             = errors.New("tokenizer: user is frozen")
+	ErrHoldingFrozen          = errors.New("tokenizer: holding is frozen")
+	ErrRateLimited            = errors.New("tokenizer: rate limited")
+	ErrConflict               = errors.New("tokenizer: conflict")
+	ErrInvalid                = errors.New("tokenizer: invalid request")
+	ErrUnauthenticated        = errors.New("tokenizer: unauthenticated")
+	ErrForbidden              = errors.New("tokenizer: forbidden")
+	ErrIdempotencyKeyReused   = errors.New("tokenizer: idempotency key reused for a different request")
+	ErrIdempotencyKeyInFlight = errors.New("tokenizer: idempotency key in use by a request in flight")
+)
+
+// codes maps the API's error codes to the errors above. Codes missing from
+// it still produce an *Error, which wraps nothing.
+var codes = map[string]error{
+	"not_found":                 ErrNotFound,
+	"insufficient_balance":      ErrInsufficientBalance,
+	"supply_exceeded":           ErrSupplyExceeded,
+	"holding_cap_exceeded":      ErrHoldingCapExceeded,
+	"user_frozen":               ErrUserFrozen,
+	"holding_frozen":            ErrHoldingFrozen,
+	"rate_limited":              ErrRateLimited,
+	"conflict":                  ErrConflict,
+	"malformed_body":            ErrInvalid,
+	"invalid_id":                ErrInvalid,
+	"invalid_query":             ErrInvalid,
+	"validation_failed":         ErrInvalid,
+	"invalid_number":            ErrInvalid,
+	"missing_reference":         ErrInvalid,
+	"unauthenticated":           ErrUnauthenticated,
+	"forbidden":                 ErrForbidden,
+	"idempotency_key_reused":    ErrIdempotencyKeyReused,
+	"idempotency_key_in_flight": ErrIdempotencyKeyInFlight,
+}
+
+// Error is an error response from the server. Violations is set for
+// limits broken by several records at once, e.g. how many users a group
+// grant would have taken over a token's cap.
+type Error struct {
+	Status     int    `json:"status"`
+	Code       string `json:"code"`
+	Detail     string `json:"detail"`
+	RequestID  string `json:"request_id"`
+	Violations int    `json:"violations,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("tokenizer: %d %s", e.Status, e.Code)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Unwrap returns the sentinel error for e's code, if there is one.
+func (e *Error) Unwrap() error {
+	return codes[e.Code]
+}
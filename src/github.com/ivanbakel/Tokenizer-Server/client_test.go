@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/client"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/metrics"
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// testAdminToken is the admin token test servers accept.
+const testAdminToken = "test-admin-token"
+
+// newTestServer serves the full router over a fresh SQLite database.
+func newTestServer(t *testing.T) *httptest.Server {
+	var dir,err = ioutil.TempDir("", "tokenizer")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db,err := storage.Open(storage.SQLite.Name, filepath.Join(dir, "tokenizer.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	if _,err = migrations.Up(db, storage.SQLite.Migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	boil.SetDB(db)
+
+	var cfg = config.Default()
+
+	cfg.Auth.AdminToken = testAdminToken
+
+	var server = httptest.NewServer(newRouter(cfg, db, storage.SQLite, metrics.New(db), logging.New(ioutil.Discard)))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestClientAgainstServer(t *testing.T) {
+	var server = newTestServer(t)
+	var c = client.New(server.URL)
+	var ctx = context.Background()
+
+	var org = &models.Organisation{Name: "client org"}
+
+	if err := org.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var alice,bob = &models.User{FacebookID: "alice"}, &models.User{FacebookID: "bob"}
+
+	for _,user := range []*models.User{alice, bob} {
+		if err := user.Insert(boil.GetDB()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token,err := c.CreateToken(ctx, v1.NewToken{Name: "coffee", Expires: time.Now().Add(time.Hour), OrgID: org.ID})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err = c.GrantGroup(ctx, token.ID, []string{alice.ID, bob.ID}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err = c.GrantUser(ctx, token.ID, alice.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	holding,err := c.Transfer(ctx, token.ID, alice.ID, bob.ID, 4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if holding.Number != 6 {
+		t.Errorf("want bob to hold 6 after the transfer, got %d", holding.Number)
+	}
+
+	// Spending twice under one key only spends once.
+	var once = client.WithIdempotencyKey(ctx, "spend-once")
+
+	for i := 0; i < 2; i++ {
+		if holding,err = c.Spend(once, bob.ID, token.ID, 5); err != nil {
+			t.Fatal(err)
+		}
+
+		if holding.Number != 1 {
+			t.Errorf("want bob to hold 1 after spending, got %d", holding.Number)
+		}
+	}
+
+	if _,err = c.Spend(ctx, alice.ID, token.ID, 2); !errors.Is(err, client.ErrInsufficientBalance) {
+		t.Errorf("want ErrInsufficientBalance spending more than alice holds, got %v", err)
+	}
+
+	holdings,err := c.ListUserTokens(ctx, bob.ID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(holdings) != 1 || holdings[0].TokenID != token.ID || holdings[0].Number != 1 {
+		t.Errorf("want bob's one holding of 1, got %+v", holdings)
+	}
+
+	if _,err = c.GetUser(ctx, storage.NewUUID()); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("want ErrNotFound for an unknown user, got %v", err)
+	}
+}
@@ -0,0 +1,400 @@
+// Package config loads the server's settings. Values are layered: built-in
+// defaults, then a TOML or YAML file, then TOKENIZER_* environment
+// variables, then command-line flags.
+package config
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// Config holds every setting the server reads at startup.
+type Config struct {
+	Database Database        `toml:"database" yaml:"database"`
+	Server   Server          `toml:"server" yaml:"server"`
+	TLS      TLS             `toml:"tls" yaml:"tls"`
+	GRPC     GRPC            `toml:"grpc" yaml:"grpc"`
+	GraphQL  GraphQL         `toml:"graphql" yaml:"graphql"`
+	Auth     Auth            `toml:"auth" yaml:"auth"`
+	Tokens   Tokens          `toml:"tokens" yaml:"tokens"`
+	Webhooks Webhooks        `toml:"webhooks" yaml:"webhooks"`
+	Relay    Relay           `toml:"relay" yaml:"relay"`
+	Limits   Limits          `toml:"limits" yaml:"limits"`
+	Fraud    Fraud           `toml:"fraud" yaml:"fraud"`
+	Features map[string]bool `toml:"features" yaml:"features"`
+}
+
+// Database configures the storage backend and its connection pool. A
+// MaxOpenConns of zero leaves the limit to the backend. SQLite ignores
+// MaxIdleConns and ConnMaxLifetime, keeping its one connection open.
+type Database struct {
+	Backend         string   `toml:"backend" yaml:"backend"`
+	DSN             string   `toml:"dsn" yaml:"dsn"`
+	MaxOpenConns    int      `toml:"max_open_conns" yaml:"max_open_conns"`
+	MaxIdleConns    int      `toml:"max_idle_conns" yaml:"max_idle_conns"`
+	ConnMaxLifetime Duration `toml:"conn_max_lifetime" yaml:"conn_max_lifetime"`
+	MigrateOnStart  bool     `toml:"migrate_on_start" yaml:"migrate_on_start"`
+}
+
+// Server configures the HTTP listener.
+type Server struct {
+	ListenAddress   string   `toml:"listen_address" yaml:"listen_address"`
+	ReadTimeout     Duration `toml:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout    Duration `toml:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout     Duration `toml:"idle_timeout" yaml:"idle_timeout"`
+	ShutdownTimeout Duration `toml:"shutdown_timeout" yaml:"shutdown_timeout"`
+}
+
+// TLS enables HTTPS when both files are set.
+type TLS struct {
+	CertFile string `toml:"cert_file" yaml:"cert_file"`
+	KeyFile  string `toml:"key_file" yaml:"key_file"`
+}
+
+// Enabled reports whether both a certificate and a key are configured.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// GRPC configures the gRPC listener. An empty ListenAddress disables it.
+type GRPC struct {
+	ListenAddress string `toml:"listen_address" yaml:"listen_address"`
+}
+
+// GraphQL bounds the queries /graphql accepts.
+type GraphQL struct {
+	MaxDepth      int `toml:"max_depth" yaml:"max_depth"`
+	MaxComplexity int `toml:"max_complexity" yaml:"max_complexity"`
+}
+
+// Auth holds the credentials callers present to the server. An empty
+// AdminToken disables the admin-only endpoints; ServiceToken is what gRPC
+// clients must present.
+type Auth struct {
+	AdminToken   string `toml:"admin_token" yaml:"admin_token"`
+	ServiceToken string `toml:"service_token" yaml:"service_token"`
+}
+
+// Tokens configures background processing of token balances.
+type Tokens struct {
+	// ExpiryInterval is how often holdings of expired tokens are emptied.
+	ExpiryInterval Duration `toml:"expiry_interval" yaml:"expiry_interval"`
+}
+
+// Webhooks configures the sending of organisations' webhook deliveries.
+type Webhooks struct {
+	// Timeout bounds each attempt at a delivery.
+	Timeout Duration `toml:"timeout" yaml:"timeout"`
+	// MaxAttempts is how many times a delivery is tried before it is
+	// marked failed.
+	MaxAttempts int `toml:"max_attempts" yaml:"max_attempts"`
+	// PollInterval is how often the outbox is checked for deliveries due a
+	// retry, or queued by other instances.
+	PollInterval Duration `toml:"poll_interval" yaml:"poll_interval"`
+	// AllowPrivate lets webhooks target loopback, link-local and private
+	// addresses, for receivers on the server's own network.
+	AllowPrivate bool `toml:"allow_private" yaml:"allow_private"`
+}
+
+// Relay configures the publishing of every balance event, in order, to a
+// downstream sink. An empty Sink disables it.
+type Relay struct {
+	// Sink is "file", to append events to Path as newline-delimited JSON.
+	Sink string `toml:"sink" yaml:"sink"`
+	Path string `toml:"path" yaml:"path"`
+	// PollInterval is how often to look for events recorded by other
+	// instances, and to retry after the sink fails.
+	PollInterval Duration `toml:"poll_interval" yaml:"poll_interval"`
+	// CursorExpiry is how long a relay may go without running before its
+	// place in the event log is forgotten. Until then, events it has yet
+	// to publish are not pruned, so a relay that was retired or renamed
+	// holds them back for this long.
+	CursorExpiry Duration `toml:"cursor_expiry" yaml:"cursor_expiry"`
+}
+
+// Limits throttles balance changes with a token bucket per user or issuer,
+// so that a compromised client cannot drain or inflate balances quickly.
+type Limits struct {
+	// Store is "memory" for each instance to enforce the limits on its own,
+	// or "postgres" to keep the buckets in the database, so that the limits
+	// hold across every instance sharing it.
+	Store string `toml:"store" yaml:"store"`
+	// Spends bounds how often each user spends.
+	Spends Limit `toml:"spends" yaml:"spends"`
+	// Grants bounds how many units each organisation grants of its tokens.
+	Grants Limit `toml:"grants" yaml:"grants"`
+	// Transfers bounds how often each user sends a transfer.
+	Transfers Limit `toml:"transfers" yaml:"transfers"`
+}
+
+// Limit allows up to Max in each period of length Per. A zero Max is no
+// limit.
+type Limit struct {
+	Max int      `toml:"max" yaml:"max"`
+	Per Duration `toml:"per" yaml:"per"`
+}
+
+// Fraud configures the rules that look for abuse in the balance events,
+// raising alerts for admins and freezing what they concern.
+type Fraud struct {
+	Enabled bool `toml:"enabled" yaml:"enabled"`
+	// PollInterval is how often to look for events recorded by other
+	// instances, and to retry after a rule fails.
+	PollInterval Duration `toml:"poll_interval" yaml:"poll_interval"`
+	// TransferFanIn matches a user receiving transfers from Threshold
+	// different users, and freezes the user.
+	TransferFanIn FraudRule `toml:"transfer_fan_in" yaml:"transfer_fan_in"`
+	// GrantThenSpend matches a user spending their whole holding of a token
+	// after being granted Threshold units of it, and freezes the holding.
+	GrantThenSpend FraudRule `toml:"grant_then_spend" yaml:"grant_then_spend"`
+	// NewUserFanOut matches a user sending transfers to Threshold users new
+	// to the server, and freezes the sender.
+	NewUserFanOut FraudRule `toml:"new_user_fan_out" yaml:"new_user_fan_out"`
+}
+
+// FraudRule matches when Threshold is reached within Window. A zero Threshold
+// switches the rule off; Freeze freezes as well as alerting.
+type FraudRule struct {
+	Threshold int      `toml:"threshold" yaml:"threshold"`
+	Window    Duration `toml:"window" yaml:"window"`
+	Freeze    bool     `toml:"freeze" yaml:"freeze"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in files and
+// environment variables.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses a duration such as "1m30s".
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// MarshalText formats the duration the way UnmarshalText reads it.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// Default returns the configuration used when nothing overrides it.
+func Default() *Config {
+	return &Config{
+		Database: Database{
+			Backend:         "postgres",
+			DSN:             "user=tokenizerDB dbname=tokenizer sslmode=verify-full",
+			MaxIdleConns:    5,
+			ConnMaxLifetime: Duration{30 * time.Minute},
+		},
+		Server: Server{
+			ListenAddress:   ":8080",
+			ReadTimeout:     Duration{15 * time.Second},
+			WriteTimeout:    Duration{30 * time.Second},
+			IdleTimeout:     Duration{2 * time.Minute},
+			ShutdownTimeout: Duration{30 * time.Second},
+		},
+		GraphQL: GraphQL{
+			MaxDepth:      8,
+			MaxComplexity: 20000,
+		},
+		Tokens: Tokens{
+			ExpiryInterval: Duration{time.Minute},
+		},
+		Webhooks: Webhooks{
+			Timeout:      Duration{10 * time.Second},
+			MaxAttempts:  10,
+			PollInterval: Duration{5 * time.Second},
+		},
+		Relay: Relay{
+			PollInterval: Duration{5 * time.Second},
+			CursorExpiry: Duration{7 * 24 * time.Hour},
+		},
+		Limits: Limits{
+			Store:     "memory",
+			Spends:    Limit{Per: Duration{time.Minute}},
+			Grants:    Limit{Per: Duration{24 * time.Hour}},
+			Transfers: Limit{Per: Duration{time.Hour}},
+		},
+		Fraud: Fraud{
+			PollInterval:   Duration{5 * time.Second},
+			TransferFanIn:  FraudRule{Threshold: 10, Window: Duration{time.Hour}},
+			GrantThenSpend: FraudRule{Threshold: 1, Window: Duration{5 * time.Minute}},
+			NewUserFanOut:  FraudRule{Threshold: 5, Window: Duration{time.Hour}},
+		},
+		Features: make(map[string]bool),
+	}
+}
+
+// Feature reports whether the named feature toggle is switched on.
+func (c *Config) Feature(name string) bool {
+	return c.Features[name]
+}
+
+// Validate checks that the configuration is usable before anything is
+// started with it.
+func (c *Config) Validate() error {
+	if c.Database.Backend == "" {
+		return errors.New("config: database.backend must be set")
+	}
+	if c.Database.DSN == "" {
+		return errors.New("config: database.dsn must be set")
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		return errors.New("config: database pool sizes must not be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		return errors.New("config: database.max_idle_conns must not exceed database.max_open_conns")
+	}
+	if c.Database.Backend == "sqlite3" && c.Database.MaxOpenConns > 1 {
+		return errors.New("config: the sqlite3 backend supports a single open connection")
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.ListenAddress); err != nil {
+		return errors.Wrap(err, "config: invalid server.listen_address")
+	}
+
+	if c.GRPC.ListenAddress != "" {
+		if _, _, err := net.SplitHostPort(c.GRPC.ListenAddress); err != nil {
+			return errors.Wrap(err, "config: invalid grpc.listen_address")
+		}
+		if c.Auth.ServiceToken == "" {
+			return errors.New("config: auth.service_token must be set to serve gRPC")
+		}
+	}
+
+	if c.GraphQL.MaxDepth <= 0 || c.GraphQL.MaxComplexity <= 0 {
+		return errors.New("config: graphql.max_depth and graphql.max_complexity must be positive")
+	}
+
+	for name, d := range map[string]Duration{
+		"database.conn_max_lifetime": c.Database.ConnMaxLifetime,
+		"server.read_timeout":        c.Server.ReadTimeout,
+		"server.write_timeout":       c.Server.WriteTimeout,
+		"server.idle_timeout":        c.Server.IdleTimeout,
+	} {
+		if d.Duration < 0 {
+			return errors.Errorf("config: %s must not be negative", name)
+		}
+	}
+	// Unlike the others, a zero shutdown timeout does not mean none: it
+	// would cut off every request in flight.
+	if c.Server.ShutdownTimeout.Duration <= 0 {
+		return errors.New("config: server.shutdown_timeout must be positive")
+	}
+
+	if c.Tokens.ExpiryInterval.Duration <= 0 {
+		return errors.New("config: tokens.expiry_interval must be positive")
+	}
+
+	if c.Webhooks.Timeout.Duration <= 0 || c.Webhooks.PollInterval.Duration <= 0 {
+		return errors.New("config: webhooks.timeout and webhooks.poll_interval must be positive")
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		return errors.New("config: webhooks.max_attempts must be at least 1")
+	}
+
+	switch c.Relay.Sink {
+	case "":
+	case "file":
+		if c.Relay.Path == "" {
+			return errors.New("config: relay.path must be set for the file sink")
+		}
+	default:
+		return errors.Errorf("config: unknown relay.sink %q", c.Relay.Sink)
+	}
+	if c.Relay.PollInterval.Duration <= 0 || c.Relay.CursorExpiry.Duration <= 0 {
+		return errors.New("config: relay.poll_interval and relay.cursor_expiry must be positive")
+	}
+
+	switch c.Limits.Store {
+	case "memory":
+	case "postgres":
+		if c.Database.Backend != "postgres" {
+			return errors.New("config: limits.store postgres needs the postgres database backend")
+		}
+	default:
+		return errors.Errorf("config: unknown limits.store %q", c.Limits.Store)
+	}
+	for name, limit := range map[string]Limit{
+		"limits.spends":    c.Limits.Spends,
+		"limits.grants":    c.Limits.Grants,
+		"limits.transfers": c.Limits.Transfers,
+	} {
+		if limit.Max < 0 {
+			return errors.Errorf("config: %s.max must not be negative", name)
+		}
+		if limit.Max > 0 && limit.Per.Duration <= 0 {
+			return errors.Errorf("config: %s.per must be positive", name)
+		}
+	}
+
+	if c.Fraud.PollInterval.Duration <= 0 {
+		return errors.New("config: fraud.poll_interval must be positive")
+	}
+	for name, rule := range map[string]FraudRule{
+		"fraud.transfer_fan_in":  c.Fraud.TransferFanIn,
+		"fraud.grant_then_spend": c.Fraud.GrantThenSpend,
+		"fraud.new_user_fan_out": c.Fraud.NewUserFanOut,
+	} {
+		if rule.Threshold < 0 {
+			return errors.Errorf("config: %s.threshold must not be negative", name)
+		}
+		if rule.Threshold > 0 && rule.Window.Duration <= 0 {
+			return errors.Errorf("config: %s.window must be positive", name)
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("config: tls.cert_file and tls.key_file must be set together")
+	}
+
+	return nil
+}
+
+const redacted = "REDACTED"
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted returns the configuration as TOML with secrets masked, suitable
+// for printing or logging.
+func (c *Config) Redacted() string {
+	masked := *c
+	masked.Database.DSN = redactDSN(c.Database.DSN)
+	if masked.Auth.AdminToken != "" {
+		masked.Auth.AdminToken = redacted
+	}
+	if masked.Auth.ServiceToken != "" {
+		masked.Auth.ServiceToken = redacted
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(masked); err != nil {
+		return "config: unable to encode: " + err.Error()
+	}
+	return buf.String()
+}
+
+// redactDSN masks the password in both URL and key=value connection strings.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+			}
+		}
+		if q := u.Query(); q.Get("password") != "" {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"encoding"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the name of every environment variable the config reads.
+// A setting's variable is its file path in upper case, e.g. database.dsn is
+// TOKENIZER_DATABASE_DSN and features.foo is TOKENIZER_FEATURES_FOO.
+const EnvPrefix = "TOKENIZER_"
+
+// Flags holds the command-line overrides registered by RegisterFlags.
+type Flags struct {
+	fs *flag.FlagSet
+
+	path    string
+	backend string
+	dsn     string
+	listen  string
+	migrate bool
+}
+
+// RegisterFlags adds the config flags to fs. Call Load after fs is parsed.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+
+	fs.StringVar(&f.path, "config", os.Getenv(EnvPrefix+"CONFIG"), "path to a TOML or YAML config file")
+	fs.StringVar(&f.backend, "backend", "", "storage backend to use (postgres or sqlite3)")
+	fs.StringVar(&f.dsn, "dsn", "", "data source name passed to the backend's driver")
+	fs.StringVar(&f.listen, "listen", "", "address to serve HTTP on")
+	fs.BoolVar(&f.migrate, "migrate", false, "apply pending schema migrations before serving")
+
+	return f
+}
+
+// Load builds and validates the configuration from every source.
+func (f *Flags) Load() (*Config, error) {
+	c := Default()
+
+	if f.path != "" {
+		if err := c.loadFile(f.path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.loadEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+
+	// Only flags given explicitly override, so that their zero values do not
+	// clobber the file and environment.
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "backend":
+			c.Database.Backend = f.backend
+		case "dsn":
+			c.Database.DSN = f.dsn
+		case "listen":
+			c.Server.ListenAddress = f.listen
+		case "migrate":
+			c.Database.MigrateOnStart = f.migrate
+		}
+	})
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "config: unable to read file")
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		var md toml.MetaData
+		if md, err = toml.Decode(string(contents), c); err == nil {
+			err = undecoded(md)
+		}
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(contents, c)
+	default:
+		return errors.Errorf("config: %s is neither .toml nor .yaml", path)
+	}
+
+	return errors.Wrapf(err, "config: unable to parse %s", path)
+}
+
+// undecoded rejects keys that match no setting, as yaml.UnmarshalStrict does,
+// so that a misspelt key is reported rather than ignored.
+func undecoded(md toml.MetaData) error {
+	keys := md.Undecoded()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = key.String()
+	}
+	return errors.Errorf("unknown keys %s", strings.Join(names, ", "))
+}
+
+// loadEnv applies TOKENIZER_* variables from environ over c.
+func (c *Config) loadEnv(environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.IndexByte(kv, '='); i > 0 && strings.HasPrefix(kv[:i], EnvPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	if err := setFromEnv(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"), env); err != nil {
+		return err
+	}
+
+	featurePrefix := EnvPrefix + "FEATURES_"
+	for name, value := range env {
+		if !strings.HasPrefix(name, featurePrefix) {
+			continue
+		}
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Wrapf(err, "config: invalid %s", name)
+		}
+		if c.Features == nil {
+			c.Features = make(map[string]bool)
+		}
+		c.Features[strings.ToLower(strings.TrimPrefix(name, featurePrefix))] = on
+	}
+
+	return nil
+}
+
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// setFromEnv walks the struct v, naming each field after its toml tag.
+func setFromEnv(v reflect.Value, prefix string, env map[string]string) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		name := prefix + "_" + strings.ToUpper(t.Field(i).Tag.Get("toml"))
+
+		if field.Kind() == reflect.Struct && !field.Addr().Type().Implements(textUnmarshaler) {
+			if err := setFromEnv(field, name, env); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := env[name]
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch {
+		case field.Addr().Type().Implements(textUnmarshaler):
+			err = field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+		case field.Kind() == reflect.String:
+			field.SetString(value)
+		case field.Kind() == reflect.Int:
+			var n int64
+			if n, err = strconv.ParseInt(value, 10, 0); err == nil {
+				field.SetInt(n)
+			}
+		case field.Kind() == reflect.Bool:
+			var b bool
+			if b, err = strconv.ParseBool(value); err == nil {
+				field.SetBool(b)
+			}
+		default:
+			continue
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "config: invalid %s", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/pubsub"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+const (
+	eventStreamType = "text/event-stream"
+
+	// heartbeatInterval is how often an idle stream sends a comment, so that
+	// proxies do not time it out.
+	heartbeatInterval = 15 * time.Second
+
+	// replayBatch is how many missed events are read from storage at once.
+	replayBatch = 500
+
+	// eventRetention is how long events are kept to resume streams from.
+	// A client that has been away for longer misses what happened meanwhile,
+	// and should list the user's holdings again.
+	eventRetention = 24 * time.Hour
+)
+
+// balanceEvents is fed every balance change as it commits, both from this
+// process and, on backends that support it, from the others.
+var balanceEvents = pubsub.New(pubsub.DefaultBuffer)
+
+// getUserEvents streams the changes to a user's holdings as Server-Sent
+// Events, as they commit. Each event's id is its position in the history, so
+// a client that reconnects with Last-Event-ID is first sent whatever it
+// missed.
+func getUserEvents(w http.ResponseWriter, r *http.Request) {
+	var userID,err = pathID(r, "uid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var after int64 = -1
+
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		after,err = strconv.ParseInt(lastEventID, 10, 64)
+
+		if err != nil || after < 0 {
+			apierror.Write(w, r, apierror.BadRequest(apierror.CodeValidation, "Last-Event-ID must be the id of an event"))
+			return
+		}
+	}
+
+	if _,err = service.GetUser(userID); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	// Subscribing before reading the history means nothing can commit in
+	// between unseen; anything seen twice is skipped by id.
+	var subscription = balanceEvents.Subscribe(userID)
+
+	if after < 0 {
+		if after,err = storage.LastEventID(); err != nil {
+			subscription.Close()
+			apierror.Write(w, r, err)
+			return
+		}
+	}
+
+	// Streams outlive the server's write timeout.
+	var controller = http.NewResponseController(w)
+
+	controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", eventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+
+	var stream = &eventStream{w: w, controller: controller, userID: userID, last: after}
+
+	// Streams end when the client goes away, or when the server shuts down.
+	var ctx,cancel = untilShutdown(r)
+	defer cancel()
+
+	for {
+		err = stream.run(ctx, subscription)
+		subscription.Close()
+
+		if err != nil {
+			logging.Error(r, "event stream failed", err)
+			return
+		}
+
+		if !subscription.Dropped() {
+			return
+		}
+
+		// The client fell behind. Start again from the last event it was
+		// sent, reading what it missed from storage.
+		subscription = balanceEvents.Subscribe(userID)
+	}
+}
+
+// eventStream writes one user's events to a client.
+type eventStream struct {
+	w		http.ResponseWriter
+	controller	*http.ResponseController
+	userID		string
+
+	// last is the id of the last event sent.
+	last	int64
+}
+
+// run sends the events stored after the last one sent, and again whenever
+// subscription announces a newer one, until the client goes away or
+// subscription is closed.
+//
+// Events are always read back from storage, where their ids are in commit
+// order, rather than sent as they are published: two changes committed one
+// after the other can be published the other way round, and sending the
+// later one first would make the client skip the earlier one on resume.
+func (s *eventStream) run(ctx context.Context, subscription *pubsub.Subscription) error {
+	if err := s.replay(); err != nil {
+		return err
+	}
+
+	var heartbeat = time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-heartbeat.C:
+			if _,err := fmt.Fprint(s.w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			s.controller.Flush()
+
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return nil
+			}
+
+			if event.ID <= s.last {
+				continue
+			}
+
+			if err := s.replay(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// replay sends every stored event after the last one sent.
+func (s *eventStream) replay() error {
+	for {
+		var events,err = storage.EventsAfter(s.userID, s.last, replayBatch)
+
+		if err != nil {
+			return err
+		}
+
+		for _,event := range events {
+			if err = s.send(event); err != nil {
+				return nil
+			}
+		}
+		s.controller.Flush()
+
+		if len(events) < replayBatch {
+			return nil
+		}
+	}
+}
+
+// send writes event in the text/event-stream format. Write errors mean the
+// client has gone, which the request context reports too.
+func (s *eventStream) send(event storage.Event) error {
+	var data,err = json.Marshal(v1BalanceEvent(event))
+
+	if err != nil {
+		return err
+	}
+
+	if _,err = fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+		return err
+	}
+
+	s.last = event.ID
+
+	return nil
+}
+
+// relayEvents passes the events the backend hears of from other instances to
+// balanceEvents until ctx is done, reconnecting if the backend fails.
+func relayEvents(backend *storage.Backend, dsn string) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		for {
+			var err = backend.Listen(ctx, dsn, balanceEvents.Publish)
+
+			if err == nil {
+				return
+			}
+
+			log.Printf("unable to relay balance events: %v", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+// pruneEvents forgets events older than eventRetention every interval until
+// ctx is done, along with the cursors of relays that have not run for
+// cursorExpiry, which would otherwise keep the events from being forgotten.
+func pruneEvents(interval, cursorExpiry time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _,err := storage.PruneRelays(now.Add(-cursorExpiry)); err != nil {
+					log.Printf("unable to prune relay cursors: %v", err)
+				}
+
+				if _,err := storage.PruneEvents(now.Add(-eventRetention)); err != nil {
+					log.Printf("unable to prune balance events: %v", err)
+				}
+			}
+		}
+	}
+}
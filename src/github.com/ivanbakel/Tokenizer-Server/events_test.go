@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// openEventStream connects to a user's event stream, resuming after
+// lastEventID unless it is empty.
+func openEventStream(t *testing.T, ctx context.Context, url, lastEventID string) *bufio.Reader {
+	var req,err = http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp,err := http.DefaultClient.Do(req.WithContext(ctx))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != eventStreamType {
+		t.Fatalf("got %d %s, want 200 %s", resp.StatusCode, resp.Header.Get("Content-Type"), eventStreamType)
+	}
+
+	return bufio.NewReader(resp.Body)
+}
+
+// readEvent reads the next event from a stream, skipping comments.
+func readEvent(t *testing.T, stream *bufio.Reader) (id, name string, data v1.BalanceEvent) {
+	for {
+		var line,err = stream.ReadString('\n')
+
+		if err != nil {
+			t.Fatal("stream ended:", err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && id != "":
+			return id, name, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestUserEvents(t *testing.T) {
+	var server = newTestServer(t)
+	var db = boil.GetDB()
+
+	storage.AddListener(balanceEvents.Publish)
+
+	var org = &models.Organisation{Name: "events org"}
+
+	if err := org.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var token = &models.Token{Name: "events token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+
+	if err := token.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var alice, bob = &models.User{FacebookID: "alice"}, &models.User{FacebookID: "bob"}
+
+	for _,user := range []*models.User{alice, bob} {
+		if err := user.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _,err := storage.Grant(token.ID, 3, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var ctx,cancel = context.WithTimeout(context.Background(), 10 * time.Second)
+	defer cancel()
+
+	var url = server.URL + "/v1/users/" + alice.ID + "/events"
+
+	// Resuming from the start replays the grant, then carries on live.
+	var stream = openEventStream(t, ctx, url, "0")
+
+	var grantID,name,grant = readEvent(t, stream)
+
+	if name != "granted" || grant.Balance != 3 || grant.Number != 3 || grant.UserID != alice.ID {
+		t.Errorf("unexpected replayed event %s %+v", name, grant)
+	}
+
+	if _,_,err := storage.Transfer(token.ID, alice.ID, bob.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var transferID,_,transfer = readEvent(t, stream)
+
+	if transfer.Type != "transferred" || transfer.FromUserID != alice.ID || transfer.FromBalance == nil || *transfer.FromBalance != 2 {
+		t.Errorf("unexpected live event %+v", transfer)
+	}
+
+	if id,_ := strconv.ParseInt(transferID, 10, 64); id <= grant.ID {
+		t.Errorf("want event ids to increase, got %s after %s", transferID, grantID)
+	}
+
+	// Resuming after the grant skips straight to the transfer.
+	stream = openEventStream(t, ctx, url, grantID)
+
+	if id,_,_ := readEvent(t, stream); id != transferID {
+		t.Errorf("want to resume at event %s, got %s", transferID, id)
+	}
+
+	// Without Last-Event-ID, only changes from now on are sent.
+	stream = openEventStream(t, ctx, url, "")
+
+	if _,err := storage.Spend(alice.ID, token.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,name,spend := readEvent(t, stream); name != "spent" || spend.Balance != 0 {
+		t.Errorf("want the spend first on a fresh stream, got %s %+v", name, spend)
+	}
+}
+
+func TestUserEventsRejectsBadLastEventID(t *testing.T) {
+	var w = httptest.NewRecorder()
+	var req = httptest.NewRequest("GET", "/v1/users/5e3bb0d4-2f3c-4b7e-8f5e-1b1f0a4ce0a1/events", nil)
+
+	req.Header.Set("Last-Event-ID", "yesterday")
+
+	newTestRouter().ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestUserEventsEndOnShutdown(t *testing.T) {
+	var handler = newTestServer(t).Config.Handler
+	var server = httptest.NewUnstartedServer(handler)
+
+	closeStreamsOnShutdown(server.Config)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	var user = &models.User{FacebookID: "streamer"}
+
+	if err := user.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	openEventStream(t, context.Background(), server.URL + "/v1/users/" + user.ID + "/events", "")
+
+	var ctx,cancel = context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	var start = time.Now()
+
+	if err := server.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("want the open stream to end on shutdown, got %v", err)
+	}
+
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("want shutdown to end the stream at once, waited %v", waited)
+	}
+}
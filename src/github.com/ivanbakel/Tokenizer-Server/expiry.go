@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// expireTokens empties holdings of expired tokens every interval until ctx
+// is done.
+func expireTokens(interval time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				var events,err = storage.Expire(now)
+
+				if err != nil {
+					log.Printf("unable to expire tokens: %v", err)
+				} else if len(events) > 0 {
+					log.Printf("expired %d holdings", len(events))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"github.com/ivanbakel/Tokenizer-Server/fraud"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+)
+
+// newFraudDetector returns a detector running the rules cfg configures.
+func newFraudDetector(cfg config.Fraud) *fraud.Detector {
+	var rule = func(r config.FraudRule) fraud.Rule {
+		return fraud.Rule{Threshold: r.Threshold, Window: r.Window.Duration, Freeze: r.Freeze}
+	}
+
+	return fraud.New(fraud.Rules{
+		TransferFanIn:	rule(cfg.TransferFanIn),
+		GrantThenSpend:	rule(cfg.GrantThenSpend),
+		NewUserFanOut:	rule(cfg.NewUserFanOut),
+	})
+}
+
+func getFraudAlerts(w http.ResponseWriter, r *http.Request) {
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var alerts,next,err = service.ListFraudAlerts(q)
+
+		var items = make([]interface{}, len(alerts))
+
+		for i,alert := range alerts {
+			items[i] = v1FraudAlert(alert)
+		}
+
+		return items, next, err
+	})
+}
+
+func getFraudAlert(w http.ResponseWriter, r *http.Request) {
+	var alertID,err = pathID(r, "aid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	alert,err := service.GetFraudAlert(alertID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1FraudAlert(alert))
+}
+
+// unfreezeUser lifts a freeze on a user, and answers with the user as they
+// now stand.
+func unfreezeUser(w http.ResponseWriter, r *http.Request) {
+	var userID,err = pathID(r, "uid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	user,err := service.UnfreezeUser(userID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1User(user))
+}
+
+// unfreezeHolding lifts a freeze on one of a user's holdings, and answers
+// with the holding as it now stands.
+func unfreezeHolding(w http.ResponseWriter, r *http.Request) {
+	var userID,err = pathID(r, "uid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	tokenID,err := pathID(r, "tid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	holding,err := service.UnfreezeHolding(userID, tokenID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1Holding(holding))
+}
@@ -0,0 +1,128 @@
+// Package fraud looks for patterns of abuse in the balance event log. A
+// Detector is a relay.Sink, so it sees every committed event in order,
+// whichever instance recorded it, and carries on where it left off after a
+// restart.
+//
+// When an event completes a pattern, the rule that matched raises an alert,
+// and if it is set to, freezes the user or holding involved. Frozen units
+// cannot be spent or transferred until an admin unfreezes them.
+package fraud
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// The names alerts give the rules.
+const (
+	RuleTransferFanIn  = "transfer_fan_in"
+	RuleGrantThenSpend = "grant_then_spend"
+	RuleNewUserFanOut  = "new_user_fan_out"
+)
+
+// Rule matches when Threshold or more are counted within Window of an event.
+// A zero Threshold switches the rule off. Freeze freezes what the rule
+// concerns as well as raising the alert.
+type Rule struct {
+	Threshold int
+	Window    time.Duration
+	Freeze    bool
+}
+
+// Rules configures each of the rules.
+type Rules struct {
+	// TransferFanIn counts the users sending transfers to one user, and
+	// freezes the recipient.
+	TransferFanIn Rule
+	// GrantThenSpend counts the units of a token granted to a user who then
+	// spends their whole holding, and freezes the holding.
+	GrantThenSpend Rule
+	// NewUserFanOut counts the new users one user sends transfers to, new
+	// meaning that nothing about them is logged from before the window, and
+	// freezes the sender.
+	NewUserFanOut Rule
+}
+
+// Detector runs the rules over the events it is given.
+type Detector struct {
+	rules Rules
+}
+
+// New returns a detector running rules.
+func New(rules Rules) *Detector {
+	return &Detector{rules: rules}
+}
+
+// Publish runs the rules over each event in turn. It is a relay.Sink.
+func (d *Detector) Publish(ctx context.Context, events []storage.Event) error {
+	for _, event := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.Inspect(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Inspect runs the rules that event could complete a pattern for.
+func (d *Detector) Inspect(event storage.Event) error {
+	switch event.Type {
+	case storage.EventTransferred:
+		err := d.check(RuleTransferFanIn, d.rules.TransferFanIn, event, event.UserID, storage.FrozeUser,
+			func(since time.Time) (int, error) {
+				return storage.CountSenders(event.UserID, since, event.ID)
+			})
+		if err != nil {
+			return err
+		}
+
+		return d.check(RuleNewUserFanOut, d.rules.NewUserFanOut, event, event.FromUserID, storage.FrozeUser,
+			func(since time.Time) (int, error) {
+				return storage.CountNewRecipients(event.FromUserID, since, event.ID)
+			})
+
+	case storage.EventSpent:
+		if event.Balance != 0 {
+			return nil
+		}
+
+		return d.check(RuleGrantThenSpend, d.rules.GrantThenSpend, event, event.UserID, storage.FrozeHolding,
+			func(since time.Time) (int, error) {
+				return storage.CountGranted(event.UserID, event.TokenID, since, event.ID)
+			})
+	}
+
+	return nil
+}
+
+// check raises an alert about userID's holding of the event's token if rule
+// counts enough in the window before event. freeze is what the alert
+// freezes, if the rule is set to.
+func (d *Detector) check(name string, rule Rule, event storage.Event, userID, freeze string, count func(since time.Time) (int, error)) error {
+	if rule.Threshold <= 0 {
+		return nil
+	}
+
+	since := event.At.Add(-rule.Window)
+
+	n, err := count(since)
+	if err != nil || n < rule.Threshold {
+		return err
+	}
+
+	alert := &storage.Alert{Rule: name, UserID: userID, TokenID: event.TokenID, EventID: event.ID, Count: n, At: event.At}
+	if rule.Freeze {
+		alert.Frozen = freeze
+	}
+
+	raised, err := storage.RaiseAlert(alert, since)
+	if raised {
+		log.Printf("fraud: %s matched %d at event %d for user %s", name, n, event.ID, userID)
+	}
+	return err
+}
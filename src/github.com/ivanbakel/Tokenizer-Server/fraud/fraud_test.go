@@ -0,0 +1,173 @@
+package fraud
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// setup opens a fresh SQLite database with a token and n users.
+func setup(t *testing.T, n int) (token string, users []string) {
+	dir, err := ioutil.TempDir("", "fraud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := storage.Open(storage.SQLite.Name, filepath.Join(dir, "tokenizer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err = migrations.Up(db, storage.SQLite.Migrations); err != nil {
+		t.Fatal(err)
+	}
+	boil.SetDB(db)
+
+	org := &models.Organisation{Name: "fraud org"}
+	if err = org.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	tok := &models.Token{Name: "fraud token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+	if err = tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		user := &models.User{FacebookID: "user" + string(rune('a'+i))}
+		if err = user.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user.ID)
+	}
+	return tok.ID, users
+}
+
+// inspect publishes the whole event log to d, twice over, as a relay may.
+func inspect(t *testing.T, d *Detector) {
+	events, err := storage.EventLog(0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = d.Publish(context.Background(), events); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func alerts(t *testing.T, rule, userID string) int {
+	var n int
+	err := boil.GetDB().QueryRow(`SELECT COUNT(*) FROM "fraud_alerts" WHERE "rule" = $1 AND "user_id" = $2`, rule, userID).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestTransferFanIn(t *testing.T) {
+	token, users := setup(t, 4)
+	mule := users[3]
+
+	if _, err := storage.Grant(token, 2, users[:3]...); err != nil {
+		t.Fatal(err)
+	}
+	for _, sender := range users[:3] {
+		if _, _, err := storage.Transfer(token, sender, mule, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inspect(t, New(Rules{TransferFanIn: Rule{Threshold: 3, Window: time.Hour, Freeze: true}}))
+
+	if n := alerts(t, RuleTransferFanIn, mule); n != 1 {
+		t.Errorf("want one alert about the recipient, got %d", n)
+	}
+	if _, err := storage.Spend(mule, token, 1); err != storage.ErrUserFrozen {
+		t.Error("want the recipient frozen, got", err)
+	}
+	if _, err := storage.Spend(users[0], token, 1); err != nil {
+		t.Error("want the senders left alone, got", err)
+	}
+}
+
+func TestGrantThenSpend(t *testing.T) {
+	token, users := setup(t, 2)
+	spender, saver := users[0], users[1]
+
+	if _, err := storage.Grant(token, 5, spender, saver); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Spend(spender, token, 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Spend(saver, token, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	inspect(t, New(Rules{GrantThenSpend: Rule{Threshold: 5, Window: time.Minute, Freeze: true}}))
+
+	if n := alerts(t, RuleGrantThenSpend, spender); n != 1 {
+		t.Errorf("want one alert about the spender, got %d", n)
+	}
+	if n := alerts(t, RuleGrantThenSpend, saver); n != 0 {
+		t.Errorf("want no alert about a partial spend, got %d", n)
+	}
+
+	holding, err := models.FindUserToken(boil.GetDB(), spender, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !holding.FrozenAt.Valid {
+		t.Error("want the holding frozen")
+	}
+	user, err := models.FindUser(boil.GetDB(), spender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FrozenAt.Valid {
+		t.Error("want only the holding frozen, not the user")
+	}
+}
+
+func TestNewUserFanOut(t *testing.T) {
+	token, users := setup(t, 4)
+	sender := users[0]
+
+	if _, err := storage.Grant(token, 3, sender); err != nil {
+		t.Fatal(err)
+	}
+	for _, recipient := range users[1:] {
+		if _, _, err := storage.Transfer(token, sender, recipient, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Switched off, the rule matches nothing.
+	inspect(t, New(Rules{}))
+	if n := alerts(t, RuleNewUserFanOut, sender); n != 0 {
+		t.Fatalf("want no alert from a disabled rule, got %d", n)
+	}
+
+	inspect(t, New(Rules{NewUserFanOut: Rule{Threshold: 3, Window: time.Hour}}))
+
+	if n := alerts(t, RuleNewUserFanOut, sender); n != 1 {
+		t.Errorf("want one alert about the sender, got %d", n)
+	}
+	user, err := models.FindUser(boil.GetDB(), sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FrozenAt.Valid {
+		t.Error("want nothing frozen by a rule that only alerts")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/client"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+func TestFraudAlerts(t *testing.T) {
+	var server = newTestServer(t)
+	var c = client.New(server.URL, client.WithRetries(0, 0))
+	var ctx = context.Background()
+
+	var org = &models.Organisation{Name: "fraud org"}
+
+	if err := org.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var token = &models.Token{Name: "fraud token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+
+	if err := token.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var alice,bob = &models.User{FacebookID: "alice"}, &models.User{FacebookID: "bob"}
+
+	for _,user := range []*models.User{alice, bob} {
+		if err := user.Insert(boil.GetDB()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _,err := c.GrantUser(ctx, token.ID, alice.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err := c.Spend(ctx, alice.ID, token.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg = config.Default().Fraud
+
+	cfg.GrantThenSpend.Freeze = true
+
+	events,err := storage.EventLog(0, 100)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = newFraudDetector(cfg).Publish(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err = c.GrantUser(ctx, token.ID, alice.ID, 2); err != nil {
+		t.Errorf("want a frozen holding credited, got %v", err)
+	}
+
+	if _,err = c.Spend(ctx, alice.ID, token.ID, 1); !errors.Is(err, client.ErrHoldingFrozen) {
+		t.Errorf("want the spend refused as frozen, got %v", err)
+	}
+
+	if _,err = c.Transfer(ctx, token.ID, alice.ID, bob.ID, 1); !errors.Is(err, client.ErrHoldingFrozen) {
+		t.Errorf("want the transfer refused as frozen, got %v", err)
+	}
+
+	if status := bearerCall(t, "wrong", http.MethodGet, server.URL + "/v1/fraud-alerts", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("want alerts hidden from non-admins, got %d", status)
+	}
+
+	var alerts struct{ Items []v1.FraudAlert }
+
+	if status := adminCall(t, http.MethodGet, server.URL + "/v1/fraud-alerts?rule=grant_then_spend", nil, &alerts); status != http.StatusOK {
+		t.Fatalf("want 200 listing alerts, got %d", status)
+	}
+
+	if len(alerts.Items) != 1 {
+		t.Fatalf("want one alert, got %+v", alerts.Items)
+	}
+
+	if a := alerts.Items[0]; a.UserID != alice.ID || a.TokenID != token.ID || a.Count != 5 || a.Frozen != storage.FrozeHolding {
+		t.Errorf("wrong alert %+v", a)
+	}
+
+	var alert v1.FraudAlert
+
+	if status := adminCall(t, http.MethodGet, server.URL + "/v1/fraud-alerts/" + alerts.Items[0].ID, nil, &alert); status != http.StatusOK || alert != alerts.Items[0] {
+		t.Errorf("want the alert, got %d %+v", status, alert)
+	}
+
+	var holding v1.Holding
+
+	var url = server.URL + "/v1/users/" + alice.ID + "/tokens/" + token.ID + "/unfreeze"
+
+	if status := adminCall(t, http.MethodPost, url, nil, &holding); status != http.StatusOK || holding.FrozenAt != nil || holding.Number != 2 {
+		t.Fatalf("want the holding unfrozen, got %d %+v", status, holding)
+	}
+
+	if _,err = c.Spend(ctx, alice.ID, token.ID, 1); err != nil {
+		t.Errorf("want an unfrozen holding spent, got %v", err)
+	}
+
+	if status := adminCall(t, http.MethodPost, server.URL + "/v1/users/" + storage.NewUUID() + "/unfreeze", nil, nil); status != http.StatusNotFound {
+		t.Errorf("want 404 unfreezing a missing user, got %d", status)
+	}
+}
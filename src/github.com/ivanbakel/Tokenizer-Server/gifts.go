@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+func createGiftBatch(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	var request v1.NewGiftBatch
+
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	batch,err := service.CreateGiftBatch(orgID, service.NewGiftBatch{
+		TokenID:	request.TokenID,
+		Number:		request.Number,
+		Count:		request.Count,
+	})
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1GiftBatch(batch))
+}
+
+func getGiftBatches(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var batches,next,err = service.ListGiftBatches(orgID, q)
+
+		var items = make([]interface{}, len(batches))
+
+		for i,batch := range batches {
+			items[i] = v1GiftBatch(batch)
+		}
+
+		return items, next, err
+	})
+}
+
+func getGiftBatch(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	batchID,err := pathID(r, "bid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	batch,err := service.GetGiftBatch(orgID, batchID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1GiftBatch(batch))
+}
+
+func getGiftCodes(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	batchID,err := pathID(r, "bid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var codes,next,err = service.ListGiftCodes(orgID, batchID, q)
+
+		var items = make([]interface{}, len(codes))
+
+		for i,code := range codes {
+			items[i] = v1GiftCode(code)
+		}
+
+		return items, next, err
+	})
+}
+
+// exportGiftCodes writes every code of a batch as CSV, one row per card, for
+// the printer. The batch is checked before anything is written, so that a
+// bad request still gets a problem response; an error once rows have been
+// sent can only cut the file short.
+func exportGiftCodes(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	batchID,err := pathID(r, "bid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	batch,err := service.GetGiftBatch(orgID, batchID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	token,err := service.GetToken(batch.TokenID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=gift-codes-" + batch.ID + ".csv")
+
+	var writer = csv.NewWriter(w)
+	var number = strconv.Itoa(int(batch.Number))
+	var expires = token.Expires.UTC().Format(time.RFC3339)
+
+	writer.Write([]string{"code", "token_id", "number", "expires", "claimed_at"})
+
+	err = service.ExportGiftCodes(orgID, batchID, func(code storage.GiftCode) error {
+		var claimedAt = ""
+
+		if code.ClaimedAt.Valid {
+			claimedAt = code.ClaimedAt.Time.UTC().Format(time.RFC3339)
+		}
+
+		return writer.Write([]string{service.FormatGiftCode(code.Code), batch.TokenID, number, expires, claimedAt})
+	})
+
+	writer.Flush()
+
+	if err == nil {
+		err = writer.Error()
+	}
+
+	if err != nil {
+		logging.Error(r, "gift code export failed", err)
+	}
+}
+
+// revokeGiftBatch stops a batch's unclaimed codes from being claimed, and
+// answers with the batch, so the caller sees how many were left.
+func revokeGiftBatch(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	batchID,err := pathID(r, "bid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	batch,err := service.RevokeGiftBatch(orgID, batchID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1GiftBatch(batch))
+}
+
+func claimGiftCode(w http.ResponseWriter, r *http.Request) {
+	var userID,err = pathID(r, "uid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var request v1.ClaimRequest
+
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	batch,holding,err := service.ClaimGiftCode(userID, request.Code)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", batch.OrgID)
+
+	var result = v1.GiftClaim{
+		Code:		service.FormatGiftCode(service.NormaliseCode(request.Code)),
+		BatchID:	batch.ID,
+		TokenID:	batch.TokenID,
+		Number:		batch.Number,
+		Holding:	v1Holding(holding),
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(result)
+}
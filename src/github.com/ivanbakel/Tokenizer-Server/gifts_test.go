@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// exportCSV fetches a batch's codes as CSV with the given org key.
+func exportCSV(t *testing.T, url, key string) [][]string {
+	var req,err = http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer " + key)
+
+	resp,err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
+		t.Fatalf("want CSV, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	rows,err := csv.NewReader(resp.Body).ReadAll()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rows
+}
+
+func TestGiftCodes(t *testing.T) {
+	var server = newTestServer(t)
+	var db = boil.GetDB()
+
+	var org = &models.Organisation{Name: "generous org"}
+
+	if err := org.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var key v1.OrgKey
+
+	if status := adminCall(t, "POST", server.URL + "/v1/orgs/" + org.ID + "/keys", v1.NewOrgKey{Name: "printer"}, &key); status != http.StatusCreated {
+		t.Fatalf("create key status = %d, want 201", status)
+	}
+
+	var token = &models.Token{Name: "cake", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+
+	if err := token.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var users [2]*models.User
+
+	for i := range users {
+		users[i] = &models.User{FacebookID: "lucky user " + string(rune('a' + i))}
+
+		if err := users[i].Insert(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var batches = server.URL + "/v1/orgs/" + org.ID + "/gift-batches"
+
+	if status := bearerCall(t, key.Key, "POST", batches, v1.NewGiftBatch{TokenID: token.ID, Number: 3, Count: 0}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("want an empty batch refused, got %d", status)
+	}
+
+	var batch v1.GiftBatch
+
+	if status := bearerCall(t, key.Key, "POST", batches, v1.NewGiftBatch{TokenID: token.ID, Number: 3, Count: 5}, &batch); status != http.StatusCreated {
+		t.Fatalf("create batch status = %d, want 201", status)
+	}
+
+	if batch.Size != 5 || batch.Unclaimed != 5 || batch.Number != 3 {
+		t.Errorf("want five unclaimed codes worth 3, got %+v", batch)
+	}
+
+	var rows = exportCSV(t, batches + "/" + batch.ID + "/codes.csv", key.Key)
+
+	if len(rows) != 6 || strings.Join(rows[0], ",") != "code,token_id,number,expires,claimed_at" {
+		t.Fatalf("want a header and five rows, got %v", rows)
+	}
+
+	var seen = make(map[string]bool)
+
+	for _,row := range rows[1:] {
+		if len(row[0]) != 19 || row[1] != token.ID || row[2] != "3" || row[4] != "" || seen[row[0]] {
+			t.Errorf("want a distinct unclaimed code for 3 cake, got %v", row)
+		}
+
+		seen[row[0]] = true
+	}
+
+	// Codes are read however they are typed, and only once.
+	var claims = server.URL + "/v1/users/" + users[0].ID + "/gift-codes/claim"
+	var typed = strings.ToLower(strings.Replace(rows[1][0], "-", "", 2))
+
+	var claim v1.GiftClaim
+
+	if status := adminCall(t, "POST", claims, v1.ClaimRequest{Code: typed}, &claim); status != http.StatusOK {
+		t.Fatalf("claim status = %d, want 200", status)
+	}
+
+	if claim.Code != rows[1][0] || claim.BatchID != batch.ID || claim.Holding.Number != 3 {
+		t.Errorf("want 3 cake credited for %s, got %+v", rows[1][0], claim)
+	}
+
+	if status,problem := redeem(t, server.URL + "/v1/users/" + users[1].ID + "/gift-codes/claim", "", rows[1][0]); status != http.StatusConflict || problem != "gift_code_claimed" {
+		t.Errorf("want a second claim refused, got %d %s", status, problem)
+	}
+
+	// A mistyped character is caught by the check character.
+	var mistyped = []byte(rows[2][0])
+
+	if mistyped[0] == 'A' {
+		mistyped[0] = 'B'
+	} else {
+		mistyped[0] = 'A'
+	}
+
+	if status,_ := redeem(t, claims, "", string(mistyped)); status != http.StatusUnprocessableEntity {
+		t.Errorf("want a mistyped code refused, got %d", status)
+	}
+
+	if status := adminCall(t, "POST", claims, v1.ClaimRequest{Code: rows[2][0]}, nil); status != http.StatusOK {
+		t.Fatalf("claim status = %d, want 200", status)
+	}
+
+	// Revoking stops the rest being claimed.
+	if status := bearerCall(t, key.Key, "POST", batches + "/" + batch.ID + "/revoke", nil, &batch); status != http.StatusOK || batch.RevokedAt == nil {
+		t.Fatalf("want the batch revoked, got %d %+v", status, batch)
+	}
+
+	if status,problem := redeem(t, claims, "", rows[3][0]); status != http.StatusConflict || problem != "gift_code_revoked" {
+		t.Errorf("want a revoked code refused, got %d %s", status, problem)
+	}
+
+	if status := bearerCall(t, key.Key, "GET", batches + "/" + batch.ID, nil, &batch); status != http.StatusOK || batch.Claimed != 2 || batch.Unclaimed != 3 {
+		t.Errorf("want 2 claimed and 3 unclaimed, got %d %+v", status, batch)
+	}
+
+	var claimedPage,unclaimedPage struct {
+		Items	[]v1.GiftCode	`json:"items"`
+	}
+
+	if status := bearerCall(t, key.Key, "GET", batches + "/" + batch.ID + "/codes?claimed=true", nil, &claimedPage); status != http.StatusOK || len(claimedPage.Items) != 2 || claimedPage.Items[0].ClaimedBy != users[0].ID {
+		t.Errorf("want the two claimed codes listed, got %d %+v", status, claimedPage.Items)
+	}
+
+	if status := bearerCall(t, key.Key, "GET", batches + "/" + batch.ID + "/codes?claimed=false", nil, &unclaimedPage); status != http.StatusOK || len(unclaimedPage.Items) != 3 || unclaimedPage.Items[0].ClaimedAt != nil {
+		t.Errorf("want the three unclaimed codes listed, got %d %+v", status, unclaimedPage.Items)
+	}
+
+	rows = exportCSV(t, batches + "/" + batch.ID + "/codes.csv", key.Key)
+
+	var claimed = 0
+
+	for _,row := range rows[1:] {
+		if row[4] != "" {
+			claimed++
+		}
+	}
+
+	if claimed != 2 {
+		t.Errorf("want two codes exported as claimed, got %d", claimed)
+	}
+
+	var list struct {
+		Items	[]v1.GiftBatch	`json:"items"`
+	}
+
+	if status := bearerCall(t, key.Key, "GET", batches + "?revoked=true", nil, &list); status != http.StatusOK || len(list.Items) != 1 || list.Items[0].ID != batch.ID {
+		t.Errorf("want the revoked batch listed, got %d %+v", status, list.Items)
+	}
+}
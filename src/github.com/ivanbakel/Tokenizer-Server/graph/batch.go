@@ -0,0 +1,79 @@
+package graph
+
+import (
+	"context"
+	"sync"
+)
+
+// Relationships are resolved one object at a time, but loaded for every
+// object fetched alongside it. The first time a relationship is asked of any
+// object in a batch, the matching sqlboiler Load* function fills it in for
+// the whole batch at once, in a single query. The objects loaded form the
+// next batch, so a query costs one database round trip per relationship per
+// level, however many objects each level holds.
+
+// batch is a slice of sibling objects, such as one page of organisations or
+// all the users of those organisations.
+type batch struct {
+	// objects is the typed slice, e.g. models.OrganisationSlice.
+	objects interface{}
+
+	mut    sync.Mutex
+	loaded map[string]error
+}
+
+// load runs fn for the named relationship, unless it has already run for
+// this batch, and returns the error it gave.
+func (b *batch) load(relationship string, fn func() error) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if err, done := b.loaded[relationship]; done {
+		return err
+	}
+
+	err := fn()
+	b.loaded[relationship] = err
+	return err
+}
+
+// batches remembers the batch of every object fetched for one request.
+type batches struct {
+	mut sync.Mutex
+	of  map[interface{}]*batch
+}
+
+type contextKey struct{}
+
+func withBatches(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, &batches{of: make(map[interface{}]*batch)})
+}
+
+// register makes objects, a slice of model pointers, a batch. elements lists
+// the same pointers, untyped.
+func register(ctx context.Context, objects interface{}, elements ...interface{}) {
+	bs := ctx.Value(contextKey{}).(*batches)
+	b := &batch{objects: objects, loaded: make(map[string]error)}
+
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	for _, element := range elements {
+		bs.of[element] = b
+	}
+}
+
+// batchOf returns the batch object was fetched in. Objects fetched on their
+// own are a batch of one, which single makes.
+func batchOf(ctx context.Context, object interface{}, single func() interface{}) *batch {
+	bs := ctx.Value(contextKey{}).(*batches)
+
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+
+	b, ok := bs.of[object]
+	if !ok {
+		b = &batch{objects: single(), loaded: make(map[string]error)}
+		bs.of[object] = b
+	}
+	return b
+}
@@ -0,0 +1,128 @@
+// Package graph serves a read-only GraphQL view of organisations, users and
+// tokens at /graphql, so that clients can fetch nested data in one request
+// rather than one per level.
+package graph
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+)
+
+// request is a GraphQL request, sent as a JSON body or, for GET, as query
+// parameters of the same names.
+type request struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// Handler serves GraphQL requests within limits.
+//
+// Requests that cannot run at all, because they do not parse, are not valid
+// against the schema or exceed the limits, are answered 400 with only
+// errors. Otherwise the answer is 200 with the data, and errors for any
+// fields that failed, each with the API's error code in extensions.code.
+func Handler(limits Limits) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := readRequest(r)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+
+		doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+		if err != nil {
+			writeErrors(w, gqlerrors.FormatError(err))
+			return
+		}
+
+		if validation := graphql.ValidateDocument(&Schema, doc, nil); !validation.IsValid {
+			writeErrors(w, validation.Errors...)
+			return
+		}
+
+		if err := limits.check(doc, req.OperationName, req.Variables); err != nil {
+			formatted := gqlerrors.NewFormattedError(err.Error())
+			formatted.Extensions = map[string]interface{}{"code": apierror.CodeInvalidQuery}
+			writeErrors(w, formatted)
+			return
+		}
+
+		result := graphql.Execute(graphql.ExecuteParams{
+			Schema:        Schema,
+			AST:           doc,
+			OperationName: req.OperationName,
+			Args:          req.Variables,
+			Context:       withBatches(r.Context()),
+		})
+
+		for i, formatted := range result.Errors {
+			result.Errors[i] = translate(r, formatted)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+func readRequest(r *http.Request) (request, error) {
+	var req request
+
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return req, apierror.MalformedJSON(apierror.CodeInvalidQuery, "variables", err)
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, apierror.MalformedJSON(apierror.CodeMalformedBody, "the request body", err)
+	}
+
+	if req.Query == "" {
+		return req, apierror.BadRequest(apierror.CodeInvalidQuery, "query must be set")
+	}
+	return req, nil
+}
+
+// translate reports a field's error as the REST API would, by code and
+// without revealing the cause of server errors, which are logged instead.
+func translate(r *http.Request, formatted gqlerrors.FormattedError) gqlerrors.FormattedError {
+	located, ok := formatted.OriginalError().(*gqlerrors.Error)
+	if !ok || located.OriginalError == nil {
+		return formatted
+	}
+
+	apiErr := apierror.From(located.OriginalError)
+	if apiErr.Status >= 500 {
+		logging.Error(r, "graphql field failed", located.OriginalError)
+	}
+
+	formatted.Message = apiErr.Detail
+	formatted.Extensions = map[string]interface{}{"code": apiErr.Code}
+	return formatted
+}
+
+func notFound(err error) bool {
+	return err != nil && apierror.From(err).Code == apierror.CodeNotFound
+}
+
+// writeErrors answers a request that could not run. The response has no
+// data entry at all, since nothing was executed.
+func writeErrors(w http.ResponseWriter, errs ...gqlerrors.FormattedError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+
+	json.NewEncoder(w).Encode(struct {
+		Errors []gqlerrors.FormattedError `json:"errors"`
+	}{errs})
+}
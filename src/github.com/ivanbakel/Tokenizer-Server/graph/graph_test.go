@@ -0,0 +1,142 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+)
+
+var testLimits = Limits{MaxDepth: 5, MaxComplexity: 2000}
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		query     string
+		variables map[string]interface{}
+		ok        bool
+	}{
+		{`{ organisations(limit: 5) { items { name orgUsers { id userTokens { number } } } } }`, nil, true},
+		{`{ user(id: "x") { userTokens { token { id } } } }`, nil, true},
+		// Six deep.
+		{`{ organisations(limit: 1) { items { orgUsers { userTokens { token { name } } } } } }`, nil, false},
+		// Fragments count as if written out.
+		{`{ organisations(limit: 1) { items { ...deep } } } fragment deep on Organisation { orgUsers { userTokens { token { id } } } }`, nil, false},
+		// 50 organisations by the default limit, each with 10 users with 10 holdings.
+		{`{ organisations { items { orgUsers { userTokens { number } } } } }`, nil, false},
+		{`query($n: Int) { organisations(limit: $n) { items { orgUsers { userTokens { number } } } } }`, map[string]interface{}{"n": float64(500)}, false},
+		{`query($n: Int) { organisations(limit: $n) { items { orgUsers { userTokens { number } } } } }`, map[string]interface{}{"n": float64(1)}, true},
+		// Introspection is free.
+		{`{ __schema { types { name fields { name type { name ofType { name ofType { name ofType { name } } } } } } } }`, nil, true},
+	}
+
+	for _, c := range cases {
+		doc, err := parser.Parse(parser.ParseParams{Source: c.query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if validation := graphql.ValidateDocument(&Schema, doc, nil); !validation.IsValid {
+			t.Fatalf("%s: %v", c.query, validation.Errors)
+		}
+
+		err = testLimits.check(doc, "", c.variables)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: want ok=%v, got %v", c.query, c.ok, err)
+		}
+	}
+}
+
+type response struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func serve(t *testing.T, r *http.Request) (int, response) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	Handler(testLimits).ServeHTTP(w, r)
+
+	var body response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+	return w.Code, body
+}
+
+func TestHandlerRejects(t *testing.T) {
+	t.Parallel()
+
+	queries := []string{
+		`{ organisations {`,
+		`{ organisations { items { owner } } }`,
+		`{ organisations { items { orgUsers { userTokens { number } } } } }`,
+	}
+
+	for _, query := range queries {
+		body, _ := json.Marshal(request{Query: query})
+		status, resp := serve(t, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body))))
+
+		if status != http.StatusBadRequest || len(resp.Errors) == 0 || resp.Data != nil {
+			t.Errorf("%s: want 400 with only errors, got %d %+v", query, status, resp)
+		}
+	}
+}
+
+func TestFieldErrorsHaveCodes(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape(`{ user(id: "42") { id } }`), nil)
+	status, resp := serve(t, r)
+
+	if status != http.StatusOK || len(resp.Errors) != 1 {
+		t.Fatalf("want 200 with one error, got %d %+v", status, resp)
+	}
+	if code := resp.Errors[0].Extensions["code"]; code != "invalid_id" {
+		t.Errorf("want code invalid_id, got %v", code)
+	}
+	if resp.Data["user"] != nil {
+		t.Errorf("want a null user, got %v", resp.Data["user"])
+	}
+}
+
+func TestBatchLoadsOnce(t *testing.T) {
+	t.Parallel()
+
+	ctx := withBatches(context.Background())
+	orgs := models.OrganisationSlice{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	registerOrganisations(ctx, orgs)
+
+	loads := 0
+	for _, org := range orgs {
+		b := batchOf(ctx, org, func() interface{} { t.Fatal("a registered object was not found"); return nil })
+		b.load("OrgUsers", func() error {
+			loads++
+			if got := b.objects.(models.OrganisationSlice); len(got) != len(orgs) {
+				t.Errorf("want the whole batch of %d, got %d", len(orgs), len(got))
+			}
+			return nil
+		})
+	}
+	if loads != 1 {
+		t.Errorf("want one load for the batch, got %d", loads)
+	}
+
+	// Objects fetched on their own are loaded on their own.
+	single := &models.Organisation{ID: "d"}
+	b := batchOf(ctx, single, func() interface{} { return models.OrganisationSlice{single} })
+	if got := b.objects.(models.OrganisationSlice); len(got) != 1 || got[0] != single {
+		t.Errorf("want a batch of just the organisation, got %v", got)
+	}
+}
@@ -0,0 +1,177 @@
+package graph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+)
+
+// Limits bound what a single query may ask for. Queries over either limit
+// are rejected before anything is read from the database.
+type Limits struct {
+	// MaxDepth is how deeply fields may be nested.
+	MaxDepth int
+	// MaxComplexity is the most fields a query may be estimated to resolve.
+	MaxComplexity int
+}
+
+// relationshipFanout is the number of rows a to-many relationship such as
+// orgUsers is assumed to hold when estimating complexity. They are not
+// paged, so the true number is not known until they have been loaded.
+const relationshipFanout = 10
+
+var relationships = map[string]bool{
+	"orgUsers":   true,
+	"orgTokens":  true,
+	"userTokens": true,
+}
+
+var pages = map[string]bool{
+	"organisations": true,
+	"users":         true,
+	"tokens":        true,
+}
+
+// cost measures the operation a query runs. Introspection fields, whose
+// names start with "__", are not counted, so that tools can read the schema
+// whatever the limits.
+type cost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// check fails if the operation exceeds limits. The document must already
+// have been validated, so that fragments are known not to form cycles.
+func (l Limits) check(doc *ast.Document, operationName string, variables map[string]interface{}) error {
+	c := cost{fragments: make(map[string]*ast.FragmentDefinition), variables: variables}
+	var operation *ast.OperationDefinition
+
+	for _, definition := range doc.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			c.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operation == nil && (operationName == "" || definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		}
+	}
+
+	if operation == nil {
+		return nil
+	}
+
+	if depth := c.depth(operation.SelectionSet); depth > l.MaxDepth {
+		return fmt.Errorf("the query is nested %d deep, more than the limit of %d", depth, l.MaxDepth)
+	}
+	if complexity := c.complexity(operation.SelectionSet, l.MaxComplexity); complexity > l.MaxComplexity {
+		return fmt.Errorf("the query may resolve more than %d fields", l.MaxComplexity)
+	}
+	return nil
+}
+
+func (c cost) depth(set *ast.SelectionSet) int {
+	if set == nil {
+		return 0
+	}
+
+	deepest := 0
+	for _, selection := range set.Selections {
+		var depth int
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+			depth = 1 + c.depth(selection.SelectionSet)
+		case *ast.InlineFragment:
+			depth = c.depth(selection.SelectionSet)
+		case *ast.FragmentSpread:
+			if fragment, ok := c.fragments[selection.Name.Value]; ok {
+				depth = c.depth(fragment.SelectionSet)
+			}
+		}
+
+		if depth > deepest {
+			deepest = depth
+		}
+	}
+	return deepest
+}
+
+// complexity estimates how many fields set resolves: one for each field,
+// plus its own fields once for every row it may return. Counting stops once
+// it passes max.
+func (c cost) complexity(set *ast.SelectionSet, max int) int {
+	if set == nil {
+		return 0
+	}
+
+	total := 0
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+			total += 1 + c.fanout(selection)*c.complexity(selection.SelectionSet, max)
+		case *ast.InlineFragment:
+			total += c.complexity(selection.SelectionSet, max)
+		case *ast.FragmentSpread:
+			if fragment, ok := c.fragments[selection.Name.Value]; ok {
+				total += c.complexity(fragment.SelectionSet, max)
+			}
+		}
+
+		if total > max {
+			return max + 1
+		}
+	}
+	return total
+}
+
+// fanout is how many rows field may return.
+func (c cost) fanout(field *ast.Field) int {
+	switch {
+	case relationships[field.Name.Value]:
+		return relationshipFanout
+	case pages[field.Name.Value]:
+		return c.limit(field)
+	}
+	return 1
+}
+
+// limit reads the page size a top-level list is asked for.
+func (c cost) limit(field *ast.Field) int {
+	limit := service.DefaultPageSize
+
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil {
+				limit = n
+			}
+		case *ast.Variable:
+			switch n := c.variables[value.Name.Value].(type) {
+			case float64:
+				limit = int(n)
+			case int:
+				limit = n
+			}
+		}
+	}
+
+	// Larger pages are refused when the query runs.
+	if limit < 1 || limit > service.MaxPageSize {
+		return service.MaxPageSize
+	}
+	return limit
+}
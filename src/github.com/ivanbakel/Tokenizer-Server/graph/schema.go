@@ -0,0 +1,388 @@
+package graph
+
+import (
+	"context"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// The object types mirror the models, with the sqlboiler relationships as
+// fields of the same names: Organisation.orgUsers and orgTokens,
+// User.userTokens and UserToken.token. Fields without a resolver are read
+// from the model field whose name matches, ignoring case.
+
+var tokenType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Token",
+	Fields: graphql.Fields{
+		"id":   {Type: graphql.NewNonNull(graphql.ID)},
+		"name": {Type: graphql.NewNonNull(graphql.String)},
+		"expires": {
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "When the token expires, as an RFC 3339 time in UTC.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*models.Token).Expires.UTC().Format(time.RFC3339Nano), nil
+			},
+		},
+		"orgId": {Type: graphql.NewNonNull(graphql.ID)},
+	},
+})
+
+var userTokenType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "UserToken",
+	Description: "A user's holding of a token.",
+	Fields: graphql.Fields{
+		"userId":  {Type: graphql.NewNonNull(graphql.ID)},
+		"tokenId": {Type: graphql.NewNonNull(graphql.ID)},
+		"number": {
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return int(p.Source.(*models.UserToken).Number.Int16), nil
+			},
+		},
+		"token": {Type: graphql.NewNonNull(tokenType), Resolve: resolveToken},
+	},
+})
+
+var userType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id":         {Type: graphql.NewNonNull(graphql.ID)},
+		"facebookId": {Type: graphql.NewNonNull(graphql.String)},
+		"orgId": {
+			Type:        graphql.ID,
+			Description: "Null for users who belong to no organisation.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if user := p.Source.(*models.User); user.OrgID.Valid {
+					return user.OrgID.String, nil
+				}
+				return nil, nil
+			},
+		},
+		"userTokens": {Type: listOf(userTokenType), Resolve: resolveUserTokens},
+	},
+})
+
+var organisationType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Organisation",
+	Fields: graphql.Fields{
+		"id":        {Type: graphql.NewNonNull(graphql.ID)},
+		"name":      {Type: graphql.NewNonNull(graphql.String)},
+		"orgUsers":  {Type: listOf(userType), Resolve: resolveOrgUsers},
+		"orgTokens": {Type: listOf(tokenType), Resolve: resolveOrgTokens},
+	},
+})
+
+// Schema is the GraphQL schema served at /graphql. It is read-only; changes
+// go through the REST or gRPC APIs.
+var Schema graphql.Schema
+
+func init() {
+	var err error
+	Schema, err = graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Lists at the top level are paged as in the REST API: limit defaults to 50
+// and may be at most 500, and cursor is the nextCursor of the previous page.
+var pageArgs = graphql.FieldConfigArgument{
+	"limit":  {Type: graphql.Int},
+	"cursor": {Type: graphql.String},
+}
+
+var queryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"organisation": {
+			Type: organisationType,
+			Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				org, err := service.GetOrganisation(p.Args["id"].(string))
+				if org == nil {
+					return nil, found(err)
+				}
+				return org, nil
+			},
+		},
+		"organisations": {
+			Type: pageOf(organisationType),
+			Args: pageArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				orgs, next, err := service.ListOrganisations(listQuery(p))
+				if err != nil {
+					return nil, err
+				}
+				registerOrganisations(p.Context, orgs)
+				return page{items: orgs, next: next}, nil
+			},
+		},
+		"user": {
+			Type: userType,
+			Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				user, err := service.GetUser(p.Args["id"].(string))
+				if user == nil {
+					return nil, found(err)
+				}
+				return user, nil
+			},
+		},
+		"users": {
+			Type: pageOf(userType),
+			Args: withArgs(pageArgs, graphql.FieldConfigArgument{
+				"orgId":      {Type: graphql.ID},
+				"facebookId": {Type: graphql.String},
+			}),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				users, next, err := service.ListUsers(listQuery(p))
+				if err != nil {
+					return nil, err
+				}
+				registerUsers(p.Context, users)
+				return page{items: users, next: next}, nil
+			},
+		},
+		"token": {
+			Type: tokenType,
+			Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				token, err := service.GetToken(p.Args["id"].(string))
+				if token == nil {
+					return nil, found(err)
+				}
+				return token, nil
+			},
+		},
+		"tokens": {
+			Type: pageOf(tokenType),
+			Args: withArgs(pageArgs, graphql.FieldConfigArgument{
+				"sort":          {Type: graphql.String, Description: `Comma-separated among id, name and expires, each optionally prefixed with "-" for descending order.`},
+				"orgId":         {Type: graphql.ID},
+				"expiresBefore": {Type: graphql.String},
+				"expiresAfter":  {Type: graphql.String},
+				"namePrefix":    {Type: graphql.String},
+			}),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				tokens, next, err := service.ListTokens(listQuery(p))
+				if err != nil {
+					return nil, err
+				}
+				return page{items: tokens, next: next}, nil
+			},
+		},
+	},
+})
+
+// filterNames maps GraphQL argument names to the service's filter names.
+var filterNames = map[string]string{
+	"orgId":         "org_id",
+	"facebookId":    "facebook_id",
+	"expiresBefore": "expires_before",
+	"expiresAfter":  "expires_after",
+	"namePrefix":    "name_prefix",
+}
+
+func listQuery(p graphql.ResolveParams) service.ListQuery {
+	q := service.ListQuery{Filters: make(map[string]string)}
+
+	for name, value := range p.Args {
+		switch name {
+		case "limit":
+			q.Limit = value.(int)
+		case "cursor":
+			q.Cursor = value.(string)
+		case "sort":
+			q.Sort = value.(string)
+		default:
+			q.Filters[filterNames[name]] = value.(string)
+		}
+	}
+
+	return q
+}
+
+// page is a page of a top-level list.
+type page struct {
+	items interface{}
+	next  string
+}
+
+func pageOf(itemType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: itemType.Name() + "Page",
+		Fields: graphql.Fields{
+			"items": {
+				Type:    listOf(itemType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(page).items, nil },
+			},
+			"nextCursor": {
+				Type:        graphql.String,
+				Description: "The cursor of the next page, or null if this is the last.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if next := p.Source.(page).next; next != "" {
+						return next, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+}
+
+func listOf(itemType graphql.Type) graphql.Type {
+	return graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(itemType)))
+}
+
+func withArgs(args ...graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	all := make(graphql.FieldConfigArgument)
+	for _, some := range args {
+		for name, arg := range some {
+			all[name] = arg
+		}
+	}
+	return all
+}
+
+// found reports a failed lookup by id, which is not an error if there was
+// nothing to find: the field is null instead.
+func found(err error) error {
+	if notFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Relationships.
+
+func registerOrganisations(ctx context.Context, orgs models.OrganisationSlice) {
+	elements := make([]interface{}, len(orgs))
+	for i, org := range orgs {
+		elements[i] = org
+	}
+	register(ctx, orgs, elements...)
+}
+
+func registerUsers(ctx context.Context, users models.UserSlice) {
+	elements := make([]interface{}, len(users))
+	for i, user := range users {
+		elements[i] = user
+	}
+	register(ctx, users, elements...)
+}
+
+func registerUserTokens(ctx context.Context, holdings models.UserTokenSlice) {
+	elements := make([]interface{}, len(holdings))
+	for i, holding := range holdings {
+		elements[i] = holding
+	}
+	register(ctx, holdings, elements...)
+}
+
+func resolveOrgUsers(p graphql.ResolveParams) (interface{}, error) {
+	org := p.Source.(*models.Organisation)
+	b := batchOf(p.Context, org, func() interface{} { return models.OrganisationSlice{org} })
+
+	err := b.load("OrgUsers", func() error {
+		orgs := b.objects.(models.OrganisationSlice)
+		if err := org.L.LoadOrgUsers(boil.GetDB(), false, &orgs); err != nil {
+			return err
+		}
+
+		var users models.UserSlice
+		for _, o := range orgs {
+			users = append(users, o.R.OrgUsers...)
+		}
+		registerUsers(p.Context, users)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects without related rows are left with a nil slice, which would
+	// be reported as null.
+	if org.R.OrgUsers == nil {
+		return models.UserSlice{}, nil
+	}
+	return org.R.OrgUsers, nil
+}
+
+func resolveOrgTokens(p graphql.ResolveParams) (interface{}, error) {
+	org := p.Source.(*models.Organisation)
+	b := batchOf(p.Context, org, func() interface{} { return models.OrganisationSlice{org} })
+
+	err := b.load("OrgTokens", func() error {
+		orgs := b.objects.(models.OrganisationSlice)
+		return org.L.LoadOrgTokens(boil.GetDB(), false, &orgs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if org.R.OrgTokens == nil {
+		return models.TokenSlice{}, nil
+	}
+	return org.R.OrgTokens, nil
+}
+
+func resolveUserTokens(p graphql.ResolveParams) (interface{}, error) {
+	user := p.Source.(*models.User)
+	b := batchOf(p.Context, user, func() interface{} { return models.UserSlice{user} })
+
+	err := b.load("UserTokens", func() error {
+		users := b.objects.(models.UserSlice)
+		if err := user.L.LoadUserTokens(boil.GetDB(), false, &users); err != nil {
+			return err
+		}
+
+		var holdings models.UserTokenSlice
+		for _, u := range users {
+			holdings = append(holdings, u.R.UserTokens...)
+		}
+		registerUserTokens(p.Context, holdings)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if user.R.UserTokens == nil {
+		return models.UserTokenSlice{}, nil
+	}
+	return user.R.UserTokens, nil
+}
+
+func resolveToken(p graphql.ResolveParams) (interface{}, error) {
+	holding := p.Source.(*models.UserToken)
+	b := batchOf(p.Context, holding, func() interface{} { return models.UserTokenSlice{holding} })
+
+	err := b.load("Token", func() error {
+		holdings := b.objects.(models.UserTokenSlice)
+		if err := holding.L.LoadToken(boil.GetDB(), false, &holdings); err != nil {
+			return err
+		}
+
+		// LoadToken only relates each token to the first holding of it, so
+		// the rest are given it here.
+		tokens := make(map[string]*models.Token)
+		for _, h := range holdings {
+			if h.R.Token != nil {
+				tokens[h.TokenID] = h.R.Token
+			}
+		}
+		for _, h := range holdings {
+			h.R.Token = tokens[h.TokenID]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return holding.R.Token, nil
+}
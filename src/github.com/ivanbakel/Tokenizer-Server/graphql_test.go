@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+	"gopkg.in/nullbio/null.v6"
+)
+
+func TestGraphQLBatchesRelationships(t *testing.T) {
+	var server = newTestServer(t)
+	var db = boil.GetDB()
+
+	var token *models.Token
+
+	for i := 0; i < 3; i++ {
+		var org = &models.Organisation{Name: "graphql org"}
+
+		if err := org.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+
+		token = &models.Token{Name: "graphql token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+
+		if err := token.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+
+		for j := 0; j < 3; j++ {
+			var user = &models.User{FacebookID: "graphql", OrgID: null.StringFrom(org.ID)}
+
+			if err := user.Insert(db); err != nil {
+				t.Fatal(err)
+			}
+
+			if _,err := storage.Grant(token.ID, 2, user.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var queries bytes.Buffer
+
+	boil.DebugMode, boil.DebugWriter = true, &queries
+	defer func() { boil.DebugMode = false }()
+
+	var body,_ = json.Marshal(map[string]string{
+		"query": `{ organisations { items { name orgUsers { id userTokens { number token { name } } } } } }`,
+	})
+
+	var resp,err = http.Post(server.URL + "/graphql", "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var result struct {
+		Data	struct {
+			Organisations struct {
+				Items []struct {
+					OrgUsers []struct {
+						UserTokens []struct {
+							Number	int
+							Token	struct{ Name string }
+						}
+					}
+				}
+			}
+		}
+		Errors	[]interface{}
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil || len(result.Errors) > 0 {
+		t.Fatalf("want a result without errors, got %v %+v", err, result.Errors)
+	}
+
+	var holdings = 0
+
+	for _,org := range result.Data.Organisations.Items {
+		for _,user := range org.OrgUsers {
+			for _,holding := range user.UserTokens {
+				if holding.Number != 2 || holding.Token.Name != "graphql token" {
+					t.Errorf("want a holding of 2 graphql tokens, got %+v", holding)
+				}
+
+				holdings++
+			}
+		}
+	}
+
+	if holdings != 9 {
+		t.Errorf("want 9 holdings, got %d", holdings)
+	}
+
+	// One eager load each for orgUsers, userTokens and token, however many
+	// organisations and users there are.
+	if loads := strings.Count(queries.String(), "select * from"); loads != 3 {
+		t.Errorf("want 3 eager loads, got %d:\n%s", loads, queries.String())
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+const readinessTimeout = 2 * time.Second
+
+type healthResponse struct {
+	Status	string			`json:"status"`
+	Checks	map[string]string	`json:"checks,omitempty"`
+}
+
+// getHealth reports that the process is up. It deliberately does not touch the
+// database, so that an unreachable database does not get the instance killed.
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(healthResponse{Status: "ok"})
+}
+
+// getReadiness reports whether the instance can serve traffic: the database
+// answers, every migration has been applied and the backend's own checks pass.
+func getReadiness(db *sql.DB, backend *storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctx,cancel = context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		var response = healthResponse{Status: "ok", Checks: make(map[string]string)}
+
+		// The probe needs no authentication, so failures are only named in
+		// the response; what went wrong is logged.
+		var check = func(name string, err error) {
+			if err != nil {
+				log.Printf("readiness: %s: %v", name, err)
+
+				response.Status = "unavailable"
+				response.Checks[name] = "failed"
+			} else {
+				response.Checks[name] = "ok"
+			}
+		}
+
+		var err = db.PingContext(ctx)
+
+		check("database", err)
+
+		if err == nil {
+			var pending []migrations.Migration
+
+			pending,err = migrations.PendingContext(ctx, db, backend.Migrations)
+
+			if err == nil && len(pending) > 0 {
+				err = fmt.Errorf("%d pending, next is %04d_%s", len(pending), pending[0].Version, pending[0].Name)
+			}
+
+			check("migrations", err)
+
+			for _,backendCheck := range backend.Checks {
+				check(backendCheck.Name, backendCheck.Check(ctx, db))
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if response.Status != "ok" {
+			w.WriteHeader(503)
+		}
+
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+type dbStatsResponse struct {
+	Backend			string		`json:"backend"`
+	MaxOpenConnections	int		`json:"max_open_connections"`
+	OpenConnections		int		`json:"open_connections"`
+	InUse			int		`json:"in_use"`
+	Idle			int		`json:"idle"`
+	WaitCount		int64		`json:"wait_count"`
+	WaitDuration		string		`json:"wait_duration"`
+	MaxIdleClosed		int64		`json:"max_idle_closed"`
+	MaxIdleTimeClosed	int64		`json:"max_idle_time_closed"`
+	MaxLifetimeClosed	int64		`json:"max_lifetime_closed"`
+}
+
+// getDBStats reports the connection pool statistics from sql.DBStats.
+func getDBStats(db *sql.DB, backend *storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var stats = db.Stats()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		json.NewEncoder(w).Encode(dbStatsResponse{
+			Backend:		backend.Name,
+			MaxOpenConnections:	stats.MaxOpenConnections,
+			OpenConnections:	stats.OpenConnections,
+			InUse:			stats.InUse,
+			Idle:			stats.Idle,
+			WaitCount:		stats.WaitCount,
+			WaitDuration:		stats.WaitDuration.String(),
+			MaxIdleClosed:		stats.MaxIdleClosed,
+			MaxIdleTimeClosed:	stats.MaxIdleTimeClosed,
+			MaxLifetimeClosed:	stats.MaxLifetimeClosed,
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+func TestHealth(t *testing.T) {
+	var server = newTestServer(t)
+
+	var health healthResponse
+
+	if status := bearerCall(t, "", http.MethodGet, server.URL + "/healthz", nil, &health); status != http.StatusOK || health.Status != "ok" {
+		t.Errorf("want the process reported up, got %d %+v", status, health)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	var server = newTestServer(t)
+
+	var ready healthResponse
+
+	if status := bearerCall(t, "", http.MethodGet, server.URL + "/readyz", nil, &ready); status != http.StatusOK || ready.Status != "ok" {
+		t.Fatalf("want a migrated database ready, got %d %+v", status, ready)
+	}
+
+	if ready.Checks["database"] != "ok" || ready.Checks["migrations"] != "ok" {
+		t.Errorf("want the database and migrations checked, got %+v", ready.Checks)
+	}
+
+	if _,err := boil.GetDB().Exec("DELETE FROM schema_migrations WHERE version = (SELECT MAX(version) FROM schema_migrations)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp,err = http.Get(server.URL + "/readyz")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("want 503 with a migration pending, got %d", resp.StatusCode)
+	}
+
+	var unready healthResponse
+
+	if err = json.NewDecoder(resp.Body).Decode(&unready); err != nil {
+		t.Fatal(err)
+	}
+
+	// What failed is named, but not how.
+	if unready.Status != "unavailable" || unready.Checks["migrations"] != "failed" {
+		t.Errorf("want the migrations check failed, got %+v", unready)
+	}
+}
+
+func TestDBStats(t *testing.T) {
+	var server = newTestServer(t)
+
+	if status := bearerCall(t, "wrong", http.MethodGet, server.URL + "/debug/db", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("want pool stats hidden from non-admins, got %d", status)
+	}
+
+	var stats dbStatsResponse
+
+	if status := adminCall(t, http.MethodGet, server.URL + "/debug/db", nil, &stats); status != http.StatusOK {
+		t.Fatalf("want 200 for an admin, got %d", status)
+	}
+
+	if stats.Backend != "sqlite3" || stats.MaxOpenConnections != 1 {
+		t.Errorf("want the sqlite pool of one connection, got %+v", stats)
+	}
+}
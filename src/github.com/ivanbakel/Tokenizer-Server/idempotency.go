@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// IdempotencyKeyHeader lets clients retry a POST without it taking effect
+// twice: the response to the first request carrying a key is replayed to
+// every later one.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	maxIdempotencyKeyLength = 255
+
+	// Idempotent bodies are held in memory to be hashed and stored.
+	maxIdempotentBodySize = 1 << 20
+
+	// idempotencyKeyTTL is how long keys are remembered. Clients must not
+	// retry with a key for longer than this.
+	idempotencyKeyTTL = 24 * time.Hour
+)
+
+// idempotent makes POSTs that carry an Idempotency-Key replay the response to
+// the key's first use. Responses that only refuse the request for now, such as
+// a server error or a rate limit, are not kept, so the request can be retried
+// for real.
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var key = r.Header.Get(IdempotencyKeyHeader)
+
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			apierror.Write(w, r, apierror.BadRequest(apierror.CodeValidation, "Idempotency-Key is too long"))
+			return
+		}
+
+		var body,err = ioutil.ReadAll(io.LimitReader(r.Body, maxIdempotentBodySize + 1))
+
+		if err != nil {
+			apierror.Write(w, r, apierror.BadRequest(apierror.CodeMalformedBody, "the request body could not be read"))
+			return
+		}
+
+		if len(body) > maxIdempotentBodySize {
+			apierror.Write(w, r, apierror.New(http.StatusRequestEntityTooLarge, apierror.CodeMalformedBody, "the request body is too large"))
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var hash = sha256.Sum256(body)
+
+		stored,err := storage.ClaimIdempotencyKey(key, r.Method, r.URL.Path, hex.EncodeToString(hash[:]), time.Now())
+
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+
+		if stored != nil {
+			logging.Annotate(r, "idempotent_replay", true)
+
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		var recorder = &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		if temporaryStatus(recorder.status) {
+			err = storage.ReleaseIdempotencyKey(key)
+		} else {
+			err = storage.CompleteIdempotencyKey(key, storage.StoredResponse{
+				Status:		recorder.status,
+				ContentType:	recorder.Header().Get("Content-Type"),
+				Body:		recorder.body.Bytes(),
+			})
+		}
+
+		if err != nil {
+			logging.Error(r, "unable to record idempotent response", err)
+		}
+	})
+}
+
+// temporaryStatus reports whether a response with the given status refuses a
+// request only until the client tries again later.
+func temporaryStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+}
+
+// responseRecorder keeps a copy of the response it passes through.
+type responseRecorder struct {
+	http.ResponseWriter
+	status	int
+	body	bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// expireIdempotencyKeys forgets keys older than idempotencyKeyTTL every
+// interval until ctx is done.
+func expireIdempotencyKeys(interval time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _,err := storage.ExpireIdempotencyKeys(now.Add(-idempotencyKeyTTL)); err != nil {
+					log.Printf("unable to expire idempotency keys: %v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"github.com/ivanbakel/Tokenizer-Server/ratelimit"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+)
+
+// newLimitStore returns the store cfg asks rate limit buckets to be kept in.
+func newLimitStore(cfg config.Limits) ratelimit.Store {
+	if cfg.Store == "postgres" {
+		return ratelimit.Database{}
+	}
+
+	return ratelimit.NewMemory()
+}
+
+// newLimiters returns the limiters cfg configures, sharing store.
+func newLimiters(cfg config.Limits, store ratelimit.Store) service.Limiters {
+	var limit = func(l config.Limit) ratelimit.Limit {
+		return ratelimit.Limit{Max: l.Max, Per: l.Per.Duration}
+	}
+
+	return service.Limiters{
+		Spends:		ratelimit.New("spends", limit(cfg.Spends), store),
+		Grants:		ratelimit.New("grants", limit(cfg.Grants), store),
+		Transfers:	ratelimit.New("transfers", limit(cfg.Transfers), store),
+	}
+}
+
+// pruneRateLimits forgets buckets left alone for longer than the longest
+// period of any limit, which have refilled and so are no different from
+// buckets never used.
+func pruneRateLimits(store ratelimit.Store, cfg config.Limits, interval time.Duration) func(ctx context.Context) {
+	var idle time.Duration
+
+	for _,limit := range []config.Limit{cfg.Spends, cfg.Grants, cfg.Transfers} {
+		if limit.Per.Duration > idle {
+			idle = limit.Per.Duration
+		}
+	}
+
+	return func(ctx context.Context) {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := store.Prune(now.Add(-idle)); err != nil {
+					log.Printf("unable to prune rate limit buckets: %v", err)
+				}
+			}
+		}
+	}
+}
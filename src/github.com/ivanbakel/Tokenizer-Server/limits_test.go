@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/client"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/ratelimit"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+	"gopkg.in/nullbio/null.v6"
+)
+
+func TestRateLimits(t *testing.T) {
+	var server = newTestServer(t)
+	var c = client.New(server.URL, client.WithRetries(0, 0))
+	var ctx = context.Background()
+
+	var limits = config.Default().Limits
+
+	limits.Spends.Max = 2
+	limits.Grants.Max = 10
+	limits.Transfers.Max = 1
+
+	service.SetLimiters(newLimiters(limits, ratelimit.NewMemory()))
+	t.Cleanup(func() { service.SetLimiters(service.Limiters{}) })
+
+	var org = &models.Organisation{Name: "limited org"}
+
+	if err := org.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var token = &models.Token{Name: "limited token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+
+	if err := token.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var alice,bob = &models.User{FacebookID: "alice"}, &models.User{FacebookID: "bob"}
+
+	for _,user := range []*models.User{alice, bob} {
+		if err := user.Insert(boil.GetDB()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A group grant counts every unit it credits against the organisation.
+	if _,err := c.GrantGroup(ctx, token.ID, []string{alice.ID, bob.ID}, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// More than the limit allows in a day could never be granted.
+	if _,err := c.GrantUser(ctx, token.ID, alice.ID, 11); !errors.Is(err, client.ErrInvalid) {
+		t.Errorf("want a grant over the whole limit invalid, got %v", err)
+	}
+
+	if _,err := c.GrantUser(ctx, token.ID, alice.ID, 3); !errors.Is(err, client.ErrRateLimited) {
+		t.Errorf("want the 11th unit granted today refused, got %v", err)
+	}
+
+	if _,err := c.GrantUser(ctx, token.ID, alice.ID, 2); err != nil {
+		t.Errorf("want the last 2 units granted, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _,err := c.Spend(ctx, alice.ID, token.ID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var url = server.URL + "/v1/users/" + alice.ID + "/tokens/" + token.ID + "/spend"
+
+	var resp,err = http.Post(url, "application/json", strings.NewReader(`{"number": 1}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	// Two spends a minute refill one every 30 seconds.
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "30" {
+		t.Errorf("want a 429 asking for 30s, got %d with Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// Refusals after the first in the period are only counted.
+	if _,err = c.Spend(ctx, alice.ID, token.ID, 1); !errors.Is(err, client.ErrRateLimited) {
+		t.Errorf("want a third spend in the minute refused, got %v", err)
+	}
+
+	// Bob has his own buckets.
+	if _,err = c.Spend(ctx, bob.ID, token.ID, 1); err != nil {
+		t.Errorf("want bob's spend allowed, got %v", err)
+	}
+
+	if _,err = c.Transfer(ctx, token.ID, alice.ID, bob.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err = c.Transfer(ctx, token.ID, alice.ID, bob.ID, 1); !errors.Is(err, client.ErrRateLimited) {
+		t.Errorf("want a second transfer in the hour refused, got %v", err)
+	}
+
+	events,err := storage.EventLog(0, 100)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var refused []storage.Event
+
+	for _,event := range events {
+		if event.Type == storage.EventRateLimited && event.TokenID == token.ID {
+			refused = append(refused, event)
+		}
+	}
+
+	if len(refused) != 3 {
+		t.Fatalf("want the first refusal by each limit recorded, got %+v", refused)
+	}
+
+	if e := refused[0]; e.Limit != "grants" || e.UserID != alice.ID || e.Number != 3 || e.Balance != 4 || e.OrgID != org.ID {
+		t.Errorf("wrong grant refusal %+v", e)
+	}
+
+	if e := refused[1]; e.Limit != "spends" || e.UserID != alice.ID || e.Number != 1 || e.Balance != 4 {
+		t.Errorf("wrong spend refusal %+v", e)
+	}
+
+	if e := refused[2]; e.Limit != "transfers" || e.UserID != bob.ID || e.FromUserID != alice.ID || e.Balance != 4 || e.FromBalance != 3 {
+		t.Errorf("wrong transfer refusal %+v", e)
+	}
+
+	if got := balanceOf(t, alice.ID, token.ID); got != 3 {
+		t.Errorf("want refused changes to leave alice with 3, got %d", got)
+	}
+}
+
+func TestFailedChangesRefundLimits(t *testing.T) {
+	var server = newTestServer(t)
+	var c = client.New(server.URL, client.WithRetries(0, 0))
+	var ctx = context.Background()
+
+	var limits = config.Default().Limits
+
+	limits.Spends.Max = 1
+	limits.Grants.Max = 10
+
+	service.SetLimiters(newLimiters(limits, ratelimit.NewMemory()))
+	t.Cleanup(func() { service.SetLimiters(service.Limiters{}) })
+
+	var org = &models.Organisation{Name: "refunded org"}
+
+	if err := org.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var token = &models.Token{Name: "refunded token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID, UserCap: null.Int16From(5)}
+
+	if err := token.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var alice,bob = &models.User{FacebookID: "alice"}, &models.User{FacebookID: "bob"}
+
+	for _,user := range []*models.User{alice, bob} {
+		if err := user.Insert(boil.GetDB()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _,err := c.GrantUser(ctx, token.ID, alice.ID, 6); !errors.Is(err, client.ErrHoldingCapExceeded) {
+		t.Fatalf("want the grant over the cap refused, got %v", err)
+	}
+
+	// The refused 6 units are not counted, so all 10 are left for today.
+	if _,err := c.GrantGroup(ctx, token.ID, []string{alice.ID, bob.ID}, 5); err != nil {
+		t.Errorf("want 10 units granted after a failed grant, got %v", err)
+	}
+
+	if _,err := c.Spend(ctx, alice.ID, token.ID, 9); !errors.Is(err, client.ErrInsufficientBalance) {
+		t.Fatalf("want the overdrawing spend refused, got %v", err)
+	}
+
+	if _,err := c.Spend(ctx, alice.ID, token.ID, 1); err != nil {
+		t.Errorf("want a spend allowed after a failed one, got %v", err)
+	}
+}
+
+func TestRateLimitsOtherPaths(t *testing.T) {
+	var server = newTestServer(t)
+
+	var limits = config.Default().Limits
+
+	limits.Spends.Max = 1
+	limits.Grants.Max = 3
+
+	var org = &models.Organisation{Name: "other paths org"}
+
+	if err := org.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var key v1.OrgKey
+
+	if status := adminCall(t, "POST", server.URL + "/v1/orgs/" + org.ID + "/keys", v1.NewOrgKey{Name: "till"}, &key); status != http.StatusCreated {
+		t.Fatalf("create key status = %d, want 201", status)
+	}
+
+	var token = &models.Token{Name: "other paths token", Expires: time.Now().Add(48 * time.Hour).UTC(), OrgID: org.ID}
+
+	if err := token.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var alice = &models.User{FacebookID: "alice"}
+
+	if err := alice.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err := storage.Grant(token.ID, 5, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var rateLimited = func(err error) bool {
+		return err != nil && apierror.From(err).Code == apierror.CodeRateLimited
+	}
+
+	var fresh = func() {
+		service.SetLimiters(newLimiters(limits, ratelimit.NewMemory()))
+	}
+
+	t.Cleanup(func() { service.SetLimiters(service.Limiters{}) })
+
+	// Gift codes are grants.
+	fresh()
+
+	batch,err := service.CreateGiftBatch(org.ID, service.NewGiftBatch{TokenID: token.ID, Number: 2, Count: 2})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []string
+
+	if err = storage.EachGiftCode(batch.ID, func(c storage.GiftCode) error { codes = append(codes, c.Code); return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,_,err = service.ClaimGiftCode(alice.ID, codes[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,_,err = service.ClaimGiftCode(alice.ID, codes[1]); !rateLimited(err) {
+		t.Errorf("want a second claim over the grants limit refused, got %v", err)
+	}
+
+	// Redemption codes are spends.
+	fresh()
+
+	var redeemed = make([]*storage.RedemptionCode, 2)
+
+	for i := range redeemed {
+		if redeemed[i],err = service.CreateRedemptionCode(alice.ID, token.ID, service.NewRedemptionCode{Number: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _,_,err = service.RedeemCode(org.ID, key.ID, redeemed[0].Code); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,_,err = service.RedeemCode(org.ID, key.ID, redeemed[1].Code); !rateLimited(err) {
+		t.Errorf("want a second redemption over the spends limit refused, got %v", err)
+	}
+
+	// So is settling vouchers.
+	fresh()
+
+	var vouchers []service.Redemption
+
+	for i := 0; i < 2; i++ {
+		var _,signed,err = service.IssueVoucher(alice.ID, token.ID, service.NewVoucher{Number: 1})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		vouchers = append(vouchers, service.Redemption{Voucher: signed, RedeemedAt: time.Now().Add(-time.Minute)})
+	}
+
+	if _,err = service.SettleVouchers(org.ID, "bus 12", vouchers); !rateLimited(err) {
+		t.Errorf("want a batch over the spends limit refused, got %v", err)
+	}
+}
+
+func TestRateLimitedRetry(t *testing.T) {
+	var server = newTestServer(t)
+	var ctx = context.Background()
+
+	var limits = config.Default().Limits
+
+	limits.Spends.Max = 1
+	limits.Spends.Per = config.Duration{Duration: 100 * time.Millisecond}
+
+	service.SetLimiters(newLimiters(limits, ratelimit.NewMemory()))
+	t.Cleanup(func() { service.SetLimiters(service.Limiters{}) })
+
+	var org = &models.Organisation{Name: "retried org"}
+
+	if err := org.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var token = &models.Token{Name: "retried token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+
+	if err := token.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var alice = &models.User{FacebookID: "alice"}
+
+	if err := alice.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err := storage.Grant(token.ID, 5, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err := client.New(server.URL, client.WithRetries(0, 0)).Spend(ctx, alice.ID, token.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry carries the same idempotency key as the refused attempt, and
+	// must be spent for real once the bucket refills, not answered with the
+	// refusal again.
+	var c = client.New(server.URL, client.WithRetries(2, 0))
+
+	if _,err := c.Spend(ctx, alice.ID, token.ID, 1); err != nil {
+		t.Fatalf("want the retried spend to go through, got %v", err)
+	}
+
+	if got := balanceOf(t, alice.ID, token.ID); got != 3 {
+		t.Errorf("want both spends made once, leaving 3, got %d", got)
+	}
+}
+
+func balanceOf(t *testing.T, userID, tokenID string) int16 {
+	var holding,err = models.FindUserToken(boil.GetDB(), userID, tokenID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return holding.Number.Int16
+}
@@ -0,0 +1,194 @@
+// Package logging writes structured JSON logs: one access line per request,
+// plus any errors handlers report, all tagged with the request's id.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RequestIDHeader carries the request id in both directions. An id sent by
+// the caller is kept, so that it can be followed across services.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// Logger writes one JSON object per line to its output.
+type Logger struct {
+	mut sync.Mutex
+	out io.Writer
+}
+
+// New returns a logger writing to out.
+func New(out io.Writer) *Logger {
+	return &Logger{out: out}
+}
+
+// Log writes fields as a single line, adding the current time.
+func (l *Logger) Log(fields map[string]interface{}) {
+	fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(fields)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{"time": fields["time"], "msg": "unable to encode log line", "error": err.Error()})
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+type contextKey struct{}
+
+// entry is the access log line being built for one request.
+type entry struct {
+	logger *Logger
+	id     string
+
+	mut    sync.Mutex
+	fields map[string]interface{}
+}
+
+func fromRequest(r *http.Request) *entry {
+	e, _ := r.Context().Value(contextKey{}).(*entry)
+	return e
+}
+
+// RequestID returns the id of the request ctx belongs to, or "" outside the
+// middleware.
+func RequestID(ctx context.Context) string {
+	if e, ok := ctx.Value(contextKey{}).(*entry); ok {
+		return e.id
+	}
+	return ""
+}
+
+// Annotate adds a field to the request's access log line, e.g. the org that
+// owns a token the request touched.
+func Annotate(r *http.Request, key string, value interface{}) {
+	if e := fromRequest(r); e != nil {
+		e.mut.Lock()
+		e.fields[key] = value
+		e.mut.Unlock()
+	}
+}
+
+// Error logs err against the request, so that it can be found from the
+// request id the client was given.
+func Error(r *http.Request, msg string, err error) {
+	e := fromRequest(r)
+	if e == nil {
+		return
+	}
+
+	e.logger.Log(map[string]interface{}{
+		"level":      "error",
+		"msg":        msg,
+		"error":      err.Error(),
+		"request_id": e.id,
+		"method":     r.Method,
+		"path":       r.URL.Path,
+	})
+}
+
+// Middleware assigns each request an id, stores it in the request context
+// and the response headers, and logs the request once it has been served.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		e := &entry{logger: l, id: id, fields: make(map[string]interface{})}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), contextKey{}, e)))
+
+		fields := map[string]interface{}{
+			"level":      "info",
+			"msg":        "request",
+			"request_id": id,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     recorder.status,
+			"latency_ms": float64(time.Since(start).Nanoseconds()) / 1e6,
+			"remote":     r.RemoteAddr,
+		}
+
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				fields["route"] = template
+			}
+		}
+
+		vars := mux.Vars(r)
+		if user, ok := vars["uid"]; ok {
+			fields["user"] = user
+		}
+		if org, ok := vars["oid"]; ok {
+			fields["org"] = org
+		}
+
+		e.mut.Lock()
+		for key, value := range e.fields {
+			fields[key] = value
+		}
+		e.mut.Unlock()
+
+		l.Log(fields)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
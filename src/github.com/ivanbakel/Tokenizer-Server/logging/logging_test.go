@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var out []map[string]interface{}
+
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("log line is not JSON: %s", scanner.Text())
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func newRouter(logger *Logger) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(logger.Middleware)
+	r.HandleFunc("/users/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		Annotate(r, "org", "some-org")
+		Error(r, "handler failed", errors.New("sql: no rows in result set"))
+		w.WriteHeader(500)
+	})
+	return r
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	r := newRouter(New(&buf))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/users/1234", nil))
+
+	id := rec.Header().Get(RequestIDHeader)
+	if id == "" {
+		t.Fatal("expected a request id to be assigned")
+	}
+
+	logged := lines(t, &buf)
+	if len(logged) != 2 {
+		t.Fatalf("want an error line and an access line, got %v", logged)
+	}
+
+	failure, access := logged[0], logged[1]
+	if failure["request_id"] != id || failure["error"] != "sql: no rows in result set" {
+		t.Errorf("unexpected error line %v", failure)
+	}
+
+	for key, want := range map[string]interface{}{
+		"request_id": id,
+		"route":      "/users/{uid}",
+		"status":     float64(500),
+		"user":       "1234",
+		"org":        "some-org",
+	} {
+		if access[key] != want {
+			t.Errorf("access line %s: want %v, got %v", key, want, access[key])
+		}
+	}
+	if _, ok := access["latency_ms"]; !ok {
+		t.Error("access line is missing latency_ms")
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	r := newRouter(New(&buf))
+
+	req := httptest.NewRequest("GET", "/users/1234", nil)
+	req.Header.Set(RequestIDHeader, "upstream-id")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "upstream-id" {
+		t.Error("want the caller's id kept, got", got)
+	}
+
+	req = httptest.NewRequest("GET", "/users/1234", nil)
+	req.Header.Set(RequestIDHeader, "bad id\nwith newline")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got == "" || got == "bad id\nwith newline" {
+		t.Error("want an invalid id replaced, got", got)
+	}
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reports sql.DBStats at scrape time.
+type poolCollector struct {
+	db *sql.DB
+
+	open, inUse, idle, maxOpen *prometheus.Desc
+	waitCount, waitDuration    *prometheus.Desc
+}
+
+func newPoolCollector(db *sql.DB) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db", name), help, nil, nil)
+	}
+
+	return &poolCollector{
+		db:           db,
+		open:         desc("open_connections", "Established connections, both in use and idle."),
+		inUse:        desc("in_use_connections", "Connections currently in use."),
+		idle:         desc("idle_connections", "Idle connections."),
+		maxOpen:      desc("max_open_connections", "Maximum number of open connections, or 0 for unlimited."),
+		waitCount:    desc("wait_count_total", "Connections waited for."),
+		waitDuration: desc("wait_duration_seconds_total", "Time spent waiting for a connection."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.maxOpen
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+}
+
+const supplyQuery = `SELECT "tokens"."org_id", "tokens"."id", COALESCE(SUM("user_tokens"."number"), 0)
+FROM "tokens" LEFT JOIN "user_tokens" ON "user_tokens"."token_id" = "tokens"."id"
+WHERE "tokens"."expires" > $1
+GROUP BY "tokens"."org_id", "tokens"."id"`
+
+// supplyCollector reports the units of each unexpired token held by users,
+// queried from the database at scrape time.
+type supplyCollector struct {
+	db *sql.DB
+
+	outstanding *prometheus.Desc
+	up          *prometheus.Desc
+}
+
+func newSupplyCollector(db *sql.DB) *supplyCollector {
+	return &supplyCollector{
+		db: db,
+		outstanding: prometheus.NewDesc(prometheus.BuildFQName(namespace, "token", "outstanding_units"),
+			"Units of an unexpired token currently held by users, by org and token.", []string{"org", "token"}, nil),
+		up: prometheus.NewDesc(prometheus.BuildFQName(namespace, "token", "supply_query_up"),
+			"Whether the outstanding supply could be read from the database.", nil, nil),
+	}
+}
+
+func (c *supplyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.outstanding
+	ch <- c.up
+}
+
+func (c *supplyCollector) Collect(ch chan<- prometheus.Metric) {
+	rows, err := c.db.Query(supplyQuery, time.Now().UTC())
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+	defer rows.Close()
+
+	var metrics []prometheus.Metric
+	for rows.Next() {
+		var org, token string
+		var units float64
+		if err = rows.Scan(&org, &token, &units); err != nil {
+			break
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.outstanding, prometheus.GaugeValue, units, org, token))
+	}
+
+	if err != nil || rows.Err() != nil {
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	for _, metric := range metrics {
+		ch <- metric
+	}
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1)
+}
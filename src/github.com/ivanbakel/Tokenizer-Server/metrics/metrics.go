@@ -0,0 +1,122 @@
+// Package metrics exposes the server's Prometheus metrics: HTTP traffic per
+// route, token flows reported by the storage layer, and database gauges.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tokenizer"
+
+// Metrics is an in-process registry holding every metric the server exports.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	tokens   *prometheus.CounterVec
+	limited  *prometheus.CounterVec
+}
+
+// New creates the registry and registers the database collectors for db.
+func New(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route template, method and status code.",
+		}, []string{"route", "method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency, by route template and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_units_total",
+			Help:      "Token units that changed hands, by event (granted, spent, expired or transferred), org and token.",
+		}, []string{"event", "org", "token"}),
+		limited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      "Balance changes refused by a rate limit, by limit and org.",
+		}, []string{"limit", "org"}),
+	}
+
+	m.registry.MustRegister(m.requests, m.latency, m.tokens, m.limited, newPoolCollector(db), newSupplyCollector(db))
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Observe counts a committed balance change. It is a storage.Listener.
+// Refusals by a rate limit are counted by RateLimited instead, since only
+// some of them are recorded as events.
+func (m *Metrics) Observe(event storage.Event) {
+	if event.Type == storage.EventRateLimited {
+		return
+	}
+	m.tokens.WithLabelValues(string(event.Type), event.OrgID, event.TokenID).Add(float64(event.Number))
+}
+
+// RateLimited counts a balance change refused by the named limit, for one of
+// an organisation's tokens. It is a service.Limiters Refused.
+func (m *Metrics) RateLimited(limit, orgID string) {
+	m.limited.WithLabelValues(limit, orgID).Inc()
+}
+
+// Middleware records each request against the template of the mux route that
+// matched it, such as /users/{uid}, so that ids do not explode the labels.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		m.latency.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m := New(db)
+
+	r := mux.NewRouter()
+	r.Use(m.Middleware)
+	r.HandleFunc("/users/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	})
+
+	for _, path := range []string{"/users/a", "/users/b"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	m.Observe(storage.Event{Type: storage.EventSpent, OrgID: "org", TokenID: "token", Number: 3})
+	m.Observe(storage.Event{Type: storage.EventSpent, OrgID: "org", TokenID: "token", Number: 2})
+	m.Observe(storage.Event{Type: storage.EventRateLimited, OrgID: "org", TokenID: "token", Number: 4, Limit: "spends"})
+	m.RateLimited("spends", "org")
+	m.RateLimited("spends", "org")
+
+	out := scrape(t, m)
+
+	for _, want := range []string{
+		`tokenizer_http_requests_total{code="404",method="GET",route="/users/{uid}"} 2`,
+		`tokenizer_http_request_duration_seconds_count{method="GET",route="/users/{uid}"} 2`,
+		`tokenizer_token_units_total{event="spent",org="org",token="token"} 5`,
+		// Refusals are counted as they happen, not from their events.
+		`tokenizer_rate_limited_total{limit="spends",org="org"} 2`,
+		`tokenizer_db_open_connections`,
+		// The in-memory database has no tables, so the supply query fails.
+		`tokenizer_token_supply_query_up 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+
+	if strings.Contains(out, "/users/a") {
+		t.Error("raw paths must not be used as labels")
+	}
+}
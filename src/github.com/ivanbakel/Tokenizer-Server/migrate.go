@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+)
+
+const migrateUsage = "usage: tokenizer-server [flags] migrate up|down|status"
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(db *sql.DB, list []migrations.Migration, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		var ran,err = migrations.Up(db, list)
+
+		for _,m := range ran {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+
+		if err == nil && len(ran) == 0 {
+			fmt.Println("schema is up to date")
+		}
+
+		return err
+	case "down":
+		var m,err = migrations.Down(db, list)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		return nil
+	case "status":
+		var states,err = migrations.Status(db, list)
+
+		if err != nil {
+			return err
+		}
+
+		var table = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+		fmt.Fprintln(table, "VERSION\tNAME\tAPPLIED")
+
+		for _,state := range states {
+			var applied = "pending"
+
+			if state.Applied {
+				applied = state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Fprintf(table, "%04d\t%s\t%s\n", state.Version, state.Name, applied)
+		}
+
+		return table.Flush()
+	}
+
+	return errors.New(migrateUsage)
+}
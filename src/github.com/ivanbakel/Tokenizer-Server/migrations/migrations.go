@@ -0,0 +1,192 @@
+// Package migrations applies numbered schema changes to a database and
+// records which of them have run in a schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Migration is a single, numbered change to the schema. Down must undo
+// exactly what Up did.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// State reports whether a migration has been applied, and when.
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// ErrNothingToRollBack is returned by Down when no migration has been applied.
+var ErrNothingToRollBack = errors.New("migrations: nothing to roll back")
+
+const createTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version	INTEGER		PRIMARY KEY,
+  name		VARCHAR(100)	NOT NULL,
+  applied_at	TIMESTAMP	NOT NULL
+)`
+
+// Up applies every migration in list that has not been applied yet, in
+// version order, and returns the migrations it applied. Each migration runs
+// in its own transaction, so a failure leaves earlier migrations in place.
+func Up(db *sql.DB, list []Migration) ([]Migration, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range sorted(list) {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		err = run(db, m, m.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+				m.Version, m.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return ran, err
+		}
+		ran = append(ran, m)
+	}
+
+	return ran, nil
+}
+
+// Down rolls back the most recently applied migration and returns it.
+func Down(db *sql.DB, list []Migration) (Migration, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	ordered := sorted(list)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		m := ordered[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		err = run(db, m, m.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version)
+			return err
+		})
+		return m, err
+	}
+
+	return Migration{}, ErrNothingToRollBack
+}
+
+// Status lists every migration in version order with its applied state.
+func Status(db *sql.DB, list []Migration) ([]State, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []State
+	for _, m := range sorted(list) {
+		at, ok := applied[m.Version]
+		states = append(states, State{Migration: m, Applied: ok, AppliedAt: at})
+	}
+	return states, nil
+}
+
+// Pending returns the migrations in list that have not been applied.
+func Pending(db *sql.DB, list []Migration) ([]Migration, error) {
+	states, err := Status(db, list)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, s := range states {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// PendingContext is Pending for callers that must not wait on a slow
+// database or change it, such as a readiness probe: it only reads
+// schema_migrations, and gives up when ctx is done. Unlike Pending, it fails
+// if the table has not been created yet.
+func PendingContext(ctx context.Context, db *sql.DB, list []Migration) ([]Migration, error) {
+	applied, err := readApplied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range sorted(list) {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func run(db *sql.DB, m Migration, query string, record func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrapf(err, "migrations: unable to begin %04d_%s", m.Version, m.Name)
+	}
+
+	if _, err = tx.Exec(query); err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "migrations: %04d_%s failed", m.Version, m.Name)
+	}
+
+	if err = record(tx); err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "migrations: unable to record %04d_%s", m.Version, m.Name)
+	}
+
+	return tx.Commit()
+}
+
+func appliedVersions(db *sql.DB) (map[int]time.Time, error) {
+	if _, err := db.Exec(createTableQuery); err != nil {
+		return nil, errors.Wrap(err, "migrations: unable to create schema_migrations")
+	}
+	return readApplied(context.Background(), db)
+}
+
+func readApplied(ctx context.Context, db *sql.DB) (map[int]time.Time, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "migrations: unable to read schema_migrations")
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, errors.Wrap(err, "migrations: unable to read schema_migrations")
+		}
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+func sorted(list []Migration) []Migration {
+	ordered := append([]Migration(nil), list...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Version < ordered[j].Version })
+	return ordered
+}
@@ -0,0 +1,151 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testList = []Migration{
+	{Version: 2, Name: "second", Up: "CREATE TABLE b (id INTEGER)", Down: "DROP TABLE b"},
+	{Version: 1, Name: "first", Up: "CREATE TABLE a (id INTEGER)", Down: "DROP TABLE a"},
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestUpDownStatus(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t)
+	defer db.Close()
+
+	ran, err := Up(db, testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 2 || ran[0].Version != 1 || ran[1].Version != 2 {
+		t.Fatalf("want versions 1 and 2 applied in order, got %v", ran)
+	}
+
+	if ran, err = Up(db, testList); err != nil || len(ran) != 0 {
+		t.Fatalf("want second Up to be a no-op, got %v, %v", ran, err)
+	}
+
+	m, err := Down(db, testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 2 {
+		t.Error("want version 2 rolled back, got", m.Version)
+	}
+	if _, err = db.Exec("SELECT * FROM b"); err == nil {
+		t.Error("expected table b to be dropped")
+	}
+
+	states, err := Status(db, testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !states[0].Applied || states[1].Applied {
+		t.Errorf("want only version 1 applied, got %+v", states)
+	}
+
+	pending, err := Pending(db, testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Version != 2 {
+		t.Errorf("want version 2 pending, got %v", pending)
+	}
+
+	if _, err = Down(db, testList); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Down(db, testList); err != ErrNothingToRollBack {
+		t.Error("want ErrNothingToRollBack, got", err)
+	}
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t)
+	defer db.Close()
+
+	broken := append(testList, Migration{Version: 3, Name: "broken", Up: "NOT SQL", Down: ""})
+
+	ran, err := Up(db, broken)
+	if err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	if len(ran) != 2 {
+		t.Error("want the first two migrations kept, got", len(ran))
+	}
+
+	pending, err := Pending(db, broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Version != 3 {
+		t.Errorf("want version 3 pending, got %v", pending)
+	}
+}
+
+func TestPendingContextOnlyReads(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t)
+	defer db.Close()
+
+	if _, err := PendingContext(context.Background(), db, testList); err == nil {
+		t.Error("want an error before schema_migrations exists")
+	}
+	if _, err := db.Exec("SELECT * FROM schema_migrations"); err == nil {
+		t.Error("want schema_migrations left uncreated")
+	}
+
+	if _, err := Up(db, testList[1:]); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := PendingContext(context.Background(), db, testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Version != 2 {
+		t.Errorf("want version 2 pending, got %v", pending)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = PendingContext(ctx, db, testList); err == nil {
+		t.Error("want an error once ctx is done")
+	}
+}
+
+func TestRealMigrationsRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t)
+	defer db.Close()
+
+	if _, err := Up(db, SQLite); err != nil {
+		t.Fatal(err)
+	}
+	for range SQLite {
+		if _, err := Down(db, SQLite); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Up(db, SQLite); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,456 @@
+package migrations
+
+// Postgres is the migration history for the Postgres backend.
+//
+// 0001 uses IF NOT EXISTS so that databases created by hand from the old
+// create_table.sql can adopt migrations without losing data.
+var Postgres = []Migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		Up: `
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS organisations (
+  id	UUID		PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name	VARCHAR(50)	NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+  id		UUID		PRIMARY KEY DEFAULT uuid_generate_v4(),
+  facebook_id	VARCHAR(128)	NOT NULL,
+  org_id	UUID		NULL REFERENCES organisations(id)
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+  id		UUID		PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name		VARCHAR(100)	NOT NULL,
+  expires	TIMESTAMP	NOT NULL,
+  org_id	UUID		NOT NULL REFERENCES organisations(id)
+);
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+  user_id	UUID		NOT NULL REFERENCES users(id),
+  token_id	UUID		NOT NULL REFERENCES tokens(id),
+  number	SMALLINT	DEFAULT 1,
+  PRIMARY KEY(user_id, token_id)
+);
+`,
+		// The uuid-ossp extension is left installed; other schemas may use it.
+		Down: `
+DROP TABLE user_tokens;
+DROP TABLE tokens;
+DROP TABLE users;
+DROP TABLE organisations;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "index_list_filters",
+		Up: `
+CREATE INDEX tokens_org_id_idx ON tokens (org_id);
+CREATE INDEX tokens_expires_idx ON tokens (expires);
+CREATE INDEX users_org_id_idx ON users (org_id);
+CREATE INDEX users_facebook_id_idx ON users (facebook_id);
+`,
+		Down: `
+DROP INDEX users_facebook_id_idx;
+DROP INDEX users_org_id_idx;
+DROP INDEX tokens_expires_idx;
+DROP INDEX tokens_org_id_idx;
+`,
+	},
+	{
+		Version: 3,
+		Name:    "create_idempotency_keys",
+		Up: `
+CREATE TABLE idempotency_keys (
+  key		VARCHAR(255)	PRIMARY KEY,
+  method	VARCHAR(10)	NOT NULL,
+  path		TEXT		NOT NULL,
+  request_hash	CHAR(64)	NOT NULL,
+  status	SMALLINT	NULL,
+  content_type	TEXT		NULL,
+  body		BYTEA		NULL,
+  created_at	TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
+`,
+		Down: `
+DROP TABLE idempotency_keys;
+`,
+	},
+	{
+		Version: 4,
+		Name:    "create_balance_events",
+		// Every committed balance change is kept for a while, so that event
+		// streams can resume, and announced on the balance_events channel
+		// when its transaction commits, so that every instance hears of it.
+		//
+		// Ids are taken under a lock held until commit, so that they are in
+		// commit order and a stream resuming after one cannot miss an event
+		// that committed later with a smaller id.
+		Up: `
+CREATE TABLE balance_events (
+  id		BIGSERIAL	PRIMARY KEY,
+  type		VARCHAR(20)	NOT NULL,
+  org_id	UUID		NOT NULL,
+  token_id	UUID		NOT NULL,
+  user_id	UUID		NOT NULL,
+  from_user_id	UUID		NULL,
+  number	SMALLINT	NOT NULL,
+  balance	SMALLINT	NOT NULL,
+  from_balance	SMALLINT	NULL,
+  at		TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX balance_events_user_id_idx ON balance_events (user_id, id);
+CREATE INDEX balance_events_from_user_id_idx ON balance_events (from_user_id, id);
+CREATE INDEX balance_events_at_idx ON balance_events (at);
+
+CREATE FUNCTION order_balance_event() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_advisory_xact_lock(hashtext('balance_events'));
+  NEW.id := nextval('balance_events_id_seq');
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER balance_events_order BEFORE INSERT ON balance_events
+  FOR EACH ROW EXECUTE PROCEDURE order_balance_event();
+
+CREATE FUNCTION notify_balance_event() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('balance_events', NEW.id::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER balance_events_notify AFTER INSERT ON balance_events
+  FOR EACH ROW EXECUTE PROCEDURE notify_balance_event();
+`,
+		Down: `
+DROP TRIGGER balance_events_notify ON balance_events;
+DROP TRIGGER balance_events_order ON balance_events;
+DROP FUNCTION notify_balance_event();
+DROP FUNCTION order_balance_event();
+DROP TABLE balance_events;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "create_webhooks",
+		// webhook_deliveries is the outbox: a row is written for every
+		// subscribed webhook in the same transaction as the balance change
+		// it reports, and webhook_attempts logs each try at sending it.
+		Up: `
+CREATE TABLE webhooks (
+  id		UUID		PRIMARY KEY,
+  org_id	UUID		NOT NULL REFERENCES organisations(id),
+  url		TEXT		NOT NULL,
+  events	VARCHAR(100)	NOT NULL,
+  secret	VARCHAR(100)	NOT NULL,
+  created_at	TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX webhooks_org_id_idx ON webhooks (org_id);
+
+CREATE TABLE webhook_deliveries (
+  id			UUID		PRIMARY KEY,
+  webhook_id		UUID		NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  event_id		BIGINT		NOT NULL,
+  event_type		VARCHAR(20)	NOT NULL,
+  payload		TEXT		NOT NULL,
+  state			VARCHAR(10)	NOT NULL,
+  attempts		INTEGER		NOT NULL,
+  next_attempt_at	TIMESTAMP	NULL,
+  created_at		TIMESTAMP	NOT NULL,
+  delivered_at		TIMESTAMP	NULL
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (state, next_attempt_at);
+CREATE INDEX webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
+
+CREATE TABLE webhook_attempts (
+  id		BIGSERIAL	PRIMARY KEY,
+  delivery_id	UUID		NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+  at		TIMESTAMP	NOT NULL,
+  status_code	SMALLINT	NULL,
+  error		TEXT		NULL,
+  duration_ms	INTEGER		NOT NULL
+);
+
+CREATE INDEX webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id, id);
+`,
+		Down: `
+DROP TABLE webhook_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
+`,
+	},
+	{
+		Version: 6,
+		Name:    "create_relay_cursors",
+		// balance_events doubles as the outbox relays publish from. Each
+		// relay's cursor is the id of the last event its sink accepted,
+		// and the lease stops two instances relaying to one sink at once.
+		Up: `
+CREATE TABLE relay_cursors (
+  name		VARCHAR(100)	PRIMARY KEY,
+  last_id	BIGINT		NOT NULL,
+  owner		VARCHAR(36)	NULL,
+  lease_until	TIMESTAMP	NULL
+);
+`,
+		Down: `
+DROP TABLE relay_cursors;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "create_org_keys",
+		// Only a hash of each key is kept, so that the keys cannot be read
+		// back from the database.
+		Up: `
+CREATE TABLE org_keys (
+  id		UUID		PRIMARY KEY,
+  org_id	UUID		NOT NULL REFERENCES organisations(id),
+  name		VARCHAR(100)	NOT NULL,
+  prefix	VARCHAR(12)	NOT NULL,
+  hash		CHAR(64)	NOT NULL UNIQUE,
+  created_at	TIMESTAMP	NOT NULL,
+  revoked_at	TIMESTAMP	NULL
+);
+
+CREATE INDEX org_keys_org_id_idx ON org_keys (org_id);
+`,
+		Down: `
+DROP TABLE org_keys;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "create_redemption_codes",
+		Up: `
+CREATE TABLE redemption_codes (
+  code		VARCHAR(10)	PRIMARY KEY,
+  user_id	UUID		NOT NULL REFERENCES users(id),
+  token_id	UUID		NOT NULL REFERENCES tokens(id),
+  number	SMALLINT	NOT NULL,
+  created_at	TIMESTAMP	NOT NULL,
+  expires_at	TIMESTAMP	NOT NULL,
+  redeemed_at	TIMESTAMP	NULL,
+  redeemed_by	UUID		NULL REFERENCES org_keys(id)
+);
+
+CREATE INDEX redemption_codes_expires_at_idx ON redemption_codes (expires_at);
+`,
+		Down: `
+DROP TABLE redemption_codes;
+`,
+	},
+	{
+		Version: 9,
+		Name:    "create_vouchers",
+		// The signing keys' private halves are kept in the database, like
+		// webhook secrets, so that every server process can issue vouchers.
+		// At most one of an organisation's keys signs at a time; retired
+		// keys are kept to check vouchers they signed.
+		//
+		// Vouchers are settled at most once, and every failed settlement is
+		// recorded as a conflict. A conflict's voucher_id is not a
+		// reference, since vouchers that fail verification may name one
+		// that was never issued.
+		Up: `
+CREATE TABLE voucher_keys (
+  id		UUID		PRIMARY KEY,
+  org_id	UUID		NOT NULL REFERENCES organisations(id),
+  public_key	CHAR(64)	NOT NULL,
+  seed		CHAR(64)	NOT NULL,
+  created_at	TIMESTAMP	NOT NULL,
+  retired_at	TIMESTAMP	NULL
+);
+
+CREATE UNIQUE INDEX voucher_keys_active_idx ON voucher_keys (org_id) WHERE retired_at IS NULL;
+
+CREATE TABLE vouchers (
+  id		UUID		PRIMARY KEY,
+  org_id	UUID		NOT NULL REFERENCES organisations(id),
+  key_id	UUID		NOT NULL REFERENCES voucher_keys(id),
+  user_id	UUID		NOT NULL REFERENCES users(id),
+  token_id	UUID		NOT NULL REFERENCES tokens(id),
+  number	SMALLINT	NOT NULL,
+  issued_at	TIMESTAMP	NOT NULL,
+  expires_at	TIMESTAMP	NOT NULL,
+  redeemed_at	TIMESTAMP	NULL,
+  settled_at	TIMESTAMP	NULL,
+  terminal	VARCHAR(100)	NULL
+);
+
+CREATE TABLE voucher_conflicts (
+  id		UUID		PRIMARY KEY,
+  org_id	UUID		NOT NULL REFERENCES organisations(id),
+  voucher_id	VARCHAR(36)	NOT NULL,
+  reason	VARCHAR(30)	NOT NULL,
+  terminal	VARCHAR(100)	NOT NULL,
+  redeemed_at	TIMESTAMP	NOT NULL,
+  settled_by	VARCHAR(100)	NULL,
+  detected_at	TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX voucher_conflicts_org_id_idx ON voucher_conflicts (org_id, detected_at);
+`,
+		Down: `
+DROP TABLE voucher_conflicts;
+DROP TABLE vouchers;
+DROP TABLE voucher_keys;
+`,
+	},
+	{
+		Version: 10,
+		Name:    "create_gift_codes",
+		// Gift codes are bearer codes printed on cards, so they are kept
+		// as they are, to be exported again for reprints; the batch's
+		// revocation covers every code in it that has not been claimed.
+		// claimed counts the batch's claimed codes, kept up to date as
+		// each is claimed so that reports need not count them.
+		Up: `
+CREATE TABLE gift_batches (
+  id		UUID		PRIMARY KEY,
+  org_id	UUID		NOT NULL REFERENCES organisations(id),
+  token_id	UUID		NOT NULL REFERENCES tokens(id),
+  number	SMALLINT	NOT NULL,
+  size		INTEGER		NOT NULL,
+  claimed	INTEGER		NOT NULL DEFAULT 0,
+  created_at	TIMESTAMP	NOT NULL,
+  revoked_at	TIMESTAMP	NULL
+);
+
+CREATE INDEX gift_batches_org_id_idx ON gift_batches (org_id);
+
+CREATE TABLE gift_codes (
+  code		CHAR(16)	PRIMARY KEY,
+  batch_id	UUID		NOT NULL REFERENCES gift_batches(id),
+  claimed_by	UUID		NULL REFERENCES users(id),
+  claimed_at	TIMESTAMP	NULL
+);
+
+CREATE INDEX gift_codes_batch_id_idx ON gift_codes (batch_id, code);
+`,
+		Down: `
+DROP TABLE gift_codes;
+DROP TABLE gift_batches;
+`,
+	},
+	{
+		Version: 11,
+		Name:    "add_token_supply",
+		// Caps are optional, so tokens created before them have none.
+		// token_supply keeps running totals of each token's units, since
+		// balance_events is pruned; it starts from what is held now, as
+		// what was spent or expired before cannot be recovered.
+		Up: `
+ALTER TABLE tokens ADD COLUMN max_supply INTEGER NULL;
+ALTER TABLE tokens ADD COLUMN user_cap SMALLINT NULL;
+
+CREATE TABLE token_supply (
+  token_id	UUID		PRIMARY KEY REFERENCES tokens(id),
+  issued	INTEGER		NOT NULL DEFAULT 0,
+  spent		INTEGER		NOT NULL DEFAULT 0,
+  expired	INTEGER		NOT NULL DEFAULT 0
+);
+
+INSERT INTO token_supply (token_id, issued)
+  SELECT token_id, COALESCE(SUM(number), 0) FROM user_tokens GROUP BY token_id;
+`,
+		Down: `
+DROP TABLE token_supply;
+ALTER TABLE tokens DROP COLUMN user_cap;
+ALTER TABLE tokens DROP COLUMN max_supply;
+`,
+	},
+	{
+		Version: 12,
+		Name:    "add_rate_limits",
+		// Buckets are keyed by limit and caller, e.g. spends:<user id>, so
+		// that every instance draws on the same ones. A bucket untouched for
+		// a whole period is full again and can be pruned.
+		//
+		// Refusals are recorded as balance events too, naming the limit.
+		Up: `
+ALTER TABLE balance_events ADD COLUMN limit_name VARCHAR(20) NULL;
+
+CREATE TABLE rate_limit_buckets (
+  key		VARCHAR(100)	PRIMARY KEY,
+  tokens	DOUBLE PRECISION	NOT NULL,
+  at		TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_at_idx ON rate_limit_buckets (at);
+`,
+		Down: `
+DROP TABLE rate_limit_buckets;
+ALTER TABLE balance_events DROP COLUMN limit_name;
+`,
+	},
+	{
+		Version: 13,
+		Name:    "add_fraud_alerts",
+		// Alerts outlive the events that raised them, which are pruned, so
+		// they keep what the rule counted. frozen is what the alert froze:
+		// "user", "holding", or nothing. A frozen user or holding stays
+		// frozen until an admin unfreezes it.
+		Up: `
+ALTER TABLE users ADD COLUMN frozen_at TIMESTAMP NULL;
+ALTER TABLE user_tokens ADD COLUMN frozen_at TIMESTAMP NULL;
+
+CREATE TABLE fraud_alerts (
+  id		UUID		PRIMARY KEY,
+  rule		VARCHAR(30)	NOT NULL,
+  user_id	UUID		NOT NULL REFERENCES users(id),
+  token_id	UUID		NOT NULL REFERENCES tokens(id),
+  event_id	BIGINT		NOT NULL,
+  count		INTEGER		NOT NULL,
+  frozen	VARCHAR(10)	NOT NULL DEFAULT '',
+  at		TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX fraud_alerts_at_idx ON fraud_alerts (at);
+CREATE INDEX fraud_alerts_subject_idx ON fraud_alerts (rule, user_id, token_id, at);
+`,
+		Down: `
+DROP TABLE fraud_alerts;
+ALTER TABLE user_tokens DROP COLUMN frozen_at;
+ALTER TABLE users DROP COLUMN frozen_at;
+`,
+	},
+	{
+		Version: 14,
+		Name:    "add_user_first_seen",
+		// When a user first took part in a balance change, so that fraud
+		// rules can tell new users from old ones once the events that
+		// showed it have been pruned. Holders none of whose events are
+		// kept any more are taken to have been seen before the log begins.
+		Up: `
+CREATE TABLE user_first_seen (
+  user_id	UUID		PRIMARY KEY REFERENCES users(id),
+  at		TIMESTAMP	NOT NULL
+);
+
+INSERT INTO user_first_seen (user_id, at)
+  SELECT user_id, MIN(at) FROM (
+    SELECT user_id, at FROM balance_events WHERE type <> 'rate_limited'
+    UNION ALL
+    SELECT from_user_id, at FROM balance_events WHERE type <> 'rate_limited' AND from_user_id IS NOT NULL
+  ) AS seen GROUP BY user_id;
+
+INSERT INTO user_first_seen (user_id, at)
+  SELECT DISTINCT user_id, '1970-01-01 00:00:00' FROM user_tokens
+  WHERE user_id NOT IN (SELECT user_id FROM user_first_seen);
+`,
+		Down: `
+DROP TABLE user_first_seen;
+`,
+	},
+}
@@ -0,0 +1,380 @@
+package migrations
+
+// SQLite is the migration history for the SQLite backend. It matches
+// Postgres version for version, but has no uuid_generate_v4() defaults.
+var SQLite = []Migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		Up: `
+CREATE TABLE IF NOT EXISTS organisations (
+  id	TEXT		PRIMARY KEY,
+  name	VARCHAR(50)	NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+  id		TEXT		PRIMARY KEY,
+  facebook_id	VARCHAR(128)	NOT NULL,
+  org_id	TEXT		NULL REFERENCES organisations(id)
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+  id		TEXT		PRIMARY KEY,
+  name		VARCHAR(100)	NOT NULL,
+  expires	TIMESTAMP	NOT NULL,
+  org_id	TEXT		NOT NULL REFERENCES organisations(id)
+);
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+  user_id	TEXT		NOT NULL REFERENCES users(id),
+  token_id	TEXT		NOT NULL REFERENCES tokens(id),
+  number	SMALLINT	DEFAULT 1,
+  PRIMARY KEY(user_id, token_id)
+);
+`,
+		Down: `
+DROP TABLE user_tokens;
+DROP TABLE tokens;
+DROP TABLE users;
+DROP TABLE organisations;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "index_list_filters",
+		Up: `
+CREATE INDEX tokens_org_id_idx ON tokens (org_id);
+CREATE INDEX tokens_expires_idx ON tokens (expires);
+CREATE INDEX users_org_id_idx ON users (org_id);
+CREATE INDEX users_facebook_id_idx ON users (facebook_id);
+`,
+		Down: `
+DROP INDEX users_facebook_id_idx;
+DROP INDEX users_org_id_idx;
+DROP INDEX tokens_expires_idx;
+DROP INDEX tokens_org_id_idx;
+`,
+	},
+	{
+		Version: 3,
+		Name:    "create_idempotency_keys",
+		Up: `
+CREATE TABLE idempotency_keys (
+  key		VARCHAR(255)	PRIMARY KEY,
+  method	VARCHAR(10)	NOT NULL,
+  path		TEXT		NOT NULL,
+  request_hash	CHAR(64)	NOT NULL,
+  status	SMALLINT	NULL,
+  content_type	TEXT		NULL,
+  body		BLOB		NULL,
+  created_at	TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
+`,
+		Down: `
+DROP TABLE idempotency_keys;
+`,
+	},
+	{
+		Version: 4,
+		Name:    "create_balance_events",
+		Up: `
+CREATE TABLE balance_events (
+  id		INTEGER		PRIMARY KEY AUTOINCREMENT,
+  type		VARCHAR(20)	NOT NULL,
+  org_id	TEXT		NOT NULL,
+  token_id	TEXT		NOT NULL,
+  user_id	TEXT		NOT NULL,
+  from_user_id	TEXT		NULL,
+  number	SMALLINT	NOT NULL,
+  balance	SMALLINT	NOT NULL,
+  from_balance	SMALLINT	NULL,
+  at		TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX balance_events_user_id_idx ON balance_events (user_id, id);
+CREATE INDEX balance_events_from_user_id_idx ON balance_events (from_user_id, id);
+CREATE INDEX balance_events_at_idx ON balance_events (at);
+`,
+		Down: `
+DROP TABLE balance_events;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "create_webhooks",
+		Up: `
+CREATE TABLE webhooks (
+  id		TEXT		PRIMARY KEY,
+  org_id	TEXT		NOT NULL REFERENCES organisations(id),
+  url		TEXT		NOT NULL,
+  events	VARCHAR(100)	NOT NULL,
+  secret	VARCHAR(100)	NOT NULL,
+  created_at	TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX webhooks_org_id_idx ON webhooks (org_id);
+
+CREATE TABLE webhook_deliveries (
+  id			TEXT		PRIMARY KEY,
+  webhook_id		TEXT		NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  event_id		BIGINT		NOT NULL,
+  event_type		VARCHAR(20)	NOT NULL,
+  payload		TEXT		NOT NULL,
+  state			VARCHAR(10)	NOT NULL,
+  attempts		INTEGER		NOT NULL,
+  next_attempt_at	TIMESTAMP	NULL,
+  created_at		TIMESTAMP	NOT NULL,
+  delivered_at		TIMESTAMP	NULL
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (state, next_attempt_at);
+CREATE INDEX webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
+
+CREATE TABLE webhook_attempts (
+  id		INTEGER		PRIMARY KEY AUTOINCREMENT,
+  delivery_id	TEXT		NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+  at		TIMESTAMP	NOT NULL,
+  status_code	SMALLINT	NULL,
+  error		TEXT		NULL,
+  duration_ms	INTEGER		NOT NULL
+);
+
+CREATE INDEX webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id, id);
+`,
+		Down: `
+DROP TABLE webhook_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
+`,
+	},
+	{
+		Version: 6,
+		Name:    "create_relay_cursors",
+		Up: `
+CREATE TABLE relay_cursors (
+  name		VARCHAR(100)	PRIMARY KEY,
+  last_id	BIGINT		NOT NULL,
+  owner		VARCHAR(36)	NULL,
+  lease_until	TIMESTAMP	NULL
+);
+`,
+		Down: `
+DROP TABLE relay_cursors;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "create_org_keys",
+		Up: `
+CREATE TABLE org_keys (
+  id		TEXT		PRIMARY KEY,
+  org_id	TEXT		NOT NULL REFERENCES organisations(id),
+  name		VARCHAR(100)	NOT NULL,
+  prefix	VARCHAR(12)	NOT NULL,
+  hash		CHAR(64)	NOT NULL UNIQUE,
+  created_at	TIMESTAMP	NOT NULL,
+  revoked_at	TIMESTAMP	NULL
+);
+
+CREATE INDEX org_keys_org_id_idx ON org_keys (org_id);
+`,
+		Down: `
+DROP TABLE org_keys;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "create_redemption_codes",
+		Up: `
+CREATE TABLE redemption_codes (
+  code		VARCHAR(10)	PRIMARY KEY,
+  user_id	TEXT		NOT NULL REFERENCES users(id),
+  token_id	TEXT		NOT NULL REFERENCES tokens(id),
+  number	SMALLINT	NOT NULL,
+  created_at	TIMESTAMP	NOT NULL,
+  expires_at	TIMESTAMP	NOT NULL,
+  redeemed_at	TIMESTAMP	NULL,
+  redeemed_by	TEXT		NULL REFERENCES org_keys(id)
+);
+
+CREATE INDEX redemption_codes_expires_at_idx ON redemption_codes (expires_at);
+`,
+		Down: `
+DROP TABLE redemption_codes;
+`,
+	},
+	{
+		Version: 9,
+		Name:    "create_vouchers",
+		Up: `
+CREATE TABLE voucher_keys (
+  id		TEXT		PRIMARY KEY,
+  org_id	TEXT		NOT NULL REFERENCES organisations(id),
+  public_key	CHAR(64)	NOT NULL,
+  seed		CHAR(64)	NOT NULL,
+  created_at	TIMESTAMP	NOT NULL,
+  retired_at	TIMESTAMP	NULL
+);
+
+CREATE UNIQUE INDEX voucher_keys_active_idx ON voucher_keys (org_id) WHERE retired_at IS NULL;
+
+CREATE TABLE vouchers (
+  id		TEXT		PRIMARY KEY,
+  org_id	TEXT		NOT NULL REFERENCES organisations(id),
+  key_id	TEXT		NOT NULL REFERENCES voucher_keys(id),
+  user_id	TEXT		NOT NULL REFERENCES users(id),
+  token_id	TEXT		NOT NULL REFERENCES tokens(id),
+  number	SMALLINT	NOT NULL,
+  issued_at	TIMESTAMP	NOT NULL,
+  expires_at	TIMESTAMP	NOT NULL,
+  redeemed_at	TIMESTAMP	NULL,
+  settled_at	TIMESTAMP	NULL,
+  terminal	VARCHAR(100)	NULL
+);
+
+CREATE TABLE voucher_conflicts (
+  id		TEXT		PRIMARY KEY,
+  org_id	TEXT		NOT NULL REFERENCES organisations(id),
+  voucher_id	VARCHAR(36)	NOT NULL,
+  reason	VARCHAR(30)	NOT NULL,
+  terminal	VARCHAR(100)	NOT NULL,
+  redeemed_at	TIMESTAMP	NOT NULL,
+  settled_by	VARCHAR(100)	NULL,
+  detected_at	TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX voucher_conflicts_org_id_idx ON voucher_conflicts (org_id, detected_at);
+`,
+		Down: `
+DROP TABLE voucher_conflicts;
+DROP TABLE vouchers;
+DROP TABLE voucher_keys;
+`,
+	},
+	{
+		Version: 10,
+		Name:    "create_gift_codes",
+		Up: `
+CREATE TABLE gift_batches (
+  id		TEXT		PRIMARY KEY,
+  org_id	TEXT		NOT NULL REFERENCES organisations(id),
+  token_id	TEXT		NOT NULL REFERENCES tokens(id),
+  number	SMALLINT	NOT NULL,
+  size		INTEGER		NOT NULL,
+  claimed	INTEGER		NOT NULL DEFAULT 0,
+  created_at	TIMESTAMP	NOT NULL,
+  revoked_at	TIMESTAMP	NULL
+);
+
+CREATE INDEX gift_batches_org_id_idx ON gift_batches (org_id);
+
+CREATE TABLE gift_codes (
+  code		CHAR(16)	PRIMARY KEY,
+  batch_id	TEXT		NOT NULL REFERENCES gift_batches(id),
+  claimed_by	TEXT		NULL REFERENCES users(id),
+  claimed_at	TIMESTAMP	NULL
+);
+
+CREATE INDEX gift_codes_batch_id_idx ON gift_codes (batch_id, code);
+`,
+		Down: `
+DROP TABLE gift_codes;
+DROP TABLE gift_batches;
+`,
+	},
+	{
+		Version: 11,
+		Name:    "add_token_supply",
+		Up: `
+ALTER TABLE tokens ADD COLUMN max_supply INTEGER NULL;
+ALTER TABLE tokens ADD COLUMN user_cap SMALLINT NULL;
+
+CREATE TABLE token_supply (
+  token_id	TEXT		PRIMARY KEY REFERENCES tokens(id),
+  issued	INTEGER		NOT NULL DEFAULT 0,
+  spent		INTEGER		NOT NULL DEFAULT 0,
+  expired	INTEGER		NOT NULL DEFAULT 0
+);
+
+INSERT INTO token_supply (token_id, issued)
+  SELECT token_id, COALESCE(SUM(number), 0) FROM user_tokens GROUP BY token_id;
+`,
+		Down: `
+DROP TABLE token_supply;
+ALTER TABLE tokens DROP COLUMN user_cap;
+ALTER TABLE tokens DROP COLUMN max_supply;
+`,
+	},
+	{
+		Version: 12,
+		Name:    "add_rate_limits",
+		Up: `
+ALTER TABLE balance_events ADD COLUMN limit_name VARCHAR(20) NULL;
+
+CREATE TABLE rate_limit_buckets (
+  key		VARCHAR(100)	PRIMARY KEY,
+  tokens	DOUBLE PRECISION	NOT NULL,
+  at		TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_at_idx ON rate_limit_buckets (at);
+`,
+		Down: `
+DROP TABLE rate_limit_buckets;
+ALTER TABLE balance_events DROP COLUMN limit_name;
+`,
+	},
+	{
+		Version: 13,
+		Name:    "add_fraud_alerts",
+		Up: `
+ALTER TABLE users ADD COLUMN frozen_at TIMESTAMP NULL;
+ALTER TABLE user_tokens ADD COLUMN frozen_at TIMESTAMP NULL;
+
+CREATE TABLE fraud_alerts (
+  id		TEXT		PRIMARY KEY,
+  rule		VARCHAR(30)	NOT NULL,
+  user_id	TEXT		NOT NULL REFERENCES users(id),
+  token_id	TEXT		NOT NULL REFERENCES tokens(id),
+  event_id	BIGINT		NOT NULL,
+  count		INTEGER		NOT NULL,
+  frozen	VARCHAR(10)	NOT NULL DEFAULT '',
+  at		TIMESTAMP	NOT NULL
+);
+
+CREATE INDEX fraud_alerts_at_idx ON fraud_alerts (at);
+CREATE INDEX fraud_alerts_subject_idx ON fraud_alerts (rule, user_id, token_id, at);
+`,
+		Down: `
+DROP TABLE fraud_alerts;
+ALTER TABLE user_tokens DROP COLUMN frozen_at;
+ALTER TABLE users DROP COLUMN frozen_at;
+`,
+	},
+	{
+		Version: 14,
+		Name:    "add_user_first_seen",
+		Up: `
+CREATE TABLE user_first_seen (
+  user_id	TEXT		PRIMARY KEY REFERENCES users(id),
+  at		TIMESTAMP	NOT NULL
+);
+
+INSERT INTO user_first_seen (user_id, at)
+  SELECT user_id, MIN(at) FROM (
+    SELECT user_id, at FROM balance_events WHERE type <> 'rate_limited'
+    UNION ALL
+    SELECT from_user_id, at FROM balance_events WHERE type <> 'rate_limited' AND from_user_id IS NOT NULL
+  ) AS seen GROUP BY user_id;
+
+INSERT INTO user_first_seen (user_id, at)
+  SELECT DISTINCT user_id, '1970-01-01 00:00:00' FROM user_tokens
+  WHERE user_id NOT IN (SELECT user_id FROM user_first_seen);
+`,
+		Down: `
+DROP TABLE user_first_seen;
+`,
+	},
+}
@@ -14,14 +14,17 @@ import (
 	"github.com/vattle/sqlboiler/queries"
 	"github.com/vattle/sqlboiler/queries/qm"
 	"github.com/vattle/sqlboiler/strmangle"
+	"gopkg.in/nullbio/null.v6"
 )
 
 // Token is an object representing the database table.
 type Token struct {
-	ID      string    `boil:"id" json:"id" toml:"id" yaml:"id"`
-	Name    string    `boil:"name" json:"name" toml:"name" yaml:"name"`
-	Expires time.Time `boil:"expires" json:"expires" toml:"expires" yaml:"expires"`
-	OrgID   string    `boil:"org_id" json:"org_id" toml:"org_id" yaml:"org_id"`
+	ID        string     `boil:"id" json:"id" toml:"id" yaml:"id"`
+	Name      string     `boil:"name" json:"name" toml:"name" yaml:"name"`
+	Expires   time.Time  `boil:"expires" json:"expires" toml:"expires" yaml:"expires"`
+	OrgID     string     `boil:"org_id" json:"org_id" toml:"org_id" yaml:"org_id"`
+	MaxSupply null.Int   `boil:"max_supply" json:"max_supply,omitempty" toml:"max_supply" yaml:"max_supply,omitempty"`
+	UserCap   null.Int16 `boil:"user_cap" json:"user_cap,omitempty" toml:"user_cap" yaml:"user_cap,omitempty"`
 
 	R *tokenR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L tokenL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -37,8 +40,8 @@ type tokenR struct {
 type tokenL struct{}
 
 var (
-	tokenColumns               = []string{"id", "name", "expires", "org_id"}
-	tokenColumnsWithoutDefault = []string{"name", "expires", "org_id"}
+	tokenColumns               = []string{"id", "name", "expires", "org_id", "max_supply", "user_cap"}
+	tokenColumnsWithoutDefault = []string{"name", "expires", "org_id", "max_supply", "user_cap"}
 	tokenColumnsWithDefault    = []string{"id"}
 	tokenPrimaryKeyColumns     = []string{"id"}
 )
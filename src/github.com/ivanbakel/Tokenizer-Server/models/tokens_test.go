@@ -781,7 +781,7 @@ func testTokensSelect(t *testing.T) {
 }
 
 var (
-	tokenDBTypes = map[string]string{`Expires`: `timestamp without time zone`, `ID`: `uuid`, `Name`: `character varying`, `OrgID`: `uuid`}
+	tokenDBTypes = map[string]string{`Expires`: `timestamp without time zone`, `ID`: `uuid`, `MaxSupply`: `integer`, `Name`: `character varying`, `OrgID`: `uuid`, `UserCap`: `smallint`}
 	_            = bytes.MinRead
 )
 
@@ -19,9 +19,10 @@ import (
 
 // UserToken is an object representing the database table.
 type UserToken struct {
-	UserID  string     `boil:"user_id" json:"user_id" toml:"user_id" yaml:"user_id"`
-	TokenID string     `boil:"token_id" json:"token_id" toml:"token_id" yaml:"token_id"`
-	Number  null.Int16 `boil:"number" json:"number,omitempty" toml:"number" yaml:"number,omitempty"`
+	UserID   string     `boil:"user_id" json:"user_id" toml:"user_id" yaml:"user_id"`
+	TokenID  string     `boil:"token_id" json:"token_id" toml:"token_id" yaml:"token_id"`
+	Number   null.Int16 `boil:"number" json:"number,omitempty" toml:"number" yaml:"number,omitempty"`
+	FrozenAt null.Time  `boil:"frozen_at" json:"frozen_at,omitempty" toml:"frozen_at" yaml:"frozen_at,omitempty"`
 
 	R *userTokenR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L userTokenL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -37,8 +38,8 @@ type userTokenR struct {
 type userTokenL struct{}
 
 var (
-	userTokenColumns               = []string{"user_id", "token_id", "number"}
-	userTokenColumnsWithoutDefault = []string{"user_id", "token_id"}
+	userTokenColumns               = []string{"user_id", "token_id", "number", "frozen_at"}
+	userTokenColumnsWithoutDefault = []string{"user_id", "token_id", "frozen_at"}
 	userTokenColumnsWithDefault    = []string{"number"}
 	userTokenPrimaryKeyColumns     = []string{"user_id", "token_id"}
 )
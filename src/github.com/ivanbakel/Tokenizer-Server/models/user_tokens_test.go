@@ -733,7 +733,7 @@ func testUserTokensSelect(t *testing.T) {
 }
 
 var (
-	userTokenDBTypes = map[string]string{`Number`: `smallint`, `TokenID`: `uuid`, `UserID`: `uuid`}
+	userTokenDBTypes = map[string]string{`FrozenAt`: `timestamp without time zone`, `Number`: `smallint`, `TokenID`: `uuid`, `UserID`: `uuid`}
 	_                = bytes.MinRead
 )
 
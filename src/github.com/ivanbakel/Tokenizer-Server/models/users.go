@@ -22,6 +22,7 @@ type User struct {
 	ID         string      `boil:"id" json:"id" toml:"id" yaml:"id"`
 	FacebookID string      `boil:"facebook_id" json:"facebook_id" toml:"facebook_id" yaml:"facebook_id"`
 	OrgID      null.String `boil:"org_id" json:"org_id,omitempty" toml:"org_id" yaml:"org_id,omitempty"`
+	FrozenAt   null.Time   `boil:"frozen_at" json:"frozen_at,omitempty" toml:"frozen_at" yaml:"frozen_at,omitempty"`
 
 	R *userR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L userL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -37,8 +38,8 @@ type userR struct {
 type userL struct{}
 
 var (
-	userColumns               = []string{"id", "facebook_id", "org_id"}
-	userColumnsWithoutDefault = []string{"facebook_id", "org_id"}
+	userColumns               = []string{"id", "facebook_id", "org_id", "frozen_at"}
+	userColumnsWithoutDefault = []string{"facebook_id", "org_id", "frozen_at"}
 	userColumnsWithDefault    = []string{"id"}
 	userPrimaryKeyColumns     = []string{"id"}
 )
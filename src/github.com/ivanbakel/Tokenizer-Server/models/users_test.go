@@ -834,7 +834,7 @@ func testUsersSelect(t *testing.T) {
 }
 
 var (
-	userDBTypes = map[string]string{`FacebookID`: `character varying`, `ID`: `uuid`, `OrgID`: `uuid`}
+	userDBTypes = map[string]string{`FacebookID`: `character varying`, `FrozenAt`: `timestamp without time zone`, `ID`: `uuid`, `OrgID`: `uuid`}
 	_           = bytes.MinRead
 )
 
@@ -0,0 +1,12 @@
+// Package openapi holds the OpenAPI 3 document describing the server's API.
+//
+// The document is written by hand. Adding a route without describing it here
+// fails TestSpecCoversRoutes in the main package.
+package openapi
+
+import _ "embed"
+
+// Spec is the OpenAPI document, as JSON.
+//
+//go:embed openapi.json
+var Spec []byte
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/metrics"
+	"github.com/ivanbakel/Tokenizer-Server/openapi"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// specOperations returns the methods the spec documents for each path.
+func specOperations(t *testing.T) map[string]map[string]bool {
+	var spec struct {
+		Paths	map[string]map[string]json.RawMessage	`json:"paths"`
+	}
+
+	if err := json.Unmarshal(openapi.Spec, &spec); err != nil {
+		t.Fatal("the spec is not valid JSON:", err)
+	}
+
+	var operations = make(map[string]map[string]bool)
+
+	for path,item := range spec.Paths {
+		operations[path] = make(map[string]bool)
+
+		for key := range item {
+			if key != "parameters" {
+				operations[path][strings.ToUpper(key)] = true
+			}
+		}
+	}
+
+	return operations
+}
+
+// routeOperations returns the methods the router serves for each path
+// template. HEAD is left out, since it is implied by GET. Bare paths that
+// alias a versioned API route are left out too; the spec describes them once.
+func routeOperations(t *testing.T, r *mux.Router) map[string]map[string]bool {
+	var operations = make(map[string]map[string]bool)
+
+	var err = r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		var template,err = route.GetPathTemplate()
+
+		if err != nil {
+			return nil
+		}
+
+		methods,err := route.GetMethods()
+
+		if err != nil {
+			// Routes without a method restriction, such as legacy
+			// redirects, are documented as POST.
+			methods = []string{http.MethodPost}
+		}
+
+		if route.GetHandler() == nil {
+			return nil
+		}
+
+		if operations[template] == nil {
+			operations[template] = make(map[string]bool)
+		}
+
+		for _,method := range methods {
+			if method != http.MethodHead {
+				operations[template][method] = true
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _,version := range versions {
+		var versioned = mux.NewRouter()
+
+		version.routes(versioned, config.Default())
+		versioned.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			var template,_ = route.GetPathTemplate()
+
+			delete(operations, template)
+			return nil
+		})
+	}
+
+	return operations
+}
+
+func TestSpecCoversRoutes(t *testing.T) {
+	var router = newRouter(config.Default(), nil, storage.SQLite, metrics.New(nil), logging.New(ioutil.Discard))
+	var spec = specOperations(t)
+	var routes = routeOperations(t, router)
+
+	for path,methods := range routes {
+		for method := range methods {
+			if !spec[path][method] {
+				t.Errorf("%s %s is routed but missing from the spec", method, path)
+			}
+		}
+	}
+
+	var stale []string
+
+	for path,methods := range spec {
+		for method := range methods {
+			if !routes[path][method] {
+				stale = append(stale, method + " " + path)
+			}
+		}
+	}
+
+	sort.Strings(stale)
+
+	for _,operation := range stale {
+		t.Errorf("%s is in the spec but not routed", operation)
+	}
+}
@@ -2,12 +2,41 @@ package main
 
 import (
 	"net/http"
+	"encoding/json"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/service"
 )
 
 func getOrgs(w http.ResponseWriter, r *http.Request) {
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var orgs,next,err = service.ListOrganisations(q)
 
+		var items = make([]interface{}, len(orgs))
+
+		for i,org := range orgs {
+			items[i] = v1Organisation(org)
+		}
+
+		return items, next, err
+	})
 }
 
 func getOrg(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	org,err := service.GetOrganisation(orgID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
 
+	encoder.Encode(v1Organisation(org))
 }
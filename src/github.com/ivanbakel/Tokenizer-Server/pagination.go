@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+)
+
+const ndjsonType = "application/x-ndjson"
+
+// lister loads the page of a collection that q selects, already converted to
+// the representation clients are sent, and the cursor of the next page.
+type lister func(q service.ListQuery) ([]interface{}, string, error)
+
+// collection is the envelope every list endpoint responds with.
+type collection struct {
+	Items		[]interface{}	`json:"items"`
+	NextCursor	string		`json:"next_cursor,omitempty"`
+}
+
+// writeCollection serves a collection. ?limit=, ?cursor= and ?sort= select
+// the page, and every other query parameter is passed on as a filter.
+//
+// Clients that accept application/x-ndjson are instead streamed every item
+// after the cursor, one per line, read from the database a page at a time.
+func writeCollection(w http.ResponseWriter, r *http.Request, list lister) {
+	var q,err = listQuery(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	if acceptsNDJSON(r) {
+		streamCollection(w, r, list, q)
+		return
+	}
+
+	items,next,err := list(q)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	if items == nil {
+		items = []interface{}{}
+	}
+
+	json.NewEncoder(w).Encode(collection{Items: items, NextCursor: next})
+}
+
+func streamCollection(w http.ResponseWriter, r *http.Request, list lister, q service.ListQuery) {
+	var encoder *json.Encoder = json.NewEncoder(w)
+	var flusher,_ = w.(http.Flusher)
+	var started = false
+
+	q.Limit = service.MaxPageSize
+
+	for {
+		var items,next,err = list(q)
+
+		if err != nil {
+			if !started {
+				apierror.Write(w, r, err)
+			} else {
+				// The status has been sent; all that is left is to cut the
+				// stream short, which the client sees as a truncated body.
+				logging.Error(r, "collection stream failed", err)
+			}
+			return
+		}
+
+		if !started {
+			w.Header().Set("Content-Type", ndjsonType)
+			started = true
+		}
+
+		for _,item := range items {
+			encoder.Encode(item)
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if next == "" || r.Context().Err() != nil {
+			return
+		}
+
+		q.Cursor = next
+	}
+}
+
+// listQuery reads a service.ListQuery from the query string.
+func listQuery(r *http.Request) (service.ListQuery, error) {
+	var values = r.URL.Query()
+	var q = service.ListQuery{Cursor: values.Get("cursor"), Sort: values.Get("sort"), Filters: make(map[string]string)}
+
+	if raw := values.Get("limit"); raw != "" {
+		var limit,err = strconv.Atoi(raw)
+
+		if err != nil || limit < 1 || limit > service.MaxPageSize {
+			return q, apierror.BadRequest(apierror.CodeInvalidQuery, fmt.Sprintf("limit must be between 1 and %d", service.MaxPageSize))
+		}
+
+		q.Limit = limit
+	}
+
+	for name := range values {
+		switch name {
+		case "limit", "cursor", "sort":
+		default:
+			q.Filters[name] = values.Get(name)
+		}
+	}
+
+	return q, nil
+}
+
+func acceptsNDJSON(r *http.Request) bool {
+	for _,accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType,_,err := mime.ParseMediaType(strings.TrimSpace(accepted)); err == nil && mediaType == ndjsonType {
+			return true
+		}
+	}
+
+	return false
+}
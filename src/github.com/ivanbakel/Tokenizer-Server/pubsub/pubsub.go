@@ -0,0 +1,144 @@
+// Package pubsub fans committed balance events out to the streams watching
+// the users they concern.
+//
+// A broker is fed from every source of events in the process: storage's
+// listeners for changes made here, and the backend's Listen for changes made
+// by other instances. The same event can arrive from both, so the broker
+// remembers the ids it has recently delivered and drops repeats.
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// DefaultBuffer is how many events a subscriber can fall behind by before it
+// is dropped.
+const DefaultBuffer = 64
+
+// defaultRemember is how many recent event ids a broker keeps to drop
+// repeats. It only needs to cover the lag between the two sources.
+const defaultRemember = 4096
+
+// Broker delivers events to subscriptions by user.
+type Broker struct {
+	buffer int
+
+	mut  sync.Mutex
+	subs map[string]map[*Subscription]struct{}
+
+	// seen holds the ids in recent, which is used as a ring.
+	seen   map[int64]struct{}
+	recent []int64
+	next   int
+}
+
+// New returns a broker whose subscribers may each fall buffer events behind.
+func New(buffer int) *Broker {
+	return &Broker{
+		buffer: buffer,
+		subs:   make(map[string]map[*Subscription]struct{}),
+		seen:   make(map[int64]struct{}),
+		recent: make([]int64, defaultRemember),
+	}
+}
+
+// Subscription receives the events concerning one user.
+type Subscription struct {
+	broker *Broker
+	userID string
+	events chan storage.Event
+
+	// dropped is set under the broker's lock when the subscription is
+	// closed for falling behind.
+	dropped bool
+}
+
+// Subscribe starts delivering events concerning userID. The subscription
+// must be closed when it is no longer wanted.
+func (b *Broker) Subscribe(userID string) *Subscription {
+	s := &Subscription{broker: b, userID: userID, events: make(chan storage.Event, b.buffer)}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[*Subscription]struct{})
+	}
+	b.subs[userID][s] = struct{}{}
+	return s
+}
+
+// Events delivers the subscription's events in the order they were
+// published. It is closed when the subscription is, including when the
+// subscriber falls too far behind; see Dropped.
+func (s *Subscription) Events() <-chan storage.Event {
+	return s.events
+}
+
+// Dropped reports whether the subscription was closed because its
+// subscriber fell behind, rather than by Close. Events published since may
+// have been missed, and should be caught up from storage.
+func (s *Subscription) Dropped() bool {
+	s.broker.mut.Lock()
+	defer s.broker.mut.Unlock()
+	return s.dropped
+}
+
+// Close stops delivery. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.broker.mut.Lock()
+	defer s.broker.mut.Unlock()
+	s.broker.remove(s)
+}
+
+func (b *Broker) remove(s *Subscription) {
+	subs, ok := b.subs[s.userID]
+	if !ok {
+		return
+	}
+	if _, ok = subs[s]; !ok {
+		return
+	}
+
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(b.subs, s.userID)
+	}
+	close(s.events)
+}
+
+// Publish delivers event to the subscribers of every user it concerns,
+// unless it has been published already. It never blocks: a subscriber whose
+// buffer is full is dropped instead.
+func (b *Broker) Publish(event storage.Event) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if event.ID != 0 {
+		if _, ok := b.seen[event.ID]; ok {
+			return
+		}
+		delete(b.seen, b.recent[b.next])
+		b.recent[b.next] = event.ID
+		b.next = (b.next + 1) % len(b.recent)
+		b.seen[event.ID] = struct{}{}
+	}
+
+	b.deliver(event.UserID, event)
+	if event.FromUserID != "" && event.FromUserID != event.UserID {
+		b.deliver(event.FromUserID, event)
+	}
+}
+
+func (b *Broker) deliver(userID string, event storage.Event) {
+	for s := range b.subs[userID] {
+		select {
+		case s.events <- event:
+		default:
+			s.dropped = true
+			b.remove(s)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+func receive(s *Subscription) []storage.Event {
+	var events []storage.Event
+	for {
+		select {
+		case event, ok := <-s.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestDeliversByUser(t *testing.T) {
+	t.Parallel()
+
+	b := New(DefaultBuffer)
+	alice, bob := b.Subscribe("alice"), b.Subscribe("bob")
+	defer alice.Close()
+	defer bob.Close()
+
+	b.Publish(storage.Event{ID: 1, Type: storage.EventGranted, UserID: "alice"})
+	b.Publish(storage.Event{ID: 2, Type: storage.EventTransferred, UserID: "bob", FromUserID: "alice"})
+	b.Publish(storage.Event{ID: 3, Type: storage.EventSpent, UserID: "carol"})
+
+	if got := receive(alice); len(got) != 2 || got[0].ID != 1 || got[1].ID != 2 {
+		t.Errorf("want alice to see events 1 and 2, got %+v", got)
+	}
+	if got := receive(bob); len(got) != 1 || got[0].ID != 2 {
+		t.Errorf("want bob to see the transfer only, got %+v", got)
+	}
+}
+
+func TestDropsRepeats(t *testing.T) {
+	t.Parallel()
+
+	b := New(DefaultBuffer)
+	s := b.Subscribe("alice")
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		b.Publish(storage.Event{ID: 7, UserID: "alice"})
+	}
+
+	if got := receive(s); len(got) != 1 {
+		t.Errorf("want a repeated event delivered once, got %+v", got)
+	}
+}
+
+func TestForgetsOldIDs(t *testing.T) {
+	t.Parallel()
+
+	b := New(1)
+	for id := int64(1); id <= defaultRemember+1; id++ {
+		b.Publish(storage.Event{ID: id, UserID: "nobody"})
+	}
+	if len(b.seen) != defaultRemember {
+		t.Errorf("want %d ids remembered, got %d", defaultRemember, len(b.seen))
+	}
+	if _, ok := b.seen[1]; ok {
+		t.Error("want the oldest id forgotten")
+	}
+}
+
+func TestDropsSlowSubscribers(t *testing.T) {
+	t.Parallel()
+
+	b := New(2)
+	slow := b.Subscribe("alice")
+
+	for id := int64(1); id <= 3; id++ {
+		b.Publish(storage.Event{ID: id, UserID: "alice"})
+	}
+
+	if got := receive(slow); len(got) != 2 {
+		t.Errorf("want the buffered events before the close, got %+v", got)
+	}
+	if !slow.Dropped() {
+		t.Error("want the subscriber dropped for falling behind")
+	}
+
+	slow.Close()
+
+	closed := b.Subscribe("bob")
+	closed.Close()
+	closed.Close()
+	if closed.Dropped() {
+		t.Error("want a closed subscriber not reported as dropped")
+	}
+	if _, ok := <-closed.Events(); ok {
+		t.Error("want events closed after Close")
+	}
+}
@@ -0,0 +1,185 @@
+// Package qr encodes short text as a QR code (ISO/IEC 18004) and renders it
+// as an image. It covers only what the server needs to print codes for
+// scanning at a till: alphanumeric and byte mode, error correction level M,
+// and versions 1 to 6, which hold up to 106 bytes.
+package qr
+
+import (
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTooLong is returned for text that does not fit in a version 6 code.
+var ErrTooLong = errors.New("qr: text is too long to encode")
+
+// QuietZone is the width, in modules, of the light border readers need
+// around a code.
+const QuietZone = 4
+
+// version describes the layout of a QR code version at level M.
+type version struct {
+	blocks       int
+	dataPerBlock int
+	ecPerBlock   int
+	// align is the row and column of the centre of the alignment pattern,
+	// or 0 for version 1, which has none.
+	align int
+}
+
+var versions = [...]version{
+	1: {blocks: 1, dataPerBlock: 16, ecPerBlock: 10},
+	2: {blocks: 1, dataPerBlock: 28, ecPerBlock: 16, align: 18},
+	3: {blocks: 1, dataPerBlock: 44, ecPerBlock: 26, align: 22},
+	4: {blocks: 2, dataPerBlock: 32, ecPerBlock: 18, align: 26},
+	5: {blocks: 2, dataPerBlock: 43, ecPerBlock: 24, align: 30},
+	6: {blocks: 4, dataPerBlock: 27, ecPerBlock: 16, align: 34},
+}
+
+const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// Code is an encoded QR code: a square of dark and light modules.
+type Code struct {
+	// Size is the number of modules along each side.
+	Size int
+
+	dark     []bool
+	function []bool
+}
+
+// Encode returns the smallest code holding text, using alphanumeric mode if
+// text is made up only of the characters it allows, and byte mode otherwise.
+func Encode(text string) (*Code, error) {
+	alnum := true
+	for _, r := range text {
+		if !strings.ContainsRune(alphanumeric, r) {
+			alnum = false
+			break
+		}
+	}
+
+	var bits bitBuffer
+	if alnum {
+		bits.append(0x2, 4)
+		bits.append(uint(len(text)), 9)
+		for i := 0; i+1 < len(text); i += 2 {
+			bits.append(uint(strings.IndexByte(alphanumeric, text[i])*45+strings.IndexByte(alphanumeric, text[i+1])), 11)
+		}
+		if len(text)%2 == 1 {
+			bits.append(uint(strings.IndexByte(alphanumeric, text[len(text)-1])), 6)
+		}
+	} else {
+		if len(text) > 255 {
+			return nil, ErrTooLong
+		}
+		bits.append(0x4, 4)
+		bits.append(uint(len(text)), 8)
+		for i := 0; i < len(text); i++ {
+			bits.append(uint(text[i]), 8)
+		}
+	}
+
+	v := 1
+	for ; v < len(versions); v++ {
+		if len(bits) <= versions[v].blocks*versions[v].dataPerBlock*8 {
+			break
+		}
+	}
+	if v == len(versions) {
+		return nil, ErrTooLong
+	}
+
+	c := newCode(v)
+	c.placeData(interleave(versions[v], pad(bits, versions[v].blocks*versions[v].dataPerBlock)))
+	c.applyBestMask()
+	return c, nil
+}
+
+// Dark reports whether the module at column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.dark[y*c.Size+x]
+}
+
+// Image renders the code with each module scale pixels square, surrounded
+// by the quiet zone.
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+
+	side := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex((x+QuietZone)*scale+dx, (y+QuietZone)*scale+dy, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// pad ends the data with a terminator and fills it out to capacity bytes
+// with the standard pad codewords.
+func pad(bits bitBuffer, capacity int) []byte {
+	for i := 0; i < 4 && len(bits) < capacity*8; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	data := bits.bytes()
+	for i := 0; len(data) < capacity; i++ {
+		data = append(data, [2]byte{0xEC, 0x11}[i%2])
+	}
+	return data
+}
+
+// interleave splits data into the version's blocks, adds each block's error
+// correction, and interleaves the codewords in the order they are placed.
+func interleave(v version, data []byte) []byte {
+	blocks := make([][]byte, v.blocks)
+	ec := make([][]byte, v.blocks)
+	for i := range blocks {
+		blocks[i] = data[i*v.dataPerBlock : (i+1)*v.dataPerBlock]
+		ec[i] = reedSolomon(blocks[i], v.ecPerBlock)
+	}
+
+	var result []byte
+	for _, group := range [][][]byte{blocks, ec} {
+		for i := range group[0] {
+			for _, block := range group {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// bitBuffer is a sequence of bits, most significant first.
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, value>>uint(i)&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			result[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return result
+}
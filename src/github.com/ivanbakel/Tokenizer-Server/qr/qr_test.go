@@ -0,0 +1,144 @@
+package qr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The worked example from the standard's tutorials: HELLO WORLD as a
+// version 1-M code.
+var (
+	helloData = []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	helloEC   = []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+)
+
+func TestEncodesData(t *testing.T) {
+	t.Parallel()
+
+	var bits bitBuffer
+	bits.append(0x2, 4)
+	bits.append(11, 9)
+	for _, pair := range []string{"HE", "LL", "O ", "WO", "RL"} {
+		bits.append(uint(strings.IndexByte(alphanumeric, pair[0])*45+strings.IndexByte(alphanumeric, pair[1])), 11)
+	}
+	bits.append(uint(strings.IndexByte(alphanumeric, 'D')), 6)
+
+	if got := pad(bits, 16); !bytes.Equal(got, helloData) {
+		t.Errorf("data codewords = %v, want %v", got, helloData)
+	}
+	if got := reedSolomon(helloData, 10); !bytes.Equal(got, helloEC) {
+		t.Errorf("error correction = %v, want %v", got, helloEC)
+	}
+}
+
+// formatBits reads the copy of the format information around the top left
+// finder, most significant bit first.
+func formatBits(c *Code) string {
+	var b strings.Builder
+	put := func(x, y int) {
+		if c.Dark(x, y) {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+
+	for x := 0; x <= 5; x++ {
+		put(x, 8)
+	}
+	put(7, 8)
+	put(8, 8)
+	put(8, 7)
+	for y := 5; y >= 0; y-- {
+		put(8, y)
+	}
+	return b.String()
+}
+
+func TestFormatInformation(t *testing.T) {
+	t.Parallel()
+
+	// The level M rows of the standard's table of format information.
+	want := []string{
+		"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+		"100010111111001", "100000011001110", "100111110010111", "100101010100000",
+	}
+
+	for mask, bits := range want {
+		c := newCode(1)
+		c.drawFormat(mask)
+		if got := formatBits(c); got != bits {
+			t.Errorf("mask %d: format = %s, want %s", mask, got, bits)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	for text, size := range map[string]int{
+		"HELLO WORLD":                 21,
+		"7KQ2M9XH4D":                  21,
+		"https://example.com/r/ABCDE": 29,
+		strings.Repeat("x", 106):      41,
+	} {
+		c, err := Encode(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Size != size {
+			t.Errorf("%q: size = %d, want %d", text, c.Size, size)
+		}
+
+		// Each finder pattern has a dark centre and a light separator.
+		for _, corner := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+			if !c.Dark(corner[0], corner[1]) {
+				t.Errorf("%q: finder at %v is not dark in the centre", text, corner)
+			}
+		}
+		if c.Dark(7, 7) || c.Dark(c.Size-8, 7) || c.Dark(7, c.Size-8) {
+			t.Errorf("%q: separators are not light", text)
+		}
+		if !c.Dark(8, c.Size-8) {
+			t.Errorf("%q: the dark module is light", text)
+		}
+
+		// The format information names one of the masks.
+		mask := -1
+		for m := 0; m < 8; m++ {
+			probe := newCode(1)
+			probe.drawFormat(m)
+			if formatBits(probe) == formatBits(c) {
+				mask = m
+			}
+		}
+		if mask < 0 {
+			t.Fatalf("%q: unreadable format information", text)
+		}
+	}
+
+	if _, err := Encode(strings.Repeat("x", 107)); err != ErrTooLong {
+		t.Errorf("want text over capacity refused, got %v", err)
+	}
+}
+
+func TestImage(t *testing.T) {
+	t.Parallel()
+
+	c, err := Encode("HELLO WORLD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := c.Image(4)
+	if side := (21 + 2*QuietZone) * 4; img.Bounds().Dx() != side || img.Bounds().Dy() != side {
+		t.Errorf("image is %v, want %d square", img.Bounds(), side)
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Error("want the quiet zone light")
+	}
+	if r, _, _, _ := img.At(QuietZone*4, QuietZone*4).RGBA(); r != 0 {
+		t.Error("want the top left finder's corner dark")
+	}
+}
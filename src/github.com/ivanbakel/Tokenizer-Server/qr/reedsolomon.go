@@ -0,0 +1,57 @@
+package qr
+
+// Error correction codewords are computed over GF(256), built from the
+// primitive polynomial x^8 + x^4 + x^3 + x^2 + 1.
+var gfExp, gfLog [256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	gfExp[255] = gfExp[0]
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+int(gfLog[b]))%255]
+}
+
+// generator returns the coefficients, highest degree first and without the
+// leading 1, of the product of (x - α^i) for i from 0 to n-1.
+func generator(n int) []byte {
+	g := []byte{1}
+	for i := 0; i < n; i++ {
+		next := make([]byte, len(g)+1)
+		for j, coef := range g {
+			next[j] ^= coef
+			next[j+1] ^= gfMul(coef, gfExp[i])
+		}
+		g = next
+	}
+	return g[1:]
+}
+
+// reedSolomon returns the n error correction codewords for data: the
+// remainder of dividing data, as a polynomial, by the generator.
+func reedSolomon(data []byte, n int) []byte {
+	g := generator(n)
+	rem := make([]byte, n)
+
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[n-1] = 0
+		for i, coef := range g {
+			rem[i] ^= gfMul(coef, factor)
+		}
+	}
+	return rem
+}
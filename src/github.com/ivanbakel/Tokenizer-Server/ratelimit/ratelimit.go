@@ -0,0 +1,190 @@
+// Package ratelimit throttles callers with a token bucket per key, such as
+// per user. A bucket holds up to a limit's Max tokens and refills evenly over
+// its period, so that callers may spend Max in a burst or spread them out,
+// but no more than Max in any period.
+//
+// Buckets are kept in a Store: in memory, where each instance enforces its
+// own limits, or in the database, where every instance sharing it draws on
+// the same buckets.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// Limit allows Max units in each period of length Per. A zero Max is no
+// limit at all.
+type Limit struct {
+	Max int
+	Per time.Duration
+}
+
+// Store keeps token buckets by key.
+type Store interface {
+	// Update passes the bucket stored under key to fn, and stores the tokens
+	// and time fn returns in its place. A bucket not stored yet starts with
+	// full tokens as of now. Updates of one bucket must not overlap.
+	Update(key string, full float64, now time.Time, fn func(tokens float64, at time.Time) (float64, time.Time)) error
+
+	// Prune forgets every bucket last updated before the given time.
+	Prune(before time.Time) error
+}
+
+// Limiter enforces a limit separately for each key it is given.
+type Limiter struct {
+	// Name identifies the limit, e.g. in errors and events. Limiters sharing
+	// a store must have different names.
+	Name  string
+	Limit Limit
+
+	store Store
+	now   func() time.Time
+}
+
+// New returns a limiter keeping its buckets in store.
+func New(name string, limit Limit, store Store) *Limiter {
+	return &Limiter{Name: name, Limit: limit, store: store, now: time.Now}
+}
+
+// Fits reports whether cost could ever be taken, that is whether it is no
+// more than the limit's Max.
+func (l *Limiter) Fits(cost int) bool {
+	return l == nil || l.Limit.Max <= 0 || cost <= l.Limit.Max
+}
+
+// Take takes cost tokens from key's bucket. If it holds too few, none are
+// taken, and Take returns how long until it would hold enough. A cost that
+// does not fit never will; the wait returned for it is a whole period.
+func (l *Limiter) Take(key string, cost int) (time.Duration, error) {
+	if l == nil || l.Limit.Max <= 0 {
+		return 0, nil
+	}
+
+	full := float64(l.Limit.Max)
+	rate := full / l.Limit.Per.Seconds()
+	want := float64(cost)
+
+	var wait time.Duration
+
+	err := l.store.Update(l.Name+":"+key, full, l.now(), func(tokens float64, at time.Time) (float64, time.Time) {
+		now := l.now()
+
+		// Clocks on different instances may disagree; a bucket updated "in
+		// the future" is simply not refilled.
+		if elapsed := now.Sub(at).Seconds(); elapsed > 0 {
+			tokens = math.Min(full, tokens+elapsed*rate)
+		}
+
+		switch {
+		case tokens >= want:
+			tokens -= want
+		case want > full:
+			wait = l.Limit.Per
+		default:
+			wait = time.Duration(math.Ceil((want - tokens) / rate * float64(time.Second)))
+		}
+		return tokens, now
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return wait, nil
+}
+
+// Refund gives cost tokens back to key's bucket, e.g. after what they were
+// taken for failed. The bucket holds no more than Max however much is given
+// back.
+func (l *Limiter) Refund(key string, cost int) error {
+	if l == nil || l.Limit.Max <= 0 {
+		return nil
+	}
+
+	full := float64(l.Limit.Max)
+	rate := full / l.Limit.Per.Seconds()
+
+	return l.store.Update(l.Name+":"+key, full, l.now(), func(tokens float64, at time.Time) (float64, time.Time) {
+		now := l.now()
+
+		if elapsed := now.Sub(at).Seconds(); elapsed > 0 {
+			tokens += elapsed * rate
+		}
+		return math.Min(full, tokens+float64(cost)), now
+	})
+}
+
+// Report reports whether a refusal for key is the first in a period of the
+// limit, so that a caller refused over and over can be reported once a period
+// rather than every time. It keeps a bucket of its own in the store.
+func (l *Limiter) Report(key string) (bool, error) {
+	reports := &Limiter{Name: l.Name + ":reported", Limit: Limit{Max: 1, Per: l.Limit.Per}, store: l.store, now: l.now}
+
+	wait, err := reports.Take(key, 1)
+	if err != nil {
+		return false, err
+	}
+	return wait == 0, nil
+}
+
+// Memory is a Store for a single instance.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]bucket
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]bucket)}
+}
+
+// Update implements Store.
+func (m *Memory) Update(key string, full float64, now time.Time, fn func(tokens float64, at time.Time) (float64, time.Time)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = bucket{tokens: full, at: now}
+	}
+
+	b.tokens, b.at = fn(b.tokens, b.at)
+	m.buckets[key] = b
+	return nil
+}
+
+// Prune implements Store.
+func (m *Memory) Prune(before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, b := range m.buckets {
+		if b.at.Before(before) {
+			delete(m.buckets, key)
+		}
+	}
+	return nil
+}
+
+// Database is a Store that keeps buckets in storage, so that instances
+// sharing a Postgres database enforce their limits together.
+type Database struct{}
+
+// Update implements Store.
+func (Database) Update(key string, full float64, now time.Time, fn func(tokens float64, at time.Time) (float64, time.Time)) error {
+	return storage.UpdateBucket(key, full, now, fn)
+}
+
+// Prune implements Store.
+func (Database) Prune(before time.Time) error {
+	_, err := storage.PruneBuckets(before)
+	return err
+}
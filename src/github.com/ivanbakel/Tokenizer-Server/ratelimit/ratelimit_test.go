@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func newLimiter(limit Limit) (*Limiter, *time.Time) {
+	clock := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := New("spends", limit, NewMemory())
+	l.now = func() time.Time { return clock }
+	return l, &clock
+}
+
+func take(t *testing.T, l *Limiter, key string, cost int) time.Duration {
+	wait, err := l.Take(key, cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wait
+}
+
+func TestBurstThenRefill(t *testing.T) {
+	l, clock := newLimiter(Limit{Max: 3, Per: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if wait := take(t, l, "alice", 1); wait != 0 {
+			t.Fatalf("take %d: want it allowed, got a wait of %v", i, wait)
+		}
+	}
+	if wait := take(t, l, "alice", 1); wait != 20*time.Second {
+		t.Errorf("want a wait of 20s for the fourth take, got %v", wait)
+	}
+
+	// Other keys have their own buckets.
+	if wait := take(t, l, "bob", 3); wait != 0 {
+		t.Errorf("want bob's take allowed, got a wait of %v", wait)
+	}
+
+	*clock = clock.Add(20 * time.Second)
+	if wait := take(t, l, "alice", 1); wait != 0 {
+		t.Errorf("want a token refilled after 20s, got a wait of %v", wait)
+	}
+
+	// Refilling stops at Max, however long the bucket is left.
+	*clock = clock.Add(time.Hour)
+	if wait := take(t, l, "alice", 4); wait != time.Minute {
+		t.Errorf("want a take over Max to wait a period, got %v", wait)
+	}
+	if wait := take(t, l, "alice", 3); wait != 0 {
+		t.Errorf("want a full bucket after an hour, got a wait of %v", wait)
+	}
+}
+
+func TestCost(t *testing.T) {
+	l, _ := newLimiter(Limit{Max: 100, Per: 24 * time.Hour})
+
+	if !l.Fits(100) || l.Fits(101) {
+		t.Error("want costs up to Max to fit, and no more")
+	}
+
+	if wait := take(t, l, "org", 60); wait != 0 {
+		t.Fatalf("want 60 allowed, got a wait of %v", wait)
+	}
+	// A refused take leaves the bucket as it was.
+	if wait := take(t, l, "org", 50); wait == 0 {
+		t.Fatal("want 50 more refused")
+	}
+	if wait := take(t, l, "org", 40); wait != 0 {
+		t.Errorf("want the remaining 40 allowed, got a wait of %v", wait)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	l, _ := newLimiter(Limit{Max: 100, Per: 24 * time.Hour})
+
+	if wait := take(t, l, "org", 100); wait != 0 {
+		t.Fatalf("want 100 allowed, got a wait of %v", wait)
+	}
+	if err := l.Refund("org", 60); err != nil {
+		t.Fatal(err)
+	}
+	if wait := take(t, l, "org", 60); wait != 0 {
+		t.Errorf("want the refunded 60 allowed, got a wait of %v", wait)
+	}
+
+	// A refund never fills the bucket past Max.
+	if err := l.Refund("org", 500); err != nil {
+		t.Fatal(err)
+	}
+	if wait := take(t, l, "org", 101); wait == 0 {
+		t.Error("want more than Max refused after a large refund")
+	}
+}
+
+func TestReport(t *testing.T) {
+	l, clock := newLimiter(Limit{Max: 1, Per: time.Minute})
+
+	for i, want := range []bool{true, false, false} {
+		report, err := l.Report("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report != want {
+			t.Errorf("refusal %d: want reported %v, got %v", i, want, report)
+		}
+	}
+
+	// Reports are kept apart from the limit's own bucket, and by key.
+	if wait := take(t, l, "alice", 1); wait != 0 {
+		t.Errorf("want reports to leave the bucket alone, got a wait of %v", wait)
+	}
+	if report, _ := l.Report("bob"); !report {
+		t.Error("want bob's first refusal reported")
+	}
+
+	*clock = clock.Add(time.Minute)
+	if report, _ := l.Report("alice"); !report {
+		t.Error("want a refusal in the next period reported")
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	var nilLimiter *Limiter
+	if wait := take(t, nilLimiter, "alice", 1000); wait != 0 {
+		t.Errorf("want a nil limiter to allow everything, got a wait of %v", wait)
+	}
+
+	l, _ := newLimiter(Limit{Per: time.Minute})
+	if wait := take(t, l, "alice", 1000); wait != 0 {
+		t.Errorf("want a zero Max to allow everything, got a wait of %v", wait)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l, clock := newLimiter(Limit{Max: 1, Per: time.Minute})
+	store := l.store.(*Memory)
+
+	take(t, l, "alice", 1)
+	*clock = clock.Add(time.Minute)
+	take(t, l, "bob", 1)
+
+	if err := store.Prune(clock.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.buckets["spends:alice"]; ok {
+		t.Error("want alice's idle bucket pruned")
+	}
+	if _, ok := store.buckets["spends:bob"]; !ok {
+		t.Error("want bob's bucket kept")
+	}
+}
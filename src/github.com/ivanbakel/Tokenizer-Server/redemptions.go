@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/qr"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// codeRetention is how long redemption codes are kept after they expire,
+// so that a till can still be told why a code it was shown failed.
+const codeRetention = 24 * time.Hour
+
+// defaultQRScale is how many pixels each module of a QR code takes, unless
+// the client asks for otherwise.
+const defaultQRScale = 8
+
+func createRedemptionCode(w http.ResponseWriter, r *http.Request) {
+	var userID,err = pathID(r, "uid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	tokenID,err := pathID(r, "tid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var request v1.NewRedemptionCode
+
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	code,err := service.CreateRedemptionCode(userID, tokenID, service.NewRedemptionCode{
+		Number:		request.Number,
+		Lifetime:	time.Duration(request.ExpiresIn) * time.Second,
+	})
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var result = v1RedemptionCode(code)
+
+	w.Header().Set("Location", createdAt(r, code.Code))
+	w.WriteHeader(http.StatusCreated)
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(result)
+}
+
+// redemptionCodeParams reads the user and token ids and the code from the
+// path, and loads the code.
+func redemptionCodeParams(r *http.Request) (*storage.RedemptionCode, error) {
+	var userID,err = pathID(r, "uid")
+
+	if err != nil {
+		return nil, err
+	}
+
+	tokenID,err := pathID(r, "tid")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return service.GetRedemptionCode(userID, tokenID, mux.Vars(r)["code"])
+}
+
+func getRedemptionCode(w http.ResponseWriter, r *http.Request) {
+	var code,err = redemptionCodeParams(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1RedemptionCode(code))
+}
+
+// getRedemptionCodeQR renders a code as a QR image for the till to scan. The
+// image holds just the code, so any scanner can read it into the till's
+// redemption request.
+func getRedemptionCodeQR(w http.ResponseWriter, r *http.Request) {
+	var code,err = redemptionCodeParams(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var scale = defaultQRScale
+
+	if raw := r.URL.Query().Get("scale"); raw != "" {
+		if scale,err = strconv.Atoi(raw); err != nil || scale < 1 || scale > 20 {
+			apierror.Write(w, r, apierror.BadRequest(apierror.CodeInvalidQuery, "scale must be an integer between 1 and 20"))
+			return
+		}
+	}
+
+	symbol,err := qr.Encode(code.Code)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	// Codes cannot change once issued, only be redeemed, so the image can
+	// be kept for as long as the code lasts.
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=" + strconv.Itoa(int(time.Until(code.ExpiresAt).Seconds())))
+
+	png.Encode(w, symbol.Image(scale))
+}
+
+// redeemCode spends what a code was issued for, on behalf of the
+// organisation whose key authenticated the request.
+func redeemCode(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	var request v1.RedeemRequest
+
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	code,holding,err := service.RedeemCode(orgID, orgKeyFrom(r).ID, request.Code)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var result = v1.Redemption{
+		Code:		code.Code,
+		UserID:		code.UserID,
+		TokenID:	code.TokenID,
+		Number:		code.Number,
+		RedeemedAt:	code.RedeemedAt.Time.UTC(),
+		Holding:	v1Holding(holding),
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(result)
+}
+
+func getOrgKeys(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var keys,next,err = service.ListOrgKeys(orgID, q)
+
+		var items = make([]interface{}, len(keys))
+
+		for i,key := range keys {
+			items[i] = v1OrgKey(key)
+		}
+
+		return items, next, err
+	})
+}
+
+func createOrgKey(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	var request v1.NewOrgKey
+
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	key,secret,err := service.CreateOrgKey(orgID, request.Name)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	// The key is shown this once; only its hash is kept.
+	var result = v1OrgKey(key)
+
+	result.Key = secret
+
+	w.WriteHeader(http.StatusCreated)
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(result)
+}
+
+func revokeOrgKey(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	keyID,err := pathID(r, "kid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	if err = service.RevokeOrgKey(orgID, keyID); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pruneRedemptionCodes forgets codes that expired more than codeRetention
+// ago every interval until ctx is done.
+func pruneRedemptionCodes(interval time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _,err := storage.PruneRedemptionCodes(now.Add(-codeRetention)); err != nil {
+					log.Printf("unable to prune redemption codes: %v", err)
+				}
+			}
+		}
+	}
+}
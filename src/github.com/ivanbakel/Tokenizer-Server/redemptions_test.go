@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// redeem asks to redeem code with the given org key, returning the status
+// and, for failures, the problem code.
+func redeem(t *testing.T, url, key, code string) (int, string) {
+	var req,err = http.NewRequest("POST", url, strings.NewReader(`{"code": "` + code + `"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer " + key)
+	}
+
+	resp,err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var problem struct {
+		Code	string	`json:"code"`
+	}
+
+	json.NewDecoder(resp.Body).Decode(&problem)
+
+	return resp.StatusCode, problem.Code
+}
+
+func TestRedemptionCodes(t *testing.T) {
+	var server = newTestServer(t)
+	var db = boil.GetDB()
+
+	var orgs [2]*models.Organisation
+	var keys [2]v1.OrgKey
+
+	for i := range orgs {
+		orgs[i] = &models.Organisation{Name: "till org"}
+
+		if err := orgs[i].Insert(db); err != nil {
+			t.Fatal(err)
+		}
+
+		if status := adminCall(t, "POST", server.URL + "/v1/orgs/" + orgs[i].ID + "/keys", v1.NewOrgKey{Name: "till 1"}, &keys[i]); status != http.StatusCreated {
+			t.Fatalf("create key status = %d, want 201", status)
+		}
+	}
+
+	if !strings.HasPrefix(keys[0].Key, keys[0].Prefix) || keys[0].Key == keys[1].Key {
+		t.Errorf("want distinct keys starting with their prefixes, got %+v and %+v", keys[0], keys[1])
+	}
+
+	var token = &models.Token{Name: "coffee", Expires: time.Now().Add(time.Hour).UTC(), OrgID: orgs[0].ID}
+
+	if err := token.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var user = &models.User{FacebookID: "redeeming user"}
+
+	if err := user.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err := storage.Grant(token.ID, 5, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var codes = server.URL + "/v1/users/" + user.ID + "/tokens/" + token.ID + "/redemption-codes"
+	var redemptions = server.URL + "/v1/orgs/" + orgs[0].ID + "/redemptions"
+
+	if status := adminCall(t, "POST", codes, v1.NewRedemptionCode{Number: 6}, nil); status != http.StatusConflict {
+		t.Errorf("want a code for more than the user holds refused, got %d", status)
+	}
+
+	if status := adminCall(t, "POST", codes, v1.NewRedemptionCode{Number: 1, ExpiresIn: 3600}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("want an overlong lifetime refused, got %d", status)
+	}
+
+	var code v1.RedemptionCode
+
+	if status := adminCall(t, "POST", codes, v1.NewRedemptionCode{Number: 2}, &code); status != http.StatusCreated {
+		t.Fatalf("create code status = %d, want 201", status)
+	}
+
+	if len(code.Code) != 10 || time.Until(code.ExpiresAt) > 5 * time.Minute {
+		t.Errorf("want a ten character code lasting five minutes, got %+v", code)
+	}
+
+	resp,err := http.Get(server.URL + code.QRURL + "?scale=2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img,err := png.Decode(resp.Body)
+
+	resp.Body.Close()
+
+	if err != nil || resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("want a PNG, got %s: %v", resp.Header.Get("Content-Type"), err)
+	}
+
+	if side := img.Bounds().Dx(); side != (21 + 8) * 2 {
+		t.Errorf("QR image is %d pixels wide, want %d", side, (21 + 8) * 2)
+	}
+
+	// Redemption needs a key, and one of the token's organisation's.
+	if status,_ := redeem(t, redemptions, "", code.Code); status != http.StatusUnauthorized {
+		t.Errorf("want redemption without a key refused, got %d", status)
+	}
+
+	if status,_ := redeem(t, redemptions, keys[1].Key, code.Code); status != http.StatusForbidden {
+		t.Errorf("want another organisation's key refused, got %d", status)
+	}
+
+	if status,_ := redeem(t, server.URL + "/v1/orgs/" + orgs[1].ID + "/redemptions", keys[1].Key, code.Code); status != http.StatusNotFound {
+		t.Errorf("want another organisation's token hidden, got %d", status)
+	}
+
+	// Codes are read however they are typed.
+	var typed = strings.ToLower(code.Code[:5]) + "-" + code.Code[5:]
+
+	var redemption v1.Redemption
+
+	if status := bearerCall(t, keys[0].Key, "POST", redemptions, v1.RedeemRequest{Code: typed}, &redemption); status != http.StatusOK {
+		t.Fatalf("redeem status = %d, want 200", status)
+	}
+
+	if redemption.Number != 2 || redemption.Holding.Number != 3 {
+		t.Errorf("want 2 spent leaving 3, got %+v", redemption)
+	}
+
+	if status,problem := redeem(t, redemptions, keys[0].Key, code.Code); status != http.StatusConflict || problem != "code_already_redeemed" {
+		t.Errorf("want a second redemption refused, got %d %s", status, problem)
+	}
+
+	var stored v1.RedemptionCode
+
+	if status := adminCall(t, "GET", codes + "/" + code.Code, nil, &stored); status != http.StatusOK || stored.RedeemedAt == nil {
+		t.Errorf("want the code shown as redeemed, got %d %+v", status, stored)
+	}
+
+	// A code whose units were spent meanwhile stays unredeemed.
+	var short v1.RedemptionCode
+
+	if status := adminCall(t, "POST", codes, v1.NewRedemptionCode{Number: 3}, &short); status != http.StatusCreated {
+		t.Fatalf("create code status = %d, want 201", status)
+	}
+
+	if _,err = storage.Spend(user.ID, token.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if status,problem := redeem(t, redemptions, keys[0].Key, short.Code); status != http.StatusConflict || problem != "insufficient_balance" {
+		t.Errorf("want a code for more than is left refused, got %d %s", status, problem)
+	}
+
+	if _,err = db.Exec(`UPDATE "redemption_codes" SET "expires_at" = $1 WHERE "code" = $2`, time.Now().Add(-time.Minute).UTC(), short.Code); err != nil {
+		t.Fatal(err)
+	}
+
+	if status,problem := redeem(t, redemptions, keys[0].Key, short.Code); status != http.StatusConflict || problem != "code_expired" {
+		t.Errorf("want an expired code refused, got %d %s", status, problem)
+	}
+
+	// Revoked keys stop working at once.
+	if status := adminCall(t, "POST", server.URL + "/v1/orgs/" + orgs[0].ID + "/keys/" + keys[0].ID + "/revoke", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("revoke status = %d, want 204", status)
+	}
+
+	if status,_ := redeem(t, redemptions, keys[0].Key, short.Code); status != http.StatusUnauthorized {
+		t.Errorf("want a revoked key refused, got %d", status)
+	}
+
+	var page struct {
+		Items	[]v1.OrgKey	`json:"items"`
+	}
+
+	if status := adminCall(t, "GET", server.URL + "/v1/orgs/" + orgs[0].ID + "/keys", nil, &page); status != http.StatusOK || len(page.Items) != 1 || page.Items[0].RevokedAt == nil || page.Items[0].Key != "" {
+		t.Errorf("want the revoked key listed without its secret, got %d %+v", status, page.Items)
+	}
+}
+
+// TestConcurrentRedemption has many tills redeem one code at once: exactly
+// one may succeed.
+func TestConcurrentRedemption(t *testing.T) {
+	var server = newTestServer(t)
+	var db = boil.GetDB()
+
+	var org = &models.Organisation{Name: "busy org"}
+
+	if err := org.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var key v1.OrgKey
+
+	if status := adminCall(t, "POST", server.URL + "/v1/orgs/" + org.ID + "/keys", v1.NewOrgKey{Name: "tills"}, &key); status != http.StatusCreated {
+		t.Fatalf("create key status = %d, want 201", status)
+	}
+
+	var token = &models.Token{Name: "tea", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+
+	if err := token.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var user = &models.User{FacebookID: "popular user"}
+
+	if err := user.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err := storage.Grant(token.ID, 10, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var code v1.RedemptionCode
+
+	if status := adminCall(t, "POST", server.URL + "/v1/users/" + user.ID + "/tokens/" + token.ID + "/redemption-codes", v1.NewRedemptionCode{Number: 1}, &code); status != http.StatusCreated {
+		t.Fatalf("create code status = %d, want 201", status)
+	}
+
+	var statuses = make(chan int)
+
+	for i := 0; i < 8; i++ {
+		go func() {
+			var status,_ = redeem(t, server.URL + "/v1/orgs/" + org.ID + "/redemptions", key.Key, code.Code)
+
+			statuses <- status
+		}()
+	}
+
+	var redeemed = 0
+
+	for i := 0; i < 8; i++ {
+		if <-statuses == http.StatusOK {
+			redeemed++
+		}
+	}
+
+	holding,err := models.FindUserToken(db, user.ID, token.ID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if redeemed != 1 || holding.Number.Int16 != 9 {
+		t.Errorf("want one redemption spending one unit, got %d leaving %d", redeemed, holding.Number.Int16)
+	}
+}
+
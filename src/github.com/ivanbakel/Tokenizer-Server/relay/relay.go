@@ -0,0 +1,125 @@
+// Package relay publishes every balance event to a downstream sink, such as
+// an analytics pipeline, in the order the events were recorded.
+//
+// Events are recorded in the same transaction as the balance change they
+// describe, so the event log is an outbox: nothing is published that did not
+// commit, and nothing that committed is missed. A relay's progress is kept
+// in storage and only advanced once the sink has accepted a batch, so
+// delivery is at least once. Consumers that must not count an event twice
+// should skip ids they have seen.
+package relay
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+const (
+	// DefaultBatch is how many events are read and published at once.
+	DefaultBatch = 500
+
+	// leaseTerm is how long a relay holds its cursor without renewing the
+	// lease. Another instance takes over once it lapses.
+	leaseTerm = time.Minute
+)
+
+// Relay publishes the event log to a Sink. Relays on several instances may
+// share a name: one of them holds the lease at a time, and the others wait.
+type Relay struct {
+	// Name identifies the relay's cursor in storage. Giving a relay a new
+	// name starts it again from the oldest event kept. The old name's
+	// cursor is forgotten once it expires; see storage.PruneRelays.
+	Name string
+
+	Sink Sink
+
+	// Batch is how many events are published at once.
+	Batch int
+
+	// PollInterval is how often the relay looks for new events when
+	// nothing has woken it, and how soon it retries after a failure.
+	PollInterval time.Duration
+
+	owner string
+	wake  chan struct{}
+}
+
+// New returns a relay that publishes to sink under the given name.
+func New(name string, sink Sink, pollInterval time.Duration) *Relay {
+	return &Relay{
+		Name:         name,
+		Sink:         sink,
+		Batch:        DefaultBatch,
+		PollInterval: pollInterval,
+		owner:        storage.NewUUID(),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Wake is a storage.Listener that makes Run publish at once, rather than at
+// its next poll.
+func (r *Relay) Wake(storage.Event) {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes events as they are recorded until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Publish(ctx); err != nil && err != storage.ErrLeaseLost {
+			log.Printf("unable to relay events to %s: %v", r.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// Publish sends the sink every event it has not yet accepted, a batch at a
+// time, and returns how many it sent. It returns storage.ErrLeaseLost if
+// another instance is relaying under the same name.
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	published := 0
+
+	for ctx.Err() == nil {
+		now := time.Now()
+
+		last, err := storage.LeaseRelay(r.Name, r.owner, now, now.Add(leaseTerm))
+		if err != nil {
+			return published, err
+		}
+
+		events, err := storage.EventLog(last, r.Batch)
+		if err != nil || len(events) == 0 {
+			return published, err
+		}
+
+		if err = r.Sink.Publish(ctx, events); err != nil {
+			return published, err
+		}
+
+		if err = storage.AdvanceRelay(r.Name, r.owner, events[len(events)-1].ID); err != nil {
+			return published, err
+		}
+
+		published += len(events)
+
+		if len(events) < r.Batch {
+			break
+		}
+	}
+
+	return published, nil
+}
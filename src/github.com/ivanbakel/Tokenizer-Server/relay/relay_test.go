@@ -0,0 +1,218 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// setup opens a fresh SQLite database and records a grant to each of two
+// users and a transfer between them.
+func setup(t *testing.T) (dir string, alice, bob string, token string) {
+	dir, err := ioutil.TempDir("", "relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := storage.Open(storage.SQLite.Name, filepath.Join(dir, "tokenizer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err = migrations.Up(db, storage.SQLite.Migrations); err != nil {
+		t.Fatal(err)
+	}
+	boil.SetDB(db)
+
+	org := &models.Organisation{Name: "relay org"}
+	if err = org.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	tok := &models.Token{Name: "relay token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+	if err = tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, name := range []string{"alice", "bob"} {
+		user := &models.User{FacebookID: name}
+		if err = user.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, user.ID)
+	}
+
+	if _, err = storage.Grant(tok.ID, 3, ids...); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = storage.Transfer(tok.ID, ids[0], ids[1], 1); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, ids[0], ids[1], tok.ID
+}
+
+func TestPublishesInOrderAtLeastOnce(t *testing.T) {
+	_, alice, _, token := setup(t)
+	ctx := context.Background()
+
+	sink := &MemorySink{}
+	r := New("test", sink, time.Hour)
+	r.Batch = 2
+
+	sink.FailWith(errors.New("sink down"))
+	if _, err := r.Publish(ctx); err == nil {
+		t.Fatal("want the sink's failure reported")
+	}
+
+	sink.FailWith(nil)
+	n, err := r.Publish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := sink.Events()
+	if n != 3 || len(events) != 3 {
+		t.Fatalf("want the two grants and the transfer once the sink recovers, got %d: %+v", n, events)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].ID <= events[i-1].ID {
+			t.Errorf("want events in the order they were recorded, got %+v", events)
+		}
+	}
+	if last := events[2]; last.Type != storage.EventTransferred || last.FromUserID != alice {
+		t.Errorf("want the transfer last, got %+v", last)
+	}
+
+	// Only new events are published from now on.
+	if _, err = storage.Spend(alice, token, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = r.Publish(ctx); err != nil || n != 1 {
+		t.Errorf("want only the spend published, got %d, %v", n, err)
+	}
+
+	// Another instance waits for the lease.
+	if _, err = New("test", &MemorySink{}, time.Hour).Publish(ctx); err != storage.ErrLeaseLost {
+		t.Errorf("want a second relay under the same name refused, got %v", err)
+	}
+}
+
+func TestKeepsUnpublishedEvents(t *testing.T) {
+	setup(t)
+	ctx := context.Background()
+
+	sink := &MemorySink{}
+	r := New("test", sink, time.Hour)
+	r.Batch = 1
+
+	sink.FailWith(errors.New("sink down"))
+	r.Publish(ctx)
+	sink.FailWith(nil)
+
+	// The relay published nothing, so nothing is old enough to prune.
+	if pruned, err := storage.PruneEvents(time.Now().Add(time.Hour)); err != nil || pruned != 0 {
+		t.Fatalf("want unpublished events kept, pruned %d, %v", pruned, err)
+	}
+
+	r.Batch = DefaultBatch
+	if _, err := r.Publish(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if pruned, err := storage.PruneEvents(time.Now().Add(time.Hour)); err != nil || pruned != 3 {
+		t.Errorf("want published events pruned, pruned %d, %v", pruned, err)
+	}
+}
+
+func TestForgetsExpiredCursors(t *testing.T) {
+	setup(t)
+
+	sink := &MemorySink{}
+	sink.FailWith(errors.New("sink down"))
+	New("retired", sink, time.Hour).Publish(context.Background())
+
+	if pruned, err := storage.PruneRelays(time.Now()); err != nil || pruned != 0 {
+		t.Fatalf("want a leased cursor kept, pruned %d, %v", pruned, err)
+	}
+	if pruned, err := storage.PruneRelays(time.Now().Add(leaseTerm + time.Minute)); err != nil || pruned != 1 {
+		t.Fatalf("want a lapsed cursor forgotten, pruned %d, %v", pruned, err)
+	}
+
+	// Nothing holds the events back any more.
+	if pruned, err := storage.PruneEvents(time.Now().Add(time.Hour)); err != nil || pruned != 3 {
+		t.Errorf("want the retired relay's events pruned, pruned %d, %v", pruned, err)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, _, _, _ := setup(t)
+	path := filepath.Join(dir, "events.ndjson")
+
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	if _, err = New("file", sink, time.Hour).Publish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var lines int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event storage.Event
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil || event.ID == 0 {
+			t.Errorf("line %d is not an event: %s", lines+1, scanner.Text())
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("want one line per event, got %d", lines)
+	}
+}
+
+type recordingBroker struct {
+	keys []string
+}
+
+func (b *recordingBroker) Send(ctx context.Context, key string, message []byte) error {
+	b.keys = append(b.keys, key)
+	return nil
+}
+
+func TestBrokerSinkKeysByUser(t *testing.T) {
+	t.Parallel()
+
+	broker := &recordingBroker{}
+	events := []storage.Event{
+		{ID: 1, UserID: "alice"},
+		{ID: 2, UserID: "bob", FromUserID: "alice"},
+	}
+
+	if err := (BrokerSink{Broker: broker}).Publish(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	if len(broker.keys) != 2 || broker.keys[0] != "alice" || broker.keys[1] != "bob" {
+		t.Errorf("want messages keyed by the changed holding's user, got %v", broker.keys)
+	}
+}
@@ -0,0 +1,133 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/pkg/errors"
+)
+
+// Sink is where a Relay publishes events. Publish is given each batch in
+// order, and must only return nil once the sink has durably accepted the
+// whole batch; on error, the batch is offered again later, so a sink may see
+// an event more than once.
+type Sink interface {
+	Publish(ctx context.Context, events []storage.Event) error
+}
+
+// FileSink appends events to a file as newline-delimited JSON, one event
+// per line.
+type FileSink struct {
+	path string
+
+	mut  sync.Mutex
+	file *os.File
+}
+
+// NewFileSink returns a sink appending to the file at path, which is created
+// if need be.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "relay: unable to open event file")
+	}
+	return &FileSink{path: path, file: file}, nil
+}
+
+// Publish writes the events and syncs the file.
+func (s *FileSink) Publish(ctx context.Context, events []storage.Event) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	w := bufio.NewWriter(s.file)
+	encoder := json.NewEncoder(w)
+
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return errors.Wrap(err, "relay: unable to write event file")
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "relay: unable to write event file")
+	}
+	return errors.Wrap(s.file.Sync(), "relay: unable to sync event file")
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.file.Close()
+}
+
+// MemorySink keeps the events published to it, for tests.
+type MemorySink struct {
+	mut    sync.Mutex
+	events []storage.Event
+	fail   error
+}
+
+// Publish keeps the events, or fails with the error set by FailWith.
+func (s *MemorySink) Publish(ctx context.Context, events []storage.Event) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.fail != nil {
+		return s.fail
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// FailWith makes every Publish fail with err until it is called again with
+// nil.
+func (s *MemorySink) FailWith(err error) {
+	s.mut.Lock()
+	s.fail = err
+	s.mut.Unlock()
+}
+
+// Events returns the events published so far, in order.
+func (s *MemorySink) Events() []storage.Event {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return append([]storage.Event(nil), s.events...)
+}
+
+// Broker is the part of a message broker's client that BrokerSink needs.
+// Send must only return nil once the broker has durably accepted the
+// message, and must keep the messages sent with the same key in the order
+// they were sent, as a Kafka partition key or a NATS subject does.
+type Broker interface {
+	Send(ctx context.Context, key string, message []byte) error
+}
+
+// BrokerSink publishes each event to a Broker as a JSON message keyed by the
+// id of the user whose holding changed: the recipient, for a transfer.
+// Messages are sent one at a time, in order, so each user's events reach the
+// broker in the order they happened.
+type BrokerSink struct {
+	Broker Broker
+}
+
+// Publish sends the events in order, stopping at the first failure.
+func (s BrokerSink) Publish(ctx context.Context, events []storage.Event) error {
+	for _, event := range events {
+		message, err := json.Marshal(event)
+		if err != nil {
+			return errors.Wrap(err, "relay: unable to encode event")
+		}
+
+		if err = s.Broker.Send(ctx, event.UserID, message); err != nil {
+			return errors.Wrap(err, "relay: unable to send event")
+		}
+	}
+	return nil
+}
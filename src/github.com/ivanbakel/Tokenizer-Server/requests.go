@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+)
+
+// pathID returns the named route variable, which must be a UUID.
+func pathID(r *http.Request, name string) (string, error) {
+	var id = mux.Vars(r)[name]
+
+	return id, service.CheckID(name, id)
+}
+
+// decodeBody reads the JSON request body into into.
+func decodeBody(r *http.Request, into interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(into); err != nil {
+		return apierror.MalformedJSON(apierror.CodeMalformedBody, "the request body", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"github.com/ivanbakel/Tokenizer-Server/graph"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/metrics"
+	"github.com/ivanbakel/Tokenizer-Server/openapi"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// legacySunset is when the deprecated paths redirected by legacyRoutes are
+// due to be removed. Until then they answer with a redirect announcing it.
+const legacySunset = "Mon, 19 Apr 2027 00:00:00 GMT"
+
+// Reads answer GET, and HEAD alongside it.
+var reads = []string{http.MethodGet, http.MethodHead}
+
+// newRouter builds the server's router: the API, the operational endpoints
+// and the handling of requests that match neither. Every route must be
+// described in the OpenAPI document; see TestSpecCoversRoutes.
+func newRouter(cfg *config.Config, db *sql.DB, backend *storage.Backend, registry *metrics.Metrics, logger *logging.Logger) *mux.Router {
+	var r = mux.NewRouter()
+
+	r.Use(logger.Middleware, registry.Middleware)
+	routeErrors(r, logger.Middleware, registry.Middleware)
+
+	if !cfg.Feature("remove_legacy_routes") {
+		legacyRoutes(r)
+	}
+
+	apiRoutes(r, cfg)
+	r.Handle("/graphql", graph.Handler(graph.Limits{MaxDepth: cfg.GraphQL.MaxDepth, MaxComplexity: cfg.GraphQL.MaxComplexity})).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/openapi.json", getSpec).Methods(reads...)
+	r.HandleFunc("/.well-known/voucher-keys/{oid}", getVoucherKeys).Methods(reads...)
+	r.HandleFunc("/healthz", getHealth).Methods(reads...)
+	r.HandleFunc("/readyz", getReadiness(db, backend)).Methods(reads...)
+	r.Handle("/metrics", registry.Handler()).Methods(reads...)
+	r.HandleFunc("/debug/db", requireAdmin(cfg.Auth.AdminToken, getDBStats(db, backend))).Methods(reads...)
+
+	return r
+}
+
+// getSpec serves the OpenAPI document describing every route.
+func getSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openapi.Spec)
+}
+
+// apiVersion is a version of the API that is being served.
+type apiVersion struct {
+	number		int
+	mediaType	string
+	routes		func(*mux.Router, *config.Config)
+}
+
+// versions lists every version served, oldest first. Each is mounted under
+// /v<number>, and on the bare paths for clients that ask for it by media
+// type. A version stays listed for as long as clients may depend on it.
+var versions = []apiVersion{
+	{number: v1.Version, mediaType: v1.MediaType, routes: v1Routes},
+}
+
+// defaultVersion serves bare paths to clients that do not name a version.
+// It must never change, or those clients would break.
+const defaultVersion = v1.Version
+
+var versionedType = regexp.MustCompile(`^application/vnd\.tokenizer\.v([0-9]+)\+json$`)
+
+// apiRoutes mounts every version of the API on r.
+func apiRoutes(r *mux.Router, cfg *config.Config) {
+	for _,version := range versions {
+		var number = version.number
+
+		var prefixed = r.PathPrefix(fmt.Sprintf("/v%d", number)).Subrouter()
+
+		prefixed.Use(announceVersion(version), idempotent)
+		version.routes(prefixed, cfg)
+
+		var negotiated = r.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+			return requestedVersion(req) == number
+		}).Subrouter()
+
+		negotiated.Use(announceVersion(version), idempotent)
+		version.routes(negotiated, cfg)
+	}
+}
+
+// requestedVersion returns the newest served version the Accept header asks
+// for, defaultVersion if it asks for none, or 0 if it only asks for versions
+// that are not served.
+func requestedVersion(r *http.Request) int {
+	var requested = false
+	var best = 0
+
+	for _,accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		var mediaType,_,err = mime.ParseMediaType(strings.TrimSpace(accepted))
+
+		if err != nil {
+			continue
+		}
+
+		var match = versionedType.FindStringSubmatch(mediaType)
+
+		if match == nil {
+			continue
+		}
+
+		requested = true
+
+		var number,_ = strconv.Atoi(match[1])
+
+		for _,version := range versions {
+			if version.number == number && number > best {
+				best = number
+			}
+		}
+	}
+
+	if !requested {
+		return defaultVersion
+	}
+
+	return best
+}
+
+// announceVersion tells clients which version answered. Clients that asked
+// for it by media type get that media type back.
+func announceVersion(version apiVersion) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Tokenizer-API-Version", strconv.Itoa(version.number))
+			w.Header().Add("Vary", "Accept")
+
+			if strings.Contains(r.Header.Get("Accept"), version.mediaType) {
+				w.Header().Set("Content-Type", version.mediaType)
+			} else {
+				w.Header().Set("Content-Type", "application/json")
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// v1Routes registers version 1: reads are GET, new records are POSTed to
+// their collection, changes to existing ones are POSTed to an action
+// beneath them and records are removed with a DELETE of their own path.
+// Webhooks and org keys are managed by admins only, since webhook
+// deliveries reveal every change to an organisation's tokens and org keys
+// can spend them. Redemptions and voucher settlements are made, and voucher
+// conflicts read, with an org key, as are gift code batches managed; anyone
+// holding a gift code can claim it. Fraud alerts are reviewed, and the users
+// and holdings they froze unfrozen, by admins.
+func v1Routes(r *mux.Router, cfg *config.Config) {
+	var admin = func(next http.HandlerFunc) http.HandlerFunc {
+		return requireAdmin(cfg.Auth.AdminToken, next)
+	}
+
+	r.HandleFunc("/users", getUsers).Methods(reads...)
+	r.HandleFunc("/users/{uid}", getUser).Methods(reads...)
+	r.HandleFunc("/users/{uid}/events", getUserEvents).Methods(http.MethodGet)
+	r.HandleFunc("/users/{uid}/unfreeze", admin(unfreezeUser)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{uid}/tokens", getUserTokens).Methods(reads...)
+	r.HandleFunc("/users/{uid}/tokens/{tid}/receive", receiveTokens).Methods(http.MethodPost)
+	r.HandleFunc("/users/{uid}/tokens/{tid}/spend", spendTokens).Methods(http.MethodPost)
+	r.HandleFunc("/users/{uid}/tokens/{tid}/unfreeze", admin(unfreezeHolding)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{uid}/tokens/{tid}/redemption-codes", createRedemptionCode).Methods(http.MethodPost)
+	r.HandleFunc("/users/{uid}/tokens/{tid}/redemption-codes/{code}", getRedemptionCode).Methods(reads...)
+	r.HandleFunc("/users/{uid}/tokens/{tid}/redemption-codes/{code}/qr", getRedemptionCodeQR).Methods(reads...)
+	r.HandleFunc("/users/{uid}/tokens/{tid}/vouchers", issueVoucher).Methods(http.MethodPost)
+	r.HandleFunc("/users/{uid}/gift-codes/claim", claimGiftCode).Methods(http.MethodPost)
+	r.HandleFunc("/tokens", getTokens).Methods(reads...)
+	r.HandleFunc("/tokens", createToken).Methods(http.MethodPost)
+	r.HandleFunc("/tokens/{tid}", getToken).Methods(reads...)
+	r.HandleFunc("/tokens/{tid}/supply", getTokenSupply).Methods(reads...)
+	r.HandleFunc("/tokens/{tid}/grant-group", giveGroupTokens).Methods(http.MethodPost)
+	r.HandleFunc("/tokens/{tid}/grant-user", giveUserTokens).Methods(http.MethodPost)
+	r.HandleFunc("/fraud-alerts", admin(getFraudAlerts)).Methods(reads...)
+	r.HandleFunc("/fraud-alerts/{aid}", admin(getFraudAlert)).Methods(reads...)
+	r.HandleFunc("/orgs", getOrgs).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}", getOrg).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/keys", admin(getOrgKeys)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/keys", admin(createOrgKey)).Methods(http.MethodPost)
+	r.HandleFunc("/orgs/{oid}/keys/{kid}/revoke", admin(revokeOrgKey)).Methods(http.MethodPost)
+	r.HandleFunc("/orgs/{oid}/redemptions", requireOrgKey(redeemCode)).Methods(http.MethodPost)
+	r.HandleFunc("/orgs/{oid}/voucher-keys/rotate", admin(rotateVoucherKey)).Methods(http.MethodPost)
+	r.HandleFunc("/orgs/{oid}/vouchers/settle", requireOrgKey(settleVouchers)).Methods(http.MethodPost)
+	r.HandleFunc("/orgs/{oid}/voucher-conflicts", requireOrgKey(getVoucherConflicts)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/gift-batches", requireOrgKey(getGiftBatches)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/gift-batches", requireOrgKey(createGiftBatch)).Methods(http.MethodPost)
+	r.HandleFunc("/orgs/{oid}/gift-batches/{bid}", requireOrgKey(getGiftBatch)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/gift-batches/{bid}/codes", requireOrgKey(getGiftCodes)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/gift-batches/{bid}/codes.csv", requireOrgKey(exportGiftCodes)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/gift-batches/{bid}/revoke", requireOrgKey(revokeGiftBatch)).Methods(http.MethodPost)
+	r.HandleFunc("/orgs/{oid}/webhooks", admin(getWebhooks)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/webhooks", admin(createWebhook)).Methods(http.MethodPost)
+	r.HandleFunc("/orgs/{oid}/webhooks/{wid}", admin(getWebhook)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/webhooks/{wid}", admin(deleteWebhook)).Methods(http.MethodDelete)
+	r.HandleFunc("/orgs/{oid}/webhooks/{wid}/deliveries", admin(getWebhookDeliveries)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/webhooks/{wid}/deliveries/{did}", admin(getWebhookDelivery)).Methods(reads...)
+	r.HandleFunc("/orgs/{oid}/webhooks/{wid}/deliveries/{did}/redeliver", admin(redeliverWebhook)).Methods(http.MethodPost)
+}
+
+// legacyRoutes redirects paths that have been replaced to their successors.
+// They must be registered before apiRoutes, since some of them would
+// otherwise match a route with a path variable, e.g. /tokens/create matching
+// /tokens/{tid}.
+func legacyRoutes(r *mux.Router) {
+	r.Handle("/tokens/create", deprecated("/v1/tokens"))
+}
+
+// deprecated redirects to successor, keeping the method and body so that
+// old clients carry on working, and tells them the path is going away.
+func deprecated(successor string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", legacySunset)
+		w.Header().Set("Link", "<" + successor + `>; rel="successor-version"`)
+
+		http.Redirect(w, r, successor, http.StatusPermanentRedirect)
+	})
+}
+
+// createdAt returns where the resource id created by r can be found: under
+// the path of the collection route that matched r, so that it carries the
+// same prefix as the request, versioned or not.
+func createdAt(r *http.Request, id string) string {
+	var route = mux.CurrentRoute(r)
+
+	if route == nil {
+		return r.URL.Path + "/" + id
+	}
+
+	var pairs []string
+
+	for name,value := range mux.Vars(r) {
+		pairs = append(pairs, name, value)
+	}
+
+	var collection,err = route.URLPath(pairs...)
+
+	if err != nil {
+		return r.URL.Path + "/" + id
+	}
+
+	return collection.Path + "/" + id
+}
+
+// routeErrors makes requests that match no route fail like any other
+// request, with a problem+json body. Requests whose path matches but whose
+// method does not are told which methods the path supports. mux only runs
+// the router's own middleware for matched routes, so the middleware given is
+// run around these responses too.
+func routeErrors(r *mux.Router, middleware ...mux.MiddlewareFunc) {
+	// mux itself only notices a method mismatch when no later route in the
+	// same subrouter gets part way through matching, so both cases are
+	// worked out here.
+	var unrouted = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if requestedVersion(req) == 0 {
+			apierror.Write(w, req, apierror.New(http.StatusNotAcceptable, apierror.CodeUnsupportedVersion,
+				"none of the API versions in the Accept header are served"))
+			return
+		}
+
+		var allowed = allowedMethods(r, req)
+
+		if len(allowed) == 0 {
+			apierror.Write(w, req, apierror.NotFound("no such endpoint"))
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		apierror.Write(w, req, apierror.New(http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed,
+			req.Method + " is not supported here; use " + strings.Join(allowed, ", ")))
+	})
+
+	var handler http.Handler = unrouted
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	r.NotFoundHandler = handler
+	r.MethodNotAllowedHandler = handler
+}
+
+// allowedMethods lists the methods of every route whose path matches req.
+func allowedMethods(router *mux.Router, req *http.Request) []string {
+	var seen = make(map[string]bool)
+
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		var methods,err = route.GetMethods()
+
+		if err != nil {
+			return nil
+		}
+
+		for _,method := range methods {
+			var match mux.RouteMatch
+			var probe = req.Clone(req.Context())
+
+			probe.Method = method
+
+			if !seen[method] && route.Match(probe, &match) {
+				seen[method] = true
+			}
+		}
+
+		return nil
+	})
+
+	var allowed []string
+
+	for method := range seen {
+		allowed = append(allowed, method)
+	}
+
+	sort.Strings(allowed)
+
+	return allowed
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+)
+
+func newTestRouter() *mux.Router {
+	var r = mux.NewRouter()
+
+	routeErrors(r)
+	legacyRoutes(r)
+	apiRoutes(r, config.Default())
+
+	return r
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	var w = httptest.NewRecorder()
+
+	newTestRouter().ServeHTTP(w, httptest.NewRequest("GET", "/users/5e3bb0d4-2f3c-4b7e-8f5e-1b1f0a4ce0a1/tokens/0b6f2a53-3f4e-4c38-9a43-8c1f4b6d2c11/spend", nil))
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405", w.Code)
+	}
+
+	if allow := w.Header().Get("Allow"); allow != "POST" {
+		t.Errorf("Allow = %q, want POST", allow)
+	}
+
+	w = httptest.NewRecorder()
+
+	newTestRouter().ServeHTTP(w, httptest.NewRequest("DELETE", "/v1/tokens", nil))
+
+	if allow := w.Header().Get("Allow"); w.Code != http.StatusMethodNotAllowed || allow != "GET, HEAD, POST" {
+		t.Errorf("DELETE /v1/tokens = %d with Allow %q, want 405 with GET, HEAD, POST", w.Code, allow)
+	}
+}
+
+func TestUnroutedRequestsAreTagged(t *testing.T) {
+	var server = newTestServer(t)
+
+	for _,c := range []struct {
+		method	string
+		path	string
+		status	int
+	}{
+		{http.MethodGet, "/no/such/endpoint", http.StatusNotFound},
+		{http.MethodDelete, "/v1/tokens", http.StatusMethodNotAllowed},
+	} {
+		var req,err = http.NewRequest(c.method, server.URL + c.path, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp,err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != c.status || resp.Header.Get("X-Request-ID") == "" {
+			t.Errorf("%s %s = %d with X-Request-ID %q, want %d with an ID", c.method, c.path, resp.StatusCode, resp.Header.Get("X-Request-ID"), c.status)
+		}
+	}
+
+	var metrics,err = http.Get(server.URL + "/metrics")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer metrics.Body.Close()
+
+	var body,_ = ioutil.ReadAll(metrics.Body)
+
+	if !strings.Contains(string(body), `code="404",method="GET",route="unmatched"`) || !strings.Contains(string(body), `code="405",method="DELETE",route="unmatched"`) {
+		t.Errorf("want unrouted requests counted, got\n%s", body)
+	}
+}
+
+func TestRequestedVersion(t *testing.T) {
+	var cases = []struct {
+		accept	string
+		want	int
+	}{
+		{"", defaultVersion},
+		{"application/json", defaultVersion},
+		{"application/vnd.tokenizer.v1+json", 1},
+		{"application/vnd.tokenizer.v9+json, application/vnd.tokenizer.v1+json;q=0.5", 1},
+		{"application/vnd.tokenizer.v9+json", 0},
+	}
+
+	for _,c := range cases {
+		var r = httptest.NewRequest("GET", "/users", nil)
+
+		r.Header.Set("Accept", c.accept)
+
+		if got := requestedVersion(r); got != c.want {
+			t.Errorf("requestedVersion(%q) = %d, want %d", c.accept, got, c.want)
+		}
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	var w = httptest.NewRecorder()
+	var r = httptest.NewRequest("GET", "/users", nil)
+
+	r.Header.Set("Accept", "application/vnd.tokenizer.v9+json")
+	newTestRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("status = %d, want 406", w.Code)
+	}
+}
+
+func TestLegacyCreateRedirects(t *testing.T) {
+	var w = httptest.NewRecorder()
+
+	newTestRouter().ServeHTTP(w, httptest.NewRequest("POST", "/tokens/create", nil))
+
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "/v1/tokens" {
+		t.Fatalf("got %d to %q, want 308 to /v1/tokens", w.Code, w.Header().Get("Location"))
+	}
+
+	if w.Header().Get("Deprecation") == "" || w.Header().Get("Sunset") == "" {
+		t.Error("redirect does not announce the deprecation")
+	}
+}
+
+func TestUnknownPath(t *testing.T) {
+	var w = httptest.NewRecorder()
+
+	newTestRouter().ServeHTTP(w, httptest.NewRequest("GET", "/nowhere", nil))
+
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("got %d %q, want a problem+json 404", w.Code, w.Header().Get("Content-Type"))
+	}
+}
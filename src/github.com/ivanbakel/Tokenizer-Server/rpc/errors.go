@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail attached to every error
+// status. Its reason is the code the REST API reports the same error with.
+const ErrorDomain = "tokenizer"
+
+var grpcCodes = map[string]codes.Code{
+	apierror.CodeMalformedBody:       codes.InvalidArgument,
+	apierror.CodeInvalidID:           codes.InvalidArgument,
+	apierror.CodeInvalidQuery:        codes.InvalidArgument,
+	apierror.CodeValidation:          codes.InvalidArgument,
+	apierror.CodeInvalidNumber:       codes.InvalidArgument,
+	apierror.CodeMissingReference:    codes.FailedPrecondition,
+	apierror.CodeNotFound:            codes.NotFound,
+	apierror.CodeConflict:            codes.AlreadyExists,
+	apierror.CodeInsufficientBalance: codes.FailedPrecondition,
+	apierror.CodeSupplyExceeded:      codes.FailedPrecondition,
+	apierror.CodeHoldingCapExceeded:  codes.FailedPrecondition,
+	apierror.CodeUserFrozen:          codes.FailedPrecondition,
+	apierror.CodeHoldingFrozen:       codes.FailedPrecondition,
+	apierror.CodeRateLimited:         codes.ResourceExhausted,
+	apierror.CodeUnauthenticated:     codes.Unauthenticated,
+	apierror.CodeForbidden:           codes.PermissionDenied,
+}
+
+// internalError keeps the error behind an Internal status, for logCalls.
+type internalError struct {
+	st    *status.Status
+	cause error
+}
+
+func (e *internalError) Error() string              { return e.st.Message() }
+func (e *internalError) GRPCStatus() *status.Status { return e.st }
+
+// toStatus classifies err as apierror.From does, and reports it with the
+// matching gRPC code. Errors that are already statuses are kept as they are.
+func toStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+
+	apiErr := apierror.From(err)
+
+	code, ok := grpcCodes[apiErr.Code]
+	if !ok {
+		code = codes.Internal
+	}
+
+	info := &errdetails.ErrorInfo{Reason: apiErr.Code, Domain: ErrorDomain}
+	if apiErr.Violations > 0 {
+		info.Metadata = map[string]string{"violations": strconv.Itoa(apiErr.Violations)}
+	}
+
+	st := status.New(code, apiErr.Detail)
+	if withInfo, err := st.WithDetails(info); err == nil {
+		st = withInfo
+	}
+	if apiErr.RetryAfter > 0 {
+		retry := &errdetails.RetryInfo{RetryDelay: durationpb.New(apiErr.RetryAfter)}
+		if withRetry, err := st.WithDetails(retry); err == nil {
+			st = withRetry
+		}
+	}
+	return st
+}
+
+// internalCause returns the error an Internal status was made from.
+func internalCause(err error) (error, bool) {
+	var internal *internalError
+	if errors.As(err, &internal) {
+		return internal.cause, true
+	}
+	return nil, false
+}
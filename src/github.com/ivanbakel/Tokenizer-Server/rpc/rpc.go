@@ -0,0 +1,88 @@
+// Package rpc serves the gRPC TokenizerService defined in tokenizerpb. It is
+// a thin layer over the service package, which does the work for the REST
+// API too.
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/tokenizerpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// NewServer returns a gRPC server offering the TokenizerService to callers
+// that present serviceToken, logging each call to logger.
+func NewServer(serviceToken string, logger *logging.Logger) *grpc.Server {
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		logCalls(logger),
+		authenticate(serviceToken),
+		translateErrors,
+	))
+	tokenizerpb.RegisterTokenizerServiceServer(s, &server{})
+	return s
+}
+
+// authenticate only lets calls carrying "authorization: Bearer <token>"
+// metadata through.
+func authenticate(serviceToken string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		var token string
+		if values := md.Get("authorization"); len(values) > 0 && strings.HasPrefix(values[0], "Bearer ") {
+			token = strings.TrimPrefix(values[0], "Bearer ")
+		}
+
+		if serviceToken == "" || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(serviceToken)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "a service bearer token is required")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// translateErrors turns the errors the service package returns into gRPC
+// statuses.
+func translateErrors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+
+	st := toStatus(err)
+	if st.Code() == codes.Internal {
+		return nil, &internalError{st: st, cause: err}
+	}
+	return nil, st.Err()
+}
+
+// logCalls writes one line per call, like the HTTP access log, adding the
+// underlying error for internal failures since it is withheld from callers.
+func logCalls(logger *logging.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		fields := map[string]interface{}{
+			"level":      "info",
+			"msg":        "rpc",
+			"method":     info.FullMethod,
+			"code":       status.Code(err).String(),
+			"latency_ms": float64(time.Since(start).Nanoseconds()) / 1e6,
+		}
+		if cause, ok := internalCause(err); ok {
+			fields["level"] = "error"
+			fields["error"] = cause.Error()
+		}
+		logger.Log(fields)
+
+		return resp, err
+	}
+}
@@ -0,0 +1,150 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	pb "github.com/ivanbakel/Tokenizer-Server/tokenizerpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testToken = "s3cret"
+
+func newTestClient(t *testing.T, log *bytes.Buffer) pb.TokenizerServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	s := NewServer(testToken, logging.New(log))
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewTokenizerServiceClient(conn)
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func reason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func TestAuthentication(t *testing.T) {
+	var log bytes.Buffer
+	client := newTestClient(t, &log)
+
+	for name, ctx := range map[string]context.Context{
+		"missing": context.Background(),
+		"wrong":   withToken("guess"),
+	} {
+		_, err := client.GetUser(ctx, &pb.GetUserRequest{UserId: "not-a-uuid"})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s token: want Unauthenticated, got %v", name, err)
+		}
+	}
+
+	if !strings.Contains(log.String(), `"code":"Unauthenticated"`) {
+		t.Errorf("calls were not logged:\n%s", log.String())
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	var log bytes.Buffer
+	client := newTestClient(t, &log)
+	ctx := withToken(testToken)
+
+	_, err := client.GetUser(ctx, &pb.GetUserRequest{UserId: "not-a-uuid"})
+	if status.Code(err) != codes.InvalidArgument || reason(err) != "invalid_id" {
+		t.Errorf("want InvalidArgument invalid_id, got %v (%q)", err, reason(err))
+	}
+
+	_, err = client.CreateToken(ctx, &pb.CreateTokenRequest{Name: "Gold", OrgId: "00000000-0000-0000-0000-000000000000"})
+	if status.Code(err) != codes.InvalidArgument || reason(err) != "validation_failed" {
+		t.Errorf("missing expiry: want InvalidArgument validation_failed, got %v", err)
+	}
+
+	_, err = client.GrantUser(ctx, &pb.GrantUserRequest{
+		TokenId: "00000000-0000-0000-0000-000000000000",
+		UserId:  "00000000-0000-0000-0000-000000000000",
+		Number:  1 << 20,
+	})
+	if status.Code(err) != codes.InvalidArgument || reason(err) != "invalid_number" {
+		t.Errorf("oversized number: want InvalidArgument invalid_number, got %v", err)
+	}
+
+	_, err = client.GrantGroup(ctx, &pb.GrantGroupRequest{TokenId: "00000000-0000-0000-0000-000000000000", Number: 1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("no users: want InvalidArgument, got %v", err)
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{sql.ErrNoRows, codes.NotFound, "not_found"},
+		{storage.ErrInsufficientBalance, codes.FailedPrecondition, "insufficient_balance"},
+		{storage.ErrConflict, codes.AlreadyExists, "conflict"},
+		{storage.ErrMissingReference, codes.FailedPrecondition, "missing_reference"},
+		{status.Error(codes.Unavailable, "down"), codes.Unavailable, ""},
+		{errors.New("connection reset"), codes.Internal, "internal_error"},
+	}
+
+	for _, c := range cases {
+		st := toStatus(c.err)
+		if st.Code() != c.code || reason(st.Err()) != c.reason {
+			t.Errorf("%v: want %v %q, got %v %q", c.err, c.code, c.reason, st.Code(), reason(st.Err()))
+		}
+	}
+
+	if st := toStatus(errors.New("connection reset")); strings.Contains(st.Message(), "connection reset") {
+		t.Error("internal errors must not reveal their cause:", st.Message())
+	}
+
+	st := toStatus(apierror.RateLimited("spends", 1500*time.Millisecond))
+	if st.Code() != codes.ResourceExhausted || reason(st.Err()) != apierror.CodeRateLimited {
+		t.Errorf("want a rate limit to be ResourceExhausted, got %v %q", st.Code(), reason(st.Err()))
+	}
+	var delay time.Duration
+	for _, detail := range st.Details() {
+		if retry, ok := detail.(*errdetails.RetryInfo); ok {
+			delay = retry.RetryDelay.AsDuration()
+		}
+	}
+	if delay != 1500*time.Millisecond {
+		t.Errorf("want a retry delay of 1.5s, got %v", delay)
+	}
+}
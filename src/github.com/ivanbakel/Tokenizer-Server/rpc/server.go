@@ -0,0 +1,219 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	pb "github.com/ivanbakel/Tokenizer-Server/tokenizerpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type server struct {
+	pb.UnimplementedTokenizerServiceServer
+}
+
+func (*server) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.User, error) {
+	user, err := service.GetUser(req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return toUser(user), nil
+}
+
+func (*server) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	q := listQuery(req.PageSize, req.PageToken, "")
+	setFilter(q, "org_id", req.OrgId)
+	setFilter(q, "facebook_id", req.FacebookId)
+
+	users, next, err := service.ListUsers(q)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListUsersResponse{NextPageToken: next}
+	for _, user := range users {
+		resp.Users = append(resp.Users, toUser(user))
+	}
+	return resp, nil
+}
+
+func (*server) ListUserTokens(ctx context.Context, req *pb.ListUserTokensRequest) (*pb.ListUserTokensResponse, error) {
+	holdings, next, err := service.ListHoldings(req.UserId, listQuery(req.PageSize, req.PageToken, ""))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListUserTokensResponse{Holdings: toHoldings(holdings), NextPageToken: next}, nil
+}
+
+func (*server) GetOrganisation(ctx context.Context, req *pb.GetOrganisationRequest) (*pb.Organisation, error) {
+	org, err := service.GetOrganisation(req.OrgId)
+	if err != nil {
+		return nil, err
+	}
+	return toOrganisation(org), nil
+}
+
+func (*server) ListOrganisations(ctx context.Context, req *pb.ListOrganisationsRequest) (*pb.ListOrganisationsResponse, error) {
+	orgs, next, err := service.ListOrganisations(listQuery(req.PageSize, req.PageToken, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListOrganisationsResponse{NextPageToken: next}
+	for _, org := range orgs {
+		resp.Organisations = append(resp.Organisations, toOrganisation(org))
+	}
+	return resp, nil
+}
+
+func (*server) GetToken(ctx context.Context, req *pb.GetTokenRequest) (*pb.Token, error) {
+	token, err := service.GetToken(req.TokenId)
+	if err != nil {
+		return nil, err
+	}
+	return toToken(token), nil
+}
+
+func (*server) ListTokens(ctx context.Context, req *pb.ListTokensRequest) (*pb.ListTokensResponse, error) {
+	q := listQuery(req.PageSize, req.PageToken, req.OrderBy)
+	setFilter(q, "org_id", req.OrgId)
+	setFilter(q, "name_prefix", req.NamePrefix)
+	if req.ExpiresBefore != nil {
+		q.Filters["expires_before"] = req.ExpiresBefore.AsTime().Format(time.RFC3339Nano)
+	}
+	if req.ExpiresAfter != nil {
+		q.Filters["expires_after"] = req.ExpiresAfter.AsTime().Format(time.RFC3339Nano)
+	}
+
+	tokens, next, err := service.ListTokens(q)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListTokensResponse{NextPageToken: next}
+	for _, token := range tokens {
+		resp.Tokens = append(resp.Tokens, toToken(token))
+	}
+	return resp, nil
+}
+
+func (*server) CreateToken(ctx context.Context, req *pb.CreateTokenRequest) (*pb.Token, error) {
+	request := service.NewToken{Name: req.Name, OrgID: req.OrgId}
+	// AsTime would turn a missing time into the Unix epoch; leave it zero,
+	// so that it is reported as missing.
+	if req.Expires != nil {
+		request.Expires = req.Expires.AsTime()
+	}
+
+	token, err := service.CreateToken(request)
+	if err != nil {
+		return nil, err
+	}
+	return toToken(token), nil
+}
+
+func (*server) GrantUser(ctx context.Context, req *pb.GrantUserRequest) (*pb.Holding, error) {
+	number, err := toNumber(req.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	holding, err := service.GrantUser(req.TokenId, req.UserId, number)
+	if err != nil {
+		return nil, err
+	}
+	return toHolding(holding), nil
+}
+
+func (*server) GrantGroup(ctx context.Context, req *pb.GrantGroupRequest) (*pb.GrantGroupResponse, error) {
+	number, err := toNumber(req.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	holdings, err := service.GrantGroup(req.TokenId, req.UserIds, number)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GrantGroupResponse{Holdings: toHoldings(holdings)}, nil
+}
+
+func (*server) Spend(ctx context.Context, req *pb.SpendRequest) (*pb.Holding, error) {
+	number, err := toNumber(req.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	holding, err := service.Spend(req.UserId, req.TokenId, number)
+	if err != nil {
+		return nil, err
+	}
+	return toHolding(holding), nil
+}
+
+func (*server) Transfer(ctx context.Context, req *pb.TransferRequest) (*pb.Holding, error) {
+	number, err := toNumber(req.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	holding, err := service.Transfer(req.TokenId, req.FromUserId, req.ToUserId, number)
+	if err != nil {
+		return nil, err
+	}
+	return toHolding(holding), nil
+}
+
+func listQuery(pageSize int32, pageToken, orderBy string) service.ListQuery {
+	return service.ListQuery{
+		Limit:   int(pageSize),
+		Cursor:  pageToken,
+		Sort:    orderBy,
+		Filters: make(map[string]string),
+	}
+}
+
+// setFilter applies a filter unless its field was left empty, which proto3
+// cannot tell apart from being unset.
+func setFilter(q service.ListQuery, name, value string) {
+	if value != "" {
+		q.Filters[name] = value
+	}
+}
+
+// toNumber narrows a number to the range holdings are stored in.
+func toNumber(number int32) (int16, error) {
+	if number > math.MaxInt16 || number < math.MinInt16 {
+		return 0, apierror.Invalid(apierror.CodeInvalidNumber, fmt.Sprintf("number must be at most %d, got %d", math.MaxInt16, number))
+	}
+	return int16(number), nil
+}
+
+func toOrganisation(org *models.Organisation) *pb.Organisation {
+	return &pb.Organisation{Id: org.ID, Name: org.Name}
+}
+
+func toUser(user *models.User) *pb.User {
+	return &pb.User{Id: user.ID, FacebookId: user.FacebookID, OrgId: user.OrgID.String}
+}
+
+func toToken(token *models.Token) *pb.Token {
+	return &pb.Token{Id: token.ID, Name: token.Name, Expires: timestamppb.New(token.Expires), OrgId: token.OrgID}
+}
+
+func toHolding(holding *models.UserToken) *pb.Holding {
+	return &pb.Holding{UserId: holding.UserID, TokenId: holding.TokenID, Number: int32(holding.Number.Int16)}
+}
+
+func toHoldings(holdings models.UserTokenSlice) []*pb.Holding {
+	result := make([]*pb.Holding, len(holdings))
+	for i, holding := range holdings {
+		result[i] = toHolding(holding)
+	}
+	return result
+}
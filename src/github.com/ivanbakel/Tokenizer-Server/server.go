@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"google.golang.org/grpc"
+)
+
+// workers tracks the background goroutines that have to finish before the
+// database is closed on shutdown.
+type workers struct {
+	ctx	context.Context
+	cancel	context.CancelFunc
+	wait	sync.WaitGroup
+}
+
+func newWorkers() *workers {
+	var ctx,cancel = context.WithCancel(context.Background())
+
+	return &workers{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in the background. fn should return soon after ctx is done.
+func (w *workers) Go(fn func(ctx context.Context)) {
+	w.wait.Add(1)
+
+	go func() {
+		defer w.wait.Done()
+		fn(w.ctx)
+	}()
+}
+
+// stop cancels every worker and waits for them until ctx expires.
+func (w *workers) stop(ctx context.Context) error {
+	w.cancel()
+
+	var done = make(chan struct{})
+
+	go func() {
+		w.wait.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdownKey holds, in the context of every request a server handles, a
+// context that is done once the server starts shutting down.
+type shutdownKey struct{}
+
+// closeStreamsOnShutdown lets the requests server handles know when it starts
+// shutting down; see untilShutdown. Shutdown waits for requests to finish
+// without cancelling them, so streams that only end when the client goes
+// away would otherwise hold it up until its deadline.
+func closeStreamsOnShutdown(server *http.Server) {
+	var shutdown,cancel = context.WithCancel(context.Background())
+
+	server.BaseContext = func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), shutdownKey{}, shutdown)
+	}
+	server.RegisterOnShutdown(cancel)
+}
+
+// untilShutdown returns a context that is done when r's is, or when the
+// server handling r starts shutting down, for requests that stream until told
+// to stop.
+func untilShutdown(r *http.Request) (context.Context, context.CancelFunc) {
+	var ctx,cancel = context.WithCancel(r.Context())
+
+	if shutdown,ok := r.Context().Value(shutdownKey{}).(context.Context); ok {
+		go func() {
+			select {
+			case <-shutdown.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return ctx, cancel
+}
+
+// serveGRPC runs the gRPC server on listener until ctx is done, then lets
+// calls in progress finish.
+func serveGRPC(server *grpc.Server, listener net.Listener) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		var stopped = make(chan struct{})
+
+		go func() {
+			<-ctx.Done()
+			server.GracefulStop()
+			close(stopped)
+		}()
+
+		log.Printf("serving gRPC on %s", listener.Addr())
+
+		if err := server.Serve(listener); err != nil {
+			log.Printf("gRPC server failed: %v", err)
+		}
+
+		<-stopped
+	}
+}
+
+// serve runs the HTTP server until SIGINT or SIGTERM, then stops accepting
+// connections and drains in-flight requests, ending event streams, stops the
+// background workers and finally closes the database. Draining the requests
+// and stopping the workers are each allowed the configured shutdown timeout.
+//
+// Requests are drained before the database is closed, so a spend that has
+// started its transaction is allowed to commit rather than being cut off. If
+// the deadline passes first, db.Close still waits for queries already running
+// on the server, and any transaction left open is rolled back as a whole.
+func serve(cfg *config.Config, handler http.Handler, db *sql.DB, background *workers) error {
+	var server = &http.Server{
+		Addr:		cfg.Server.ListenAddress,
+		Handler:	handler,
+		ReadTimeout:	cfg.Server.ReadTimeout.Duration,
+		WriteTimeout:	cfg.Server.WriteTimeout.Duration,
+		IdleTimeout:	cfg.Server.IdleTimeout.Duration,
+	}
+
+	closeStreamsOnShutdown(server)
+
+	if cfg.TLS.Enabled() {
+		var certs,err = newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+
+		if err != nil {
+			return err
+		}
+
+		server.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+
+		background.Go(certs.watch)
+	}
+
+	var failed = make(chan error, 1)
+
+	go func() {
+		var err error
+
+		if cfg.TLS.Enabled() {
+			log.Printf("serving HTTPS on %s", server.Addr)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("serving HTTP on %s", server.Addr)
+			err = server.ListenAndServe()
+		}
+
+		if err != http.ErrServerClosed {
+			failed <- err
+		}
+	}()
+
+	var signals = make(chan os.Signal, 1)
+
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var serveErr error
+
+	select {
+	case serveErr = <-failed:
+		log.Printf("server failed: %v", serveErr)
+	case sig := <-signals:
+		log.Printf("received %v, shutting down", sig)
+	}
+
+	var ctx,cancel = context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Duration)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("unable to drain requests: %v", err)
+	}
+
+	// The workers get a deadline of their own: requests that took the whole
+	// of the first must not leave them running when the database is closed.
+	var workersCtx,cancelWorkers = context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Duration)
+	defer cancelWorkers()
+
+	if err := background.stop(workersCtx); err != nil {
+		log.Printf("unable to stop background workers: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		log.Printf("unable to close database: %v", err)
+	}
+
+	return serveErr
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+	"github.com/vattle/sqlboiler/queries/qm"
+)
+
+// ListFraudAlerts returns a page of the alerts fraud rules have raised,
+// newest first, filtered by rule, user_id, token_id or frozen.
+func ListFraudAlerts(q ListQuery) ([]*storage.Alert, string, error) {
+	at := sortColumn{
+		column: `"fraud_alerts"."at"`,
+		value:  func(item interface{}) string { return formatTime(item.(*storage.Alert).At) },
+		parse:  parseTime,
+	}
+
+	alertListing := listing{
+		key: sortColumn{
+			column: `"fraud_alerts"."id"`,
+			value:  func(item interface{}) string { return item.(*storage.Alert).ID },
+			parse:  parseID,
+		},
+		sorts: map[string]sortColumn{"at": at},
+		filters: map[string]filter{
+			"rule":     equals(`"fraud_alerts"."rule"`),
+			"user_id":  equalsID(`"fraud_alerts"."user_id"`),
+			"token_id": equalsID(`"fraud_alerts"."token_id"`),
+			"frozen":   equals(`"fraud_alerts"."frozen"`),
+		},
+		fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+			var alerts []*storage.Alert
+
+			query := append([]qm.QueryMod{
+				qm.Select(storage.AlertColumns...),
+				qm.From(`"fraud_alerts"`),
+			}, mods...)
+			err := models.NewQuery(boil.GetDB(), query...).Bind(&alerts)
+
+			items := make([]interface{}, len(alerts))
+			for i, a := range alerts {
+				items[i] = a
+			}
+			return items, err
+		},
+	}
+
+	if q.Sort == "" {
+		q.Sort = "-at"
+	}
+
+	items, next, err := alertListing.list(q)
+
+	alerts := make([]*storage.Alert, len(items))
+	for i, item := range items {
+		alerts[i] = item.(*storage.Alert)
+	}
+	return alerts, next, err
+}
+
+// GetFraudAlert returns the alert with the given id.
+func GetFraudAlert(alertID string) (*storage.Alert, error) {
+	if err := CheckID("alert id", alertID); err != nil {
+		return nil, err
+	}
+	return storage.FindAlert(alertID)
+}
+
+// UnfreezeUser lets a frozen user spend and transfer again. Their holdings
+// stay frozen if they were frozen themselves.
+func UnfreezeUser(userID string) (*models.User, error) {
+	if err := CheckID("user id", userID); err != nil {
+		return nil, err
+	}
+	return storage.UnfreezeUser(userID)
+}
+
+// UnfreezeHolding lets a frozen holding be spent and transferred again, so
+// long as its user is not frozen too.
+func UnfreezeHolding(userID, tokenID string) (*models.UserToken, error) {
+	if err := CheckID("user id", userID); err != nil {
+		return nil, err
+	}
+	if err := CheckID("token id", tokenID); err != nil {
+		return nil, err
+	}
+	return storage.UnfreezeHolding(userID, tokenID)
+}
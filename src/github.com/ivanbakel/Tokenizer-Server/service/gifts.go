@@ -0,0 +1,287 @@
+package service
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+	"github.com/vattle/sqlboiler/queries/qm"
+)
+
+// Gift codes are fifteen random characters of the redemption code alphabet,
+// 75 bits that cannot be guessed, and a check character, so that a code
+// mistyped from a card is caught before it reaches the database.
+const (
+	giftCodeRandom = 15
+	giftCodeLength = giftCodeRandom + 1
+)
+
+// MaxGiftBatch is the most codes one batch can hold.
+const MaxGiftBatch = 10000
+
+// checkCharacter returns the Luhn mod 32 check character of code: it
+// catches every single mistyped character, and every swap of two adjacent
+// ones except of 0 and Z.
+func checkCharacter(code string) byte {
+	factor, sum := 2, 0
+	for i := len(code) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(codeAlphabet, code[i])
+		sum += addend/len(codeAlphabet) + addend%len(codeAlphabet)
+		factor = 3 - factor
+	}
+	return codeAlphabet[(len(codeAlphabet)-sum%len(codeAlphabet))%len(codeAlphabet)]
+}
+
+// validGiftCode reports whether a normalised code is well formed and its
+// check character matches.
+func validGiftCode(code string) bool {
+	return len(code) == giftCodeLength && strings.Trim(code, codeAlphabet) == "" &&
+		checkCharacter(code[:giftCodeRandom]) == code[giftCodeRandom]
+}
+
+// FormatGiftCode splits a gift code into groups of four, for printing.
+func FormatGiftCode(code string) string {
+	var groups []string
+	for len(code) > 4 {
+		groups = append(groups, code[:4])
+		code = code[4:]
+	}
+	return strings.Join(append(groups, code), "-")
+}
+
+func generateGiftCode() (string, error) {
+	random := make([]byte, giftCodeRandom)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, giftCodeRandom, giftCodeLength)
+	for i, b := range random {
+		code[i] = codeAlphabet[b&31]
+	}
+	return string(append(code, checkCharacter(string(code)))), nil
+}
+
+// NewGiftBatch is a batch of gift codes to be created.
+type NewGiftBatch struct {
+	TokenID string
+	Number  int16
+	Count   int
+}
+
+// CreateGiftBatch creates a batch of codes for one of an organisation's
+// tokens, each worth number units to whoever claims it.
+func CreateGiftBatch(orgID string, request NewGiftBatch) (*storage.GiftBatch, error) {
+	if err := CheckID("token_id", request.TokenID); err != nil {
+		return nil, err
+	}
+	if err := checkNumber(request.Number); err != nil {
+		return nil, err
+	}
+	if request.Count < 1 || request.Count > MaxGiftBatch {
+		return nil, apierror.Invalid(apierror.CodeValidation, fmt.Sprintf("count must be between 1 and %d", MaxGiftBatch))
+	}
+
+	if _, err := GetOrganisation(orgID); err != nil {
+		return nil, err
+	}
+
+	token, err := models.FindToken(boil.GetDB(), request.TokenID)
+	if err == sql.ErrNoRows || (err == nil && token.OrgID != orgID) {
+		return nil, apierror.Invalid(apierror.CodeMissingReference, "token_id must be one of the organisation's tokens")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A clash with another batch is vanishingly unlikely at 75 bits, but
+	// costs only a retry.
+	for attempt := 0; ; attempt++ {
+		seen := make(map[string]bool, request.Count)
+		codes := make([]string, 0, request.Count)
+		for len(codes) < request.Count {
+			code, err := generateGiftCode()
+			if err != nil {
+				return nil, err
+			}
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+
+		batch := &storage.GiftBatch{OrgID: orgID, TokenID: request.TokenID, Number: request.Number}
+		err = storage.CreateGiftBatch(batch, codes)
+		if storage.Translate(err) == storage.ErrConflict && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return batch, nil
+	}
+}
+
+// GetGiftBatch returns one of an organisation's batches, with how many of
+// its codes have been claimed.
+func GetGiftBatch(orgID, batchID string) (*storage.GiftBatch, error) {
+	if err := CheckID("organisation id", orgID); err != nil {
+		return nil, err
+	}
+	if err := CheckID("batch id", batchID); err != nil {
+		return nil, err
+	}
+	return storage.FindGiftBatch(orgID, batchID)
+}
+
+// RevokeGiftBatch stops a batch's unclaimed codes from being claimed.
+// Codes already claimed are unaffected.
+func RevokeGiftBatch(orgID, batchID string) (*storage.GiftBatch, error) {
+	if _, err := GetGiftBatch(orgID, batchID); err != nil {
+		return nil, err
+	}
+	return storage.RevokeGiftBatch(orgID, batchID, time.Now())
+}
+
+// ExportGiftCodes calls fn with every code of one of an organisation's
+// batches, in order.
+func ExportGiftCodes(orgID, batchID string, fn func(storage.GiftCode) error) error {
+	if _, err := GetGiftBatch(orgID, batchID); err != nil {
+		return err
+	}
+	return storage.EachGiftCode(batchID, fn)
+}
+
+// ClaimGiftCode credits a user with what a gift code is worth, returning
+// the code's batch and the user's holding afterwards. A claim is a grant, so
+// it counts against the grants limit of the token's organisation.
+func ClaimGiftCode(userID, code string) (*storage.GiftBatch, *models.UserToken, error) {
+	if err := CheckID("user id", userID); err != nil {
+		return nil, nil, err
+	}
+
+	code = NormaliseCode(code)
+	if !validGiftCode(code) {
+		return nil, nil, apierror.Invalid(apierror.CodeValidation, "code is not a gift code; check that it was typed correctly")
+	}
+
+	batch, err := storage.FindGiftCodeBatch(code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refund, err := throttleGrant(batch.TokenID, batch.Number, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	batch, holding, err := storage.ClaimGiftCode(code, userID)
+	if err != nil {
+		refund()
+	}
+	return batch, holding, err
+}
+
+// ListGiftBatches returns a page of an organisation's batches, newest
+// first, filtered by token_id or revoked.
+func ListGiftBatches(orgID string, q ListQuery) ([]*storage.GiftBatch, string, error) {
+	if _, err := GetOrganisation(orgID); err != nil {
+		return nil, "", err
+	}
+
+	createdAt := sortColumn{
+		column: `"gift_batches"."created_at"`,
+		value:  func(item interface{}) string { return formatTime(item.(*storage.GiftBatch).CreatedAt) },
+		parse:  parseTime,
+	}
+
+	batchListing := listing{
+		key: sortColumn{
+			column: `"gift_batches"."id"`,
+			value:  func(item interface{}) string { return item.(*storage.GiftBatch).ID },
+			parse:  parseID,
+		},
+		sorts: map[string]sortColumn{"created_at": createdAt},
+		filters: map[string]filter{
+			"token_id": equalsID(`"gift_batches"."token_id"`),
+			"revoked":  isSet(`"gift_batches"."revoked_at"`),
+		},
+		fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+			var batches []*storage.GiftBatch
+
+			query := append([]qm.QueryMod{
+				qm.Select(storage.GiftBatchColumns...),
+				qm.From(`"gift_batches"`),
+				qm.Where(`"gift_batches"."org_id" = ?`, orgID),
+			}, mods...)
+			err := models.NewQuery(boil.GetDB(), query...).Bind(&batches)
+
+			items := make([]interface{}, len(batches))
+			for i, b := range batches {
+				items[i] = b
+			}
+			return items, err
+		},
+	}
+
+	if q.Sort == "" {
+		q.Sort = "-created_at"
+	}
+
+	items, next, err := batchListing.list(q)
+
+	batches := make([]*storage.GiftBatch, len(items))
+	for i, item := range items {
+		batches[i] = item.(*storage.GiftBatch)
+	}
+	return batches, next, err
+}
+
+// ListGiftCodes returns a page of a batch's codes, ordered by code and
+// filtered by claimed.
+func ListGiftCodes(orgID, batchID string, q ListQuery) ([]*storage.GiftCode, string, error) {
+	if _, err := GetGiftBatch(orgID, batchID); err != nil {
+		return nil, "", err
+	}
+
+	codeListing := listing{
+		key: sortColumn{
+			column: `"gift_codes"."code"`,
+			value:  func(item interface{}) string { return item.(*storage.GiftCode).Code },
+			parse:  parseString,
+		},
+		filters: map[string]filter{
+			"claimed": isSet(`"gift_codes"."claimed_at"`),
+		},
+		fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+			var codes []*storage.GiftCode
+
+			query := append([]qm.QueryMod{
+				qm.Select(storage.GiftCodeColumns...),
+				qm.From(`"gift_codes"`),
+				qm.Where(`"gift_codes"."batch_id" = ?`, batchID),
+			}, mods...)
+			err := models.NewQuery(boil.GetDB(), query...).Bind(&codes)
+
+			items := make([]interface{}, len(codes))
+			for i, c := range codes {
+				items[i] = c
+			}
+			return items, err
+		},
+	}
+
+	items, next, err := codeListing.list(q)
+
+	codes := make([]*storage.GiftCode, len(items))
+	for i, item := range items {
+		codes[i] = item.(*storage.GiftCode)
+	}
+	return codes, next, err
+}
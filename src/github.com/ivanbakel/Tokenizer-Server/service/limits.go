@@ -0,0 +1,97 @@
+package service
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/ratelimit"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// Limiters throttle the balance changes a compromised client could use to
+// drain or inflate balances quickly. A nil limiter is not enforced.
+type Limiters struct {
+	// Spends is taken from once per spend, keyed by the spending user.
+	Spends *ratelimit.Limiter
+	// Grants is taken from once per unit granted, keyed by the organisation
+	// issuing the token.
+	Grants *ratelimit.Limiter
+	// Transfers is taken from once per transfer, keyed by the sending user.
+	Transfers *ratelimit.Limiter
+
+	// Refused, if set, is told of every change a limit refuses, for one of
+	// the organisation's tokens, including those not recorded as events.
+	Refused func(limit, orgID string)
+}
+
+var limiters Limiters
+
+// SetLimiters enforces l on every later balance change. It is meant to be
+// called once, before serving.
+func SetLimiters(l Limiters) {
+	limiters = l
+}
+
+// throttle takes cost from limiter's bucket for key. If the limit has been
+// reached, the refusal is reported with how long to wait. The first refusal
+// for key in each period also records the refused change of each of userIDs'
+// holdings as a rate_limited event; the rest are only passed to Refused, so
+// that a client hammering a limit does not cause a write and deliveries to
+// every subscriber each time.
+//
+// The returned refund gives cost back, for when the change it was taken for
+// fails; a change that does not happen should not count against the limit.
+func throttle(limiter *ratelimit.Limiter, key string, cost int, tokenID, fromUserID string, number int16, userIDs ...string) (refund func(), err error) {
+	refund = func() {
+		if err := limiter.Refund(key, cost); err != nil {
+			log.Printf("unable to refund %d to the %s limit for %s: %v", cost, limiter.Name, key, err)
+		}
+	}
+
+	// Waiting would not help a change bigger than the limit allows at all.
+	if !limiter.Fits(cost) {
+		return nil, apierror.Invalid(apierror.CodeValidation,
+			fmt.Sprintf("the %s rate limit allows at most %d every %s", limiter.Name, limiter.Limit.Max, limiter.Limit.Per))
+	}
+
+	wait, err := limiter.Take(key, cost)
+	if err != nil || wait == 0 {
+		return refund, err
+	}
+
+	report, err := limiter.Report(key)
+	if err != nil {
+		return nil, err
+	}
+	if report {
+		if err = storage.RecordRateLimited(limiter.Name, tokenID, fromUserID, number, userIDs...); err != nil {
+			return nil, err
+		}
+	}
+
+	if limiters.Refused != nil {
+		var orgID string
+		if token, err := models.FindToken(boil.GetDB(), tokenID); err == nil {
+			orgID = token.OrgID
+		}
+		limiters.Refused(limiter.Name, orgID)
+	}
+	return nil, apierror.RateLimited(limiter.Name, wait)
+}
+
+// throttleGrant counts a grant of number units to each of userIDs against
+// the limit of the organisation issuing the token, as throttle does.
+func throttleGrant(tokenID string, number int16, userIDs ...string) (refund func(), err error) {
+	if limiters.Grants == nil {
+		return func() {}, nil
+	}
+
+	token, err := GetToken(tokenID)
+	if err != nil {
+		return nil, err
+	}
+	return throttle(limiters.Grants, token.OrgID, int(number)*len(userIDs), tokenID, "", number, userIDs...)
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/vattle/sqlboiler/queries/qm"
+)
+
+const (
+	// DefaultPageSize is the page size when a query does not give one.
+	DefaultPageSize = 50
+	// MaxPageSize is the largest page a query may ask for.
+	MaxPageSize = 500
+)
+
+// ListQuery selects a page of a collection.
+type ListQuery struct {
+	// Limit is the page size, or 0 for DefaultPageSize.
+	Limit int
+	// Cursor is the NextCursor of the previous page, or "" for the first.
+	Cursor string
+	// Sort is a comma-separated list of the columns to order by, each
+	// optionally prefixed with "-" for descending order, e.g. "expires,-name".
+	Sort string
+	// Filters narrow the collection, by filter name. Names the collection
+	// does not know are refused, so that a misspelt filter is reported
+	// rather than silently listing everything.
+	Filters map[string]string
+}
+
+// listing describes a collection. Only the columns and filters named here
+// can be reached from a ListQuery, so callers never get to put SQL of their
+// own into the query.
+type listing struct {
+	// key is a unique column, used to break ties so that every item has a
+	// definite place in the order.
+	key sortColumn
+	// sorts are the columns ListQuery.Sort accepts, by the name clients use.
+	sorts map[string]sortColumn
+	// filters are the ListQuery.Filters that narrow the collection.
+	filters map[string]filter
+	// fetch loads the items the mods select.
+	fetch func(mods ...qm.QueryMod) ([]interface{}, error)
+}
+
+// sortColumn is a column a collection can be ordered by.
+type sortColumn struct {
+	column string
+	// value reads the column from an item, in the form stored in cursors.
+	value func(item interface{}) string
+	// parse turns a value read from a cursor back into a query argument.
+	parse func(value string) (interface{}, error)
+}
+
+// filter turns the named filter's value into a query mod.
+type filter func(name, value string) (qm.QueryMod, error)
+
+type orderTerm struct {
+	name string
+	sortColumn
+	desc bool
+}
+
+// cursor marks the last item of a page by its values for every column the
+// collection is ordered by.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// list returns the page of l that q selects, and the cursor of the next page,
+// or "" if this is the last. Pages are selected by keyset rather than offset,
+// so that they stay cheap and stable however deep the client reads.
+func (l listing) list(q ListQuery) ([]interface{}, string, error) {
+	limit := q.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit < 1 || limit > MaxPageSize {
+		return nil, "", apierror.BadRequest(apierror.CodeInvalidQuery, fmt.Sprintf("limit must be between 1 and %d", MaxPageSize))
+	}
+
+	order, err := l.order(q.Sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filters, err := l.filterMods(q.Filters)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var after []interface{}
+	if q.Cursor != "" {
+		if after, err = decodeCursor(q.Cursor, q.Sort, order); err != nil {
+			return nil, "", err
+		}
+	}
+
+	items, err := l.fetch(pageMods(filters, order, after, limit+1)...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One extra row was asked for, only to learn whether another page exists.
+	if len(items) <= limit {
+		return items, "", nil
+	}
+	return items[:limit], encodeCursor(q.Sort, order, items[limit-1]), nil
+}
+
+// order parses a sort such as "expires,-name". The key is always appended,
+// unless the client sorted by it already.
+func (l listing) order(sort string) ([]orderTerm, error) {
+	var order []orderTerm
+	seen := make(map[string]bool)
+	hasKey := false
+
+	for _, name := range strings.Split(sort, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		term := orderTerm{name: strings.TrimPrefix(name, "-"), desc: strings.HasPrefix(name, "-")}
+
+		column, ok := l.sorts[term.name]
+		if !ok {
+			return nil, apierror.BadRequest(apierror.CodeInvalidQuery, fmt.Sprintf("cannot sort by %q", term.name))
+		}
+		if seen[term.name] {
+			return nil, apierror.BadRequest(apierror.CodeInvalidQuery, fmt.Sprintf("%q is sorted by twice", term.name))
+		}
+
+		seen[term.name] = true
+		term.sortColumn = column
+		hasKey = hasKey || column.column == l.key.column
+		order = append(order, term)
+	}
+
+	if !hasKey {
+		order = append(order, orderTerm{name: "key", sortColumn: l.key})
+	}
+	return order, nil
+}
+
+func (l listing) filterMods(values map[string]string) ([]qm.QueryMod, error) {
+	var unknown []string
+	for name := range values {
+		if _, ok := l.filters[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, apierror.BadRequest(apierror.CodeInvalidQuery, fmt.Sprintf("cannot filter by %q", unknown[0]))
+	}
+
+	var mods []qm.QueryMod
+
+	for name, filter := range l.filters {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+
+		mod, err := filter(name, value)
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, mod)
+	}
+
+	return mods, nil
+}
+
+// pageMods selects at most limit rows, in order, that come after the cursor.
+func pageMods(filters []qm.QueryMod, order []orderTerm, after []interface{}, limit int) []qm.QueryMod {
+	clauses := make([]string, len(order))
+	for i, term := range order {
+		clauses[i] = term.column + " ASC"
+		if term.desc {
+			clauses[i] = term.column + " DESC"
+		}
+	}
+
+	mods := append(filters[:len(filters):len(filters)], qm.OrderBy(strings.Join(clauses, ", ")), qm.Limit(limit))
+	if after != nil {
+		mods = append(mods, keyset(order, after))
+	}
+	return mods
+}
+
+// keyset matches the rows that sort after the given values: those that equal
+// them on the first i columns and come later on the next one, for some i.
+func keyset(order []orderTerm, after []interface{}) qm.QueryMod {
+	var alternatives []string
+	var args []interface{}
+
+	for i, term := range order {
+		var conditions []string
+
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, order[j].column+" = ?")
+			args = append(args, after[j])
+		}
+
+		if term.desc {
+			conditions = append(conditions, term.column+" < ?")
+		} else {
+			conditions = append(conditions, term.column+" > ?")
+		}
+		args = append(args, after[i])
+
+		alternatives = append(alternatives, "("+strings.Join(conditions, " AND ")+")")
+	}
+
+	return qm.Where("("+strings.Join(alternatives, " OR ")+")", args...)
+}
+
+// Cursors are opaque to clients, so that what they encode can change without
+// breaking anyone who stores them.
+func encodeCursor(sort string, order []orderTerm, last interface{}) string {
+	c := cursor{Sort: sort}
+	for _, term := range order {
+		c.Values = append(c.Values, term.value(last))
+	}
+
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeCursor(raw, sort string, order []orderTerm) ([]interface{}, error) {
+	invalid := apierror.BadRequest(apierror.CodeInvalidQuery, "cursor is not one returned by the server for this sort order")
+
+	var c cursor
+	encoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil || json.Unmarshal(encoded, &c) != nil || c.Sort != sort || len(c.Values) != len(order) {
+		return nil, invalid
+	}
+
+	args := make([]interface{}, len(order))
+	for i, term := range order {
+		if args[i], err = term.parse(c.Values[i]); err != nil {
+			return nil, invalid
+		}
+	}
+	return args, nil
+}
+
+// Parsers for sortColumn.parse.
+
+func parseID(value string) (interface{}, error) {
+	return value, CheckID("id", value)
+}
+
+func parseString(value string) (interface{}, error) {
+	return value, nil
+}
+
+func parseTime(value string) (interface{}, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Filters.
+
+// equalsID matches rows whose column holds the given UUID.
+func equalsID(column string) filter {
+	return func(name, value string) (qm.QueryMod, error) {
+		if err := CheckID(name, value); err != nil {
+			return nil, err
+		}
+		return qm.Where(column+" = ?", value), nil
+	}
+}
+
+// equals matches rows whose column holds exactly the given value.
+func equals(column string) filter {
+	return func(name, value string) (qm.QueryMod, error) {
+		return qm.Where(column+" = ?", value), nil
+	}
+}
+
+// compareTime matches rows whose column is before or after an RFC 3339 time,
+// depending on op.
+func compareTime(column, op string) filter {
+	return func(name, value string) (qm.QueryMod, error) {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, apierror.BadRequest(apierror.CodeInvalidQuery, fmt.Sprintf("%s %q is not an RFC 3339 time", name, value))
+		}
+		return qm.Where(column+" "+op+" ?", t.UTC()), nil
+	}
+}
+
+// hasPrefix matches rows whose column starts with the given text, ignoring
+// case. LIKE wildcards in the text are escaped, so they only ever match
+// themselves. Both sides are lowered rather than relying on LIKE, which
+// ignores case on SQLite but not on Postgres; SQLite's lower only folds
+// ASCII letters, though, so other letters must still match exactly there.
+func hasPrefix(column string) filter {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+	return func(name, value string) (qm.QueryMod, error) {
+		return qm.Where(`lower(`+column+`) LIKE lower(?) ESCAPE '\'`, escaper.Replace(value)+"%"), nil
+	}
+}
+
+// isSet matches rows whose column is, for "true", or is not, for "false",
+// set.
+func isSet(column string) filter {
+	return func(name, value string) (qm.QueryMod, error) {
+		switch value {
+		case "true":
+			return qm.Where(column + " IS NOT NULL"), nil
+		case "false":
+			return qm.Where(column + " IS NULL"), nil
+		}
+		return nil, apierror.BadRequest(apierror.CodeInvalidQuery, fmt.Sprintf("%s must be true or false, got %q", name, value))
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+	"github.com/vattle/sqlboiler/queries/qm"
+)
+
+// orgKeyPrefix starts every org key, so that leaked keys are recognisable.
+const orgKeyPrefix = "tk_"
+
+// CreateOrgKey creates an API key for an organisation, returning it along
+// with the key itself. The key is only ever returned here; only its hash is
+// kept.
+func CreateOrgKey(orgID, name string) (*storage.OrgKey, string, error) {
+	if _, err := GetOrganisation(orgID); err != nil {
+		return nil, "", err
+	}
+	if name == "" || len(name) > 100 {
+		return nil, "", apierror.Invalid(apierror.CodeValidation, "name must be between 1 and 100 characters")
+	}
+
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", err
+	}
+	secret := orgKeyPrefix + hex.EncodeToString(random)
+
+	key := &storage.OrgKey{OrgID: orgID, Name: name, Prefix: secret[:len(orgKeyPrefix)+6]}
+	if err := storage.CreateOrgKey(key, hashOrgKey(secret)); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+func hashOrgKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthenticateOrgKey returns the unrevoked key a request presented.
+func AuthenticateOrgKey(secret string) (*storage.OrgKey, error) {
+	if !strings.HasPrefix(secret, orgKeyPrefix) {
+		return nil, apierror.Unauthenticated("an organisation API key is required")
+	}
+
+	key, err := storage.FindOrgKeyByHash(hashOrgKey(secret))
+	if err == sql.ErrNoRows {
+		return nil, apierror.Unauthenticated("the API key is not valid")
+	}
+	return key, err
+}
+
+// RevokeOrgKey stops one of an organisation's keys from authenticating.
+func RevokeOrgKey(orgID, keyID string) error {
+	if err := CheckID("organisation id", orgID); err != nil {
+		return err
+	}
+	if err := CheckID("key id", keyID); err != nil {
+		return err
+	}
+	return storage.RevokeOrgKey(orgID, keyID, time.Now())
+}
+
+// ListOrgKeys returns a page of an organisation's keys, revoked ones
+// included, ordered by id.
+func ListOrgKeys(orgID string, q ListQuery) ([]*storage.OrgKey, string, error) {
+	if _, err := GetOrganisation(orgID); err != nil {
+		return nil, "", err
+	}
+
+	keyListing := listing{
+		key: sortColumn{
+			column: `"org_keys"."id"`,
+			value:  func(item interface{}) string { return item.(*storage.OrgKey).ID },
+			parse:  parseID,
+		},
+		fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+			var keys []*storage.OrgKey
+
+			query := append([]qm.QueryMod{
+				qm.Select(storage.OrgKeyColumns...),
+				qm.From(`"org_keys"`),
+				qm.Where(`"org_keys"."org_id" = ?`, orgID),
+			}, mods...)
+			err := models.NewQuery(boil.GetDB(), query...).Bind(&keys)
+
+			items := make([]interface{}, len(keys))
+			for i, k := range keys {
+				items[i] = k
+			}
+			return items, err
+		},
+	}
+
+	items, next, err := keyListing.list(q)
+
+	keys := make([]*storage.OrgKey, len(items))
+	for i, item := range items {
+		keys[i] = item.(*storage.OrgKey)
+	}
+	return keys, next, err
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// Redemption codes are written in Crockford's base32, which leaves out the
+// letters most easily mistaken for digits or each other.
+const (
+	codeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	codeLength   = 10
+)
+
+// How long a redemption code lasts, unless asked for otherwise, and the
+// bounds of what can be asked for.
+const (
+	DefaultCodeLifetime = 5 * time.Minute
+	MinCodeLifetime     = 30 * time.Second
+	MaxCodeLifetime     = 15 * time.Minute
+)
+
+// NewRedemptionCode is a redemption code to be created.
+type NewRedemptionCode struct {
+	Number int16
+	// Lifetime is how long the code lasts, or zero for DefaultCodeLifetime.
+	Lifetime time.Duration
+}
+
+// CreateRedemptionCode creates a single-use code a merchant can redeem to
+// spend number units of a token from a user's holding.
+func CreateRedemptionCode(userID, tokenID string, request NewRedemptionCode) (*storage.RedemptionCode, error) {
+	if err := CheckID("user id", userID); err != nil {
+		return nil, err
+	}
+	if err := CheckID("token id", tokenID); err != nil {
+		return nil, err
+	}
+	if err := checkNumber(request.Number); err != nil {
+		return nil, err
+	}
+
+	lifetime := request.Lifetime
+	if lifetime == 0 {
+		lifetime = DefaultCodeLifetime
+	}
+	if lifetime < MinCodeLifetime || lifetime > MaxCodeLifetime {
+		return nil, apierror.Invalid(apierror.CodeValidation, fmt.Sprintf("expires_in must be between %d and %d seconds",
+			int(MinCodeLifetime.Seconds()), int(MaxCodeLifetime.Seconds())))
+	}
+
+	// A clash is vanishingly unlikely at 50 bits, but costs only a retry.
+	for attempt := 0; ; attempt++ {
+		code, err := generateCode()
+		if err != nil {
+			return nil, err
+		}
+
+		rc := &storage.RedemptionCode{
+			Code:      code,
+			UserID:    userID,
+			TokenID:   tokenID,
+			Number:    request.Number,
+			ExpiresAt: time.Now().Add(lifetime),
+		}
+		err = storage.CreateRedemptionCode(rc)
+		if storage.Translate(err) == storage.ErrConflict && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return rc, nil
+	}
+}
+
+func generateCode() (string, error) {
+	random := make([]byte, codeLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, codeLength)
+	for i, b := range random {
+		code[i] = codeAlphabet[b&31]
+	}
+	return string(code), nil
+}
+
+// NormaliseCode puts a code as someone typed it into the form it was issued
+// in: upper case, without separators, and with the letters Crockford's
+// alphabet leaves out read as the digits they look like.
+func NormaliseCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ':
+			return -1
+		case 'O', 'o':
+			return '0'
+		case 'I', 'i', 'L', 'l':
+			return '1'
+		}
+		if 'a' <= r && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}, code)
+}
+
+// GetRedemptionCode returns one of a user's codes for a token.
+func GetRedemptionCode(userID, tokenID, code string) (*storage.RedemptionCode, error) {
+	if err := CheckID("user id", userID); err != nil {
+		return nil, err
+	}
+	if err := CheckID("token id", tokenID); err != nil {
+		return nil, err
+	}
+
+	rc, err := storage.FindRedemptionCode(NormaliseCode(code))
+	if err != nil {
+		return nil, err
+	}
+	if rc.UserID != userID || rc.TokenID != tokenID {
+		return nil, apierror.NotFound("no such redemption code")
+	}
+	return rc, nil
+}
+
+// RedeemCode redeems a code for one of an organisation's tokens on behalf
+// of the given org key, spending from the holding of the user it was issued
+// to. The spend counts against the user's spends limit.
+func RedeemCode(orgID, keyID, code string) (*storage.RedemptionCode, *models.UserToken, error) {
+	if err := CheckID("organisation id", orgID); err != nil {
+		return nil, nil, err
+	}
+
+	code = NormaliseCode(code)
+	if len(code) != codeLength || strings.Trim(code, codeAlphabet) != "" {
+		return nil, nil, apierror.Invalid(apierror.CodeValidation, "code is not a redemption code")
+	}
+
+	rc, err := storage.FindRedemptionCode(code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refund, err := throttle(limiters.Spends, rc.UserID, 1, rc.TokenID, "", rc.Number, rc.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rc, holding, err := storage.Redeem(orgID, code, keyID)
+	if err != nil {
+		refund()
+	}
+	return rc, holding, err
+}
@@ -0,0 +1,379 @@
+// Package service holds the operations the API offers, independent of the
+// transport they arrive by. REST handlers and the gRPC server both call it,
+// so validation and behaviour are the same whichever a client uses.
+//
+// Failures are reported as *apierror.Error, or as errors apierror.From
+// classifies; each transport turns them into its own status codes.
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/vattle/sqlboiler/boil"
+	"github.com/vattle/sqlboiler/queries/qm"
+	"gopkg.in/nullbio/null.v6"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// CheckID fails unless id is a UUID, so that malformed ids are reported as
+// such instead of reaching the database. name is how the caller refers to
+// the id in the request.
+func CheckID(name, id string) error {
+	if !uuidPattern.MatchString(id) {
+		return apierror.BadRequest(apierror.CodeInvalidID, fmt.Sprintf("%s %q is not a UUID", name, id))
+	}
+	return nil
+}
+
+func checkNumber(number int16) error {
+	if number <= 0 {
+		return apierror.Invalid(apierror.CodeInvalidNumber, fmt.Sprintf("number must be positive, got %d", number))
+	}
+	return nil
+}
+
+// GetUser returns the user with the given id.
+func GetUser(userID string) (*models.User, error) {
+	if err := CheckID("user id", userID); err != nil {
+		return nil, err
+	}
+	return models.FindUser(boil.GetDB(), userID)
+}
+
+// GetOrganisation returns the organisation with the given id.
+func GetOrganisation(orgID string) (*models.Organisation, error) {
+	if err := CheckID("organisation id", orgID); err != nil {
+		return nil, err
+	}
+	return models.FindOrganisation(boil.GetDB(), orgID)
+}
+
+// GetToken returns the token with the given id.
+func GetToken(tokenID string) (*models.Token, error) {
+	if err := CheckID("token id", tokenID); err != nil {
+		return nil, err
+	}
+	return models.FindToken(boil.GetDB(), tokenID)
+}
+
+// NewToken is a token to be created. MaxSupply and UserCap are its caps,
+// if it has any.
+type NewToken struct {
+	Name      string
+	Expires   time.Time
+	OrgID     string
+	MaxSupply null.Int
+	UserCap   null.Int16
+}
+
+// CreateToken validates and creates a token.
+func CreateToken(request NewToken) (*models.Token, error) {
+	if request.Name == "" || len(request.Name) > 100 {
+		return nil, apierror.Invalid(apierror.CodeValidation, "name must be between 1 and 100 characters")
+	}
+	if request.Expires.IsZero() {
+		return nil, apierror.Invalid(apierror.CodeValidation, "expires must be set")
+	}
+	if err := CheckID("org_id", request.OrgID); err != nil {
+		return nil, err
+	}
+	if request.MaxSupply.Valid && request.MaxSupply.Int < 1 {
+		return nil, apierror.Invalid(apierror.CodeValidation, "max_supply must be positive")
+	}
+	if request.UserCap.Valid && request.UserCap.Int16 < 1 {
+		return nil, apierror.Invalid(apierror.CodeValidation, "user_cap must be positive")
+	}
+
+	// Expiry times are kept in UTC; see storage.Expire.
+	token := &models.Token{
+		Name:      request.Name,
+		Expires:   request.Expires.UTC(),
+		OrgID:     request.OrgID,
+		MaxSupply: request.MaxSupply,
+		UserCap:   request.UserCap,
+	}
+	if err := token.Insert(boil.GetDB()); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// GrantUser credits number units of a token to a user.
+func GrantUser(tokenID, userID string, number int16) (*models.UserToken, error) {
+	if err := CheckID("token id", tokenID); err != nil {
+		return nil, err
+	}
+	if err := CheckID("user_id", userID); err != nil {
+		return nil, err
+	}
+	if err := checkNumber(number); err != nil {
+		return nil, err
+	}
+
+	refund, err := throttleGrant(tokenID, number, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	holdings, err := storage.Grant(tokenID, number, userID)
+	if err != nil {
+		refund()
+		return nil, err
+	}
+	return holdings[0], nil
+}
+
+// GrantGroup credits number units of a token to each of the users, or to
+// none of them if any cannot be credited. If the grant would take any over
+// the token's per-user cap, the error counts how many.
+func GrantGroup(tokenID string, userIDs []string, number int16) (models.UserTokenSlice, error) {
+	if err := CheckID("token id", tokenID); err != nil {
+		return nil, err
+	}
+	if len(userIDs) == 0 {
+		return nil, apierror.Invalid(apierror.CodeValidation, "user_ids must not be empty")
+	}
+	for _, userID := range userIDs {
+		if err := CheckID("user_ids", userID); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkNumber(number); err != nil {
+		return nil, err
+	}
+
+	refund, err := throttleGrant(tokenID, number, userIDs...)
+	if err != nil {
+		return nil, err
+	}
+
+	holdings, err := storage.Grant(tokenID, number, userIDs...)
+	if err != nil {
+		refund()
+	}
+	return holdings, err
+}
+
+// GetTokenSupply returns a token with the account of its units.
+func GetTokenSupply(tokenID string) (*models.Token, *storage.Supply, error) {
+	token, err := GetToken(tokenID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	supply, err := storage.FindSupply(tokenID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, supply, nil
+}
+
+// Spend removes number units of a token from a user's holding.
+func Spend(userID, tokenID string, number int16) (*models.UserToken, error) {
+	if err := CheckID("user id", userID); err != nil {
+		return nil, err
+	}
+	if err := CheckID("token id", tokenID); err != nil {
+		return nil, err
+	}
+	if err := checkNumber(number); err != nil {
+		return nil, err
+	}
+
+	refund, err := throttle(limiters.Spends, userID, 1, tokenID, "", number, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	holding, err := storage.Spend(userID, tokenID, number)
+	if err != nil {
+		refund()
+	}
+	return holding, err
+}
+
+// Transfer moves number units of a token from one user to another, returning
+// the recipient's holding.
+func Transfer(tokenID, fromUserID, toUserID string, number int16) (*models.UserToken, error) {
+	if err := CheckID("token id", tokenID); err != nil {
+		return nil, err
+	}
+	if err := CheckID("from", fromUserID); err != nil {
+		return nil, err
+	}
+	if err := CheckID("user id", toUserID); err != nil {
+		return nil, err
+	}
+	if err := checkNumber(number); err != nil {
+		return nil, err
+	}
+
+	refund, err := throttle(limiters.Transfers, fromUserID, 1, tokenID, fromUserID, number, toUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, to, err := storage.Transfer(tokenID, fromUserID, toUserID, number)
+	if err != nil {
+		refund()
+	}
+	return to, err
+}
+
+var userListing = listing{
+	key: sortColumn{
+		column: `"users"."id"`,
+		value:  func(item interface{}) string { return item.(*models.User).ID },
+		parse:  parseID,
+	},
+	filters: map[string]filter{
+		"org_id":      equalsID(`"users"."org_id"`),
+		"facebook_id": equals(`"users"."facebook_id"`),
+	},
+	fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+		users, err := models.Users(boil.GetDB(), mods...).All()
+
+		items := make([]interface{}, len(users))
+		for i, user := range users {
+			items[i] = user
+		}
+		return items, err
+	},
+}
+
+// ListUsers returns a page of users, filtered by org_id or facebook_id.
+func ListUsers(q ListQuery) (models.UserSlice, string, error) {
+	items, next, err := userListing.list(q)
+
+	users := make(models.UserSlice, len(items))
+	for i, item := range items {
+		users[i] = item.(*models.User)
+	}
+	return users, next, err
+}
+
+var orgListing = listing{
+	key: sortColumn{
+		column: `"organisations"."id"`,
+		value:  func(item interface{}) string { return item.(*models.Organisation).ID },
+		parse:  parseID,
+	},
+	fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+		orgs, err := models.Organisations(boil.GetDB(), mods...).All()
+
+		items := make([]interface{}, len(orgs))
+		for i, org := range orgs {
+			items[i] = org
+		}
+		return items, err
+	},
+}
+
+// ListOrganisations returns a page of organisations.
+func ListOrganisations(q ListQuery) (models.OrganisationSlice, string, error) {
+	items, next, err := orgListing.list(q)
+
+	orgs := make(models.OrganisationSlice, len(items))
+	for i, item := range items {
+		orgs[i] = item.(*models.Organisation)
+	}
+	return orgs, next, err
+}
+
+var tokenKey = sortColumn{
+	column: `"tokens"."id"`,
+	value:  func(item interface{}) string { return item.(*models.Token).ID },
+	parse:  parseID,
+}
+
+var tokenListing = listing{
+	key: tokenKey,
+	sorts: map[string]sortColumn{
+		"id": tokenKey,
+		"name": {
+			column: `"tokens"."name"`,
+			value:  func(item interface{}) string { return item.(*models.Token).Name },
+			parse:  parseString,
+		},
+		"expires": {
+			column: `"tokens"."expires"`,
+			value:  func(item interface{}) string { return formatTime(item.(*models.Token).Expires) },
+			parse:  parseTime,
+		},
+	},
+	filters: map[string]filter{
+		"org_id":         equalsID(`"tokens"."org_id"`),
+		"expires_before": compareTime(`"tokens"."expires"`, "<"),
+		"expires_after":  compareTime(`"tokens"."expires"`, ">"),
+		"name_prefix":    hasPrefix(`"tokens"."name"`),
+	},
+	fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+		tokens, err := models.Tokens(boil.GetDB(), mods...).All()
+
+		items := make([]interface{}, len(tokens))
+		for i, token := range tokens {
+			items[i] = token
+		}
+		return items, err
+	},
+}
+
+// ListTokens returns a page of tokens, filtered by org_id, expires_before,
+// expires_after or name_prefix, and sortable by id, name and expires.
+func ListTokens(q ListQuery) (models.TokenSlice, string, error) {
+	items, next, err := tokenListing.list(q)
+
+	tokens := make(models.TokenSlice, len(items))
+	for i, item := range items {
+		tokens[i] = item.(*models.Token)
+	}
+	return tokens, next, err
+}
+
+// ListHoldings returns a page of a user's holdings, ordered by token id.
+func ListHoldings(userID string, q ListQuery) (models.UserTokenSlice, string, error) {
+	if err := CheckID("user id", userID); err != nil {
+		return nil, "", err
+	}
+
+	exists, err := models.UserExists(boil.GetDB(), userID)
+	if err == nil && !exists {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	holdingListing := listing{
+		key: sortColumn{
+			column: `"user_tokens"."token_id"`,
+			value:  func(item interface{}) string { return item.(*models.UserToken).TokenID },
+			parse:  parseID,
+		},
+		fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+			query := append([]qm.QueryMod{qm.Where(`"user_tokens"."user_id" = ?`, userID)}, mods...)
+			holdings, err := models.UserTokens(boil.GetDB(), query...).All()
+
+			items := make([]interface{}, len(holdings))
+			for i, holding := range holdings {
+				items[i] = holding
+			}
+			return items, err
+		},
+	}
+
+	items, next, err := holdingListing.list(q)
+
+	holdings := make(models.UserTokenSlice, len(items))
+	for i, item := range items {
+		holdings[i] = item.(*models.UserToken)
+	}
+	return holdings, next, err
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+)
+
+const someID = "00000000-0000-0000-0000-000000000000"
+
+func code(err error) string {
+	if err == nil {
+		return ""
+	}
+	return apierror.From(err).Code
+}
+
+func TestValidation(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		err  error
+		want string
+	}{
+		"bad id": {
+			func() error { _, err := GetUser("42"); return err }(),
+			apierror.CodeInvalidID,
+		},
+		"empty name": {
+			func() error { _, err := CreateToken(NewToken{Expires: time.Now(), OrgID: someID}); return err }(),
+			apierror.CodeValidation,
+		},
+		"no expiry": {
+			func() error { _, err := CreateToken(NewToken{Name: "Gold", OrgID: someID}); return err }(),
+			apierror.CodeValidation,
+		},
+		"no users": {
+			func() error { _, err := GrantGroup(someID, nil, 1); return err }(),
+			apierror.CodeValidation,
+		},
+		"zero number": {
+			func() error { _, err := Spend(someID, someID, 0); return err }(),
+			apierror.CodeInvalidNumber,
+		},
+		"bad sender": {
+			func() error { _, err := Transfer(someID, "nobody", someID, 1); return err }(),
+			apierror.CodeInvalidID,
+		},
+	}
+
+	for name, c := range cases {
+		if got := code(c.err); got != c.want {
+			t.Errorf("%s: want %q, got %q (%v)", name, c.want, got, c.err)
+		}
+	}
+}
+
+func TestListQueryValidation(t *testing.T) {
+	t.Parallel()
+
+	queries := map[string]ListQuery{
+		"limit":        {Limit: MaxPageSize + 1},
+		"sort":         {Sort: "owner"},
+		"sorted twice": {Sort: "name,-name"},
+		"cursor":       {Cursor: "not-a-cursor"},
+		"filter":       {Filters: map[string]string{"expires_before": "yesterday"}},
+		"filter id":    {Filters: map[string]string{"org_id": "acme"}},
+		"filter name":  {Filters: map[string]string{"owner": "acme"}},
+	}
+
+	for name, q := range queries {
+		if _, _, err := ListTokens(q); code(err) != apierror.CodeInvalidQuery && code(err) != apierror.CodeInvalidID {
+			t.Errorf("%s: want a query error, got %v", name, err)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	order, err := tokenListing.order("-name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := &models.Token{ID: someID, Name: "Gold"}
+	raw := encodeCursor("-name", order, last)
+
+	args, err := decodeCursor(raw, "-name", order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[0] != "Gold" || args[1] != someID {
+		t.Errorf("want [Gold %s], got %v", someID, args)
+	}
+
+	if _, err := decodeCursor(raw, "name", order); err == nil {
+		t.Error("a cursor must not be accepted for a different sort")
+	}
+}
+
+func TestGiftCodeCheckCharacter(t *testing.T) {
+	t.Parallel()
+
+	code, err := generateGiftCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validGiftCode(code) || !validGiftCode(NormaliseCode(strings.ToLower(FormatGiftCode(code)))) {
+		t.Fatalf("want %s valid as generated and as printed", code)
+	}
+
+	for i := range code {
+		for _, c := range []byte(codeAlphabet) {
+			if c == code[i] {
+				continue
+			}
+			typo := code[:i] + string(c) + code[i+1:]
+			if validGiftCode(typo) {
+				t.Errorf("want %s, one character off %s, caught", typo, code)
+			}
+		}
+	}
+
+	for _, pair := range []string{"01", "12", "9A", "XY"} {
+		swapped := pair[1:] + pair[:1]
+		original := "ABCDEFGHJKMN" + pair + "P"
+		original += string(checkCharacter(original))
+		typo := "ABCDEFGHJKMN" + swapped + "P" + original[giftCodeRandom:]
+		if validGiftCode(typo) {
+			t.Errorf("want the swap of %s caught", pair)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/ivanbakel/Tokenizer-Server/voucher"
+	"github.com/vattle/sqlboiler/boil"
+	"github.com/vattle/sqlboiler/queries/qm"
+	"gopkg.in/nullbio/null.v6"
+)
+
+// How long a voucher lasts, unless asked for otherwise, and the bounds of
+// what can be asked for. A retired signing key stays published for
+// MaxVoucherLifetime, so that terminals can check every voucher it signed.
+const (
+	DefaultVoucherLifetime = 24 * time.Hour
+	MinVoucherLifetime     = time.Minute
+	MaxVoucherLifetime     = 30 * 24 * time.Hour
+)
+
+// MaxSettlementBatch is how many vouchers one settlement can carry.
+const MaxSettlementBatch = 500
+
+// clockSkew is how far ahead of the server a terminal's clock may run.
+const clockSkew = 5 * time.Minute
+
+// The reasons a synced voucher cannot be settled.
+const (
+	ConflictDoubleSpend         = "double_spend"
+	ConflictExpired             = "expired"
+	ConflictInsufficientBalance = "insufficient_balance"
+	ConflictInvalidSignature    = "invalid_signature"
+	ConflictUnknownVoucher      = "unknown_voucher"
+	ConflictFrozen              = "frozen"
+)
+
+// NewVoucher is a voucher to be issued.
+type NewVoucher struct {
+	Number int16
+	// Lifetime is how long the voucher lasts, or zero for
+	// DefaultVoucherLifetime. It never outlasts the token.
+	Lifetime time.Duration
+}
+
+// IssueVoucher issues a voucher for number units of a token from a user's
+// holding, signed with the key of the token's organisation, returning it
+// with its encoded form.
+func IssueVoucher(userID, tokenID string, request NewVoucher) (*storage.Voucher, string, error) {
+	if err := CheckID("user id", userID); err != nil {
+		return nil, "", err
+	}
+	if err := checkNumber(request.Number); err != nil {
+		return nil, "", err
+	}
+
+	lifetime := request.Lifetime
+	if lifetime == 0 {
+		lifetime = DefaultVoucherLifetime
+	}
+	if lifetime < MinVoucherLifetime || lifetime > MaxVoucherLifetime {
+		return nil, "", apierror.Invalid(apierror.CodeValidation, fmt.Sprintf("expires_in must be between %d and %d seconds",
+			int(MinVoucherLifetime.Seconds()), int(MaxVoucherLifetime.Seconds())))
+	}
+
+	token, err := GetToken(tokenID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err := signingKey(token.OrgID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now().UTC()
+	v := &storage.Voucher{
+		ID:        storage.NewUUID(),
+		OrgID:     token.OrgID,
+		KeyID:     key.ID,
+		UserID:    userID,
+		TokenID:   tokenID,
+		Number:    request.Number,
+		IssuedAt:  now.Truncate(time.Second),
+		ExpiresAt: now.Add(lifetime).Truncate(time.Second),
+	}
+	if v.ExpiresAt.After(token.Expires) {
+		v.ExpiresAt = token.Expires.UTC()
+	}
+
+	if err = storage.IssueVoucher(v); err != nil {
+		return nil, "", err
+	}
+
+	encoded, err := voucher.Sign(voucher.Voucher{
+		ID:        v.ID,
+		KeyID:     v.KeyID,
+		OrgID:     v.OrgID,
+		TokenID:   v.TokenID,
+		UserID:    v.UserID,
+		Number:    v.Number,
+		IssuedAt:  v.IssuedAt,
+		ExpiresAt: v.ExpiresAt,
+	}, key.PrivateKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return v, encoded, nil
+}
+
+// signingKey returns the key an organisation signs vouchers with, creating
+// its first on demand.
+func signingKey(orgID string) (*storage.VoucherKey, error) {
+	key, err := storage.ActiveVoucherKey(orgID)
+	if err != sql.ErrNoRows {
+		return key, err
+	}
+
+	// Two first vouchers at once both try to create the key; the loser
+	// uses the winner's.
+	if _, err = createVoucherKey(orgID); storage.Translate(err) != storage.ErrConflict && err != nil {
+		return nil, err
+	}
+	return storage.ActiveVoucherKey(orgID)
+}
+
+func createVoucherKey(orgID string) (*storage.VoucherKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &storage.VoucherKey{OrgID: orgID, PublicKey: public, PrivateKey: private}
+	if err = storage.CreateVoucherKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RotateVoucherKey replaces the key an organisation signs vouchers with.
+// Vouchers signed with the old key stay valid, and it stays published,
+// until they have all expired.
+func RotateVoucherKey(orgID string) (*storage.VoucherKey, error) {
+	if _, err := GetOrganisation(orgID); err != nil {
+		return nil, err
+	}
+	return createVoucherKey(orgID)
+}
+
+// VoucherKeys returns the key set terminals verify an organisation's
+// vouchers with.
+func VoucherKeys(orgID string) (voucher.KeySet, error) {
+	if _, err := GetOrganisation(orgID); err != nil {
+		return voucher.KeySet{}, err
+	}
+
+	keys, err := storage.VoucherKeys(orgID, time.Now().Add(-MaxVoucherLifetime))
+	if err != nil {
+		return voucher.KeySet{}, err
+	}
+
+	set := voucher.KeySet{Keys: make([]voucher.JWK, len(keys))}
+	for i, key := range keys {
+		set.Keys[i] = voucher.PublicJWK(key.ID, key.PublicKey)
+	}
+	return set, nil
+}
+
+// Redemption is a voucher a terminal accepted, as it syncs it back.
+type Redemption struct {
+	Voucher    string
+	RedeemedAt time.Time
+}
+
+// Settlement is the outcome of settling one voucher. Status is settled,
+// duplicate if the same terminal had already settled it, or conflict, in
+// which case Reason says why and the conflict has been recorded.
+type Settlement struct {
+	VoucherID string
+	Status    string
+	Reason    string
+	Holding   *models.UserToken
+}
+
+// SettleVouchers settles the vouchers a terminal of an organisation
+// accepted while offline, spending each from the holding it was issued
+// against. Each voucher is settled on its own; those that cannot be are
+// recorded as conflicts and reported, and do not stop the rest. Only a
+// refusal by the spends limit stops the batch.
+func SettleVouchers(orgID, terminal string, redemptions []Redemption) ([]Settlement, error) {
+	if terminal == "" || len(terminal) > 100 {
+		return nil, apierror.Invalid(apierror.CodeValidation, "terminal must be between 1 and 100 characters")
+	}
+	if len(redemptions) == 0 || len(redemptions) > MaxSettlementBatch {
+		return nil, apierror.Invalid(apierror.CodeValidation, fmt.Sprintf("redemptions must hold between 1 and %d vouchers", MaxSettlementBatch))
+	}
+	for _, r := range redemptions {
+		if r.RedeemedAt.IsZero() || r.RedeemedAt.After(time.Now().Add(clockSkew)) {
+			return nil, apierror.Invalid(apierror.CodeValidation, "every redeemed_at must be set, and not in the future")
+		}
+	}
+
+	keys, err := VoucherKeys(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Settlement, len(redemptions))
+	for i, r := range redemptions {
+		if results[i], err = settle(orgID, terminal, keys, r); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func settle(orgID, terminal string, keys voucher.KeySet, r Redemption) (Settlement, error) {
+	conflict := func(voucherID, reason, settledBy string) (Settlement, error) {
+		c := &storage.VoucherConflict{OrgID: orgID, VoucherID: voucherID, Reason: reason, Terminal: terminal, RedeemedAt: r.RedeemedAt}
+		if settledBy != "" {
+			c.SettledBy = null.StringFrom(settledBy)
+		}
+		if err := storage.RecordVoucherConflict(c); err != nil {
+			return Settlement{}, err
+		}
+		return Settlement{VoucherID: voucherID, Status: "conflict", Reason: reason}, nil
+	}
+
+	// The id of a voucher that fails verification is recorded as given,
+	// cut to fit, so the conflict can be matched to what the terminal saw.
+	v, err := keys.Verify(r.Voucher)
+	if err != nil {
+		id := ""
+		if claimed, decodeErr := voucher.Decode(r.Voucher); decodeErr == nil {
+			id = claimed.ID
+		}
+		if len(id) > 36 {
+			id = id[:36]
+		}
+		return conflict(id, ConflictInvalidSignature, "")
+	}
+	if v.OrgID != orgID {
+		return conflict(v.ID, ConflictInvalidSignature, "")
+	}
+
+	// Settling spends, so it counts against the user's spends limit. A
+	// refusal fails the batch with how long to wait; vouchers already
+	// settled come back as duplicates when the terminal tries again.
+	refund, err := throttle(limiters.Spends, v.UserID, 1, v.TokenID, "", v.Number, v.UserID)
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	holding, err := storage.SettleVoucher(orgID, v.ID, terminal, r.RedeemedAt)
+	if err != nil {
+		refund()
+	}
+	switch err {
+	case nil:
+		return Settlement{VoucherID: v.ID, Status: "settled", Holding: holding}, nil
+	case sql.ErrNoRows:
+		return conflict(v.ID, ConflictUnknownVoucher, "")
+	case storage.ErrVoucherExpired:
+		return conflict(v.ID, ConflictExpired, "")
+	case storage.ErrInsufficientBalance:
+		return conflict(v.ID, ConflictInsufficientBalance, "")
+	case storage.ErrUserFrozen, storage.ErrHoldingFrozen:
+		return conflict(v.ID, ConflictFrozen, "")
+	case storage.ErrVoucherSettled:
+	default:
+		return Settlement{}, err
+	}
+
+	// A terminal syncing again after losing the answer sees its own
+	// settlement, not a conflict.
+	settled, err := storage.FindVoucher(orgID, v.ID)
+	if err != nil {
+		return Settlement{}, err
+	}
+	if settled.Terminal.String == terminal && settled.RedeemedAt.Time.Equal(r.RedeemedAt) {
+		return Settlement{VoucherID: v.ID, Status: "duplicate"}, nil
+	}
+	return conflict(v.ID, ConflictDoubleSpend, settled.Terminal.String)
+}
+
+// ListVoucherConflicts returns a page of an organisation's voucher
+// conflicts, newest first, filtered by reason or terminal.
+func ListVoucherConflicts(orgID string, q ListQuery) ([]*storage.VoucherConflict, string, error) {
+	if _, err := GetOrganisation(orgID); err != nil {
+		return nil, "", err
+	}
+
+	detectedAt := sortColumn{
+		column: `"voucher_conflicts"."detected_at"`,
+		value:  func(item interface{}) string { return formatTime(item.(*storage.VoucherConflict).DetectedAt) },
+		parse:  parseTime,
+	}
+
+	conflictListing := listing{
+		key: sortColumn{
+			column: `"voucher_conflicts"."id"`,
+			value:  func(item interface{}) string { return item.(*storage.VoucherConflict).ID },
+			parse:  parseID,
+		},
+		sorts: map[string]sortColumn{"detected_at": detectedAt},
+		filters: map[string]filter{
+			"reason":   equals(`"voucher_conflicts"."reason"`),
+			"terminal": equals(`"voucher_conflicts"."terminal"`),
+		},
+		fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+			var conflicts []*storage.VoucherConflict
+
+			query := append([]qm.QueryMod{
+				qm.Select(storage.VoucherConflictColumns...),
+				qm.From(`"voucher_conflicts"`),
+				qm.Where(`"voucher_conflicts"."org_id" = ?`, orgID),
+			}, mods...)
+			err := models.NewQuery(boil.GetDB(), query...).Bind(&conflicts)
+
+			items := make([]interface{}, len(conflicts))
+			for i, c := range conflicts {
+				items[i] = c
+			}
+			return items, err
+		},
+	}
+
+	if q.Sort == "" {
+		q.Sort = "-detected_at"
+	}
+
+	items, next, err := conflictListing.list(q)
+
+	conflicts := make([]*storage.VoucherConflict, len(items))
+	for i, item := range items {
+		conflicts[i] = item.(*storage.VoucherConflict)
+	}
+	return conflicts, next, err
+}
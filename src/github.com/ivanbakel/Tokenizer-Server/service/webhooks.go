@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/ivanbakel/Tokenizer-Server/webhook"
+	"github.com/vattle/sqlboiler/boil"
+	"github.com/vattle/sqlboiler/queries/qm"
+)
+
+// eventTypes are the event types a webhook can subscribe to.
+var eventTypes = map[string]bool{
+	string(storage.EventGranted):     true,
+	string(storage.EventSpent):       true,
+	string(storage.EventTransferred): true,
+	string(storage.EventExpired):     true,
+	string(storage.EventRateLimited): true,
+}
+
+// allowPrivateWebhooks lets webhooks target addresses that are not public;
+// see webhook.ErrPrivateTarget.
+var allowPrivateWebhooks bool
+
+// AllowPrivateWebhooks sets whether webhooks may be registered for hosts
+// that are not public. It is meant to be called once, before serving.
+func AllowPrivateWebhooks(allow bool) {
+	allowPrivateWebhooks = allow
+}
+
+// webhookResolveTimeout bounds the lookup of a webhook's host.
+const webhookResolveTimeout = 5 * time.Second
+
+// NewWebhook is a webhook to be created.
+type NewWebhook struct {
+	URL string
+	// Events are the event types to deliver, or none for all of them.
+	Events []string
+	// Secret keys the deliveries' signatures. If empty, one is generated.
+	Secret string
+}
+
+// CreateWebhook validates and creates a webhook for an organisation. The
+// secret is only ever returned here; afterwards it cannot be read back.
+func CreateWebhook(orgID string, request NewWebhook) (*storage.Webhook, error) {
+	if _, err := GetOrganisation(orgID); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(request.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(request.URL) > 2000 {
+		return nil, apierror.Invalid(apierror.CodeValidation, "url must be an absolute http or https URL of at most 2000 characters")
+	}
+	if !allowPrivateWebhooks {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookResolveTimeout)
+		defer cancel()
+
+		if err = webhook.CheckHost(ctx, u.Hostname()); err != nil {
+			return nil, apierror.Invalid(apierror.CodeValidation, "url must have a host that resolves to public addresses only")
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range request.Events {
+		if !eventTypes[name] {
+			return nil, apierror.Invalid(apierror.CodeValidation, fmt.Sprintf("%q is not an event type", name))
+		}
+		seen[name] = true
+	}
+
+	events := make([]string, 0, len(seen))
+	for name := range seen {
+		events = append(events, name)
+	}
+	sort.Strings(events)
+
+	secret := request.Secret
+	if secret == "" {
+		if secret, err = generateSecret(); err != nil {
+			return nil, err
+		}
+	} else if len(secret) < 16 || len(secret) > 100 {
+		return nil, apierror.Invalid(apierror.CodeValidation, "secret must be between 16 and 100 characters")
+	}
+
+	w := &storage.Webhook{OrgID: orgID, URL: request.URL, Events: strings.Join(events, ","), Secret: secret}
+	if err = storage.CreateWebhook(w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func generateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// GetWebhook returns one of an organisation's webhooks.
+func GetWebhook(orgID, webhookID string) (*storage.Webhook, error) {
+	if err := CheckID("organisation id", orgID); err != nil {
+		return nil, err
+	}
+	if err := CheckID("webhook id", webhookID); err != nil {
+		return nil, err
+	}
+	return storage.FindWebhook(orgID, webhookID)
+}
+
+// DeleteWebhook removes one of an organisation's webhooks, and with it any
+// deliveries still outstanding.
+func DeleteWebhook(orgID, webhookID string) error {
+	if err := CheckID("organisation id", orgID); err != nil {
+		return err
+	}
+	if err := CheckID("webhook id", webhookID); err != nil {
+		return err
+	}
+	return storage.DeleteWebhook(orgID, webhookID)
+}
+
+// ListWebhooks returns a page of an organisation's webhooks, ordered by id.
+func ListWebhooks(orgID string, q ListQuery) ([]*storage.Webhook, string, error) {
+	if _, err := GetOrganisation(orgID); err != nil {
+		return nil, "", err
+	}
+
+	webhookListing := listing{
+		key: sortColumn{
+			column: `"webhooks"."id"`,
+			value:  func(item interface{}) string { return item.(*storage.Webhook).ID },
+			parse:  parseID,
+		},
+		fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+			var webhooks []*storage.Webhook
+
+			query := append([]qm.QueryMod{
+				qm.Select(storage.WebhookColumns...),
+				qm.From(`"webhooks"`),
+				qm.Where(`"webhooks"."org_id" = ?`, orgID),
+			}, mods...)
+			err := models.NewQuery(boil.GetDB(), query...).Bind(&webhooks)
+
+			items := make([]interface{}, len(webhooks))
+			for i, w := range webhooks {
+				items[i] = w
+			}
+			return items, err
+		},
+	}
+
+	items, next, err := webhookListing.list(q)
+
+	webhooks := make([]*storage.Webhook, len(items))
+	for i, item := range items {
+		webhooks[i] = item.(*storage.Webhook)
+	}
+	return webhooks, next, err
+}
+
+// ListWebhookDeliveries returns a page of a webhook's deliveries, newest
+// first, filtered by state.
+func ListWebhookDeliveries(orgID, webhookID string, q ListQuery) ([]*storage.WebhookDelivery, string, error) {
+	if _, err := GetWebhook(orgID, webhookID); err != nil {
+		return nil, "", err
+	}
+
+	createdAt := sortColumn{
+		column: `"webhook_deliveries"."created_at"`,
+		value:  func(item interface{}) string { return formatTime(item.(*storage.WebhookDelivery).CreatedAt) },
+		parse:  parseTime,
+	}
+
+	deliveryListing := listing{
+		key: sortColumn{
+			column: `"webhook_deliveries"."id"`,
+			value:  func(item interface{}) string { return item.(*storage.WebhookDelivery).ID },
+			parse:  parseID,
+		},
+		sorts: map[string]sortColumn{"created_at": createdAt},
+		filters: map[string]filter{
+			"state": equals(`"webhook_deliveries"."state"`),
+		},
+		fetch: func(mods ...qm.QueryMod) ([]interface{}, error) {
+			var deliveries []*storage.WebhookDelivery
+
+			query := append([]qm.QueryMod{
+				qm.Select(storage.WebhookDeliveryColumns...),
+				qm.From(`"webhook_deliveries"`),
+				qm.Where(`"webhook_deliveries"."webhook_id" = ?`, webhookID),
+			}, mods...)
+			err := models.NewQuery(boil.GetDB(), query...).Bind(&deliveries)
+
+			items := make([]interface{}, len(deliveries))
+			for i, d := range deliveries {
+				items[i] = d
+			}
+			return items, err
+		},
+	}
+
+	if q.Sort == "" {
+		q.Sort = "-created_at"
+	}
+
+	items, next, err := deliveryListing.list(q)
+
+	deliveries := make([]*storage.WebhookDelivery, len(items))
+	for i, item := range items {
+		deliveries[i] = item.(*storage.WebhookDelivery)
+	}
+	return deliveries, next, err
+}
+
+// GetWebhookDelivery returns one of a webhook's deliveries, with the log of
+// attempts at it, oldest first.
+func GetWebhookDelivery(orgID, webhookID, deliveryID string) (*storage.WebhookDelivery, []storage.WebhookAttempt, error) {
+	if err := CheckID("delivery id", deliveryID); err != nil {
+		return nil, nil, err
+	}
+	if _, err := GetWebhook(orgID, webhookID); err != nil {
+		return nil, nil, err
+	}
+
+	delivery, err := storage.FindWebhookDelivery(webhookID, deliveryID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attempts, err := storage.WebhookAttempts(deliveryID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return delivery, attempts, nil
+}
+
+// RedeliverWebhookDelivery sends one of a webhook's deliveries again, with a
+// fresh set of attempts, whatever became of it before.
+func RedeliverWebhookDelivery(orgID, webhookID, deliveryID string) error {
+	if err := CheckID("delivery id", deliveryID); err != nil {
+		return err
+	}
+	if _, err := GetWebhook(orgID, webhookID); err != nil {
+		return err
+	}
+	return storage.RedeliverWebhookDelivery(webhookID, deliveryID, time.Now())
+}
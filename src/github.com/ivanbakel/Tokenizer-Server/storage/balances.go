@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// ErrInsufficientBalance is returned when a user does not hold enough of a
+// token to spend or transfer the requested number.
+var ErrInsufficientBalance = errors.New("storage: insufficient balance")
+
+// ErrInvalidNumber is returned when a balance change is not positive.
+var ErrInvalidNumber = errors.New("storage: number must be positive")
+
+// The statements below are written to run unchanged on every backend: both
+// Postgres and SQLite accept $n placeholders and ON CONFLICT upserts.
+const (
+	creditQuery = `INSERT INTO "user_tokens" ("user_id", "token_id", "number") VALUES ($1, $2, $3)
+ON CONFLICT ("user_id", "token_id") DO UPDATE SET "number" = COALESCE("user_tokens"."number", 0) + excluded."number"`
+
+	debitQuery = `UPDATE "user_tokens" SET "number" = "number" - $1
+WHERE "user_id" = $2 AND "token_id" = $3 AND "number" >= $4`
+)
+
+// Grant credits number units of a token to each of the given users, creating
+// their holdings where necessary. Either every user is credited or none are:
+// a grant that would take the token past its maximum supply fails with
+// ErrSupplyExceeded, and one that would leave any user over its per-user cap
+// with a *HoldingCapError counting every such user.
+func Grant(tokenID string, number int16, userIDs ...string) (models.UserTokenSlice, error) {
+	if number <= 0 {
+		return nil, ErrInvalidNumber
+	}
+
+	var holdings models.UserTokenSlice
+	var events []Event
+
+	err := Transaction(func(tx boil.Transactor) error {
+		token, err := models.FindToken(tx, tokenID)
+		if err != nil {
+			return err
+		}
+
+		if err = issue(tx, token, int(number)*len(userIDs)); err != nil {
+			return err
+		}
+
+		// Every user is credited, even after one goes over the cap, so that
+		// the error says how many would; the transaction is rolled back.
+		exceeded := 0
+		for _, userID := range userIDs {
+			holding, err := credit(tx, token, userID, number)
+			if _, ok := err.(*HoldingCapError); ok {
+				exceeded++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			holdings = append(holdings, holding)
+			events = append(events, newEvent(EventGranted, token, holding, number))
+		}
+		if exceeded > 0 {
+			return &HoldingCapError{Cap: token.UserCap.Int16, Users: exceeded}
+		}
+		return recordEvents(tx, events)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	publish(events)
+	return holdings, nil
+}
+
+// Spend removes number units of a token from a user's holding. The check and
+// the update are a single statement, so concurrent spends cannot overdraw.
+func Spend(userID, tokenID string, number int16) (*models.UserToken, error) {
+	if number <= 0 {
+		return nil, ErrInvalidNumber
+	}
+
+	var holding *models.UserToken
+	var events []Event
+
+	err := Transaction(func(tx boil.Transactor) (err error) {
+		holding, events, err = spend(tx, userID, tokenID, number)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	publish(events)
+	return holding, nil
+}
+
+// spend is Spend within tx. The events it records are returned to be
+// published once tx commits.
+func spend(tx boil.Transactor, userID, tokenID string, number int16) (*models.UserToken, []Event, error) {
+	token, err := models.FindToken(tx, tokenID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	holding, err := debit(tx, userID, tokenID, number)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err = count(tx, tokenID, supplySpent, int(number)); err != nil {
+		return nil, nil, err
+	}
+
+	events := []Event{newEvent(EventSpent, token, holding, number)}
+	return holding, events, recordEvents(tx, events)
+}
+
+// Transfer moves number units of a token from one user to another, returning
+// the sender's and the recipient's holdings after the move. It fails with a
+// *HoldingCapError if the recipient would hold more than the token's cap.
+func Transfer(tokenID, fromUserID, toUserID string, number int16) (from, to *models.UserToken, err error) {
+	if number <= 0 {
+		return nil, nil, ErrInvalidNumber
+	}
+
+	var events []Event
+
+	err = Transaction(func(tx boil.Transactor) error {
+		token, err := models.FindToken(tx, tokenID)
+		if err != nil {
+			return err
+		}
+
+		if from, err = debit(tx, fromUserID, tokenID, number); err != nil {
+			return err
+		}
+		if to, err = credit(tx, token, toUserID, number); err != nil {
+			return err
+		}
+		event := newEvent(EventTransferred, token, to, number)
+		event.FromUserID = fromUserID
+		event.FromBalance = from.Number.Int16
+		events = []Event{event}
+		return recordEvents(tx, events)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publish(events)
+	return from, to, nil
+}
+
+func newEvent(typ EventType, token *models.Token, holding *models.UserToken, number int16) Event {
+	return Event{
+		Type:    typ,
+		OrgID:   token.OrgID,
+		TokenID: token.ID,
+		UserID:  holding.UserID,
+		Number:  number,
+		Balance: holding.Number.Int16,
+		At:      time.Now().UTC(),
+	}
+}
+
+// credit adds number units of token to a user's holding, failing with a
+// *HoldingCapError if that leaves it over the token's per-user cap. As with
+// debit, the upsert holds the row, so concurrent credits cannot both slip
+// under the cap.
+func credit(exec boil.Executor, token *models.Token, userID string, number int16) (*models.UserToken, error) {
+	if _, err := exec.Exec(creditQuery, userID, token.ID, number); err != nil {
+		return nil, errors.Wrap(err, "storage: unable to credit user_tokens")
+	}
+
+	holding, err := models.FindUserToken(exec, userID, token.ID)
+	if err != nil {
+		return nil, err
+	}
+	if token.UserCap.Valid && holding.Number.Int16 > token.UserCap.Int16 {
+		return nil, &HoldingCapError{Cap: token.UserCap.Int16, Users: 1}
+	}
+	return holding, nil
+}
+
+// debit takes number units of a token from a user's holding, failing with
+// ErrUserFrozen or ErrHoldingFrozen if the user or the holding is frozen.
+func debit(exec boil.Executor, userID, tokenID string, number int16) (*models.UserToken, error) {
+	if err := checkFrozen(exec, userID, tokenID); err != nil {
+		return nil, err
+	}
+
+	result, err := exec.Exec(debitQuery, number, userID, tokenID, number)
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to debit user_tokens")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to debit user_tokens")
+	}
+	if affected == 0 {
+		return nil, ErrInsufficientBalance
+	}
+
+	return models.FindUserToken(exec, userID, tokenID)
+}
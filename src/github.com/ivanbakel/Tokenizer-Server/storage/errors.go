@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// Driver-independent versions of the constraint errors callers care about.
+var (
+	// ErrConflict is returned when a write collides with an existing record.
+	ErrConflict = errors.New("storage: conflicts with an existing record")
+	// ErrMissingReference is returned when a write refers to a record, such
+	// as a user or an organisation, that does not exist.
+	ErrMissingReference = errors.New("storage: refers to a record that does not exist")
+	// ErrMalformedValue is returned when the database rejects the syntax of a
+	// value, e.g. an id that is not a UUID.
+	ErrMalformedValue = errors.New("storage: malformed value")
+)
+
+// Translate replaces a Postgres or SQLite constraint error anywhere in err's
+// cause chain with the matching storage error. Other errors are returned as
+// they are.
+func Translate(err error) error {
+	switch cause := errors.Cause(err).(type) {
+	case *pq.Error:
+		switch cause.Code {
+		case "23505":
+			return ErrConflict
+		case "23503":
+			return ErrMissingReference
+		case "22P02":
+			return ErrMalformedValue
+		}
+	case sqlite3.Error:
+		switch cause.ExtendedCode {
+		case sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintUnique:
+			return ErrConflict
+		case sqlite3.ErrConstraintForeignKey:
+			return ErrMissingReference
+		}
+	}
+	return err
+}
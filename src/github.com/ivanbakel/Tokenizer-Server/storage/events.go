@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// EventType names a kind of balance change.
+type EventType string
+
+// The balance changes the storage layer reports.
+const (
+	EventGranted     EventType = "granted"
+	EventSpent       EventType = "spent"
+	EventTransferred EventType = "transferred"
+	EventExpired     EventType = "expired"
+	// EventRateLimited is a change refused because it would break the rate
+	// limit named by the event's Limit. The holdings were left as they were.
+	EventRateLimited EventType = "rate_limited"
+)
+
+// Event describes one committed change to a user's holding of a token.
+//
+// For a transfer, UserID is the recipient and FromUserID the sender, and
+// Balance and FromBalance are their new balances. A rate_limited event
+// describes the change that was refused, with the balances as they stood.
+//
+// Events are recorded in the same transaction as the change, and their IDs
+// increase in the order they were recorded.
+type Event struct {
+	ID          int64     `json:"id"`
+	Type        EventType `json:"type"`
+	OrgID       string    `json:"org_id"`
+	TokenID     string    `json:"token_id"`
+	UserID      string    `json:"user_id"`
+	FromUserID  string    `json:"from_user_id,omitempty"`
+	Number      int16     `json:"number"`
+	Balance     int16     `json:"balance"`
+	FromBalance int16     `json:"from_balance,omitempty"`
+	Limit       string    `json:"limit,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// MarshalJSON encodes the event as the API does, with from_balance present
+// for every transfer, even one that emptied the sender's holding.
+func (e Event) MarshalJSON() ([]byte, error) {
+	type event Event
+
+	var fromBalance *int16
+	if e.FromUserID != "" {
+		fromBalance = &e.FromBalance
+	}
+
+	return json.Marshal(struct {
+		event
+		FromBalance *int16 `json:"from_balance,omitempty"`
+	}{event(e), fromBalance})
+}
+
+// Concerns reports whether the event changed userID's holding.
+func (e Event) Concerns(userID string) bool {
+	return e.UserID == userID || e.FromUserID == userID
+}
+
+// Listener is called with every event after its transaction has committed.
+// Listeners run synchronously on the request's goroutine and must not block.
+type Listener func(Event)
+
+var (
+	listenersMut sync.RWMutex
+	listeners    []Listener
+)
+
+// AddListener registers fn to be told about every committed balance change.
+func AddListener(fn Listener) {
+	listenersMut.Lock()
+	listeners = append(listeners, fn)
+	listenersMut.Unlock()
+}
+
+func publish(events []Event) {
+	listenersMut.RLock()
+	defer listenersMut.RUnlock()
+
+	for _, event := range events {
+		for _, fn := range listeners {
+			fn(event)
+		}
+	}
+}
+
+const (
+	recordEventQuery = `INSERT INTO "balance_events"
+("type", "org_id", "token_id", "user_id", "from_user_id", "number", "balance", "from_balance", "limit_name", "at")
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING "id"`
+
+	// firstSeenQuery notes when a user first took part in a balance change.
+	firstSeenQuery = `INSERT INTO "user_first_seen" ("user_id", "at") VALUES ($1, $2)
+ON CONFLICT ("user_id") DO NOTHING`
+
+	eventColumns = `"id", "type", "org_id", "token_id", "user_id", "from_user_id", "number", "balance", "from_balance", "limit_name", "at"`
+
+	eventsAfterQuery = `SELECT ` + eventColumns + ` FROM "balance_events"
+WHERE ("user_id" = $1 OR "from_user_id" = $1) AND "id" > $2 ORDER BY "id" LIMIT $3`
+
+	eventLogQuery = `SELECT ` + eventColumns + ` FROM "balance_events" WHERE "id" > $1 ORDER BY "id" LIMIT $2`
+
+	pruneEventsQuery = `DELETE FROM "balance_events"
+WHERE "at" < $1 AND NOT EXISTS (SELECT 1 FROM "relay_cursors" WHERE "last_id" < "balance_events"."id")`
+)
+
+// recordEvents stores events as part of tx, filling in their IDs, and queues
+// them for the webhooks that want them. Doing both in the change's own
+// transaction means no change goes unannounced, and none is announced that
+// did not commit.
+func recordEvents(tx boil.Transactor, events []Event) error {
+	for i := range events {
+		e := &events[i]
+
+		var fromUserID, fromBalance, limit interface{}
+		if e.FromUserID != "" {
+			fromUserID, fromBalance = e.FromUserID, e.FromBalance
+		}
+		if e.Limit != "" {
+			limit = e.Limit
+		}
+
+		err := tx.QueryRow(recordEventQuery, e.Type, e.OrgID, e.TokenID, e.UserID, fromUserID,
+			e.Number, e.Balance, fromBalance, limit, e.At).Scan(&e.ID)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to record balance event")
+		}
+
+		if e.Type != EventRateLimited {
+			if err = noteFirstSeen(tx, e.At, e.UserID, e.FromUserID); err != nil {
+				return err
+			}
+		}
+	}
+	return enqueueDeliveries(tx, events)
+}
+
+// noteFirstSeen records at as when each of the users took part in a balance
+// change for the first time, unless they already had. Unlike the events,
+// which are pruned, this is kept for as long as the user is.
+func noteFirstSeen(tx boil.Transactor, at time.Time, userIDs ...string) error {
+	for _, userID := range userIDs {
+		if userID == "" {
+			continue
+		}
+		if _, err := tx.Exec(firstSeenQuery, userID, at); err != nil {
+			return errors.Wrap(err, "storage: unable to note when a user was first seen")
+		}
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEvent(row scanner) (Event, error) {
+	var e Event
+	var fromUserID, limit sql.NullString
+	var fromBalance sql.NullInt64
+
+	err := row.Scan(&e.ID, &e.Type, &e.OrgID, &e.TokenID, &e.UserID, &fromUserID, &e.Number, &e.Balance, &fromBalance, &limit, &e.At)
+	e.FromUserID = fromUserID.String
+	e.Limit = limit.String
+	e.FromBalance = int16(fromBalance.Int64)
+	e.At = e.At.UTC()
+	return e, err
+}
+
+// FindEvent loads the event with the given ID. It returns sql.ErrNoRows if
+// there is none, e.g. because it has been pruned.
+func FindEvent(id int64) (Event, error) {
+	row := boil.GetDB().QueryRow(`SELECT `+eventColumns+` FROM "balance_events" WHERE "id" = $1`, id)
+
+	e, err := scanEvent(row)
+	if err == sql.ErrNoRows {
+		return e, err
+	}
+	return e, errors.Wrap(err, "storage: unable to find balance event")
+}
+
+// LastEventID returns the ID of the newest event, or 0 if there are none.
+func LastEventID() (int64, error) {
+	var id int64
+	err := boil.GetDB().QueryRow(`SELECT COALESCE(MAX("id"), 0) FROM "balance_events"`).Scan(&id)
+	return id, errors.Wrap(err, "storage: unable to find the last balance event")
+}
+
+// EventsAfter returns up to limit of the events concerning userID that were
+// recorded after the event afterID, oldest first.
+func EventsAfter(userID string, afterID int64, limit int) ([]Event, error) {
+	return queryEvents(eventsAfterQuery, userID, afterID, limit)
+}
+
+// EventLog returns up to limit of the events recorded after the event
+// afterID, whoever they concern, oldest first.
+func EventLog(afterID int64, limit int) ([]Event, error) {
+	return queryEvents(eventLogQuery, afterID, limit)
+}
+
+func queryEvents(query string, args ...interface{}) ([]Event, error) {
+	rows, err := boil.GetDB().Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to list balance events")
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "storage: unable to list balance events")
+		}
+		events = append(events, e)
+	}
+
+	return events, errors.Wrap(rows.Err(), "storage: unable to list balance events")
+}
+
+// PruneEvents forgets every event recorded before the given time, returning
+// how many there were. Streams can no longer resume from pruned events.
+// Events a relay has yet to publish are kept however old they are, for as
+// long as its cursor is; see PruneRelays.
+func PruneEvents(before time.Time) (int64, error) {
+	result, err := boil.GetDB().Exec(pruneEventsQuery, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "storage: unable to prune balance events")
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// Expiry times are stored in UTC, so that SQLite's textual timestamps compare
+// in the same order as the times they represent.
+const expiredHoldingsQuery = `SELECT "user_tokens"."user_id", "user_tokens"."token_id", "tokens"."org_id"
+FROM "user_tokens" INNER JOIN "tokens" ON "tokens"."id" = "user_tokens"."token_id"
+WHERE "tokens"."expires" <= $1 AND "user_tokens"."number" > 0`
+
+// lockHoldingQuery returns a holding's number as it stands, and holds the row
+// until the transaction ends, so that nothing changes it before
+// emptyHoldingQuery empties it. An empty holding returns no row.
+const (
+	lockHoldingQuery = `UPDATE "user_tokens" SET "number" = "number"
+WHERE "user_id" = $1 AND "token_id" = $2 AND "number" > 0 RETURNING "number"`
+
+	emptyHoldingQuery = `UPDATE "user_tokens" SET "number" = 0 WHERE "user_id" = $1 AND "token_id" = $2`
+)
+
+// Expire empties every holding of a token that expired at or before now, and
+// reports each emptied holding as an EventExpired.
+func Expire(now time.Time) ([]Event, error) {
+	now = now.UTC()
+
+	var events []Event
+
+	err := Transaction(func(tx boil.Transactor) error {
+		rows, err := tx.Query(expiredHoldingsQuery, now)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to find expired holdings")
+		}
+
+		for rows.Next() {
+			event := Event{Type: EventExpired, At: now}
+			if err = rows.Scan(&event.UserID, &event.TokenID, &event.OrgID); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "storage: unable to find expired holdings")
+			}
+			events = append(events, event)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return errors.Wrap(err, "storage: unable to find expired holdings")
+		}
+
+		// Holdings may change between the read above and emptying them: a
+		// spend may commit, or another instance expire them first. What is
+		// reported is what each holding held once locked, and a holding
+		// found empty by then is not reported at all.
+		emptied := events[:0]
+		for _, event := range events {
+			err := tx.QueryRow(lockHoldingQuery, event.UserID, event.TokenID).Scan(&event.Number)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return errors.Wrap(err, "storage: unable to empty expired holding")
+			}
+			if _, err = tx.Exec(emptyHoldingQuery, event.UserID, event.TokenID); err != nil {
+				return errors.Wrap(err, "storage: unable to empty expired holding")
+			}
+			if _, err = count(tx, event.TokenID, supplyExpired, int(event.Number)); err != nil {
+				return err
+			}
+			emptied = append(emptied, event)
+		}
+		events = emptied
+		return recordEvents(tx, events)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	publish(events)
+	return events, nil
+}
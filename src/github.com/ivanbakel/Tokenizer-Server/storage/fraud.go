@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// ErrUserFrozen and ErrHoldingFrozen are returned when a balance change would
+// take units from a frozen user, or from a frozen holding of a user who is
+// not. Each names what has to be unfrozen for the change to be made.
+var (
+	ErrUserFrozen    = errors.New("storage: user is frozen")
+	ErrHoldingFrozen = errors.New("storage: holding is frozen")
+)
+
+// What an alert froze, if anything.
+const (
+	FrozeUser    = "user"
+	FrozeHolding = "holding"
+)
+
+// Alert is raised when a fraud rule matches the balance events. EventID is
+// the event that completed the pattern, At when it was recorded, and Count
+// what the rule counted, e.g. how many users sent transfers. Frozen is what
+// the alert froze: FrozeUser, FrozeHolding or nothing.
+type Alert struct {
+	ID      string    `boil:"id"`
+	Rule    string    `boil:"rule"`
+	UserID  string    `boil:"user_id"`
+	TokenID string    `boil:"token_id"`
+	EventID int64     `boil:"event_id"`
+	Count   int       `boil:"count"`
+	Frozen  string    `boil:"frozen"`
+	At      time.Time `boil:"at"`
+}
+
+// AlertColumns lists the columns an Alert is read from, for queries built
+// elsewhere.
+var AlertColumns = []string{"id", "rule", "user_id", "token_id", "event_id", "count", "frozen", "at"}
+
+const (
+	// frozenQuery finds the freezes that stop a user's holding of a token
+	// being debited: the user's own, and the holding's.
+	frozenQuery = `SELECT "users"."frozen_at" IS NOT NULL, "user_tokens"."frozen_at" IS NOT NULL
+FROM "users" LEFT JOIN "user_tokens"
+ON "user_tokens"."user_id" = "users"."id" AND "user_tokens"."token_id" = $1
+WHERE "users"."id" = $2`
+
+	sendersQuery = `SELECT COUNT(DISTINCT "from_user_id") FROM "balance_events"
+WHERE "type" = $1 AND "user_id" = $2 AND "at" > $3 AND "id" <= $4`
+
+	// A recipient is new if they first took part in a balance change
+	// within the window.
+	newRecipientsQuery = `SELECT COUNT(DISTINCT "e"."user_id") FROM "balance_events" AS "e"
+JOIN "user_first_seen" AS "s" ON "s"."user_id" = "e"."user_id"
+WHERE "e"."type" = $1 AND "e"."from_user_id" = $2 AND "e"."at" > $3 AND "e"."id" <= $4 AND "s"."at" > $3`
+
+	grantedQuery = `SELECT COALESCE(SUM("number"), 0) FROM "balance_events"
+WHERE "type" = $1 AND "user_id" = $2 AND "token_id" = $3 AND "at" > $4 AND "id" < $5`
+
+	recentAlertQuery = `SELECT COUNT(*) FROM "fraud_alerts"
+WHERE "rule" = $1 AND "user_id" = $2 AND "token_id" = $3 AND "at" > $4`
+)
+
+// checkFrozen fails with ErrUserFrozen or ErrHoldingFrozen if a user's
+// holding of a token may not be debited. A user who does not exist is not
+// frozen; the debit itself fails.
+func checkFrozen(exec boil.Executor, userID, tokenID string) error {
+	var userFrozen, holdingFrozen bool
+	err := exec.QueryRow(frozenQuery, tokenID, userID).Scan(&userFrozen, &holdingFrozen)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "storage: unable to check for freezes")
+	}
+	if userFrozen {
+		return ErrUserFrozen
+	}
+	if holdingFrozen {
+		return ErrHoldingFrozen
+	}
+	return nil
+}
+
+// CountSenders counts the users who transferred to userID after since, in
+// events up to and including the event upTo.
+func CountSenders(userID string, since time.Time, upTo int64) (int, error) {
+	var n int
+	err := boil.GetDB().QueryRow(sendersQuery, EventTransferred, userID, since.UTC(), upTo).Scan(&n)
+	return n, errors.Wrap(err, "storage: unable to count senders")
+}
+
+// CountNewRecipients counts the users fromUserID transferred to after since,
+// in events up to and including the event upTo, who had never taken part in
+// a balance change before since.
+func CountNewRecipients(fromUserID string, since time.Time, upTo int64) (int, error) {
+	var n int
+	err := boil.GetDB().QueryRow(newRecipientsQuery, EventTransferred, fromUserID, since.UTC(), upTo).Scan(&n)
+	return n, errors.Wrap(err, "storage: unable to count new recipients")
+}
+
+// CountGranted sums the units of a token granted to userID after since, in
+// events recorded before the event with ID before.
+func CountGranted(userID, tokenID string, since time.Time, before int64) (int, error) {
+	var n int
+	err := boil.GetDB().QueryRow(grantedQuery, EventGranted, userID, tokenID, since.UTC(), before).Scan(&n)
+	return n, errors.Wrap(err, "storage: unable to count grants")
+}
+
+// RaiseAlert stores alert, filling in its ID, and freezes whatever its Frozen
+// names. If an alert for the same rule, user and token was raised for an
+// event after since, the new one is dropped and RaiseAlert returns false, so
+// that a pattern that carries on is reported once, and an event published
+// again is not reported twice.
+func RaiseAlert(alert *Alert, since time.Time) (bool, error) {
+	raised := false
+
+	err := Transaction(func(tx boil.Transactor) error {
+		var recent int
+		err := tx.QueryRow(recentAlertQuery, alert.Rule, alert.UserID, alert.TokenID, since.UTC()).Scan(&recent)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to find recent alerts")
+		}
+		if recent > 0 {
+			return nil
+		}
+
+		alert.ID = NewUUID()
+		alert.At = alert.At.UTC()
+
+		_, err = tx.Exec(`INSERT INTO "fraud_alerts" ("id", "rule", "user_id", "token_id", "event_id", "count", "frozen", "at")
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			alert.ID, alert.Rule, alert.UserID, alert.TokenID, alert.EventID, alert.Count, alert.Frozen, alert.At)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to raise alert")
+		}
+
+		switch alert.Frozen {
+		case FrozeUser:
+			_, err = tx.Exec(`UPDATE "users" SET "frozen_at" = $1 WHERE "id" = $2 AND "frozen_at" IS NULL`,
+				time.Now().UTC(), alert.UserID)
+		case FrozeHolding:
+			_, err = tx.Exec(`UPDATE "user_tokens" SET "frozen_at" = $1 WHERE "user_id" = $2 AND "token_id" = $3 AND "frozen_at" IS NULL`,
+				time.Now().UTC(), alert.UserID, alert.TokenID)
+		}
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to freeze")
+		}
+
+		raised = true
+		return nil
+	})
+
+	return raised, err
+}
+
+// FindAlert loads the alert with the given ID. It returns sql.ErrNoRows if
+// there is none.
+func FindAlert(id string) (*Alert, error) {
+	var a Alert
+
+	err := boil.GetDB().QueryRow(`SELECT "id", "rule", "user_id", "token_id", "event_id", "count", "frozen", "at"
+FROM "fraud_alerts" WHERE "id" = $1`, id).Scan(
+		&a.ID, &a.Rule, &a.UserID, &a.TokenID, &a.EventID, &a.Count, &a.Frozen, &a.At)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find alert")
+	}
+	a.At = a.At.UTC()
+	return &a, nil
+}
+
+// UnfreezeUser lifts the freeze on a user, leaving any on their holdings. It
+// returns sql.ErrNoRows if there is no such user.
+func UnfreezeUser(userID string) (*models.User, error) {
+	if err := unfreeze(`UPDATE "users" SET "frozen_at" = NULL WHERE "id" = $1`, userID); err != nil {
+		return nil, err
+	}
+	return models.FindUser(boil.GetDB(), userID)
+}
+
+// UnfreezeHolding lifts the freeze on a user's holding of a token, leaving
+// any on the user. It returns sql.ErrNoRows if there is no such holding.
+func UnfreezeHolding(userID, tokenID string) (*models.UserToken, error) {
+	if err := unfreeze(`UPDATE "user_tokens" SET "frozen_at" = NULL WHERE "user_id" = $1 AND "token_id" = $2`, userID, tokenID); err != nil {
+		return nil, err
+	}
+	return models.FindUserToken(boil.GetDB(), userID, tokenID)
+}
+
+func unfreeze(query string, args ...interface{}) error {
+	result, err := boil.GetDB().Exec(query, args...)
+	if err != nil {
+		return errors.Wrap(err, "storage: unable to unfreeze")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "storage: unable to unfreeze")
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
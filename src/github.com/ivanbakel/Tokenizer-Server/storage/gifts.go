@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+	"gopkg.in/nullbio/null.v6"
+)
+
+// Errors ClaimGiftCode returns for codes that exist but cannot be claimed.
+var (
+	ErrGiftCodeClaimed = errors.New("storage: gift code already claimed")
+	ErrGiftCodeRevoked = errors.New("storage: gift code revoked")
+)
+
+// giftCodeChunk is how many codes are inserted per statement, keeping well
+// within every backend's limit on bound parameters.
+const giftCodeChunk = 400
+
+// GiftBatch is a batch of gift codes, each worth Number units of a token to
+// whoever claims it. Claimed counts the codes claimed so far.
+type GiftBatch struct {
+	ID        string    `boil:"id"`
+	OrgID     string    `boil:"org_id"`
+	TokenID   string    `boil:"token_id"`
+	Number    int16     `boil:"number"`
+	Size      int       `boil:"size"`
+	Claimed   int       `boil:"claimed"`
+	CreatedAt time.Time `boil:"created_at"`
+	// RevokedAt is when the batch's unclaimed codes stopped being
+	// claimable, if they have.
+	RevokedAt null.Time `boil:"revoked_at"`
+}
+
+// GiftBatchColumns lists the columns a GiftBatch is read from, for queries
+// built elsewhere.
+var GiftBatchColumns = []string{"id", "org_id", "token_id", "number", "size", "claimed", "created_at", "revoked_at"}
+
+// GiftCode is one code of a batch, and who claimed it, if anyone has.
+type GiftCode struct {
+	Code      string      `boil:"code"`
+	BatchID   string      `boil:"batch_id"`
+	ClaimedBy null.String `boil:"claimed_by"`
+	ClaimedAt null.Time   `boil:"claimed_at"`
+}
+
+// GiftCodeColumns lists the columns a GiftCode is read from, for queries
+// built elsewhere.
+var GiftCodeColumns = []string{"code", "batch_id", "claimed_by", "claimed_at"}
+
+// CreateGiftBatch stores a batch and its codes, filling in the batch's ID,
+// size and creation time. It fails with ErrConflict, storing nothing, if any
+// of the codes is already taken.
+func CreateGiftBatch(batch *GiftBatch, codes []string) error {
+	batch.ID = NewUUID()
+	batch.Size = len(codes)
+	batch.CreatedAt = time.Now().UTC()
+
+	return Transaction(func(tx boil.Transactor) error {
+		if _, err := models.FindToken(tx, batch.TokenID); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`INSERT INTO "gift_batches" ("id", "org_id", "token_id", "number", "size", "created_at") VALUES ($1, $2, $3, $4, $5, $6)`,
+			batch.ID, batch.OrgID, batch.TokenID, batch.Number, batch.Size, batch.CreatedAt)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to create gift batch")
+		}
+
+		for start := 0; start < len(codes); start += giftCodeChunk {
+			chunk := codes[start:]
+			if len(chunk) > giftCodeChunk {
+				chunk = chunk[:giftCodeChunk]
+			}
+
+			values := make([]string, len(chunk))
+			args := make([]interface{}, 0, 2*len(chunk))
+			for i, code := range chunk {
+				values[i] = "($" + strconv.Itoa(2*i+1) + ", $" + strconv.Itoa(2*i+2) + ")"
+				args = append(args, code, batch.ID)
+			}
+
+			_, err = tx.Exec(`INSERT INTO "gift_codes" ("code", "batch_id") VALUES `+strings.Join(values, ", "), args...)
+			if err != nil {
+				return errors.Wrap(err, "storage: unable to create gift codes")
+			}
+		}
+		return nil
+	})
+}
+
+// FindGiftBatch loads one of an organisation's batches. It returns
+// sql.ErrNoRows if there is none.
+func FindGiftBatch(orgID, id string) (*GiftBatch, error) {
+	return findGiftBatch(boil.GetDB(), orgID, id)
+}
+
+func findGiftBatch(exec boil.Executor, orgID, id string) (*GiftBatch, error) {
+	var b GiftBatch
+
+	err := exec.QueryRow(`SELECT "id", "org_id", "token_id", "number", "size", "claimed", "created_at", "revoked_at"
+FROM "gift_batches" WHERE "id" = $1 AND "org_id" = $2`, id, orgID).Scan(
+		&b.ID, &b.OrgID, &b.TokenID, &b.Number, &b.Size, &b.Claimed, &b.CreatedAt, &b.RevokedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find gift batch")
+	}
+	return &b, nil
+}
+
+// RevokeGiftBatch stops a batch's unclaimed codes from being claimed, and
+// returns the batch. Revoking a batch again changes nothing.
+func RevokeGiftBatch(orgID, id string, at time.Time) (*GiftBatch, error) {
+	_, err := boil.GetDB().Exec(`UPDATE "gift_batches" SET "revoked_at" = $1 WHERE "id" = $2 AND "org_id" = $3 AND "revoked_at" IS NULL`,
+		at.UTC(), id, orgID)
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to revoke gift batch")
+	}
+	return FindGiftBatch(orgID, id)
+}
+
+// EachGiftCode calls fn with every code of a batch, in order.
+func EachGiftCode(batchID string, fn func(GiftCode) error) error {
+	rows, err := boil.GetDB().Query(`SELECT "code", "batch_id", "claimed_by", "claimed_at" FROM "gift_codes" WHERE "batch_id" = $1 ORDER BY "code"`, batchID)
+	if err != nil {
+		return errors.Wrap(err, "storage: unable to list gift codes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c GiftCode
+		if err = rows.Scan(&c.Code, &c.BatchID, &c.ClaimedBy, &c.ClaimedAt); err != nil {
+			return errors.Wrap(err, "storage: unable to list gift codes")
+		}
+		if err = fn(c); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(rows.Err(), "storage: unable to list gift codes")
+}
+
+// FindGiftCodeBatch returns the batch a code belongs to, whether or not the
+// code has been claimed.
+func FindGiftCodeBatch(code string) (*GiftBatch, error) {
+	var b GiftBatch
+
+	err := boil.GetDB().QueryRow(`SELECT "gift_batches"."id", "gift_batches"."org_id", "gift_batches"."token_id", "gift_batches"."number",
+"gift_batches"."size", "gift_batches"."claimed", "gift_batches"."created_at", "gift_batches"."revoked_at"
+FROM "gift_codes" INNER JOIN "gift_batches" ON "gift_batches"."id" = "gift_codes"."batch_id" WHERE "gift_codes"."code" = $1`, code).Scan(
+		&b.ID, &b.OrgID, &b.TokenID, &b.Number, &b.Size, &b.Claimed, &b.CreatedAt, &b.RevokedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find gift batch")
+	}
+	return &b, nil
+}
+
+// ClaimGiftCode credits a user with what a code is worth and marks it
+// claimed by them, in one transaction. A code already claimed fails with
+// ErrGiftCodeClaimed, however many users try it at once, and one from a
+// revoked batch with ErrGiftCodeRevoked. Claims are grants, so the token's
+// supply and per-user caps apply, and a code that would break them stays
+// unclaimed. It returns the code's batch and the user's holding afterwards.
+func ClaimGiftCode(code, userID string) (*GiftBatch, *models.UserToken, error) {
+	var batch *GiftBatch
+	var holding *models.UserToken
+	var events []Event
+
+	err := Transaction(func(tx boil.Transactor) error {
+		now := time.Now().UTC()
+
+		result, err := tx.Exec(`UPDATE "gift_codes" SET "claimed_by" = $1, "claimed_at" = $2
+WHERE "code" = $3 AND "claimed_at" IS NULL AND "batch_id" IN (SELECT "id" FROM "gift_batches" WHERE "revoked_at" IS NULL)`, userID, now, code)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to claim gift code")
+		}
+
+		claimed, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to claim gift code")
+		}
+
+		var orgID, batchID string
+		var claimedAt null.Time
+		err = tx.QueryRow(`SELECT "gift_batches"."org_id", "gift_codes"."batch_id", "gift_codes"."claimed_at" FROM "gift_codes"
+INNER JOIN "gift_batches" ON "gift_batches"."id" = "gift_codes"."batch_id" WHERE "gift_codes"."code" = $1`, code).Scan(&orgID, &batchID, &claimedAt)
+		if err == sql.ErrNoRows {
+			return err
+		}
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to claim gift code")
+		}
+
+		if claimed == 0 {
+			if claimedAt.Valid {
+				return ErrGiftCodeClaimed
+			}
+			return ErrGiftCodeRevoked
+		}
+
+		if _, err = tx.Exec(`UPDATE "gift_batches" SET "claimed" = "claimed" + 1 WHERE "id" = $1`, batchID); err != nil {
+			return errors.Wrap(err, "storage: unable to claim gift code")
+		}
+
+		if batch, err = findGiftBatch(tx, orgID, batchID); err != nil {
+			return err
+		}
+
+		token, err := models.FindToken(tx, batch.TokenID)
+		if err != nil {
+			return err
+		}
+
+		if err = issue(tx, token, int(batch.Number)); err != nil {
+			return err
+		}
+
+		if holding, err = credit(tx, token, userID, batch.Number); err != nil {
+			return err
+		}
+
+		events = []Event{newEvent(EventGranted, token, holding, batch.Number)}
+		return recordEvents(tx, events)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publish(events)
+	return batch, holding, nil
+}
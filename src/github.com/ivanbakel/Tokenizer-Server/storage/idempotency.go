@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// ErrIdempotencyKeyReused is returned when a key is presented again with a
+// different request from the one it was first used for.
+var ErrIdempotencyKeyReused = errors.New("storage: idempotency key was used for a different request")
+
+// ErrIdempotencyKeyInFlight is returned when a key is presented again before
+// the request it was first used for has finished.
+var ErrIdempotencyKeyInFlight = errors.New("storage: idempotency key is in use by a request in flight")
+
+// StoredResponse is the response recorded against an idempotency key, to be
+// replayed to retries of the same request.
+type StoredResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+const (
+	claimKeyQuery = `INSERT INTO "idempotency_keys" ("key", "method", "path", "request_hash", "created_at")
+VALUES ($1, $2, $3, $4, $5) ON CONFLICT ("key") DO NOTHING`
+
+	findKeyQuery = `SELECT "method", "path", "request_hash", "status", "content_type", "body"
+FROM "idempotency_keys" WHERE "key" = $1`
+)
+
+// ClaimIdempotencyKey reserves key for a request, identified by its method,
+// path and a hash of its body. A nil response means the key is new and the
+// request should go ahead; otherwise the key has been used before and the
+// response it produced is returned.
+func ClaimIdempotencyKey(key, method, path, requestHash string, now time.Time) (*StoredResponse, error) {
+	var exec = boil.GetDB()
+
+	result, err := exec.Exec(claimKeyQuery, key, method, path, requestHash, now.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to claim idempotency key")
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to claim idempotency key")
+	}
+	if claimed == 1 {
+		return nil, nil
+	}
+
+	var storedMethod, storedPath, storedHash string
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+
+	err = exec.QueryRow(findKeyQuery, key).Scan(&storedMethod, &storedPath, &storedHash, &status, &contentType, &body)
+	if err == sql.ErrNoRows {
+		// The key was released between the insert and the select.
+		return nil, ErrIdempotencyKeyInFlight
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find idempotency key")
+	}
+
+	if storedMethod != method || storedPath != path || storedHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !status.Valid {
+		return nil, ErrIdempotencyKeyInFlight
+	}
+
+	return &StoredResponse{Status: int(status.Int64), ContentType: contentType.String, Body: body}, nil
+}
+
+// CompleteIdempotencyKey records the response to the request key was claimed
+// for, so that retries get the same response.
+func CompleteIdempotencyKey(key string, response StoredResponse) error {
+	_, err := boil.GetDB().Exec(`UPDATE "idempotency_keys" SET "status" = $1, "content_type" = $2, "body" = $3 WHERE "key" = $4`,
+		response.Status, response.ContentType, response.Body, key)
+	return errors.Wrap(err, "storage: unable to complete idempotency key")
+}
+
+// ReleaseIdempotencyKey forgets key, so that the request can be tried again
+// from scratch. It is for requests that failed without taking effect.
+func ReleaseIdempotencyKey(key string) error {
+	_, err := boil.GetDB().Exec(`DELETE FROM "idempotency_keys" WHERE "key" = $1`, key)
+	return errors.Wrap(err, "storage: unable to release idempotency key")
+}
+
+// ExpireIdempotencyKeys forgets every key claimed before the given time,
+// returning how many there were.
+func ExpireIdempotencyKeys(before time.Time) (int64, error) {
+	result, err := boil.GetDB().Exec(`DELETE FROM "idempotency_keys" WHERE "created_at" < $1`, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "storage: unable to expire idempotency keys")
+	}
+
+	return result.RowsAffected()
+}
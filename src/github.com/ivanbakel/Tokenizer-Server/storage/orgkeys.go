@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+	"gopkg.in/nullbio/null.v6"
+)
+
+// OrgKey is an API key an organisation's systems, such as its tills,
+// authenticate with. Only the SHA-256 hash of the key itself is stored;
+// Prefix is its first few characters, for telling keys apart.
+type OrgKey struct {
+	ID        string    `boil:"id"`
+	OrgID     string    `boil:"org_id"`
+	Name      string    `boil:"name"`
+	Prefix    string    `boil:"prefix"`
+	CreatedAt time.Time `boil:"created_at"`
+	RevokedAt null.Time `boil:"revoked_at"`
+}
+
+// OrgKeyColumns lists the columns an OrgKey is read from, for queries built
+// elsewhere.
+var OrgKeyColumns = []string{"id", "org_id", "name", "prefix", "created_at", "revoked_at"}
+
+// CreateOrgKey stores a new key with the given hash, filling in its ID and
+// creation time.
+func CreateOrgKey(key *OrgKey, hash string) error {
+	key.ID = NewUUID()
+	key.CreatedAt = time.Now().UTC()
+
+	_, err := boil.GetDB().Exec(`INSERT INTO "org_keys" ("id", "org_id", "name", "prefix", "hash", "created_at")
+VALUES ($1, $2, $3, $4, $5, $6)`, key.ID, key.OrgID, key.Name, key.Prefix, hash, key.CreatedAt)
+	return errors.Wrap(err, "storage: unable to create org key")
+}
+
+// FindOrgKeyByHash loads the unrevoked key with the given hash. It returns
+// sql.ErrNoRows if there is none.
+func FindOrgKeyByHash(hash string) (*OrgKey, error) {
+	var key OrgKey
+
+	err := boil.GetDB().QueryRow(`SELECT "id", "org_id", "name", "prefix", "created_at", "revoked_at" FROM "org_keys"
+WHERE "hash" = $1 AND "revoked_at" IS NULL`, hash).Scan(&key.ID, &key.OrgID, &key.Name, &key.Prefix, &key.CreatedAt, &key.RevokedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find org key")
+	}
+	return &key, nil
+}
+
+// RevokeOrgKey stops one of an organisation's keys from authenticating. It
+// returns sql.ErrNoRows if the organisation has no such unrevoked key.
+func RevokeOrgKey(orgID, id string, at time.Time) error {
+	result, err := boil.GetDB().Exec(`UPDATE "org_keys" SET "revoked_at" = $1
+WHERE "id" = $2 AND "org_id" = $3 AND "revoked_at" IS NULL`, at.UTC(), id, orgID)
+	if err != nil {
+		return errors.Wrap(err, "storage: unable to revoke org key")
+	}
+	return expectRow(result, "storage: unable to revoke org key")
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// Postgres is the default backend.
+var Postgres = &Backend{
+	Name:       "postgres",
+	Driver:     "postgres",
+	Migrations: migrations.Postgres,
+	Checks: []Check{
+		{Name: "uuid-ossp", Check: checkExtension("uuid-ossp")},
+	},
+	Listen: listenPostgres,
+}
+
+// eventsChannel is notified with the id of each balance event as it commits;
+// see the create_balance_events migration.
+const eventsChannel = "balance_events"
+
+// listenPostgres relays the events announced on eventsChannel. Events that
+// commit while the connection is down are caught up from the table once it
+// is back.
+func listenPostgres(ctx context.Context, dsn string, fn Listener) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, nil)
+	defer listener.Close()
+
+	if err := listener.Listen(eventsChannel); err != nil {
+		return errors.Wrap(err, "storage: unable to listen for balance events")
+	}
+
+	last, err := LastEventID()
+	if err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ping.C:
+			go listener.Ping()
+
+		case n := <-listener.Notify:
+			// A nil notification means the connection was re-established,
+			// and anything announced in between was lost.
+			if n == nil {
+				if last, err = catchUp(last, fn); err != nil {
+					return err
+				}
+				continue
+			}
+
+			id, err := strconv.ParseInt(n.Extra, 10, 64)
+			if err != nil || id <= last {
+				continue
+			}
+
+			event, err := FindEvent(id)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			fn(event)
+			last = id
+		}
+	}
+}
+
+// catchUp passes fn every event recorded after last, returning the id of the
+// newest one.
+func catchUp(last int64, fn Listener) (int64, error) {
+	rows, err := boil.GetDB().Query(`SELECT `+eventColumns+` FROM "balance_events" WHERE "id" > $1 ORDER BY "id"`, last)
+	if err != nil {
+		return last, errors.Wrap(err, "storage: unable to catch up on balance events")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return last, errors.Wrap(err, "storage: unable to catch up on balance events")
+		}
+		fn(event)
+		last = event.ID
+	}
+
+	return last, errors.Wrap(rows.Err(), "storage: unable to catch up on balance events")
+}
+
+// checkExtension fails unless the named extension is installed.
+func checkExtension(name string) func(ctx context.Context, db *sql.DB) error {
+	return func(ctx context.Context, db *sql.DB) error {
+		var installed bool
+		err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = $1)", name).Scan(&installed)
+		if err != nil {
+			return errors.Wrapf(err, "storage: unable to look up extension %s", name)
+		}
+		if !installed {
+			return errors.Errorf("storage: extension %s is not installed", name)
+		}
+		return nil
+	}
+}
+
+func init() {
+	Register(Postgres)
+}
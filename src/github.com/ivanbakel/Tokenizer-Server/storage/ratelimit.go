@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// lockBucketQuery stores a new bucket, or touches an existing one so that its
+// row is held until the transaction ends, as creditQuery does for holdings.
+const lockBucketQuery = `INSERT INTO "rate_limit_buckets" ("key", "tokens", "at") VALUES ($1, $2, $3)
+ON CONFLICT ("key") DO UPDATE SET "key" = excluded."key"`
+
+// UpdateBucket passes the token bucket stored under key to fn, and stores the
+// tokens and time fn returns in its place. A bucket not stored yet starts
+// with full tokens as of now. Updates of the same bucket, from this or any
+// other instance, run one after the other.
+func UpdateBucket(key string, full float64, now time.Time, fn func(tokens float64, at time.Time) (float64, time.Time)) error {
+	return Transaction(func(tx boil.Transactor) error {
+		if _, err := tx.Exec(lockBucketQuery, key, full, now.UTC()); err != nil {
+			return errors.Wrap(err, "storage: unable to lock rate limit bucket")
+		}
+
+		var tokens float64
+		var at time.Time
+
+		err := tx.QueryRow(`SELECT "tokens", "at" FROM "rate_limit_buckets" WHERE "key" = $1`, key).Scan(&tokens, &at)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to find rate limit bucket")
+		}
+
+		tokens, at = fn(tokens, at.UTC())
+
+		_, err = tx.Exec(`UPDATE "rate_limit_buckets" SET "tokens" = $1, "at" = $2 WHERE "key" = $3`, tokens, at.UTC(), key)
+		return errors.Wrap(err, "storage: unable to update rate limit bucket")
+	})
+}
+
+// PruneBuckets forgets every bucket last updated before the given time,
+// returning how many there were.
+func PruneBuckets(before time.Time) (int64, error) {
+	result, err := boil.GetDB().Exec(`DELETE FROM "rate_limit_buckets" WHERE "at" < $1`, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "storage: unable to prune rate limit buckets")
+	}
+	return result.RowsAffected()
+}
+
+// RecordRateLimited records that the rate limit named limit refused to change
+// each of userIDs' holdings of a token by number units; for a transfer,
+// fromUserID is the sender. The events carry the balances as they stand.
+// Nothing is recorded for a token that does not exist, as there is no
+// organisation to tell.
+func RecordRateLimited(limit, tokenID, fromUserID string, number int16, userIDs ...string) error {
+	var events []Event
+
+	err := Transaction(func(tx boil.Transactor) error {
+		token, err := models.FindToken(tx, tokenID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, userID := range userIDs {
+			event := Event{
+				Type:    EventRateLimited,
+				OrgID:   token.OrgID,
+				TokenID: token.ID,
+				UserID:  userID,
+				Number:  number,
+				Limit:   limit,
+				At:      time.Now().UTC(),
+			}
+			if event.Balance, err = balanceOf(tx, userID, tokenID); err != nil {
+				return err
+			}
+			if fromUserID != "" {
+				event.FromUserID = fromUserID
+				if event.FromBalance, err = balanceOf(tx, fromUserID, tokenID); err != nil {
+					return err
+				}
+			}
+			events = append(events, event)
+		}
+		return recordEvents(tx, events)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	publish(events)
+	return nil
+}
+
+// balanceOf returns how much of a token a user holds, which is none if they
+// have never held it.
+func balanceOf(exec boil.Executor, userID, tokenID string) (int16, error) {
+	var number sql.NullInt64
+
+	err := exec.QueryRow(`SELECT "number" FROM "user_tokens" WHERE "user_id" = $1 AND "token_id" = $2`, userID, tokenID).Scan(&number)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return int16(number.Int64), errors.Wrap(err, "storage: unable to find balance")
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+	"gopkg.in/nullbio/null.v6"
+)
+
+// Errors Redeem returns for codes that exist but cannot be redeemed.
+var (
+	ErrCodeRedeemed = errors.New("storage: redemption code already redeemed")
+	ErrCodeExpired  = errors.New("storage: redemption code expired")
+)
+
+// RedemptionCode is a user's one-off permission for a merchant to spend
+// Number units of a token from their holding, before ExpiresAt.
+type RedemptionCode struct {
+	Code      string
+	UserID    string
+	TokenID   string
+	Number    int16
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// RedeemedAt is set once the code has been redeemed, and RedeemedBy to
+	// the org key that redeemed it.
+	RedeemedAt null.Time
+	RedeemedBy null.String
+}
+
+const (
+	redemptionCodeColumns = `"code", "user_id", "token_id", "number", "created_at", "expires_at", "redeemed_at", "redeemed_by"`
+
+	claimCodeQuery = `UPDATE "redemption_codes" SET "redeemed_at" = $1, "redeemed_by" = $2
+WHERE "code" = $3 AND "redeemed_at" IS NULL AND "expires_at" > $1
+AND "token_id" IN (SELECT "id" FROM "tokens" WHERE "org_id" = $4)`
+)
+
+// CreateRedemptionCode stores a new code, filling in its creation time. It
+// fails with ErrInsufficientBalance if the user does not hold enough of the
+// token now, though the units are not set aside, and with ErrConflict if
+// the code is already taken.
+func CreateRedemptionCode(rc *RedemptionCode) error {
+	rc.CreatedAt = time.Now().UTC()
+	rc.ExpiresAt = rc.ExpiresAt.UTC()
+
+	return Transaction(func(tx boil.Transactor) error {
+		if _, err := models.FindToken(tx, rc.TokenID); err != nil {
+			return err
+		}
+
+		holding, err := models.FindUserToken(tx, rc.UserID, rc.TokenID)
+		if err == sql.ErrNoRows || (err == nil && holding.Number.Int16 < rc.Number) {
+			return ErrInsufficientBalance
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO "redemption_codes" ("code", "user_id", "token_id", "number", "created_at", "expires_at")
+VALUES ($1, $2, $3, $4, $5, $6)`, rc.Code, rc.UserID, rc.TokenID, rc.Number, rc.CreatedAt, rc.ExpiresAt)
+		return errors.Wrap(err, "storage: unable to create redemption code")
+	})
+}
+
+// FindRedemptionCode loads a code. It returns sql.ErrNoRows if there is
+// none.
+func FindRedemptionCode(code string) (*RedemptionCode, error) {
+	return findRedemptionCode(boil.GetDB(), code)
+}
+
+func findRedemptionCode(exec boil.Executor, code string) (*RedemptionCode, error) {
+	var rc RedemptionCode
+
+	err := exec.QueryRow(`SELECT `+redemptionCodeColumns+` FROM "redemption_codes" WHERE "code" = $1`, code).Scan(
+		&rc.Code, &rc.UserID, &rc.TokenID, &rc.Number, &rc.CreatedAt, &rc.ExpiresAt, &rc.RedeemedAt, &rc.RedeemedBy)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find redemption code")
+	}
+	return &rc, nil
+}
+
+// Redeem marks a code redeemed by the given org key and spends what it was
+// issued for, in one transaction: the code is used up if and only if the
+// spend happens. Only codes for the organisation's own tokens can be
+// redeemed; others are reported as sql.ErrNoRows, like codes that do not
+// exist. A code already redeemed fails with ErrCodeRedeemed, however many
+// tills try it at once, and one past its expiry with ErrCodeExpired.
+func Redeem(orgID, code, keyID string) (*RedemptionCode, *models.UserToken, error) {
+	var rc *RedemptionCode
+	var holding *models.UserToken
+	var events []Event
+
+	err := Transaction(func(tx boil.Transactor) error {
+		now := time.Now().UTC()
+
+		result, err := tx.Exec(claimCodeQuery, now, keyID, code, orgID)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to redeem code")
+		}
+
+		claimed, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to redeem code")
+		}
+
+		if rc, err = findRedemptionCode(tx, code); err != nil {
+			return err
+		}
+
+		if claimed == 0 {
+			return unredeemable(tx, rc, orgID, now)
+		}
+
+		holding, events, err = spend(tx, rc.UserID, rc.TokenID, rc.Number)
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publish(events)
+	return rc, holding, nil
+}
+
+// unredeemable explains why a code could not be claimed.
+func unredeemable(exec boil.Executor, rc *RedemptionCode, orgID string, now time.Time) error {
+	token, err := models.FindToken(exec, rc.TokenID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case token.OrgID != orgID:
+		return sql.ErrNoRows
+	case rc.RedeemedAt.Valid:
+		return ErrCodeRedeemed
+	case !rc.ExpiresAt.After(now):
+		return ErrCodeExpired
+	}
+	return errors.New("storage: redemption code unexpectedly not claimed")
+}
+
+// PruneRedemptionCodes forgets every code that expired before the given
+// time, redeemed or not, returning how many there were.
+func PruneRedemptionCodes(before time.Time) (int64, error) {
+	result, err := boil.GetDB().Exec(`DELETE FROM "redemption_codes" WHERE "expires_at" < $1`, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "storage: unable to prune redemption codes")
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// ErrLeaseLost is returned when a relay's lease on its cursor has lapsed and
+// been taken by another instance.
+var ErrLeaseLost = errors.New("storage: relay lease lost")
+
+const (
+	createCursorQuery = `INSERT INTO "relay_cursors" ("name", "last_id") VALUES ($1, 0)
+ON CONFLICT ("name") DO NOTHING`
+
+	leaseCursorQuery = `UPDATE "relay_cursors" SET "owner" = $1, "lease_until" = $2
+WHERE "name" = $3 AND ("owner" = $1 OR "owner" IS NULL OR "lease_until" < $4) RETURNING "last_id"`
+
+	advanceCursorQuery = `UPDATE "relay_cursors" SET "last_id" = $1
+WHERE "name" = $2 AND "owner" = $3 AND "last_id" < $1`
+)
+
+// LeaseRelay takes or renews owner's lease on the named relay's cursor until
+// the given time, creating the cursor at the start of the event log if it is
+// new. It returns the ID of the last event the relay published, or
+// ErrLeaseLost if another owner holds an unexpired lease.
+func LeaseRelay(name, owner string, now, until time.Time) (int64, error) {
+	var last int64
+
+	err := Transaction(func(tx boil.Transactor) error {
+		if _, err := tx.Exec(createCursorQuery, name); err != nil {
+			return err
+		}
+		return tx.QueryRow(leaseCursorQuery, owner, until.UTC(), name, now.UTC()).Scan(&last)
+	})
+	if errors.Cause(err) == sql.ErrNoRows {
+		return 0, ErrLeaseLost
+	}
+	return last, errors.Wrap(err, "storage: unable to lease relay cursor")
+}
+
+// PruneRelays forgets the cursors of relays whose lease has not been renewed
+// since before the given time, returning how many there were. Until then a
+// relay that has been retired or renamed keeps every event it has yet to
+// publish from being pruned. A relay that comes back after its cursor was
+// forgotten starts again from the oldest event kept.
+func PruneRelays(before time.Time) (int64, error) {
+	result, err := boil.GetDB().Exec(`DELETE FROM "relay_cursors" WHERE "lease_until" < $1`, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "storage: unable to prune relay cursors")
+	}
+
+	return result.RowsAffected()
+}
+
+// AdvanceRelay records that the named relay has published every event up to
+// and including lastID. It returns ErrLeaseLost unless owner holds the lease.
+func AdvanceRelay(name, owner string, lastID int64) error {
+	result, err := boil.GetDB().Exec(advanceCursorQuery, lastID, name, owner)
+	if err != nil {
+		return errors.Wrap(err, "storage: unable to advance relay cursor")
+	}
+
+	if err = expectRow(result, "storage: unable to advance relay cursor"); err == sql.ErrNoRows {
+		return ErrLeaseLost
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLite is an embedded backend for single-node deployments. It has no
+// uuid_generate_v4(), so ids are generated by the application before insert.
+//
+// SQLite allows only one writer at a time, so the pool is limited to a single
+// connection; this also keeps ":memory:" databases alive between queries.
+var SQLite = &Backend{
+	Name:       "sqlite3",
+	Driver:     "sqlite3",
+	Migrations: migrations.SQLite,
+	Configure: func(db *sql.DB) error {
+		db.SetMaxOpenConns(1)
+		_, err := db.Exec("PRAGMA foreign_keys = ON")
+		return err
+	},
+}
+
+func init() {
+	Register(SQLite)
+}
@@ -0,0 +1,119 @@
+// Package storage selects and prepares the database backend used by the
+// server, and holds the balance operations that must run transactionally
+// regardless of which backend is in use.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// Backend describes a database that the generated models can run against.
+type Backend struct {
+	// Name is the value used to select the backend in configuration.
+	Name string
+	// Driver is the database/sql driver the backend is opened with.
+	Driver string
+	// Migrations is the backend's schema history, in its own SQL dialect.
+	Migrations []migrations.Migration
+	// Configure tunes a freshly opened handle, e.g. limiting connections.
+	Configure func(db *sql.DB) error
+	// Checks are backend-specific readiness checks, run after a successful ping.
+	Checks []Check
+	// Listen, if set, calls fn with every event committed to the database by
+	// any process until ctx is done. Backends without it are single-process.
+	Listen func(ctx context.Context, dsn string, fn Listener) error
+}
+
+// Check is a named readiness check against an open database.
+type Check struct {
+	Name  string
+	Check func(ctx context.Context, db *sql.DB) error
+}
+
+var (
+	backendsMut sync.RWMutex
+	backends    = make(map[string]*Backend)
+)
+
+// Register makes a backend available to Open under its name.
+func Register(b *Backend) {
+	backendsMut.Lock()
+	defer backendsMut.Unlock()
+
+	if _, dup := backends[b.Name]; dup {
+		panic("storage: backend registered twice: " + b.Name)
+	}
+	backends[b.Name] = b
+}
+
+// Lookup returns the backend registered under name.
+func Lookup(name string) (*Backend, error) {
+	backendsMut.RLock()
+	defer backendsMut.RUnlock()
+
+	b, ok := backends[name]
+	if !ok {
+		return nil, errors.Errorf("storage: unknown backend %q (have %s)", name, strings.Join(names(), ", "))
+	}
+	return b, nil
+}
+
+func names() []string {
+	var list []string
+	for name := range backends {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Open connects to the named backend and installs the hooks the models need
+// to run against it. It does not migrate the schema; see the migrations package.
+func Open(name, dsn string) (*sql.DB, error) {
+	b, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(b.Driver, dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "storage: unable to open %s database", b.Name)
+	}
+
+	if b.Configure != nil {
+		if err = b.Configure(db); err != nil {
+			db.Close()
+			return nil, errors.Wrapf(err, "storage: unable to configure %s database", b.Name)
+		}
+	}
+
+	registerHooks()
+	return db, nil
+}
+
+// Transaction runs fn inside a transaction on the global database handle.
+// The transaction is committed if fn returns nil and rolled back otherwise.
+func Transaction(fn func(tx boil.Transactor) error) error {
+	tx, err := boil.Begin()
+	if err != nil {
+		return errors.Wrap(err, "storage: unable to begin transaction")
+	}
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "storage: unable to commit transaction")
+	}
+	return nil
+}
@@ -0,0 +1,613 @@
+package storage
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/vattle/sqlboiler/boil"
+	"gopkg.in/nullbio/null.v6"
+)
+
+// postgresDSNEnv names the environment variable holding a disposable Postgres
+// database for the shared suite. The Postgres run is skipped when it is unset.
+const postgresDSNEnv = "TOKENIZER_TEST_POSTGRES_DSN"
+
+// TestBackends runs the same suite against every backend, so that behaviour
+// cannot drift between Postgres and SQLite.
+func TestBackends(t *testing.T) {
+	t.Run(Postgres.Name, func(t *testing.T) {
+		dsn := os.Getenv(postgresDSNEnv)
+		if dsn == "" {
+			t.Skipf("%s is not set", postgresDSNEnv)
+		}
+		runSuite(t, Postgres.Name, dsn)
+	})
+
+	t.Run(SQLite.Name, func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tokenizer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		runSuite(t, SQLite.Name, filepath.Join(dir, "tokenizer.db"))
+	})
+}
+
+func runSuite(t *testing.T, backend, dsn string) {
+	db, err := Open(backend, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	b, err := Lookup(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrations.Up(db, b.Migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	boil.SetDB(db)
+
+	t.Run("GeneratedIDs", testGeneratedIDs)
+	t.Run("Grant", testGrant)
+	t.Run("GrantIsAtomic", testGrantIsAtomic)
+	t.Run("Spend", testSpend)
+	t.Run("Transfer", testTransfer)
+	t.Run("Expire", testExpire)
+	t.Run("Caps", testCaps)
+	t.Run("Supply", testSupply)
+	t.Run("RateLimited", testRateLimited)
+	t.Run("Fraud", testFraud)
+	t.Run("Events", testEvents)
+}
+
+type fixture struct {
+	org   *models.Organisation
+	token *models.Token
+	users models.UserSlice
+}
+
+func newFixture(t *testing.T, users int) *fixture {
+	f := &fixture{org: &models.Organisation{Name: "test org"}}
+	if err := f.org.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	f.token = &models.Token{Name: "test token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: f.org.ID}
+	if err := f.token.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < users; i++ {
+		user := &models.User{FacebookID: NewUUID()}
+		if err := user.Insert(boil.GetDB()); err != nil {
+			t.Fatal(err)
+		}
+		f.users = append(f.users, user)
+	}
+
+	return f
+}
+
+func balance(t *testing.T, userID, tokenID string) int16 {
+	holding, err := models.FindUserToken(boil.GetDB(), userID, tokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return holding.Number.Int16
+}
+
+func testGeneratedIDs(t *testing.T) {
+	f := newFixture(t, 2)
+
+	if f.org.ID == "" || f.token.ID == "" {
+		t.Fatal("expected ids to be generated on insert")
+	}
+	if f.users[0].ID == f.users[1].ID {
+		t.Error("expected distinct ids, got", f.users[0].ID, "twice")
+	}
+
+	found, err := models.FindToken(boil.GetDB(), f.token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.OrgID != f.org.ID {
+		t.Errorf("want org %s, got %s", f.org.ID, found.OrgID)
+	}
+}
+
+func testGrant(t *testing.T) {
+	f := newFixture(t, 2)
+
+	holdings, err := Grant(f.token.ID, 3, f.users[0].ID, f.users[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holdings) != 2 {
+		t.Fatal("want 2 holdings, got", len(holdings))
+	}
+
+	if _, err = Grant(f.token.ID, 2, f.users[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := balance(t, f.users[0].ID, f.token.ID); got != 5 {
+		t.Error("want 5, got", got)
+	}
+	if got := balance(t, f.users[1].ID, f.token.ID); got != 3 {
+		t.Error("want 3, got", got)
+	}
+
+	if _, err = Grant(f.token.ID, 0, f.users[0].ID); err != ErrInvalidNumber {
+		t.Error("want ErrInvalidNumber, got", err)
+	}
+}
+
+func testGrantIsAtomic(t *testing.T) {
+	f := newFixture(t, 1)
+
+	if _, err := Grant(f.token.ID, 1, f.users[0].ID, NewUUID()); err == nil {
+		t.Fatal("expected granting to a missing user to fail")
+	}
+
+	if _, err := models.FindUserToken(boil.GetDB(), f.users[0].ID, f.token.ID); err == nil {
+		t.Error("expected the whole grant to be rolled back")
+	}
+}
+
+func testSpend(t *testing.T) {
+	f := newFixture(t, 1)
+	user := f.users[0].ID
+
+	if _, err := Spend(user, f.token.ID, 1); err != ErrInsufficientBalance {
+		t.Error("want ErrInsufficientBalance without a holding, got", err)
+	}
+
+	if _, err := Grant(f.token.ID, 4, user); err != nil {
+		t.Fatal(err)
+	}
+
+	holding, err := Spend(user, f.token.ID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holding.Number.Int16 != 1 {
+		t.Error("want 1 left, got", holding.Number.Int16)
+	}
+
+	if _, err = Spend(user, f.token.ID, 2); err != ErrInsufficientBalance {
+		t.Error("want ErrInsufficientBalance, got", err)
+	}
+	if got := balance(t, user, f.token.ID); got != 1 {
+		t.Error("failed spend changed the balance to", got)
+	}
+}
+
+func testTransfer(t *testing.T) {
+	f := newFixture(t, 2)
+	from, to := f.users[0].ID, f.users[1].ID
+
+	if _, err := Grant(f.token.ID, 5, from); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Transfer(f.token.ID, from, to, 6); err != ErrInsufficientBalance {
+		t.Error("want ErrInsufficientBalance, got", err)
+	}
+
+	sender, recipient, err := Transfer(f.token.ID, from, to, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender.Number.Int16 != 3 || recipient.Number.Int16 != 2 {
+		t.Errorf("want 3 and 2, got %d and %d", sender.Number.Int16, recipient.Number.Int16)
+	}
+
+	// A transfer to a missing user must not debit the sender.
+	if _, _, err = Transfer(f.token.ID, from, NewUUID(), 1); err == nil {
+		t.Error("expected transfer to a missing user to fail")
+	}
+	if got := balance(t, from, f.token.ID); got != 3 {
+		t.Error("failed transfer changed the balance to", got)
+	}
+}
+
+func testExpire(t *testing.T) {
+	f := newFixture(t, 2)
+
+	if _, err := Grant(f.token.ID, 2, f.users[0].ID, f.users[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := Expire(f.token.Expires.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if event.TokenID == f.token.ID {
+			t.Fatal("token expired early")
+		}
+	}
+
+	events, err = Expire(f.token.Expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := 0
+	for _, event := range events {
+		if event.TokenID == f.token.ID {
+			expired++
+			if event.Type != EventExpired || event.Number != 2 || event.OrgID != f.org.ID {
+				t.Errorf("unexpected event %+v", event)
+			}
+		}
+	}
+	if expired != 2 {
+		t.Error("want 2 holdings expired, got", expired)
+	}
+	if got := balance(t, f.users[0].ID, f.token.ID); got != 0 {
+		t.Error("want expired holding emptied, got", got)
+	}
+
+	// Instances expiring the same holdings at once report each of them once.
+	g := newFixture(t, 2)
+	if _, err = Grant(g.token.ID, 3, g.users[0].ID, g.users[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan []Event, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			events, err := Expire(g.token.Expires)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- events
+		}()
+	}
+
+	expired = 0
+	for i := 0; i < 2; i++ {
+		for _, event := range <-results {
+			if event.TokenID == g.token.ID {
+				expired++
+			}
+		}
+	}
+	if expired != 2 {
+		t.Error("want each holding reported expired once, got", expired)
+	}
+
+	supply, err := FindSupply(g.token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if supply.Expired != 6 {
+		t.Error("want 6 units counted expired, got", supply.Expired)
+	}
+}
+
+func testCaps(t *testing.T) {
+	f := newFixture(t, 3)
+
+	f.token.MaxSupply = null.IntFrom(10)
+	f.token.UserCap = null.Int16From(4)
+	if err := f.token.Update(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Grant(f.token.ID, 3, f.users[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two of the three would go over the cap, so none are credited.
+	_, err := Grant(f.token.ID, 2, f.users[0].ID, f.users[1].ID, f.users[0].ID)
+	if capErr, ok := err.(*HoldingCapError); !ok || capErr.Users != 2 || capErr.Cap != 4 {
+		t.Fatal("want 2 users over the cap of 4, got", err)
+	}
+	if _, err = models.FindUserToken(boil.GetDB(), f.users[1].ID, f.token.ID); err != sql.ErrNoRows {
+		t.Error("expected the whole grant to be rolled back, got", err)
+	}
+
+	if _, err = Grant(f.token.ID, 4, f.users[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Transfer(f.token.ID, f.users[1].ID, f.users[0].ID, 2); err == nil {
+		t.Error("expected a transfer over the recipient's cap to fail")
+	}
+	if got := balance(t, f.users[1].ID, f.token.ID); got != 4 {
+		t.Error("failed transfer changed the balance to", got)
+	}
+
+	// 7 of the 10 have been issued; spending them does not free any.
+	if _, err = Spend(f.users[1].ID, f.token.ID, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Grant(f.token.ID, 2, f.users[1].ID, f.users[2].ID); err != ErrSupplyExceeded {
+		t.Error("want ErrSupplyExceeded, got", err)
+	}
+	if _, err = Grant(f.token.ID, 3, f.users[2].ID); err != nil {
+		t.Error("want the last 3 units issued, got", err)
+	}
+}
+
+func testRateLimited(t *testing.T) {
+	f := newFixture(t, 2)
+	key := "spends:" + f.users[0].ID
+	start := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+
+	// A new bucket starts full, and keeps what each update leaves.
+	for _, want := range []float64{5, 3} {
+		err := UpdateBucket(key, 5, start, func(tokens float64, at time.Time) (float64, time.Time) {
+			if tokens != want || !at.Equal(start) {
+				t.Errorf("want %v tokens at %v, got %v at %v", want, start, tokens, at)
+			}
+			return tokens - 2, start
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pruned, err := PruneBuckets(start); err != nil || pruned != 0 {
+		t.Errorf("want nothing pruned, got %d, %v", pruned, err)
+	}
+	if pruned, err := PruneBuckets(start.Add(time.Second)); err != nil || pruned != 1 {
+		t.Errorf("want the bucket pruned, got %d, %v", pruned, err)
+	}
+
+	if _, err := Grant(f.token.ID, 3, f.users[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []Event
+	AddListener(func(event Event) {
+		if event.TokenID == f.token.ID {
+			seen = append(seen, event)
+		}
+	})
+
+	if err := RecordRateLimited("transfers", f.token.ID, f.users[0].ID, 2, f.users[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 {
+		t.Fatal("want one event published, got", len(seen))
+	}
+	if e := seen[0]; e.Type != EventRateLimited || e.Limit != "transfers" || e.UserID != f.users[1].ID || e.Balance != 0 ||
+		e.FromUserID != f.users[0].ID || e.FromBalance != 3 || e.OrgID != f.org.ID {
+		t.Errorf("wrong event %+v", e)
+	}
+
+	found, err := FindEvent(seen[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Limit != "transfers" {
+		t.Errorf("want the limit stored, got %+v", found)
+	}
+
+	// Tokens that do not exist have no organisation to tell.
+	if err = RecordRateLimited("spends", NewUUID(), "", 1, f.users[0].ID); err != nil {
+		t.Error("want nothing recorded for a missing token, got", err)
+	}
+}
+
+func testFraud(t *testing.T) {
+	f := newFixture(t, 4)
+	u := []string{f.users[0].ID, f.users[1].ID, f.users[2].ID, f.users[3].ID}
+
+	if _, err := Grant(f.token.ID, 10, u[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Grant(f.token.ID, 5, u[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Now().UTC()
+
+	for _, transfer := range [][2]string{{u[0], u[1]}, {u[0], u[2]}, {u[0], u[3]}, {u[3], u[1]}} {
+		if _, _, err := Transfer(f.token.ID, transfer[0], transfer[1], 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	last, err := LastEventID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := CountSenders(u[1], since, last); err != nil || n != 2 {
+		t.Errorf("want 2 senders, got %d, %v", n, err)
+	}
+	// The third user was granted tokens before the window, so is not new.
+	if n, err := CountNewRecipients(u[0], since, last); err != nil || n != 2 {
+		t.Errorf("want 2 new recipients, got %d, %v", n, err)
+	}
+	if n, err := CountGranted(u[3], f.token.ID, since.Add(-time.Hour), last); err != nil || n != 5 {
+		t.Errorf("want 5 granted in the hour, got %d, %v", n, err)
+	}
+	if n, err := CountGranted(u[3], f.token.ID, since, last); err != nil || n != 0 {
+		t.Errorf("want nothing granted since the transfers began, got %d, %v", n, err)
+	}
+	// The third user does not become new once the grant has been pruned.
+	if _, err = PruneEvents(since); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := CountNewRecipients(u[0], since, last); err != nil || n != 2 {
+		t.Errorf("want 2 new recipients after pruning, got %d, %v", n, err)
+	}
+
+	alert := &Alert{Rule: "grant_then_spend", UserID: u[0], TokenID: f.token.ID, EventID: last, Count: 10, Frozen: FrozeHolding, At: time.Now()}
+	if raised, err := RaiseAlert(alert, since); err != nil || !raised {
+		t.Fatalf("want the alert raised, got %v, %v", raised, err)
+	}
+
+	if _, err = Spend(u[0], f.token.ID, 1); err != ErrHoldingFrozen {
+		t.Error("want ErrHoldingFrozen spending a frozen holding, got", err)
+	}
+	if _, _, err = Transfer(f.token.ID, u[0], u[1], 1); err != ErrHoldingFrozen {
+		t.Error("want ErrHoldingFrozen transferring from a frozen holding, got", err)
+	}
+	// Frozen holdings can still be credited.
+	if _, _, err = Transfer(f.token.ID, u[1], u[0], 1); err != nil {
+		t.Error("want a transfer to a frozen holding allowed, got", err)
+	}
+
+	again := &Alert{Rule: "grant_then_spend", UserID: u[0], TokenID: f.token.ID, EventID: last, Count: 10, At: time.Now()}
+	if raised, err := RaiseAlert(again, since); err != nil || raised {
+		t.Errorf("want a repeat alert dropped, got %v, %v", raised, err)
+	}
+
+	found, err := FindAlert(alert.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Rule != alert.Rule || found.EventID != last || found.Count != 10 || found.Frozen != FrozeHolding {
+		t.Errorf("wrong alert %+v", found)
+	}
+	if _, err = FindAlert(NewUUID()); err != sql.ErrNoRows {
+		t.Error("want sql.ErrNoRows for a missing alert, got", err)
+	}
+
+	holding, err := UnfreezeHolding(u[0], f.token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holding.FrozenAt.Valid {
+		t.Error("want the holding unfrozen, got", holding.FrozenAt)
+	}
+	if _, err = Spend(u[0], f.token.ID, 1); err != nil {
+		t.Error("want an unfrozen holding spent, got", err)
+	}
+
+	alert = &Alert{Rule: "transfer_fan_in", UserID: u[1], TokenID: f.token.ID, EventID: last, Count: 2, Frozen: FrozeUser, At: time.Now()}
+	if _, err = RaiseAlert(alert, since); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Spend(u[1], f.token.ID, 1); err != ErrUserFrozen {
+		t.Error("want ErrUserFrozen spending as a frozen user, got", err)
+	}
+
+	user, err := UnfreezeUser(u[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FrozenAt.Valid {
+		t.Error("want the user unfrozen, got", user.FrozenAt)
+	}
+	if _, err = Spend(u[1], f.token.ID, 1); err != nil {
+		t.Error("want an unfrozen user's spend allowed, got", err)
+	}
+
+	if _, err = UnfreezeUser(NewUUID()); err != sql.ErrNoRows {
+		t.Error("want sql.ErrNoRows for a missing user, got", err)
+	}
+	if _, err = UnfreezeHolding(u[2], NewUUID()); err != sql.ErrNoRows {
+		t.Error("want sql.ErrNoRows for a missing holding, got", err)
+	}
+}
+
+func testSupply(t *testing.T) {
+	f := newFixture(t, 2)
+
+	supply, err := FindSupply(f.token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *supply != (Supply{TokenID: f.token.ID}) {
+		t.Errorf("want an empty account, got %+v", supply)
+	}
+
+	if _, err = Grant(f.token.ID, 5, f.users[0].ID, f.users[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Spend(f.users[0].ID, f.token.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Transfer(f.token.ID, f.users[0].ID, f.users[1].ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Expire(f.token.Expires); err != nil {
+		t.Fatal(err)
+	}
+
+	if supply, err = FindSupply(f.token.ID); err != nil {
+		t.Fatal(err)
+	}
+	want := Supply{TokenID: f.token.ID, Issued: 10, Spent: 2, Expired: 8}
+	if *supply != want {
+		t.Errorf("want %+v, got %+v", want, *supply)
+	}
+}
+
+func testEvents(t *testing.T) {
+	f := newFixture(t, 2)
+
+	var seen []Event
+	AddListener(func(event Event) {
+		if event.TokenID == f.token.ID {
+			seen = append(seen, event)
+		}
+	})
+
+	if _, err := Grant(f.token.ID, 3, f.users[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Transfer(f.token.ID, f.users[0].ID, f.users[1].ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Spend(f.users[0].ID, f.token.ID, 5); err != ErrInsufficientBalance {
+		t.Fatal("want ErrInsufficientBalance, got", err)
+	}
+
+	if len(seen) != 2 {
+		t.Fatalf("want 2 events for committed changes only, got %+v", seen)
+	}
+	if seen[0].Type != EventGranted || seen[0].Balance != 3 || seen[0].OrgID != f.org.ID {
+		t.Errorf("unexpected grant event %+v", seen[0])
+	}
+	if seen[1].Type != EventTransferred || seen[1].FromUserID != f.users[0].ID || seen[1].UserID != f.users[1].ID {
+		t.Errorf("unexpected transfer event %+v", seen[1])
+	}
+	if seen[1].Balance != 1 || seen[1].FromBalance != 2 {
+		t.Errorf("want both balances on the transfer event, got %+v", seen[1])
+	}
+
+	// Both events were recorded, and the transfer concerns both users.
+	if seen[0].ID == 0 || seen[1].ID <= seen[0].ID {
+		t.Fatalf("want increasing event ids, got %d then %d", seen[0].ID, seen[1].ID)
+	}
+
+	stored, err := EventsAfter(f.users[0].ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored[0] != seen[0] || stored[1] != seen[1] {
+		t.Errorf("want the published events stored, got %+v", stored)
+	}
+
+	if stored, err = EventsAfter(f.users[1].ID, seen[0].ID, 10); err != nil || len(stored) != 1 || stored[0].ID != seen[1].ID {
+		t.Errorf("want only the transfer for the recipient, got %+v, %v", stored, err)
+	}
+
+	if last, err := LastEventID(); err != nil || last < seen[1].ID {
+		t.Errorf("want the last event id to be at least %d, got %d, %v", seen[1].ID, last, err)
+	}
+
+	if _, err = PruneEvents(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FindEvent(seen[0].ID); err != sql.ErrNoRows {
+		t.Error("want pruned events gone, got", err)
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// ErrSupplyExceeded is returned when a balance change would issue more units
+// of a token than its maximum supply allows.
+var ErrSupplyExceeded = errors.New("storage: token supply exceeded")
+
+// HoldingCapError is returned when a balance change would leave users
+// holding more of a token than its per-user cap. Users is how many.
+type HoldingCapError struct {
+	Cap   int16
+	Users int
+}
+
+func (e *HoldingCapError) Error() string {
+	return fmt.Sprintf("storage: %d holdings would exceed the cap of %d", e.Users, e.Cap)
+}
+
+// The running totals of a token's units, each kept by an upsert of the same
+// shape as creditQuery.
+const (
+	supplyIssued  = "issued"
+	supplySpent   = "spent"
+	supplyExpired = "expired"
+)
+
+const supplyQuery = `INSERT INTO "token_supply" ("token_id", "%[1]s") VALUES ($1, $2)
+ON CONFLICT ("token_id") DO UPDATE SET "%[1]s" = "token_supply"."%[1]s" + excluded."%[1]s"`
+
+// Supply is the account of a token's units: Issued ever, of which Spent were
+// spent and Expired expired, leaving Outstanding in users' holdings.
+type Supply struct {
+	TokenID     string
+	Issued      int64
+	Spent       int64
+	Expired     int64
+	Outstanding int64
+}
+
+// FindSupply returns the account of a token's units. A token none of which
+// have been issued has a zero account.
+func FindSupply(tokenID string) (*Supply, error) {
+	s := Supply{TokenID: tokenID}
+
+	err := boil.GetDB().QueryRow(`SELECT "issued", "spent", "expired" FROM "token_supply" WHERE "token_id" = $1`, tokenID).Scan(
+		&s.Issued, &s.Spent, &s.Expired)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "storage: unable to find token supply")
+	}
+
+	err = boil.GetDB().QueryRow(`SELECT COALESCE(SUM("number"), 0) FROM "user_tokens" WHERE "token_id" = $1`, tokenID).Scan(&s.Outstanding)
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find token supply")
+	}
+	return &s, nil
+}
+
+// count adds number to one of a token's running totals, returning the new
+// total.
+func count(exec boil.Executor, tokenID, total string, number int) (int64, error) {
+	if _, err := exec.Exec(fmt.Sprintf(supplyQuery, total), tokenID, number); err != nil {
+		return 0, errors.Wrap(err, "storage: unable to count token supply")
+	}
+
+	var sum int64
+	err := exec.QueryRow(fmt.Sprintf(`SELECT "%s" FROM "token_supply" WHERE "token_id" = $1`, total), tokenID).Scan(&sum)
+	return sum, errors.Wrap(err, "storage: unable to count token supply")
+}
+
+// issue counts number more units of token as issued, failing with
+// ErrSupplyExceeded if that takes it past its maximum supply. The upsert
+// holds the token's row until the transaction ends, so concurrent issues are
+// checked one after the other.
+func issue(exec boil.Executor, token *models.Token, number int) error {
+	issued, err := count(exec, token.ID, supplyIssued, number)
+	if err != nil {
+		return err
+	}
+	if token.MaxSupply.Valid && issued > int64(token.MaxSupply.Int) {
+		return ErrSupplyExceeded
+	}
+	return nil
+}
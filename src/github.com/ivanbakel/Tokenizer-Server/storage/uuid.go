@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// NewUUID returns a random (version 4) UUID in its canonical string form.
+func NewUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+var hooksOnce sync.Once
+
+// registerHooks makes the models fill in their own primary keys, so that
+// inserts do not depend on a database-side uuid_generate_v4() default.
+func registerHooks() {
+	hooksOnce.Do(func() {
+		for _, point := range []boil.HookPoint{boil.BeforeInsertHook, boil.BeforeUpsertHook} {
+			models.AddOrganisationHook(point, func(_ boil.Executor, o *models.Organisation) error {
+				if o.ID == "" {
+					o.ID = NewUUID()
+				}
+				return nil
+			})
+			models.AddUserHook(point, func(_ boil.Executor, o *models.User) error {
+				if o.ID == "" {
+					o.ID = NewUUID()
+				}
+				return nil
+			})
+			models.AddTokenHook(point, func(_ boil.Executor, o *models.Token) error {
+				if o.ID == "" {
+					o.ID = NewUUID()
+				}
+				return nil
+			})
+		}
+	})
+}
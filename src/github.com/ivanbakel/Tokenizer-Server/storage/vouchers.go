@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+	"gopkg.in/nullbio/null.v6"
+)
+
+// Errors SettleVoucher returns for vouchers that were issued but cannot be
+// settled.
+var (
+	ErrVoucherSettled = errors.New("storage: voucher already settled")
+	ErrVoucherExpired = errors.New("storage: voucher redeemed after it expired")
+)
+
+// VoucherKey is one of the Ed25519 keys an organisation's vouchers are
+// signed with. PrivateKey is only loaded for the key currently signing.
+type VoucherKey struct {
+	ID         string
+	OrgID      string
+	PublicKey  ed25519.PublicKey
+	PrivateKey ed25519.PrivateKey
+	CreatedAt  time.Time
+	// RetiredAt is when the key stopped signing, if it has.
+	RetiredAt null.Time
+}
+
+// CreateVoucherKey stores a new signing key for an organisation, filling in
+// its ID and creation time, and retires the one it replaces.
+func CreateVoucherKey(key *VoucherKey) error {
+	key.ID = NewUUID()
+	key.CreatedAt = time.Now().UTC()
+
+	return Transaction(func(tx boil.Transactor) error {
+		_, err := tx.Exec(`UPDATE "voucher_keys" SET "retired_at" = $1 WHERE "org_id" = $2 AND "retired_at" IS NULL`, key.CreatedAt, key.OrgID)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to retire voucher key")
+		}
+
+		_, err = tx.Exec(`INSERT INTO "voucher_keys" ("id", "org_id", "public_key", "seed", "created_at") VALUES ($1, $2, $3, $4, $5)`,
+			key.ID, key.OrgID, hex.EncodeToString(key.PublicKey), hex.EncodeToString(key.PrivateKey.Seed()), key.CreatedAt)
+		return errors.Wrap(err, "storage: unable to create voucher key")
+	})
+}
+
+// ActiveVoucherKey loads the key an organisation currently signs vouchers
+// with, private half included. It returns sql.ErrNoRows if there is none.
+func ActiveVoucherKey(orgID string) (*VoucherKey, error) {
+	var key VoucherKey
+	var public, seed string
+
+	err := boil.GetDB().QueryRow(`SELECT "id", "org_id", "public_key", "seed", "created_at" FROM "voucher_keys"
+WHERE "org_id" = $1 AND "retired_at" IS NULL`, orgID).Scan(&key.ID, &key.OrgID, &public, &seed, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find voucher key")
+	}
+
+	if key.PublicKey, err = hex.DecodeString(public); err != nil {
+		return nil, errors.Wrap(err, "storage: unable to read voucher key")
+	}
+	raw, err := hex.DecodeString(seed)
+	if err != nil || len(raw) != ed25519.SeedSize {
+		return nil, errors.New("storage: unable to read voucher key")
+	}
+	key.PrivateKey = ed25519.NewKeyFromSeed(raw)
+	return &key, nil
+}
+
+// VoucherKeys returns the public halves of an organisation's keys that are
+// signing or were retired after the given time, oldest first.
+func VoucherKeys(orgID string, retiredAfter time.Time) ([]VoucherKey, error) {
+	rows, err := boil.GetDB().Query(`SELECT "id", "org_id", "public_key", "created_at", "retired_at" FROM "voucher_keys"
+WHERE "org_id" = $1 AND ("retired_at" IS NULL OR "retired_at" > $2) ORDER BY "created_at", "id"`, orgID, retiredAfter.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to list voucher keys")
+	}
+	defer rows.Close()
+
+	var keys []VoucherKey
+	for rows.Next() {
+		var key VoucherKey
+		var public string
+		if err = rows.Scan(&key.ID, &key.OrgID, &public, &key.CreatedAt, &key.RetiredAt); err != nil {
+			return nil, errors.Wrap(err, "storage: unable to list voucher keys")
+		}
+		if key.PublicKey, err = hex.DecodeString(public); err != nil {
+			return nil, errors.Wrap(err, "storage: unable to read voucher key")
+		}
+		keys = append(keys, key)
+	}
+	return keys, errors.Wrap(rows.Err(), "storage: unable to list voucher keys")
+}
+
+// Voucher is the server's record of a voucher it issued. RedeemedAt, when
+// the terminal says it accepted the voucher, and SettledAt and Terminal are
+// set once it has been settled.
+type Voucher struct {
+	ID         string
+	OrgID      string
+	KeyID      string
+	UserID     string
+	TokenID    string
+	Number     int16
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+	RedeemedAt null.Time
+	SettledAt  null.Time
+	Terminal   null.String
+}
+
+// IssueVoucher records a voucher about to be handed out. It fails with
+// ErrInsufficientBalance if the user does not hold enough of the token now;
+// the units are not set aside, so settlement may still find them gone.
+func IssueVoucher(v *Voucher) error {
+	v.IssuedAt = v.IssuedAt.UTC()
+	v.ExpiresAt = v.ExpiresAt.UTC()
+
+	return Transaction(func(tx boil.Transactor) error {
+		if _, err := models.FindToken(tx, v.TokenID); err != nil {
+			return err
+		}
+
+		holding, err := models.FindUserToken(tx, v.UserID, v.TokenID)
+		if err == sql.ErrNoRows || (err == nil && holding.Number.Int16 < v.Number) {
+			return ErrInsufficientBalance
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO "vouchers" ("id", "org_id", "key_id", "user_id", "token_id", "number", "issued_at", "expires_at")
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, v.ID, v.OrgID, v.KeyID, v.UserID, v.TokenID, v.Number, v.IssuedAt, v.ExpiresAt)
+		return errors.Wrap(err, "storage: unable to issue voucher")
+	})
+}
+
+// FindVoucher loads one of an organisation's vouchers. It returns
+// sql.ErrNoRows if there is none.
+func FindVoucher(orgID, id string) (*Voucher, error) {
+	return findVoucher(boil.GetDB(), orgID, id)
+}
+
+func findVoucher(exec boil.Executor, orgID, id string) (*Voucher, error) {
+	var v Voucher
+
+	err := exec.QueryRow(`SELECT "id", "org_id", "key_id", "user_id", "token_id", "number", "issued_at", "expires_at", "redeemed_at", "settled_at", "terminal"
+FROM "vouchers" WHERE "id" = $1 AND "org_id" = $2`, id, orgID).Scan(
+		&v.ID, &v.OrgID, &v.KeyID, &v.UserID, &v.TokenID, &v.Number, &v.IssuedAt, &v.ExpiresAt, &v.RedeemedAt, &v.SettledAt, &v.Terminal)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find voucher")
+	}
+	return &v, nil
+}
+
+// SettleVoucher marks a voucher settled, as accepted by terminal at
+// redeemedAt, and spends what it was issued for, in one transaction: the
+// voucher is settled if and only if the spend happens. A voucher already
+// settled fails with ErrVoucherSettled, however many terminals sync it at
+// once, and one accepted after its expiry with ErrVoucherExpired.
+func SettleVoucher(orgID, id, terminal string, redeemedAt time.Time) (*models.UserToken, error) {
+	var holding *models.UserToken
+	var events []Event
+
+	err := Transaction(func(tx boil.Transactor) error {
+		result, err := tx.Exec(`UPDATE "vouchers" SET "settled_at" = $1, "terminal" = $2, "redeemed_at" = $3
+WHERE "id" = $4 AND "org_id" = $5 AND "settled_at" IS NULL AND "expires_at" > $3`, time.Now().UTC(), terminal, redeemedAt.UTC(), id, orgID)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to settle voucher")
+		}
+
+		claimed, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to settle voucher")
+		}
+
+		v, err := findVoucher(tx, orgID, id)
+		if err != nil {
+			return err
+		}
+
+		if claimed == 0 {
+			if v.SettledAt.Valid {
+				return ErrVoucherSettled
+			}
+			return ErrVoucherExpired
+		}
+
+		holding, events, err = spend(tx, v.UserID, v.TokenID, v.Number)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	publish(events)
+	return holding, nil
+}
+
+// VoucherConflict is a voucher a terminal synced that could not be
+// settled. Reason says why; for a double spend, SettledBy is the terminal
+// that settled the voucher first.
+type VoucherConflict struct {
+	ID         string      `boil:"id"`
+	OrgID      string      `boil:"org_id"`
+	VoucherID  string      `boil:"voucher_id"`
+	Reason     string      `boil:"reason"`
+	Terminal   string      `boil:"terminal"`
+	RedeemedAt time.Time   `boil:"redeemed_at"`
+	SettledBy  null.String `boil:"settled_by"`
+	DetectedAt time.Time   `boil:"detected_at"`
+}
+
+// VoucherConflictColumns lists the columns a VoucherConflict is read from,
+// for queries built elsewhere.
+var VoucherConflictColumns = []string{"id", "org_id", "voucher_id", "reason", "terminal", "redeemed_at", "settled_by", "detected_at"}
+
+// RecordVoucherConflict stores a conflict, filling in its ID and when it
+// was detected.
+func RecordVoucherConflict(c *VoucherConflict) error {
+	c.ID = NewUUID()
+	c.DetectedAt = time.Now().UTC()
+	c.RedeemedAt = c.RedeemedAt.UTC()
+
+	_, err := boil.GetDB().Exec(`INSERT INTO "voucher_conflicts" ("id", "org_id", "voucher_id", "reason", "terminal", "redeemed_at", "settled_by", "detected_at")
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, c.ID, c.OrgID, c.VoucherID, c.Reason, c.Terminal, c.RedeemedAt, c.SettledBy, c.DetectedAt)
+	return errors.Wrap(err, "storage: unable to record voucher conflict")
+}
@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vattle/sqlboiler/boil"
+	"gopkg.in/nullbio/null.v6"
+)
+
+// Webhook is an organisation's subscription to the events of its tokens.
+type Webhook struct {
+	ID    string `boil:"id"`
+	OrgID string `boil:"org_id"`
+	URL   string `boil:"url"`
+	// Events is a comma-separated list of the event types wanted, or "" for
+	// every type.
+	Events string `boil:"events"`
+	// Secret keys the signature on each delivery.
+	Secret    string    `boil:"secret"`
+	CreatedAt time.Time `boil:"created_at"`
+}
+
+// Wants reports whether the webhook subscribes to events of type t.
+func (w *Webhook) Wants(t EventType) bool {
+	if w.Events == "" {
+		return true
+	}
+	for _, name := range strings.Split(w.Events, ",") {
+		if EventType(name) == t {
+			return true
+		}
+	}
+	return false
+}
+
+// The states of a webhook delivery. A pending delivery is sent once its
+// NextAttemptAt has passed; the others are final until redelivered.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is the sending of one event to one webhook. Its payload is
+// fixed when the event is recorded, so every attempt sends the same body.
+type WebhookDelivery struct {
+	ID            string    `boil:"id"`
+	WebhookID     string    `boil:"webhook_id"`
+	EventID       int64     `boil:"event_id"`
+	EventType     EventType `boil:"event_type"`
+	Payload       string    `boil:"payload"`
+	State         string    `boil:"state"`
+	Attempts      int       `boil:"attempts"`
+	NextAttemptAt null.Time `boil:"next_attempt_at"`
+	CreatedAt     time.Time `boil:"created_at"`
+	DeliveredAt   null.Time `boil:"delivered_at"`
+}
+
+// WebhookAttempt is the outcome of one try at sending a delivery: the status
+// the receiver answered with, or why there was no answer.
+type WebhookAttempt struct {
+	ID         int64       `boil:"id"`
+	DeliveryID string      `boil:"delivery_id"`
+	At         time.Time   `boil:"at"`
+	StatusCode null.Int16  `boil:"status_code"`
+	Error      null.String `boil:"error"`
+	DurationMS int         `boil:"duration_ms"`
+}
+
+// OutboundDelivery is a due delivery with what is needed to send it.
+type OutboundDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// WebhookColumns and WebhookDeliveryColumns list the columns the structs
+// are read from, for queries built elsewhere.
+var (
+	WebhookColumns         = []string{"id", "org_id", "url", "events", "secret", "created_at"}
+	WebhookDeliveryColumns = []string{"id", "webhook_id", "event_id", "event_type", "payload", "state", "attempts", "next_attempt_at", "created_at", "delivered_at"}
+)
+
+const (
+	insertWebhookQuery = `INSERT INTO "webhooks" ("id", "org_id", "url", "events", "secret", "created_at")
+VALUES ($1, $2, $3, $4, $5, $6)`
+
+	insertDeliveryQuery = `INSERT INTO "webhook_deliveries"
+("id", "webhook_id", "event_id", "event_type", "payload", "state", "attempts", "next_attempt_at", "created_at")
+VALUES ($1, $2, $3, $4, $5, $6, 0, $7, $7)`
+
+	dueDeliveriesQuery = `SELECT "d"."id", "d"."webhook_id", "d"."event_id", "d"."event_type", "d"."payload", "d"."attempts", "w"."url", "w"."secret"
+FROM "webhook_deliveries" AS "d" INNER JOIN "webhooks" AS "w" ON "w"."id" = "d"."webhook_id"
+WHERE "d"."state" = $1 AND "d"."next_attempt_at" <= $2 ORDER BY "d"."next_attempt_at" LIMIT $3`
+
+	claimDeliveryQuery = `UPDATE "webhook_deliveries" SET "next_attempt_at" = $1
+WHERE "id" = $2 AND "state" = $3 AND "next_attempt_at" <= $4`
+
+	insertAttemptQuery = `INSERT INTO "webhook_attempts" ("delivery_id", "at", "status_code", "error", "duration_ms")
+VALUES ($1, $2, $3, $4, $5)`
+
+	finishDeliveryQuery = `UPDATE "webhook_deliveries"
+SET "attempts" = "attempts" + 1, "state" = $1, "next_attempt_at" = $2, "delivered_at" = $3 WHERE "id" = $4`
+
+	redeliverQuery = `UPDATE "webhook_deliveries"
+SET "state" = $1, "attempts" = 0, "next_attempt_at" = $2, "delivered_at" = NULL WHERE "id" = $3 AND "webhook_id" = $4`
+)
+
+// CreateWebhook stores a new webhook, filling in its ID and creation time.
+func CreateWebhook(w *Webhook) error {
+	w.ID = NewUUID()
+	w.CreatedAt = time.Now().UTC()
+
+	_, err := boil.GetDB().Exec(insertWebhookQuery, w.ID, w.OrgID, w.URL, w.Events, w.Secret, w.CreatedAt)
+	return errors.Wrap(err, "storage: unable to create webhook")
+}
+
+// FindWebhook loads one of an organisation's webhooks. It returns
+// sql.ErrNoRows if the organisation has no such webhook.
+func FindWebhook(orgID, id string) (*Webhook, error) {
+	var w Webhook
+
+	err := boil.GetDB().QueryRow(`SELECT "id", "org_id", "url", "events", "secret", "created_at" FROM "webhooks"
+WHERE "id" = $1 AND "org_id" = $2`, id, orgID).Scan(&w.ID, &w.OrgID, &w.URL, &w.Events, &w.Secret, &w.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find webhook")
+	}
+	return &w, nil
+}
+
+// DeleteWebhook removes one of an organisation's webhooks, along with its
+// deliveries. It returns sql.ErrNoRows if the organisation has no such
+// webhook.
+func DeleteWebhook(orgID, id string) error {
+	result, err := boil.GetDB().Exec(`DELETE FROM "webhooks" WHERE "id" = $1 AND "org_id" = $2`, id, orgID)
+	if err != nil {
+		return errors.Wrap(err, "storage: unable to delete webhook")
+	}
+	return expectRow(result, "storage: unable to delete webhook")
+}
+
+// enqueueDeliveries adds a delivery to the outbox for every webhook that
+// wants one of events, as part of the transaction recording them.
+func enqueueDeliveries(tx boil.Executor, events []Event) error {
+	webhooks := make(map[string][]*Webhook)
+
+	for _, event := range events {
+		subscribed, ok := webhooks[event.OrgID]
+		if !ok {
+			var err error
+			if subscribed, err = orgWebhooks(tx, event.OrgID); err != nil {
+				return err
+			}
+			webhooks[event.OrgID] = subscribed
+		}
+
+		var payload []byte
+		for _, w := range subscribed {
+			if !w.Wants(event.Type) {
+				continue
+			}
+
+			if payload == nil {
+				var err error
+				if payload, err = json.Marshal(event); err != nil {
+					return errors.Wrap(err, "storage: unable to encode webhook payload")
+				}
+			}
+
+			_, err := tx.Exec(insertDeliveryQuery, NewUUID(), w.ID, event.ID, event.Type, string(payload), DeliveryPending, event.At)
+			if err != nil {
+				return errors.Wrap(err, "storage: unable to enqueue webhook delivery")
+			}
+		}
+	}
+	return nil
+}
+
+func orgWebhooks(exec boil.Executor, orgID string) ([]*Webhook, error) {
+	rows, err := exec.Query(`SELECT "id", "events" FROM "webhooks" WHERE "org_id" = $1`, orgID)
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find webhooks")
+	}
+	defer rows.Close()
+
+	var webhooks []*Webhook
+	for rows.Next() {
+		w := &Webhook{OrgID: orgID}
+		if err = rows.Scan(&w.ID, &w.Events); err != nil {
+			return nil, errors.Wrap(err, "storage: unable to find webhooks")
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, errors.Wrap(rows.Err(), "storage: unable to find webhooks")
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries whose next
+// attempt is due at now, the longest overdue first.
+func DueWebhookDeliveries(now time.Time, limit int) ([]OutboundDelivery, error) {
+	rows, err := boil.GetDB().Query(dueDeliveriesQuery, DeliveryPending, now.UTC(), limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find due webhook deliveries")
+	}
+	defer rows.Close()
+
+	var due []OutboundDelivery
+	for rows.Next() {
+		var d OutboundDelivery
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Attempts, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, errors.Wrap(err, "storage: unable to find due webhook deliveries")
+		}
+		d.State = DeliveryPending
+		due = append(due, d)
+	}
+	return due, errors.Wrap(rows.Err(), "storage: unable to find due webhook deliveries")
+}
+
+// ClaimWebhookDelivery takes a due delivery for sending, by putting its next
+// attempt off until until. It reports false if the delivery is no longer
+// due, e.g. because another instance claimed it first. A claim that is never
+// finished lapses at until, and the delivery is tried again.
+func ClaimWebhookDelivery(id string, now, until time.Time) (bool, error) {
+	result, err := boil.GetDB().Exec(claimDeliveryQuery, until.UTC(), id, DeliveryPending, now.UTC())
+	if err != nil {
+		return false, errors.Wrap(err, "storage: unable to claim webhook delivery")
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "storage: unable to claim webhook delivery")
+	}
+	return claimed == 1, nil
+}
+
+// FinishWebhookAttempt logs an attempt at a claimed delivery and moves the
+// delivery to state: delivered, failed, or pending until next.
+func FinishWebhookAttempt(attempt WebhookAttempt, state string, next null.Time) error {
+	var deliveredAt null.Time
+	if state == DeliveryDelivered {
+		deliveredAt = null.TimeFrom(attempt.At.UTC())
+	}
+	if next.Valid {
+		next.Time = next.Time.UTC()
+	}
+
+	return Transaction(func(tx boil.Transactor) error {
+		_, err := tx.Exec(insertAttemptQuery, attempt.DeliveryID, attempt.At.UTC(), attempt.StatusCode, attempt.Error, attempt.DurationMS)
+		if err != nil {
+			return errors.Wrap(err, "storage: unable to log webhook attempt")
+		}
+
+		_, err = tx.Exec(finishDeliveryQuery, state, next, deliveredAt, attempt.DeliveryID)
+		return errors.Wrap(err, "storage: unable to update webhook delivery")
+	})
+}
+
+// RedeliverWebhookDelivery makes a delivery due again at now, with its full
+// number of attempts. It returns sql.ErrNoRows if the webhook has no such
+// delivery.
+func RedeliverWebhookDelivery(webhookID, id string, now time.Time) error {
+	result, err := boil.GetDB().Exec(redeliverQuery, DeliveryPending, now.UTC(), id, webhookID)
+	if err != nil {
+		return errors.Wrap(err, "storage: unable to redeliver webhook delivery")
+	}
+	return expectRow(result, "storage: unable to redeliver webhook delivery")
+}
+
+// FindWebhookDelivery loads one of a webhook's deliveries. It returns
+// sql.ErrNoRows if the webhook has no such delivery.
+func FindWebhookDelivery(webhookID, id string) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+
+	err := boil.GetDB().QueryRow(`SELECT "id", "webhook_id", "event_id", "event_type", "payload", "state", "attempts",
+"next_attempt_at", "created_at", "delivered_at" FROM "webhook_deliveries" WHERE "id" = $1 AND "webhook_id" = $2`, id, webhookID).Scan(
+		&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.State, &d.Attempts, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to find webhook delivery")
+	}
+	return &d, nil
+}
+
+// WebhookAttempts returns the log of attempts at a delivery, oldest first.
+func WebhookAttempts(deliveryID string) ([]WebhookAttempt, error) {
+	rows, err := boil.GetDB().Query(`SELECT "id", "delivery_id", "at", "status_code", "error", "duration_ms"
+FROM "webhook_attempts" WHERE "delivery_id" = $1 ORDER BY "id"`, deliveryID)
+	if err != nil {
+		return nil, errors.Wrap(err, "storage: unable to list webhook attempts")
+	}
+	defer rows.Close()
+
+	var attempts []WebhookAttempt
+	for rows.Next() {
+		var a WebhookAttempt
+		if err = rows.Scan(&a.ID, &a.DeliveryID, &a.At, &a.StatusCode, &a.Error, &a.DurationMS); err != nil {
+			return nil, errors.Wrap(err, "storage: unable to list webhook attempts")
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, errors.Wrap(rows.Err(), "storage: unable to list webhook attempts")
+}
+
+// PruneWebhookDeliveries forgets every finished delivery created before the
+// given time, along with its attempts, returning how many there were.
+func PruneWebhookDeliveries(before time.Time) (int64, error) {
+	result, err := boil.GetDB().Exec(`DELETE FROM "webhook_deliveries" WHERE "state" <> $1 AND "created_at" < $2`, DeliveryPending, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "storage: unable to prune webhook deliveries")
+	}
+
+	return result.RowsAffected()
+}
+
+// expectRow turns a statement that affected no rows into sql.ErrNoRows.
+func expectRow(result sql.Result, msg string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, msg)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
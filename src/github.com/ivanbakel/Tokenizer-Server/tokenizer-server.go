@@ -1,45 +1,166 @@
 package main
 
 import (
+	"database/sql"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
-	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
-	"database/sql"
+	"os"
+	"time"
 	"log"
 	"github.com/vattle/sqlboiler/boil"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/metrics"
+	"github.com/ivanbakel/Tokenizer-Server/migrations"
+	"github.com/ivanbakel/Tokenizer-Server/relay"
+	"github.com/ivanbakel/Tokenizer-Server/rpc"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/ivanbakel/Tokenizer-Server/webhook"
 )
 
-var dataBase *sql.DB
-
-const databaseUsername = "tokenizerDB"
-const databaseName = "tokenizer"
+var configFlags = config.RegisterFlags(flag.CommandLine)
+var printConfig = flag.Bool("print-config", false, "print the configuration with secrets redacted, then exit")
 
 func handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Hi there, I love %s!", r.URL.Path[1:])
 }
 
+// configurePool applies cfg's pool settings to db. SQLite keeps its single
+// connection for good: closing it would lose a ":memory:" database, so the
+// idle and lifetime settings are left alone there.
+func configurePool(db *sql.DB, backend *storage.Backend, cfg config.Database) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+
+	if backend == storage.SQLite {
+		return
+	}
+
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime.Duration)
+}
+
 func main() {
-	db, err := sql.Open("postgres", fmt.Sprintf("user=%s dbname=%s sslmode=verify-full", databaseUsername, databaseName))
+	flag.Parse()
+
+	cfg, err := configFlags.Load()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *printConfig {
+		fmt.Print(cfg.Redacted())
+		return
+	}
+
+	backend, err := storage.Lookup(cfg.Database.Backend)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := storage.Open(backend.Name, cfg.Database.DSN)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	configurePool(db, backend, cfg.Database)
+
+	if flag.Arg(0) == "migrate" {
+		if err = runMigrate(db, backend.Migrations, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Single-node SQLite deployments have nobody to run the migrations by hand.
+	if cfg.Database.MigrateOnStart || backend == storage.SQLite {
+		if _, err = migrations.Up(db, backend.Migrations); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	boil.SetDB(db)
 
-	r := mux.NewRouter()
-	r.HandleFunc("/users", getUsers)
-	r.HandleFunc("/users/{uid}", getUser)
-	r.HandleFunc("/users/{uid}/tokens", getUserTokens)
-	r.HandleFunc("/tokens", getTokens)
-	r.HandleFunc("/tokens/{tid}", getToken)
-	r.HandleFunc("/tokens/{tid}/grant-group", giveGroupTokens)
-	r.HandleFunc("/tokens/{tid}/grant-user", giveUserTokens)
-	r.HandleFunc("/tokens/create", createToken)
-	r.HandleFunc("/users/{uid}/tokens/{tid}/receive", receiveTokens)
-	r.HandleFunc("/users/{uid}/tokens/{tid}/spend", spendTokens)
-	r.HandleFunc("/orgs", getOrgs)
-	r.HandleFunc("/orgs/{oid}", getOrg)
-	http.ListenAndServe(":8080", r)
+	var background = newWorkers()
+
+	background.Go(expireTokens(cfg.Tokens.ExpiryInterval.Duration))
+	background.Go(expireIdempotencyKeys(time.Hour))
+
+	var registry = metrics.New(db)
+
+	storage.AddListener(registry.Observe)
+	storage.AddListener(balanceEvents.Publish)
+
+	if backend.Listen != nil {
+		background.Go(relayEvents(backend, cfg.Database.DSN))
+	}
+
+	background.Go(pruneEvents(time.Hour, cfg.Relay.CursorExpiry.Duration))
+
+	var dispatcher = webhook.NewDispatcher(cfg.Webhooks.Timeout.Duration, cfg.Webhooks.MaxAttempts, cfg.Webhooks.PollInterval.Duration)
+
+	dispatcher.AllowPrivate = cfg.Webhooks.AllowPrivate
+
+	service.AllowPrivateWebhooks(cfg.Webhooks.AllowPrivate)
+
+	storage.AddListener(dispatcher.Wake)
+	background.Go(dispatcher.Run)
+	background.Go(pruneWebhookDeliveries(time.Hour))
+	background.Go(pruneRedemptionCodes(time.Hour))
+
+	var limitStore = newLimitStore(cfg.Limits)
+
+	var limiters = newLimiters(cfg.Limits, limitStore)
+
+	limiters.Refused = registry.RateLimited
+
+	service.SetLimiters(limiters)
+	background.Go(pruneRateLimits(limitStore, cfg.Limits, time.Hour))
+
+	if cfg.Relay.Sink == "file" {
+		var sink,err = relay.NewFileSink(cfg.Relay.Path)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer sink.Close()
+
+		var r = relay.New("file:" + cfg.Relay.Path, sink, cfg.Relay.PollInterval.Duration)
+
+		storage.AddListener(r.Wake)
+		background.Go(r.Run)
+	}
+
+	if cfg.Fraud.Enabled {
+		var r = relay.New("fraud", newFraudDetector(cfg.Fraud), cfg.Fraud.PollInterval.Duration)
+
+		storage.AddListener(r.Wake)
+		background.Go(r.Run)
+	}
+
+	var logger = logging.New(os.Stdout)
+
+	if cfg.GRPC.ListenAddress != "" {
+		var listener,err = net.Listen("tcp", cfg.GRPC.ListenAddress)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		background.Go(serveGRPC(rpc.NewServer(cfg.Auth.ServiceToken, logger), listener))
+	}
+
+	var r = newRouter(cfg, db, backend, registry, logger)
+
+	if err = serve(cfg, r, db, background); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/config"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+func TestConfigurePoolKeepsSQLiteMemory(t *testing.T) {
+	var db,err = storage.Open(storage.SQLite.Name, ":memory:")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	var cfg = config.Default().Database
+
+	cfg.MaxIdleConns = 0
+	cfg.ConnMaxLifetime = config.Duration{Duration: time.Millisecond}
+
+	configurePool(db, storage.SQLite, cfg)
+
+	if _,err = db.Exec(`CREATE TABLE "kept" ("id" INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// A recycled connection would be a fresh, empty database.
+	if _,err = db.Exec(`INSERT INTO "kept" ("id") VALUES (1)`); err != nil {
+		t.Errorf("want the in-memory database kept, got %v", err)
+	}
+}
@@ -0,0 +1,5 @@
+// Package tokenizerpb holds the code generated from tokenizer.proto, the
+// gRPC interface to the tokenizer. Edit the .proto file, not the Go code.
+package tokenizerpb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative tokenizerpb/tokenizer.proto
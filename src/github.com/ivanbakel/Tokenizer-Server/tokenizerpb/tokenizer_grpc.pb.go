@@ -0,0 +1,569 @@
+// The gRPC interface to the tokenizer. It offers the same operations as the
+// v1 REST API, with the same validation and errors; see openapi/openapi.json
+// for the REST form of each.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v4.25.3
+// source: tokenizerpb/tokenizer.proto
+
+package tokenizerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TokenizerService_GetUser_FullMethodName           = "/tokenizer.v1.TokenizerService/GetUser"
+	TokenizerService_ListUsers_FullMethodName         = "/tokenizer.v1.TokenizerService/ListUsers"
+	TokenizerService_ListUserTokens_FullMethodName    = "/tokenizer.v1.TokenizerService/ListUserTokens"
+	TokenizerService_GetOrganisation_FullMethodName   = "/tokenizer.v1.TokenizerService/GetOrganisation"
+	TokenizerService_ListOrganisations_FullMethodName = "/tokenizer.v1.TokenizerService/ListOrganisations"
+	TokenizerService_GetToken_FullMethodName          = "/tokenizer.v1.TokenizerService/GetToken"
+	TokenizerService_ListTokens_FullMethodName        = "/tokenizer.v1.TokenizerService/ListTokens"
+	TokenizerService_CreateToken_FullMethodName       = "/tokenizer.v1.TokenizerService/CreateToken"
+	TokenizerService_GrantUser_FullMethodName         = "/tokenizer.v1.TokenizerService/GrantUser"
+	TokenizerService_GrantGroup_FullMethodName        = "/tokenizer.v1.TokenizerService/GrantGroup"
+	TokenizerService_Spend_FullMethodName             = "/tokenizer.v1.TokenizerService/Spend"
+	TokenizerService_Transfer_FullMethodName          = "/tokenizer.v1.TokenizerService/Transfer"
+)
+
+// TokenizerServiceClient is the client API for TokenizerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TokenizerService reads users, organisations and tokens, and changes users'
+// holdings of tokens. Every call must carry the service token as
+// "authorization: Bearer <token>" metadata.
+//
+// Errors use the standard status codes, with a google.rpc.ErrorInfo detail
+// whose reason is the REST API's problem code, e.g. "insufficient_balance".
+type TokenizerServiceClient interface {
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
+	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	ListUserTokens(ctx context.Context, in *ListUserTokensRequest, opts ...grpc.CallOption) (*ListUserTokensResponse, error)
+	GetOrganisation(ctx context.Context, in *GetOrganisationRequest, opts ...grpc.CallOption) (*Organisation, error)
+	ListOrganisations(ctx context.Context, in *ListOrganisationsRequest, opts ...grpc.CallOption) (*ListOrganisationsResponse, error)
+	GetToken(ctx context.Context, in *GetTokenRequest, opts ...grpc.CallOption) (*Token, error)
+	ListTokens(ctx context.Context, in *ListTokensRequest, opts ...grpc.CallOption) (*ListTokensResponse, error)
+	CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*Token, error)
+	// GrantUser credits a number of units of a token to one user.
+	GrantUser(ctx context.Context, in *GrantUserRequest, opts ...grpc.CallOption) (*Holding, error)
+	// GrantGroup credits a number of units of a token to each of several
+	// users, or to none of them if any cannot be credited.
+	GrantGroup(ctx context.Context, in *GrantGroupRequest, opts ...grpc.CallOption) (*GrantGroupResponse, error)
+	// Spend removes a number of units from a user's holding.
+	Spend(ctx context.Context, in *SpendRequest, opts ...grpc.CallOption) (*Holding, error)
+	// Transfer moves a number of units from one user to another, and returns
+	// the recipient's holding.
+	Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*Holding, error)
+}
+
+type tokenizerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTokenizerServiceClient(cc grpc.ClientConnInterface) TokenizerServiceClient {
+	return &tokenizerServiceClient{cc}
+}
+
+func (c *tokenizerServiceClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, TokenizerService_GetUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUsersResponse)
+	err := c.cc.Invoke(ctx, TokenizerService_ListUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) ListUserTokens(ctx context.Context, in *ListUserTokensRequest, opts ...grpc.CallOption) (*ListUserTokensResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUserTokensResponse)
+	err := c.cc.Invoke(ctx, TokenizerService_ListUserTokens_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) GetOrganisation(ctx context.Context, in *GetOrganisationRequest, opts ...grpc.CallOption) (*Organisation, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Organisation)
+	err := c.cc.Invoke(ctx, TokenizerService_GetOrganisation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) ListOrganisations(ctx context.Context, in *ListOrganisationsRequest, opts ...grpc.CallOption) (*ListOrganisationsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListOrganisationsResponse)
+	err := c.cc.Invoke(ctx, TokenizerService_ListOrganisations_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) GetToken(ctx context.Context, in *GetTokenRequest, opts ...grpc.CallOption) (*Token, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Token)
+	err := c.cc.Invoke(ctx, TokenizerService_GetToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) ListTokens(ctx context.Context, in *ListTokensRequest, opts ...grpc.CallOption) (*ListTokensResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTokensResponse)
+	err := c.cc.Invoke(ctx, TokenizerService_ListTokens_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*Token, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Token)
+	err := c.cc.Invoke(ctx, TokenizerService_CreateToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) GrantUser(ctx context.Context, in *GrantUserRequest, opts ...grpc.CallOption) (*Holding, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Holding)
+	err := c.cc.Invoke(ctx, TokenizerService_GrantUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) GrantGroup(ctx context.Context, in *GrantGroupRequest, opts ...grpc.CallOption) (*GrantGroupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GrantGroupResponse)
+	err := c.cc.Invoke(ctx, TokenizerService_GrantGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) Spend(ctx context.Context, in *SpendRequest, opts ...grpc.CallOption) (*Holding, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Holding)
+	err := c.cc.Invoke(ctx, TokenizerService_Spend_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenizerServiceClient) Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*Holding, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Holding)
+	err := c.cc.Invoke(ctx, TokenizerService_Transfer_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TokenizerServiceServer is the server API for TokenizerService service.
+// All implementations must embed UnimplementedTokenizerServiceServer
+// for forward compatibility.
+//
+// TokenizerService reads users, organisations and tokens, and changes users'
+// holdings of tokens. Every call must carry the service token as
+// "authorization: Bearer <token>" metadata.
+//
+// Errors use the standard status codes, with a google.rpc.ErrorInfo detail
+// whose reason is the REST API's problem code, e.g. "insufficient_balance".
+type TokenizerServiceServer interface {
+	GetUser(context.Context, *GetUserRequest) (*User, error)
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	ListUserTokens(context.Context, *ListUserTokensRequest) (*ListUserTokensResponse, error)
+	GetOrganisation(context.Context, *GetOrganisationRequest) (*Organisation, error)
+	ListOrganisations(context.Context, *ListOrganisationsRequest) (*ListOrganisationsResponse, error)
+	GetToken(context.Context, *GetTokenRequest) (*Token, error)
+	ListTokens(context.Context, *ListTokensRequest) (*ListTokensResponse, error)
+	CreateToken(context.Context, *CreateTokenRequest) (*Token, error)
+	// GrantUser credits a number of units of a token to one user.
+	GrantUser(context.Context, *GrantUserRequest) (*Holding, error)
+	// GrantGroup credits a number of units of a token to each of several
+	// users, or to none of them if any cannot be credited.
+	GrantGroup(context.Context, *GrantGroupRequest) (*GrantGroupResponse, error)
+	// Spend removes a number of units from a user's holding.
+	Spend(context.Context, *SpendRequest) (*Holding, error)
+	// Transfer moves a number of units from one user to another, and returns
+	// the recipient's holding.
+	Transfer(context.Context, *TransferRequest) (*Holding, error)
+	mustEmbedUnimplementedTokenizerServiceServer()
+}
+
+// UnimplementedTokenizerServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTokenizerServiceServer struct{}
+
+func (UnimplementedTokenizerServiceServer) GetUser(context.Context, *GetUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedTokenizerServiceServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (UnimplementedTokenizerServiceServer) ListUserTokens(context.Context, *ListUserTokensRequest) (*ListUserTokensResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUserTokens not implemented")
+}
+func (UnimplementedTokenizerServiceServer) GetOrganisation(context.Context, *GetOrganisationRequest) (*Organisation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOrganisation not implemented")
+}
+func (UnimplementedTokenizerServiceServer) ListOrganisations(context.Context, *ListOrganisationsRequest) (*ListOrganisationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOrganisations not implemented")
+}
+func (UnimplementedTokenizerServiceServer) GetToken(context.Context, *GetTokenRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetToken not implemented")
+}
+func (UnimplementedTokenizerServiceServer) ListTokens(context.Context, *ListTokensRequest) (*ListTokensResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTokens not implemented")
+}
+func (UnimplementedTokenizerServiceServer) CreateToken(context.Context, *CreateTokenRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateToken not implemented")
+}
+func (UnimplementedTokenizerServiceServer) GrantUser(context.Context, *GrantUserRequest) (*Holding, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GrantUser not implemented")
+}
+func (UnimplementedTokenizerServiceServer) GrantGroup(context.Context, *GrantGroupRequest) (*GrantGroupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GrantGroup not implemented")
+}
+func (UnimplementedTokenizerServiceServer) Spend(context.Context, *SpendRequest) (*Holding, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Spend not implemented")
+}
+func (UnimplementedTokenizerServiceServer) Transfer(context.Context, *TransferRequest) (*Holding, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Transfer not implemented")
+}
+func (UnimplementedTokenizerServiceServer) mustEmbedUnimplementedTokenizerServiceServer() {}
+func (UnimplementedTokenizerServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeTokenizerServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TokenizerServiceServer will
+// result in compilation errors.
+type UnsafeTokenizerServiceServer interface {
+	mustEmbedUnimplementedTokenizerServiceServer()
+}
+
+func RegisterTokenizerServiceServer(s grpc.ServiceRegistrar, srv TokenizerServiceServer) {
+	// If the following call pancis, it indicates UnimplementedTokenizerServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TokenizerService_ServiceDesc, srv)
+}
+
+func _TokenizerService_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_GetUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_ListUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_ListUserTokens_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUserTokensRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).ListUserTokens(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_ListUserTokens_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).ListUserTokens(ctx, req.(*ListUserTokensRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_GetOrganisation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOrganisationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).GetOrganisation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_GetOrganisation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).GetOrganisation(ctx, req.(*GetOrganisationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_ListOrganisations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOrganisationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).ListOrganisations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_ListOrganisations_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).ListOrganisations(ctx, req.(*ListOrganisationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_GetToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).GetToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_GetToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).GetToken(ctx, req.(*GetTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_ListTokens_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTokensRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).ListTokens(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_ListTokens_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).ListTokens(ctx, req.(*ListTokensRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_CreateToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).CreateToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_CreateToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).CreateToken(ctx, req.(*CreateTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_GrantUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrantUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).GrantUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_GrantUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).GrantUser(ctx, req.(*GrantUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_GrantGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrantGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).GrantGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_GrantGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).GrantGroup(ctx, req.(*GrantGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_Spend_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SpendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).Spend(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_Spend_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).Spend(ctx, req.(*SpendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenizerService_Transfer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenizerServiceServer).Transfer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenizerService_Transfer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenizerServiceServer).Transfer(ctx, req.(*TransferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TokenizerService_ServiceDesc is the grpc.ServiceDesc for TokenizerService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TokenizerService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "tokenizer.v1.TokenizerService",
+	HandlerType: (*TokenizerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetUser",
+			Handler:    _TokenizerService_GetUser_Handler,
+		},
+		{
+			MethodName: "ListUsers",
+			Handler:    _TokenizerService_ListUsers_Handler,
+		},
+		{
+			MethodName: "ListUserTokens",
+			Handler:    _TokenizerService_ListUserTokens_Handler,
+		},
+		{
+			MethodName: "GetOrganisation",
+			Handler:    _TokenizerService_GetOrganisation_Handler,
+		},
+		{
+			MethodName: "ListOrganisations",
+			Handler:    _TokenizerService_ListOrganisations_Handler,
+		},
+		{
+			MethodName: "GetToken",
+			Handler:    _TokenizerService_GetToken_Handler,
+		},
+		{
+			MethodName: "ListTokens",
+			Handler:    _TokenizerService_ListTokens_Handler,
+		},
+		{
+			MethodName: "CreateToken",
+			Handler:    _TokenizerService_CreateToken_Handler,
+		},
+		{
+			MethodName: "GrantUser",
+			Handler:    _TokenizerService_GrantUser_Handler,
+		},
+		{
+			MethodName: "GrantGroup",
+			Handler:    _TokenizerService_GrantGroup_Handler,
+		},
+		{
+			MethodName: "Spend",
+			Handler:    _TokenizerService_Spend_Handler,
+		},
+		{
+			MethodName: "Transfer",
+			Handler:    _TokenizerService_Transfer_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "tokenizerpb/tokenizer.proto",
+}
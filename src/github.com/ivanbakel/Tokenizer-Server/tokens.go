@@ -3,59 +3,210 @@ package main
 import (
 	"net/http"
 	"encoding/json"
-	"github.com/ivanbakel/Tokenizer-Server/models"
-	"github.com/vattle/sqlboiler/boil"
-	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"gopkg.in/nullbio/null.v6"
 )
 
-
 func getTokens(w http.ResponseWriter, r *http.Request) {
-	var tokens,err = models.Tokens(boil.GetDB()).All()
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var tokens,next,err = service.ListTokens(q)
+
+		var items = make([]interface{}, len(tokens))
+
+		for i,token := range tokens {
+			items[i] = v1Token(token)
+		}
+
+		return items, next, err
+	})
+}
+
+func getToken(w http.ResponseWriter, r *http.Request) {
+	var tokenID,err = pathID(r, "tid")
 
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		apierror.Write(w, r, err)
 		return
 	}
 
-	var encoder *json.Encoder = json.NewEncoder(w)
+	token,err := service.GetToken(tokenID)
 
-	for _,token := range tokens {
-		encoder.Encode(token)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
 	}
 
+	logging.Annotate(r, "org", token.OrgID)
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1Token(token))
 }
 
-func getToken(w http.ResponseWriter, r *http.Request) {
-	var tokenID = mux.Vars(r)["tid"]
+// getTokenSupply reports how many units of a token have been issued,
+// spent and expired, and how many are still held.
+func getTokenSupply(w http.ResponseWriter, r *http.Request) {
+	var tokenID,err = pathID(r, "tid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
 
-	var token,err = models.FindToken(boil.GetDB(), tokenID)
+	token,supply,err := service.GetTokenSupply(tokenID)
 
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		apierror.Write(w, r, err)
 		return
 	}
 
+	logging.Annotate(r, "org", token.OrgID)
+
 	var encoder *json.Encoder = json.NewEncoder(w)
 
-	encoder.Encode(token)
+	encoder.Encode(v1TokenSupply(token, supply))
 }
 
 func giveGroupTokens(w http.ResponseWriter, r *http.Request) {
+	var tokenID,err = pathID(r, "tid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var request v1.GrantRequest
 
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	holdings,err := service.GrantGroup(tokenID, request.UserIDs, request.Number)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1Holdings(holdings))
 }
 
 func giveUserTokens(w http.ResponseWriter, r *http.Request) {
+	var tokenID,err = pathID(r, "tid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var request v1.GrantRequest
+
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	holding,err := service.GrantUser(tokenID, request.UserID, request.Number)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
 
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1Holding(holding))
 }
 
 func createToken(w http.ResponseWriter, r *http.Request) {
+	var request v1.NewToken
+
+	if err := decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var token,err = service.CreateToken(service.NewToken{
+		Name:		request.Name,
+		Expires:	request.Expires,
+		OrgID:		request.OrgID,
+		MaxSupply:	null.IntFromPtr(request.MaxSupply),
+		UserCap:	null.Int16FromPtr(request.UserCap),
+	})
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", token.OrgID)
+
+	w.Header().Set("Location", createdAt(r, token.ID))
+	w.WriteHeader(http.StatusCreated)
 
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1Token(token))
 }
 
 func receiveTokens(w http.ResponseWriter, r *http.Request) {
-	
+	var userID,tokenID,request,err = transferParams(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	holding,err := service.Transfer(tokenID, request.From, userID, request.Number)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1Holding(holding))
 }
 
 func spendTokens(w http.ResponseWriter, r *http.Request) {
+	var userID,tokenID,request,err = transferParams(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	holding,err := service.Spend(userID, tokenID, request.Number)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1Holding(holding))
+}
+
+// transferParams reads the user and token ids from the path of a balance
+// change, and its body.
+func transferParams(r *http.Request) (userID, tokenID string, request v1.TransferRequest, err error) {
+	if userID,err = pathID(r, "uid"); err != nil {
+		return
+	}
+
+	if tokenID,err = pathID(r, "tid"); err != nil {
+		return
+	}
+
+	err = decodeBody(r, &request)
 
+	return
 }
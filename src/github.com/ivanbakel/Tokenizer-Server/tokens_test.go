@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/client"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+func TestTokenCaps(t *testing.T) {
+	var server = newTestServer(t)
+	var c = client.New(server.URL)
+	var ctx = context.Background()
+
+	var org = &models.Organisation{Name: "capped org"}
+
+	if err := org.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	var users [3]string
+
+	for i := range users {
+		var user = &models.User{FacebookID: "capped user"}
+
+		if err := user.Insert(boil.GetDB()); err != nil {
+			t.Fatal(err)
+		}
+
+		users[i] = user.ID
+	}
+
+	var maxSupply,userCap = 12, int16(5)
+
+	var zero = 0
+
+	if _,err := c.CreateToken(ctx, v1.NewToken{Name: "limited", Expires: time.Now().Add(time.Hour), OrgID: org.ID, MaxSupply: &zero}); !errors.Is(err, client.ErrInvalid) {
+		t.Errorf("want a zero max_supply refused, got %v", err)
+	}
+
+	token,err := c.CreateToken(ctx, v1.NewToken{Name: "limited", Expires: time.Now().Add(time.Hour), OrgID: org.ID, MaxSupply: &maxSupply, UserCap: &userCap})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.MaxSupply == nil || *token.MaxSupply != 12 || token.UserCap == nil || *token.UserCap != 5 {
+		t.Fatalf("want the caps echoed, got %+v", token)
+	}
+
+	if _,err = c.GrantUser(ctx, token.ID, users[0], 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two would go over the cap, so nobody is credited.
+	var apiErr *client.Error
+
+	_,err = c.GrantGroup(ctx, token.ID, []string{users[0], users[1], users[0]}, 2)
+
+	if !errors.Is(err, client.ErrHoldingCapExceeded) || !errors.As(err, &apiErr) || apiErr.Violations != 2 {
+		t.Fatalf("want 2 users over the cap, got %v", err)
+	}
+
+	if _,err = c.GrantUser(ctx, token.ID, users[1], 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err = c.Transfer(ctx, token.ID, users[1], users[0], 2); !errors.Is(err, client.ErrHoldingCapExceeded) {
+		t.Errorf("want a transfer over the cap refused, got %v", err)
+	}
+
+	if _,err = c.Spend(ctx, users[1], token.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err = c.GrantUser(ctx, token.ID, users[2], 4); !errors.Is(err, client.ErrSupplyExceeded) {
+		t.Errorf("want a grant past the supply refused, got %v", err)
+	}
+
+	supply,err := c.GetTokenSupply(ctx, token.ID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if supply.Issued != 9 || supply.Spent != 2 || supply.Outstanding != 7 || supply.Expired != 0 || supply.Remaining == nil || *supply.Remaining != 3 {
+		t.Errorf("want 9 issued, 2 spent, 7 outstanding and 3 remaining, got %+v", supply)
+	}
+}
+
+func TestCreatedTokenLocation(t *testing.T) {
+	var server = newTestServer(t)
+
+	var org = &models.Organisation{Name: "located org"}
+
+	if err := org.Insert(boil.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _,prefix := range []string{"/v1", ""} {
+		var body,err = json.Marshal(v1.NewToken{Name: "located", Expires: time.Now().Add(time.Hour), OrgID: org.ID})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp,err := http.Post(server.URL + prefix + "/tokens", "application/json", bytes.NewReader(body))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var token v1.Token
+
+		err = json.NewDecoder(resp.Body).Decode(&token)
+		resp.Body.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if location := resp.Header.Get("Location"); resp.StatusCode != http.StatusCreated || location != prefix + "/tokens/" + token.ID {
+			t.Errorf("POST %s/tokens = %d at %q, want 201 at %s/tokens/%s", prefix, resp.StatusCode, location, prefix, token.ID)
+		}
+	}
+}
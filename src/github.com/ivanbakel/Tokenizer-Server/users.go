@@ -3,56 +3,61 @@ package main
 import (
 	"net/http"
 	"encoding/json"
-	"github.com/ivanbakel/Tokenizer-Server/models"
-	"github.com/vattle/sqlboiler/boil"
-	"github.com/gorilla/mux"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/service"
 )
 
 func getUsers(w http.ResponseWriter, r *http.Request) {
-	var users,err = models.Users(boil.GetDB()).All()
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var users,next,err = service.ListUsers(q)
 
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
+		var items = make([]interface{}, len(users))
 
-	var encoder *json.Encoder = json.NewEncoder(w)
+		for i,user := range users {
+			items[i] = v1User(user)
+		}
 
-	for _,user := range users {
-		encoder.Encode(user)
-	}
+		return items, next, err
+	})
 }
 
 func getUser(w http.ResponseWriter, r *http.Request) {
-	var userID = mux.Vars(r)["uid"]
+	var userID,err = pathID(r, "uid")
 
-	var user,err = models.FindUser(boil.GetDB(), userID)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	user,err := service.GetUser(userID)
 
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	var encoder *json.Encoder = json.NewEncoder(w)
 
-	encoder.Encode(user)
+	encoder.Encode(v1User(user))
 }
 
 func getUserTokens(w http.ResponseWriter, r *http.Request) {
-	var userID = mux.Vars(r)["uid"]
-
-	var user,err = models.FindUser(boil.GetDB(), userID)
+	var userID,err = pathID(r, "uid")
 
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		apierror.Write(w, r, err)
 		return
 	}
 
-	user.L.LoadUserTokens(boil.GetDB(), true, user)
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var holdings,next,err = service.ListHoldings(userID, q)
 
-	var encoder *json.Encoder = json.NewEncoder(w)
+		var items = make([]interface{}, len(holdings))
 
-	for _,token := range user.R.UserTokens {
-		encoder.Encode(token)
-	}
+		for i,holding := range holdings {
+			items[i] = v1Holding(holding)
+		}
+
+		return items, next, err
+	})
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/ivanbakel/Tokenizer-Server/voucher"
+)
+
+// The functions below turn models into their v1 representations. Handlers
+// must never encode a model directly.
+
+func v1Organisation(org *models.Organisation) v1.Organisation {
+	return v1.Organisation{ID: org.ID, Name: org.Name}
+}
+
+func v1User(user *models.User) v1.User {
+	var result = v1.User{ID: user.ID, FacebookID: user.FacebookID}
+
+	if user.OrgID.Valid {
+		var orgID = user.OrgID.String
+
+		result.OrgID = &orgID
+	}
+
+	if user.FrozenAt.Valid {
+		var frozenAt = user.FrozenAt.Time.UTC()
+
+		result.FrozenAt = &frozenAt
+	}
+
+	return result
+}
+
+func v1Token(token *models.Token) v1.Token {
+	var result = v1.Token{ID: token.ID, Name: token.Name, Expires: token.Expires.UTC(), OrgID: token.OrgID}
+
+	if token.MaxSupply.Valid {
+		var maxSupply = token.MaxSupply.Int
+
+		result.MaxSupply = &maxSupply
+	}
+
+	if token.UserCap.Valid {
+		var userCap = token.UserCap.Int16
+
+		result.UserCap = &userCap
+	}
+
+	return result
+}
+
+func v1TokenSupply(token *models.Token, supply *storage.Supply) v1.TokenSupply {
+	var caps = v1Token(token)
+
+	var result = v1.TokenSupply{
+		TokenID:	supply.TokenID,
+		Issued:		supply.Issued,
+		Outstanding:	supply.Outstanding,
+		Spent:		supply.Spent,
+		Expired:	supply.Expired,
+		MaxSupply:	caps.MaxSupply,
+		UserCap:	caps.UserCap,
+	}
+
+	if caps.MaxSupply != nil {
+		var remaining = int64(*caps.MaxSupply) - supply.Issued
+
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		result.Remaining = &remaining
+	}
+
+	return result
+}
+
+func v1Holding(holding *models.UserToken) v1.Holding {
+	var result = v1.Holding{UserID: holding.UserID, TokenID: holding.TokenID, Number: holding.Number.Int16}
+
+	if holding.FrozenAt.Valid {
+		var frozenAt = holding.FrozenAt.Time.UTC()
+
+		result.FrozenAt = &frozenAt
+	}
+
+	return result
+}
+
+func v1Holdings(holdings models.UserTokenSlice) []v1.Holding {
+	var result = make([]v1.Holding, len(holdings))
+
+	for i,holding := range holdings {
+		result[i] = v1Holding(holding)
+	}
+
+	return result
+}
+
+func v1BalanceEvent(event storage.Event) v1.BalanceEvent {
+	var result = v1.BalanceEvent{
+		ID:		event.ID,
+		Type:		string(event.Type),
+		OrgID:		event.OrgID,
+		TokenID:	event.TokenID,
+		UserID:		event.UserID,
+		FromUserID:	event.FromUserID,
+		Number:		event.Number,
+		Balance:	event.Balance,
+		Limit:		event.Limit,
+		At:		event.At.UTC(),
+	}
+
+	if event.FromUserID != "" {
+		var fromBalance = event.FromBalance
+
+		result.FromBalance = &fromBalance
+	}
+
+	return result
+}
+
+func v1Webhook(webhook *storage.Webhook) v1.Webhook {
+	var result = v1.Webhook{
+		ID:		webhook.ID,
+		OrgID:		webhook.OrgID,
+		URL:		webhook.URL,
+		Events:		[]string{},
+		CreatedAt:	webhook.CreatedAt.UTC(),
+	}
+
+	if webhook.Events != "" {
+		result.Events = strings.Split(webhook.Events, ",")
+	}
+
+	return result
+}
+
+// v1WebhookDelivery includes the attempts log only if attempts is non-nil.
+func v1WebhookDelivery(delivery *storage.WebhookDelivery, attempts []storage.WebhookAttempt) v1.WebhookDelivery {
+	var result = v1.WebhookDelivery{
+		ID:		delivery.ID,
+		WebhookID:	delivery.WebhookID,
+		EventID:	delivery.EventID,
+		EventType:	string(delivery.EventType),
+		State:		delivery.State,
+		AttemptCount:	delivery.Attempts,
+		CreatedAt:	delivery.CreatedAt.UTC(),
+	}
+
+	// The payload was encoded from a storage.Event, whose JSON is the same
+	// as a BalanceEvent's.
+	json.Unmarshal([]byte(delivery.Payload), &result.Payload)
+
+	if delivery.State == storage.DeliveryPending && delivery.NextAttemptAt.Valid {
+		var next = delivery.NextAttemptAt.Time.UTC()
+
+		result.NextAttemptAt = &next
+	}
+
+	if delivery.DeliveredAt.Valid {
+		var deliveredAt = delivery.DeliveredAt.Time.UTC()
+
+		result.DeliveredAt = &deliveredAt
+	}
+
+	if attempts != nil {
+		result.Attempts = make([]v1.WebhookAttempt, len(attempts))
+	}
+
+	for i,attempt := range attempts {
+		result.Attempts[i] = v1.WebhookAttempt{At: attempt.At.UTC(), Error: attempt.Error.String, DurationMS: attempt.DurationMS}
+
+		if attempt.StatusCode.Valid {
+			var status = attempt.StatusCode.Int16
+
+			result.Attempts[i].StatusCode = &status
+		}
+	}
+
+	return result
+}
+
+// v1RedemptionCode links to the code's QR image on the versioned path, which
+// every client can reach whatever it negotiates.
+func v1RedemptionCode(code *storage.RedemptionCode) v1.RedemptionCode {
+	var result = v1.RedemptionCode{
+		Code:		code.Code,
+		UserID:		code.UserID,
+		TokenID:	code.TokenID,
+		Number:		code.Number,
+		ExpiresAt:	code.ExpiresAt.UTC(),
+		QRURL:		"/v1/users/" + code.UserID + "/tokens/" + code.TokenID + "/redemption-codes/" + code.Code + "/qr",
+	}
+
+	if code.RedeemedAt.Valid {
+		var redeemedAt = code.RedeemedAt.Time.UTC()
+
+		result.RedeemedAt = &redeemedAt
+	}
+
+	return result
+}
+
+func v1OrgKey(key *storage.OrgKey) v1.OrgKey {
+	var result = v1.OrgKey{
+		ID:		key.ID,
+		OrgID:		key.OrgID,
+		Name:		key.Name,
+		Prefix:		key.Prefix,
+		CreatedAt:	key.CreatedAt.UTC(),
+	}
+
+	if key.RevokedAt.Valid {
+		var revokedAt = key.RevokedAt.Time.UTC()
+
+		result.RevokedAt = &revokedAt
+	}
+
+	return result
+}
+
+func v1Voucher(issued *storage.Voucher, encoded string) v1.Voucher {
+	return v1.Voucher{
+		ID:		issued.ID,
+		Voucher:	encoded,
+		OrgID:		issued.OrgID,
+		KeyID:		issued.KeyID,
+		UserID:		issued.UserID,
+		TokenID:	issued.TokenID,
+		Number:		issued.Number,
+		IssuedAt:	issued.IssuedAt.UTC(),
+		ExpiresAt:	issued.ExpiresAt.UTC(),
+	}
+}
+
+func voucherJWK(key *storage.VoucherKey) voucher.JWK {
+	return voucher.PublicJWK(key.ID, key.PublicKey)
+}
+
+func v1VoucherConflict(conflict *storage.VoucherConflict) v1.VoucherConflict {
+	return v1.VoucherConflict{
+		ID:		conflict.ID,
+		VoucherID:	conflict.VoucherID,
+		Reason:		conflict.Reason,
+		Terminal:	conflict.Terminal,
+		RedeemedAt:	conflict.RedeemedAt.UTC(),
+		SettledBy:	conflict.SettledBy.String,
+		DetectedAt:	conflict.DetectedAt.UTC(),
+	}
+}
+
+func v1GiftBatch(batch *storage.GiftBatch) v1.GiftBatch {
+	var result = v1.GiftBatch{
+		ID:		batch.ID,
+		OrgID:		batch.OrgID,
+		TokenID:	batch.TokenID,
+		Number:		batch.Number,
+		Size:		batch.Size,
+		Claimed:	batch.Claimed,
+		Unclaimed:	batch.Size - batch.Claimed,
+		CreatedAt:	batch.CreatedAt.UTC(),
+	}
+
+	if batch.RevokedAt.Valid {
+		var revokedAt = batch.RevokedAt.Time.UTC()
+
+		result.RevokedAt = &revokedAt
+	}
+
+	return result
+}
+
+func v1GiftCode(code *storage.GiftCode) v1.GiftCode {
+	var result = v1.GiftCode{
+		Code:		service.FormatGiftCode(code.Code),
+		BatchID:	code.BatchID,
+		ClaimedBy:	code.ClaimedBy.String,
+	}
+
+	if code.ClaimedAt.Valid {
+		var claimedAt = code.ClaimedAt.Time.UTC()
+
+		result.ClaimedAt = &claimedAt
+	}
+
+	return result
+}
+
+func v1FraudAlert(alert *storage.Alert) v1.FraudAlert {
+	return v1.FraudAlert{
+		ID:		alert.ID,
+		Rule:		alert.Rule,
+		UserID:		alert.UserID,
+		TokenID:	alert.TokenID,
+		EventID:	alert.EventID,
+		Count:		alert.Count,
+		Frozen:		alert.Frozen,
+		At:		alert.At.UTC(),
+	}
+}
@@ -0,0 +1,155 @@
+// Package voucher signs offline vouchers, and verifies them for terminals
+// that cannot reach the server. A voucher is an organisation's promise that
+// a user may spend some units of one of its tokens before it expires,
+// signed with one of the organisation's Ed25519 keys. Those keys are
+// published as a JSON Web Key Set, so terminals can fetch them while online
+// and check vouchers without it.
+//
+// The encoded form is the base64url of the voucher's JSON and of the
+// signature over that JSON, joined by a dot.
+package voucher
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Errors Decode and Verify return.
+var (
+	ErrMalformed        = errors.New("voucher: malformed voucher")
+	ErrInvalidSignature = errors.New("voucher: signature does not match")
+)
+
+// Voucher is what a voucher says. KeyID names the organisation key that
+// signed it.
+type Voucher struct {
+	ID        string    `json:"id"`
+	KeyID     string    `json:"kid"`
+	OrgID     string    `json:"org_id"`
+	TokenID   string    `json:"token_id"`
+	UserID    string    `json:"user_id"`
+	Number    int16     `json:"number"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the voucher could no longer be accepted at t.
+func (v *Voucher) Expired(t time.Time) bool {
+	return !t.Before(v.ExpiresAt)
+}
+
+var encoding = base64.RawURLEncoding
+
+// Sign encodes v, signed with key.
+func Sign(v Voucher, key ed25519.PrivateKey) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.Wrap(err, "voucher: unable to encode")
+	}
+
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(ed25519.Sign(key, payload)), nil
+}
+
+// Decode reads what an encoded voucher says without checking its signature,
+// e.g. to find which key to verify it with. Nothing it returns can be
+// trusted until Verify has passed.
+func Decode(encoded string) (*Voucher, error) {
+	v, _, _, err := split(encoded)
+	return v, err
+}
+
+// Verify checks an encoded voucher's signature against key, and returns what
+// it says. It does not check expiry; see Voucher.Expired.
+func Verify(encoded string, key ed25519.PublicKey) (*Voucher, error) {
+	v, payload, signature, err := split(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, payload, signature) {
+		return nil, ErrInvalidSignature
+	}
+	return v, nil
+}
+
+func split(encoded string) (*Voucher, []byte, []byte, error) {
+	parts := strings.Split(encoded, ".")
+	if len(parts) != 2 {
+		return nil, nil, nil, ErrMalformed
+	}
+
+	payload, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, nil, ErrMalformed
+	}
+
+	signature, err := encoding.DecodeString(parts[1])
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, nil, nil, ErrMalformed
+	}
+
+	var v Voucher
+	if err = json.Unmarshal(payload, &v); err != nil || v.ID == "" || v.KeyID == "" {
+		return nil, nil, nil, ErrMalformed
+	}
+	return &v, payload, signature, nil
+}
+
+// JWK is an Ed25519 public key in the form of RFC 8037.
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	X       string `json:"x"`
+}
+
+// KeySet is a JSON Web Key Set: the keys vouchers may be signed with.
+type KeySet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWK describes key, named id, as a JWK.
+func PublicJWK(id string, key ed25519.PublicKey) JWK {
+	return JWK{KeyType: "OKP", Curve: "Ed25519", KeyID: id, Use: "sig", X: encoding.EncodeToString(key)}
+}
+
+// PublicKey returns the key a JWK describes.
+func (k JWK) PublicKey() (ed25519.PublicKey, error) {
+	if k.KeyType != "OKP" || k.Curve != "Ed25519" {
+		return nil, errors.Errorf("voucher: key %q is not an Ed25519 key", k.KeyID)
+	}
+
+	x, err := encoding.DecodeString(k.X)
+	if err != nil || len(x) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("voucher: key %q is malformed", k.KeyID)
+	}
+	return ed25519.PublicKey(x), nil
+}
+
+// Verify checks an encoded voucher against whichever of the set's keys it
+// names.
+func (s KeySet) Verify(encoded string) (*Voucher, error) {
+	v, err := Decode(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range s.Keys {
+		if k.KeyID != v.KeyID {
+			continue
+		}
+
+		key, err := k.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		return Verify(encoded, key)
+	}
+	return nil, ErrInvalidSignature
+}
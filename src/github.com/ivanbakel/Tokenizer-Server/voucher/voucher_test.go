@@ -0,0 +1,66 @@
+package voucher
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	v := Voucher{ID: "v1", KeyID: "k1", OrgID: "o", TokenID: "t", UserID: "u", Number: 3, IssuedAt: issued, ExpiresAt: issued.Add(time.Hour)}
+
+	encoded, err := Sign(v, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Verify(encoded, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != v {
+		t.Errorf("verified %+v, want %+v", *got, v)
+	}
+	if got.Expired(issued.Add(59*time.Minute)) || !got.Expired(issued.Add(time.Hour)) {
+		t.Error("want the voucher to expire after an hour")
+	}
+
+	// Through a published key set, as a terminal would.
+	var set KeySet
+	published, _ := json.Marshal(KeySet{Keys: []JWK{PublicJWK("k0", make([]byte, ed25519.PublicKeySize)), PublicJWK("k1", pub)}})
+	if err = json.Unmarshal(published, &set); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = set.Verify(encoded); err != nil {
+		t.Errorf("want the key set to verify the voucher, got %v", err)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if _, err = Verify(encoded, otherPub); err != ErrInvalidSignature {
+		t.Errorf("want another key refused, got %v", err)
+	}
+
+	// Any change to what the voucher says breaks the signature.
+	v.Number = 30
+	forged, _ := Sign(v, priv)
+	tampered := strings.SplitN(forged, ".", 2)[0] + "." + strings.SplitN(encoded, ".", 2)[1]
+	if _, err = Verify(tampered, pub); err != ErrInvalidSignature {
+		t.Errorf("want a tampered voucher refused, got %v", err)
+	}
+
+	for _, malformed := range []string{"", "abc", "a.b.c", "!!.??", strings.SplitN(encoded, ".", 2)[0] + ".AAAA"} {
+		if _, err = Decode(malformed); err != ErrMalformed {
+			t.Errorf("Decode(%q) = %v, want ErrMalformed", malformed, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+)
+
+func issueVoucher(w http.ResponseWriter, r *http.Request) {
+	var userID,err = pathID(r, "uid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	tokenID,err := pathID(r, "tid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var request v1.NewVoucher
+
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	voucher,encoded,err := service.IssueVoucher(userID, tokenID, service.NewVoucher{
+		Number:		request.Number,
+		Lifetime:	time.Duration(request.ExpiresIn) * time.Second,
+	})
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", voucher.OrgID)
+
+	w.WriteHeader(http.StatusCreated)
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1Voucher(voucher, encoded))
+}
+
+// getVoucherKeys publishes the keys an organisation's vouchers are signed
+// with, as a JSON Web Key Set. Terminals fetch it while online; caching it
+// for a few minutes lets them pick up a rotation soon after it happens.
+func getVoucherKeys(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	keys,err := service.VoucherKeys(orgID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(keys)
+}
+
+// rotateVoucherKey starts signing an organisation's vouchers with a new key,
+// and answers with its public half.
+func rotateVoucherKey(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	key,err := service.RotateVoucherKey(orgID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(voucherJWK(key))
+}
+
+// settleVouchers settles the vouchers one of an organisation's terminals
+// accepted while offline. Vouchers that cannot be settled are reported in
+// the results, not as an error, so that the rest still are.
+func settleVouchers(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	var request v1.SettlementRequest
+
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var redemptions = make([]service.Redemption, len(request.Redemptions))
+
+	for i,redemption := range request.Redemptions {
+		redemptions[i] = service.Redemption{Voucher: redemption.Voucher, RedeemedAt: redemption.RedeemedAt}
+	}
+
+	settlements,err := service.SettleVouchers(orgID, request.Terminal, redemptions)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var result = v1.Settlement{Results: make([]v1.SettlementResult, len(settlements))}
+
+	for i,settlement := range settlements {
+		result.Results[i] = v1.SettlementResult{VoucherID: settlement.VoucherID, Status: settlement.Status, Reason: settlement.Reason}
+
+		if settlement.Holding != nil {
+			var holding = v1Holding(settlement.Holding)
+
+			result.Results[i].Holding = &holding
+		}
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(result)
+}
+
+func getVoucherConflicts(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var conflicts,next,err = service.ListVoucherConflicts(orgID, q)
+
+		var items = make([]interface{}, len(conflicts))
+
+		for i,conflict := range conflicts {
+			items[i] = v1VoucherConflict(conflict)
+		}
+
+		return items, next, err
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/ivanbakel/Tokenizer-Server/voucher"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// fetchVoucherKeys gets an organisation's key set, as a terminal would.
+func fetchVoucherKeys(t *testing.T, url string) voucher.KeySet {
+	var resp,err = http.Get(url)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var keys voucher.KeySet
+
+	if err = json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+
+	return keys
+}
+
+func TestVouchers(t *testing.T) {
+	var server = newTestServer(t)
+	var db = boil.GetDB()
+
+	var org = &models.Organisation{Name: "offline org"}
+
+	if err := org.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var key v1.OrgKey
+
+	if status := adminCall(t, "POST", server.URL + "/v1/orgs/" + org.ID + "/keys", v1.NewOrgKey{Name: "terminals"}, &key); status != http.StatusCreated {
+		t.Fatalf("create key status = %d, want 201", status)
+	}
+
+	var token = &models.Token{Name: "bus fare", Expires: time.Now().Add(48 * time.Hour).UTC(), OrgID: org.ID}
+
+	if err := token.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var user = &models.User{FacebookID: "commuter"}
+
+	if err := user.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if _,err := storage.Grant(token.ID, 5, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var issue = func(request v1.NewVoucher) v1.Voucher {
+		var issued v1.Voucher
+
+		if status := adminCall(t, "POST", server.URL + "/v1/users/" + user.ID + "/tokens/" + token.ID + "/vouchers", request, &issued); status != http.StatusCreated {
+			t.Fatalf("issue status = %d, want 201", status)
+		}
+
+		return issued
+	}
+
+	var first, second, third = issue(v1.NewVoucher{Number: 2}), issue(v1.NewVoucher{Number: 2}), issue(v1.NewVoucher{Number: 2})
+
+	// A terminal checks vouchers offline against the published keys.
+	var wellKnown = server.URL + "/.well-known/voucher-keys/" + org.ID
+	var keys = fetchVoucherKeys(t, wellKnown)
+
+	checked,err := keys.Verify(first.Voucher)
+
+	if err != nil {
+		t.Fatalf("want the voucher to verify offline, got %v", err)
+	}
+
+	if checked.ID != first.ID || checked.UserID != user.ID || checked.TokenID != token.ID || checked.Number != 2 || !checked.ExpiresAt.Equal(first.ExpiresAt) {
+		t.Errorf("voucher says %+v, want it to match %+v", checked, first)
+	}
+
+	if time.Until(first.ExpiresAt) > 24 * time.Hour {
+		t.Errorf("want vouchers to last a day, got %v", first.ExpiresAt)
+	}
+
+	var settle = func(terminal string, redemptions ...v1.VoucherRedemption) []v1.SettlementResult {
+		var settlement v1.Settlement
+
+		if status := bearerCall(t, key.Key, "POST", server.URL + "/v1/orgs/" + org.ID + "/vouchers/settle", v1.SettlementRequest{Terminal: terminal, Redemptions: redemptions}, &settlement); status != http.StatusOK {
+			t.Fatalf("settle status = %d, want 200", status)
+		}
+
+		return settlement.Results
+	}
+
+	var accepted = time.Now().Add(-time.Minute).UTC().Truncate(time.Millisecond)
+
+	var results = settle("bus 12", v1.VoucherRedemption{Voucher: first.Voucher, RedeemedAt: accepted}, v1.VoucherRedemption{Voucher: second.Voucher, RedeemedAt: accepted})
+
+	if results[0].Status != "settled" || results[1].Status != "settled" || results[1].Holding == nil || results[1].Holding.Number != 1 {
+		t.Fatalf("want both vouchers settled leaving 1, got %+v", results)
+	}
+
+	// The same terminal syncing again changes nothing.
+	if results = settle("bus 12", v1.VoucherRedemption{Voucher: first.Voucher, RedeemedAt: accepted}); results[0].Status != "duplicate" {
+		t.Errorf("want a repeated sync reported as a duplicate, got %+v", results)
+	}
+
+	// Another terminal presenting a settled voucher is a double spend, and
+	// one presenting a voucher the user can no longer cover, or a forged
+	// voucher, is a conflict too.
+	var parts = strings.Split(third.Voucher, ".")
+	var forged = strings.Split(first.Voucher, ".")[0] + "." + parts[1]
+
+	results = settle("bus 40",
+		v1.VoucherRedemption{Voucher: first.Voucher, RedeemedAt: accepted},
+		v1.VoucherRedemption{Voucher: third.Voucher, RedeemedAt: accepted},
+		v1.VoucherRedemption{Voucher: forged, RedeemedAt: accepted})
+
+	for i,reason := range []string{"double_spend", "insufficient_balance", "invalid_signature"} {
+		if results[i].Status != "conflict" || results[i].Reason != reason {
+			t.Errorf("result %d = %+v, want a %s conflict", i, results[i], reason)
+		}
+	}
+
+	var page struct {
+		Items	[]v1.VoucherConflict	`json:"items"`
+	}
+
+	if status := bearerCall(t, key.Key, "GET", server.URL + "/v1/orgs/" + org.ID + "/voucher-conflicts?reason=double_spend", nil, &page); status != http.StatusOK {
+		t.Fatalf("list conflicts status = %d, want 200", status)
+	}
+
+	if len(page.Items) != 1 || page.Items[0].VoucherID != first.ID || page.Items[0].Terminal != "bus 40" || page.Items[0].SettledBy != "bus 12" {
+		t.Errorf("want the double spend reported against the first terminal, got %+v", page.Items)
+	}
+
+	if status := adminCall(t, "GET", server.URL + "/v1/orgs/" + org.ID + "/voucher-conflicts", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("want conflicts to need an org key, got %d", status)
+	}
+
+	// A voucher accepted after it expired is refused.
+	if _,err = storage.Grant(token.ID, 5, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var brief = issue(v1.NewVoucher{Number: 1, ExpiresIn: 60})
+
+	if results = settle("bus 12", v1.VoucherRedemption{Voucher: brief.Voucher, RedeemedAt: brief.ExpiresAt.Add(time.Second)}); results[0].Reason != "expired" {
+		t.Errorf("want a voucher accepted after expiry refused, got %+v", results)
+	}
+
+	// After a rotation, vouchers signed with either key verify.
+	var rotated voucher.JWK
+
+	if status := adminCall(t, "POST", server.URL + "/v1/orgs/" + org.ID + "/voucher-keys/rotate", nil, &rotated); status != http.StatusCreated {
+		t.Fatalf("rotate status = %d, want 201", status)
+	}
+
+	var fresh = issue(v1.NewVoucher{Number: 1})
+
+	if fresh.KeyID != rotated.KeyID || fresh.KeyID == first.KeyID {
+		t.Errorf("want new vouchers signed with the new key, got %s", fresh.KeyID)
+	}
+
+	keys = fetchVoucherKeys(t, wellKnown)
+
+	for _,encoded := range []string{second.Voucher, fresh.Voucher} {
+		if _,err = keys.Verify(encoded); err != nil {
+			t.Errorf("want vouchers from both keys to verify, got %v", err)
+		}
+	}
+
+	if results = settle("bus 12", v1.VoucherRedemption{Voucher: fresh.Voucher, RedeemedAt: time.Now()}); results[0].Status != "settled" {
+		t.Errorf("want a voucher from the new key settled, got %+v", results)
+	}
+
+	// A frozen holding is a conflict, not a failure of the whole sync.
+	var frozen = issue(v1.NewVoucher{Number: 1})
+
+	if _,err = db.Exec(`UPDATE "user_tokens" SET "frozen_at" = $1 WHERE "user_id" = $2`, time.Now().UTC(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if results = settle("bus 12", v1.VoucherRedemption{Voucher: frozen.Voucher, RedeemedAt: time.Now()}); results[0].Status != "conflict" || results[0].Reason != "frozen" {
+		t.Errorf("want a voucher on a frozen holding reported as a conflict, got %+v", results)
+	}
+}
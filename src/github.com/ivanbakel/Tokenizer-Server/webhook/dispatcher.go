@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"gopkg.in/nullbio/null.v6"
+)
+
+const (
+	// batchSize is how many due deliveries are read from storage at once.
+	batchSize = 100
+
+	// concurrency is how many deliveries are sent at once, so that one slow
+	// receiver does not hold up the others.
+	concurrency = 8
+
+	// leaseMargin is how much longer than the client's timeout a claim on a
+	// delivery lasts, to leave time to record the outcome.
+	leaseMargin = time.Minute
+
+	// maxResponseRead is how much of a response body is read before the
+	// connection is given up on rather than reused.
+	maxResponseRead = 64 << 10
+)
+
+// Dispatcher sends the deliveries in storage's outbox as they fall due. Any
+// number of instances can share an outbox: each delivery is claimed before it
+// is sent, so only one of them sends it.
+type Dispatcher struct {
+	// Client sends the requests. Its Timeout bounds each attempt.
+	Client *http.Client
+
+	// MaxAttempts is how many times a delivery is tried before it is marked
+	// failed.
+	MaxAttempts int
+
+	// PollInterval is how often Run looks for due deliveries when nothing
+	// has woken it.
+	PollInterval time.Duration
+
+	// Backoff is how long to wait before retrying a delivery that has
+	// failed the given number of times.
+	Backoff func(failures int) time.Duration
+
+	// AllowPrivate lets deliveries be sent to addresses that are not Public,
+	// e.g. for receivers on the same private network as the server.
+	AllowPrivate bool
+
+	wake chan struct{}
+}
+
+// NewDispatcher returns a Dispatcher that gives each attempt timeout to
+// succeed, and makes up to maxAttempts of them.
+func NewDispatcher(timeout time.Duration, maxAttempts int, pollInterval time.Duration) *Dispatcher {
+	d := &Dispatcher{
+		MaxAttempts:  maxAttempts,
+		PollInterval: pollInterval,
+		Backoff:      Backoff,
+		wake:         make(chan struct{}, 1),
+	}
+
+	d.Client = &http.Client{
+		Timeout: timeout,
+		// Deliveries go straight to the receiver, never through a proxy,
+		// so that the address checked is the one connected to.
+		Transport: &http.Transport{
+			DialContext:         publicDialer(timeout, func() bool { return d.AllowPrivate }).DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: concurrency,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// Receivers must answer at the URL they registered; following
+		// redirects would send signed payloads wherever they pointed.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return d
+}
+
+// Wake is a storage.Listener that makes Run look for due deliveries at once,
+// rather than at its next poll, so that changes are sent promptly.
+func (d *Dispatcher) Wake(storage.Event) {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends deliveries as they fall due until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.DeliverDue(ctx); err != nil {
+			log.Printf("unable to send webhook deliveries: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue makes one attempt at every delivery that is due, and returns
+// once they have all finished.
+func (d *Dispatcher) DeliverDue(ctx context.Context) error {
+	for {
+		now := time.Now()
+
+		due, err := storage.DueWebhookDeliveries(now, batchSize)
+		if err != nil {
+			return err
+		}
+
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, concurrency)
+
+		for _, delivery := range due {
+			if ctx.Err() != nil {
+				break
+			}
+
+			claimed, err := storage.ClaimWebhookDelivery(delivery.ID, now, now.Add(d.Client.Timeout+leaseMargin))
+			if err != nil {
+				wg.Wait()
+				return err
+			}
+			if !claimed {
+				continue
+			}
+
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(delivery storage.OutboundDelivery) {
+				defer func() { <-slots; wg.Done() }()
+				d.attempt(ctx, delivery)
+			}(delivery)
+		}
+
+		wg.Wait()
+
+		// Every delivery in the batch is now either finished or put off, so
+		// the next read moves on.
+		if len(due) < batchSize || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// attempt sends a claimed delivery once and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, delivery storage.OutboundDelivery) {
+	start := time.Now()
+	status, err := d.send(ctx, delivery, start)
+
+	// An attempt cut short by shutdown is not the receiver's fault. The
+	// claim lapses and the delivery is tried again.
+	if ctx.Err() != nil {
+		return
+	}
+
+	attempt := storage.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		At:         start,
+		DurationMS: int(time.Since(start) / time.Millisecond),
+	}
+	if status != 0 {
+		attempt.StatusCode = null.Int16From(int16(status))
+	}
+
+	state, next := storage.DeliveryDelivered, null.Time{}
+	if err != nil {
+		attempt.Error = null.StringFrom(err.Error())
+
+		failures := delivery.Attempts + 1
+		if failures >= d.MaxAttempts {
+			state = storage.DeliveryFailed
+		} else {
+			state, next = storage.DeliveryPending, null.TimeFrom(time.Now().Add(d.Backoff(failures)))
+		}
+	}
+
+	if err = storage.FinishWebhookAttempt(attempt, state, next); err != nil {
+		log.Printf("unable to record webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// send posts the delivery's payload to its webhook, returning the status the
+// receiver answered with, if any. Only a 2xx status counts as delivered.
+func (d *Dispatcher) send(ctx context.Context, delivery storage.OutboundDelivery, at time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tokenizer-Webhooks/1")
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(EventHeader, string(delivery.EventType))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, at, []byte(delivery.Payload)))
+
+	resp, err := d.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseRead))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
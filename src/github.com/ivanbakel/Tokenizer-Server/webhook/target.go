@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPrivateTarget is returned for a webhook whose host is, or resolves to,
+// an address inside the network: loopback, link-local, private or
+// unspecified. Deliveries are sent from inside the network, and their status
+// and errors are shown to the organisation, so such hosts would let it probe
+// and call services that are not meant to be reachable.
+var ErrPrivateTarget = errors.New("webhook: target is not a public address")
+
+// Public reports whether ip may be sent deliveries.
+func Public(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified())
+}
+
+// CheckHost resolves host, failing with ErrPrivateTarget if any of its
+// addresses is not Public. It is meant for when a webhook is registered;
+// since the host may resolve differently by the time a delivery is sent, a
+// Dispatcher checks the address it connects to as well.
+func CheckHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.Wrapf(err, "webhook: unable to resolve %s", host)
+	}
+
+	for _, addr := range addrs {
+		if !Public(addr.IP) {
+			return ErrPrivateTarget
+		}
+	}
+	return nil
+}
+
+// publicDialer returns a dialer that refuses to connect to addresses that
+// are not Public, unless allowPrivate says otherwise when it dials. The check
+// is made on the address actually dialed, after resolution.
+func publicDialer(timeout time.Duration, allowPrivate func() bool) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate() {
+				return nil
+			}
+
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !Public(ip) {
+				return ErrPrivateTarget
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::6810": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.0.0.8":        false,
+		"172.16.4.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+	} {
+		if got := Public(net.ParseIP(addr)); got != want {
+			t.Errorf("Public(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	if err := CheckHost(context.Background(), "127.0.0.1"); err != ErrPrivateTarget {
+		t.Errorf("want a loopback host refused, got %v", err)
+	}
+	if err := CheckHost(context.Background(), "93.184.216.34"); err != nil {
+		t.Errorf("want a public address allowed, got %v", err)
+	}
+}
+
+func TestDispatcherRefusesPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	d := NewDispatcher(5*time.Second, 1, time.Hour)
+
+	// The address is checked as it is dialed, whatever the URL said.
+	if _, err := d.Client.Get(receiver.URL); !errors.Is(err, ErrPrivateTarget) {
+		t.Errorf("want the loopback receiver refused, got %v", err)
+	}
+
+	d.AllowPrivate = true
+	resp, err := d.Client.Get(receiver.URL)
+	if err != nil {
+		t.Fatalf("want the receiver reached once allowed, got %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,113 @@
+// Package webhook sends the deliveries storage queues for organisations'
+// webhooks, and signs them so that receivers can tell they came from this
+// server.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The headers sent with every delivery. SignatureHeader carries the
+// signature Sign makes; DeliveryHeader the delivery's id, which stays the
+// same across retries so receivers can discard repeats; and EventHeader the
+// event type.
+const (
+	SignatureHeader = "Tokenizer-Signature"
+	DeliveryHeader  = "Tokenizer-Delivery"
+	EventHeader     = "Tokenizer-Event"
+)
+
+// DefaultTolerance is how old a signature Verify accepts by default.
+const DefaultTolerance = 5 * time.Minute
+
+// Errors Verify returns.
+var (
+	ErrMalformedSignature = errors.New("webhook: malformed signature header")
+	ErrInvalidSignature   = errors.New("webhook: signature does not match")
+	ErrStaleSignature     = errors.New("webhook: signature is too old")
+)
+
+// Sign returns the SignatureHeader value for body sent at t, in the form
+// "t=<unix time>,v1=<signature>". The signature is the hex HMAC-SHA256,
+// keyed by secret, of the time and body joined by a dot; covering the time
+// stops a captured request being replayed later.
+func Sign(secret string, t time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac(secret, t.Unix(), body)))
+}
+
+// Verify checks a SignatureHeader value against body, and that it was made
+// no more than tolerance before now. Receivers written in Go can use it
+// as is.
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp int64
+	var signatures [][]byte
+
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return ErrMalformedSignature
+		}
+
+		switch kv[0] {
+		case "t":
+			var err error
+			if timestamp, err = strconv.ParseInt(kv[1], 10, 64); err != nil {
+				return ErrMalformedSignature
+			}
+		case "v1":
+			signature, err := hex.DecodeString(kv[1])
+			if err != nil {
+				return ErrMalformedSignature
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+
+	if timestamp == 0 || len(signatures) == 0 {
+		return ErrMalformedSignature
+	}
+
+	// Several v1 signatures are accepted so that a secret can be rotated
+	// without a window in which deliveries fail.
+	expected := mac(secret, timestamp, body)
+	for _, signature := range signatures {
+		if hmac.Equal(signature, expected) {
+			if now.Sub(time.Unix(timestamp, 0)) > tolerance {
+				return ErrStaleSignature
+			}
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func mac(secret string, timestamp int64, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%d.", timestamp)
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Backoff returns how long to wait before retrying a delivery that has
+// failed the given number of times: 30 seconds, doubling with each failure,
+// up to an hour.
+func Backoff(failures int) time.Duration {
+	const first, max = 30 * time.Second, time.Hour
+
+	wait := first
+	for i := 1; i < failures && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`{"id":1,"type":"granted"}`)
+	at := time.Unix(1700000000, 0)
+	header := Sign("secret", at, body)
+
+	if err := Verify("secret", header, body, at.Add(time.Minute), DefaultTolerance); err != nil {
+		t.Errorf("want a fresh signature accepted, got %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		secret, header string
+		body           []byte
+		now            time.Time
+		want           error
+	}{
+		"wrong secret":  {"other", header, body, at, ErrInvalidSignature},
+		"altered body":  {"secret", header, []byte(`{"id":2,"type":"granted"}`), at, ErrInvalidSignature},
+		"old signature": {"secret", header, body, at.Add(DefaultTolerance + time.Second), ErrStaleSignature},
+		"no timestamp":  {"secret", "v1=00", body, at, ErrMalformedSignature},
+		"not hex":       {"secret", "t=1700000000,v1=zz", body, at, ErrMalformedSignature},
+	} {
+		if err := Verify(test.secret, test.header, test.body, test.now, DefaultTolerance); err != test.want {
+			t.Errorf("%s: got %v, want %v", name, err, test.want)
+		}
+	}
+
+	rotated := Sign("old", at, body) + "," + header[len("t=1700000000,"):]
+	if err := Verify("secret", rotated, body, at, DefaultTolerance); err != nil {
+		t.Errorf("want any matching v1 signature accepted, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	for failures, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		8:  time.Hour,
+		40: time.Hour,
+	} {
+		if got := Backoff(failures); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/apierror"
+	"github.com/ivanbakel/Tokenizer-Server/logging"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+)
+
+// deliveryRetention is how long finished webhook deliveries are kept for
+// inspection and redelivery.
+const deliveryRetention = 30 * 24 * time.Hour
+
+// webhookParams reads the organisation and webhook ids from the path.
+func webhookParams(r *http.Request) (orgID, webhookID string, err error) {
+	if orgID,err = pathID(r, "oid"); err != nil {
+		return "", "", err
+	}
+
+	if webhookID,err = pathID(r, "wid"); err != nil {
+		return "", "", err
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	return orgID, webhookID, nil
+}
+
+func getWebhooks(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var webhooks,next,err = service.ListWebhooks(orgID, q)
+
+		var items = make([]interface{}, len(webhooks))
+
+		for i,webhook := range webhooks {
+			items[i] = v1Webhook(webhook)
+		}
+
+		return items, next, err
+	})
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	var orgID,err = pathID(r, "oid")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	logging.Annotate(r, "org", orgID)
+
+	var request v1.NewWebhook
+
+	if err = decodeBody(r, &request); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	webhook,err := service.CreateWebhook(orgID, service.NewWebhook{URL: request.URL, Events: request.Events, Secret: request.Secret})
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	// The secret is shown this once, so that the receiver can be set up
+	// to check signatures.
+	var result = v1Webhook(webhook)
+
+	result.Secret = webhook.Secret
+
+	w.Header().Set("Location", createdAt(r, webhook.ID))
+	w.WriteHeader(http.StatusCreated)
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(result)
+}
+
+func getWebhook(w http.ResponseWriter, r *http.Request) {
+	var orgID,webhookID,err = webhookParams(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	webhook,err := service.GetWebhook(orgID, webhookID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1Webhook(webhook))
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	var orgID,webhookID,err = webhookParams(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	if err = service.DeleteWebhook(orgID, webhookID); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	var orgID,webhookID,err = webhookParams(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	writeCollection(w, r, func(q service.ListQuery) ([]interface{}, string, error) {
+		var deliveries,next,err = service.ListWebhookDeliveries(orgID, webhookID, q)
+
+		var items = make([]interface{}, len(deliveries))
+
+		for i,delivery := range deliveries {
+			items[i] = v1WebhookDelivery(delivery, nil)
+		}
+
+		return items, next, err
+	})
+}
+
+func getWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	var orgID,webhookID,err = webhookParams(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	deliveryID,err := pathID(r, "did")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	delivery,attempts,err := service.GetWebhookDelivery(orgID, webhookID, deliveryID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1WebhookDelivery(delivery, attempts))
+}
+
+// redeliverWebhook queues a delivery to be sent again, and answers with the
+// delivery as it now stands. The sending itself happens in the background.
+func redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	var orgID,webhookID,err = webhookParams(r)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	deliveryID,err := pathID(r, "did")
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	if err = service.RedeliverWebhookDelivery(orgID, webhookID, deliveryID); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	delivery,attempts,err := service.GetWebhookDelivery(orgID, webhookID, deliveryID)
+
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	var encoder *json.Encoder = json.NewEncoder(w)
+
+	encoder.Encode(v1WebhookDelivery(delivery, attempts))
+}
+
+// pruneWebhookDeliveries forgets finished deliveries older than
+// deliveryRetention every interval until ctx is done.
+func pruneWebhookDeliveries(interval time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _,err := storage.PruneWebhookDeliveries(now.Add(-deliveryRetention)); err != nil {
+					log.Printf("unable to prune webhook deliveries: %v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"github.com/ivanbakel/Tokenizer-Server/api/v1"
+	"github.com/ivanbakel/Tokenizer-Server/models"
+	"github.com/ivanbakel/Tokenizer-Server/service"
+	"github.com/ivanbakel/Tokenizer-Server/storage"
+	"github.com/ivanbakel/Tokenizer-Server/webhook"
+	"github.com/vattle/sqlboiler/boil"
+)
+
+// received is a request a test receiver was sent.
+type received struct {
+	header	http.Header
+	body	[]byte
+}
+
+// adminCall makes a request with the admin token and decodes the response
+// into into, returning the status.
+func adminCall(t *testing.T, method, url string, body, into interface{}) int {
+	return bearerCall(t, testAdminToken, method, url, body, into)
+}
+
+// bearerCall makes a request with the given bearer token and decodes a
+// successful response into into, returning the status.
+func bearerCall(t *testing.T, bearer, method, url string, body, into interface{}) int {
+	var encoded,err = json.Marshal(body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req,err := http.NewRequest(method, url, bytes.NewReader(encoded))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer " + bearer)
+
+	resp,err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if into != nil && resp.StatusCode < 300 {
+		if err = json.NewDecoder(resp.Body).Decode(into); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var server = newTestServer(t)
+	var db = boil.GetDB()
+
+	// The receiver fails the first request, then accepts the rest.
+	var requests = make(chan received, 10)
+	var statuses = make(chan int, 1)
+
+	statuses <- http.StatusInternalServerError
+
+	var receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body,_ = ioutil.ReadAll(r.Body)
+
+		requests <- received{header: r.Header, body: body}
+
+		select {
+		case status := <-statuses:
+			w.WriteHeader(status)
+		default:
+		}
+	}))
+
+	defer receiver.Close()
+
+	var org = &models.Organisation{Name: "webhook org"}
+
+	if err := org.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var token = &models.Token{Name: "webhook token", Expires: time.Now().Add(time.Hour).UTC(), OrgID: org.ID}
+
+	if err := token.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var user = &models.User{FacebookID: "webhook user"}
+
+	if err := user.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var hooks = server.URL + "/v1/orgs/" + org.ID + "/webhooks"
+
+	if resp,err := http.Post(hooks, "application/json", bytes.NewReader([]byte(`{"url": "http://example.com"}`))); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("want webhooks to need the admin token, got %v %v", resp, err)
+	}
+
+	// Targets inside the network are refused, unless allowed.
+	for _,url := range []string{receiver.URL, "http://169.254.169.254/latest/meta-data", "https://10.1.2.3/hook", "http://localhost:8080"} {
+		if status := adminCall(t, "POST", hooks, v1.NewWebhook{URL: url}, nil); status != http.StatusUnprocessableEntity {
+			t.Errorf("want %s refused, got %d", url, status)
+		}
+	}
+
+	service.AllowPrivateWebhooks(true)
+	t.Cleanup(func() { service.AllowPrivateWebhooks(false) })
+
+	var hook v1.Webhook
+
+	if status := adminCall(t, "POST", hooks, v1.NewWebhook{URL: receiver.URL, Events: []string{"granted"}}, &hook); status != http.StatusCreated {
+		t.Fatalf("create status = %d, want 201", status)
+	}
+
+	if len(hook.Secret) != 64 || len(hook.Events) != 1 {
+		t.Errorf("want a generated secret and the granted event, got %+v", hook)
+	}
+
+	if status := adminCall(t, "POST", hooks, v1.NewWebhook{URL: "ftp://example.com"}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("want a non-HTTP URL rejected, got %d", status)
+	}
+
+	if _,err := storage.Grant(token.ID, 5, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spends are not subscribed to, so are never queued.
+	if _,err := storage.Spend(user.ID, token.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var dispatcher = webhook.NewDispatcher(5 * time.Second, 3, time.Hour)
+
+	dispatcher.Backoff = func(int) time.Duration { return 0 }
+	dispatcher.AllowPrivate = true
+
+	// The first attempt fails and the delivery is put off until the next
+	// pass, which succeeds.
+	var first, second received
+
+	for _,into := range []*received{&first, &second} {
+		if err := dispatcher.DeliverDue(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case *into = <-requests:
+		default:
+			t.Fatal("want a delivery on every pass until one succeeds")
+		}
+	}
+
+	var deliveryID = first.header.Get(webhook.DeliveryHeader)
+
+	if second.header.Get(webhook.DeliveryHeader) != deliveryID || first.header.Get(webhook.EventHeader) != "granted" {
+		t.Errorf("want a retry to repeat the delivery id, got %v then %v", first.header, second.header)
+	}
+
+	if err := webhook.Verify(hook.Secret, second.header.Get(webhook.SignatureHeader), second.body, time.Now(), webhook.DefaultTolerance); err != nil {
+		t.Errorf("want a valid signature, got %v", err)
+	}
+
+	var event v1.BalanceEvent
+
+	if err := json.Unmarshal(second.body, &event); err != nil || event.Type != "granted" || event.Balance != 5 || event.UserID != user.ID {
+		t.Errorf("unexpected payload %s: %v", second.body, err)
+	}
+
+	var deliveries struct{ Items []v1.WebhookDelivery }
+
+	adminCall(t, "GET", hooks + "/" + hook.ID + "/deliveries", nil, &deliveries)
+
+	if len(deliveries.Items) != 1 || deliveries.Items[0].ID != deliveryID || deliveries.Items[0].State != storage.DeliveryDelivered {
+		t.Fatalf("want the grant's delivery, delivered, got %+v", deliveries.Items)
+	}
+
+	var deliveryURL = hooks + "/" + hook.ID + "/deliveries/" + deliveryID
+	var delivery v1.WebhookDelivery
+
+	adminCall(t, "GET", deliveryURL, nil, &delivery)
+
+	if len(delivery.Attempts) != 2 || *delivery.Attempts[0].StatusCode != 500 || *delivery.Attempts[1].StatusCode != 200 || delivery.DeliveredAt == nil {
+		t.Errorf("want a failed then a successful attempt logged, got %+v", delivery)
+	}
+
+	// Redelivery sends the same delivery again.
+	if status := adminCall(t, "POST", deliveryURL + "/redeliver", nil, &delivery); status != http.StatusAccepted || delivery.State != storage.DeliveryPending {
+		t.Fatalf("redeliver = %d %+v, want 202 and pending", status, delivery)
+	}
+
+	if err := dispatcher.DeliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case again := <-requests:
+		if again.header.Get(webhook.DeliveryHeader) != deliveryID {
+			t.Errorf("want the redelivery to keep its id, got %v", again.header)
+		}
+	default:
+		t.Fatal("want the redelivery sent")
+	}
+
+	if status := adminCall(t, "DELETE", hooks + "/" + hook.ID, nil, nil); status != http.StatusNoContent {
+		t.Errorf("delete status = %d, want 204", status)
+	}
+
+	if status := adminCall(t, "GET", hooks + "/" + hook.ID, nil, nil); status != http.StatusNotFound {
+		t.Errorf("want the webhook gone, got %d", status)
+	}
+
+	if status := adminCall(t, "GET", deliveryURL, nil, nil); status != http.StatusNotFound {
+		t.Errorf("want the deliveries gone with the webhook, got %d", status)
+	}
+}